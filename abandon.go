@@ -0,0 +1,103 @@
+package tacplus
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// AbortReason is why an interactive authentication session ended without a
+// Pass or Fail reply.
+type AbortReason int
+
+// AbortReason values
+const (
+	AbortClient   AbortReason = iota // client aborted the session
+	AbortTimeout                     // client or request handler took too long
+	AbortConnLost                    // session or connection closed while prompting
+	AbortNoReply                     // handler returned no reply, or one other than Pass or Fail
+)
+
+func (r AbortReason) String() string {
+	switch r {
+	case AbortClient:
+		return "aborted by client"
+	case AbortTimeout:
+		return "timed out"
+	case AbortConnLost:
+		return "connection lost"
+	case AbortNoReply:
+		return "no final reply"
+	default:
+		return "unknown"
+	}
+}
+
+// AbandonedSession describes an interactive authentication session that ended
+// without a Pass or Fail reply, such as a login left at the password prompt.
+type AbandonedSession struct {
+	RemoteAddr net.Addr      // peer address
+	SessionID  uint32        // session id from the packet header
+	User       string        // user from the start packet or the answer to GetUser
+	Prompts    int           // prompts sent to the client
+	Duration   time.Duration // time from the start packet to the session ending
+	Err        error         // error ending the session, nil for AbortNoReply
+}
+
+// abortReason returns the AbortReason for a session ending with err.
+func abortReason(err error) AbortReason {
+	var ae *AbortError
+	switch {
+	case err == nil:
+		return AbortNoReply
+	case errors.As(err, &ae):
+		return AbortClient
+	case errors.Is(err, errAuthenTooLong), errors.Is(err, errHandlerTimeout),
+		errors.Is(err, context.DeadlineExceeded):
+		return AbortTimeout
+	}
+	return AbortConnLost
+}
+
+// setUser records the user of an authentication session, if not already known.
+func (s *ServerSession) setUser(user string) {
+	s.mu.Lock()
+	if s.user == "" {
+		s.user = user
+	}
+	s.mu.Unlock()
+}
+
+// reportAbandoned calls OnSessionAbort if the authentication session prompted
+// the client and ended without a Pass or Fail reply. Err is the error ending
+// the session, if any.
+func (s *ServerSession) reportAbandoned(err error) {
+	f := s.c.OnSessionAbort
+	if f == nil {
+		return
+	}
+	s.mu.Lock()
+	prompts, user, final := s.rounds, s.user, s.final
+	s.mu.Unlock()
+	if prompts == 0 {
+		return
+	}
+	if err == nil {
+		if final == AuthenStatusPass || final == AuthenStatusFail {
+			return
+		}
+		if final == 0 {
+			// the session may have closed while the handler was prompting
+			err = s.Err()
+		}
+	}
+	f(AbandonedSession{
+		RemoteAddr: s.RemoteAddr(),
+		SessionID:  s.id,
+		User:       user,
+		Prompts:    prompts,
+		Duration:   time.Since(s.start),
+		Err:        err,
+	}, abortReason(err))
+}
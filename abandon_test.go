@@ -0,0 +1,101 @@
+package tacplus
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestOnSessionAbort(t *testing.T) {
+	skipWithoutMD5(t)
+	type abandoned struct {
+		info   AbandonedSession
+		reason AbortReason
+	}
+	events := make(chan abandoned, 1)
+	h := testHandler
+	h.ConnConfig.MaxAuthenDuration = 3 * timeScale
+	h.ConnConfig.OnSessionAbort = func(info AbandonedSession, reason AbortReason) {
+		events <- abandoned{info, reason}
+	}
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	defer c.Close()
+
+	tests := []struct {
+		name    string
+		finish  func(ctx context.Context, cs *ClientSession)
+		reason  AbortReason
+		prompts int
+	}{
+		{"pass", func(ctx context.Context, cs *ClientSession) {
+			_, _ = cs.Continue(ctx, "@password@")
+		}, -1, 0},
+		{"fail", func(ctx context.Context, cs *ClientSession) {
+			_, _ = cs.Continue(ctx, "wrong")
+		}, -1, 0},
+		{"abort", func(ctx context.Context, cs *ClientSession) {
+			_ = cs.Abort(ctx, "user left")
+		}, AbortClient, 2},
+		{"timeout", func(ctx context.Context, cs *ClientSession) {
+			time.Sleep(4 * timeScale)
+		}, AbortTimeout, 2},
+		{"close", func(ctx context.Context, cs *ClientSession) {
+			s.closeConns()
+		}, AbortConnLost, 2},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		_, cs, err := c.SendAuthenStart(ctx, testAuthStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = cs.Continue(ctx, "fred"); err != nil {
+			t.Fatal(err)
+		}
+		tt.finish(ctx, cs)
+		select {
+		case e := <-events:
+			if tt.reason < 0 {
+				t.Errorf("%s: unexpected abort %s", tt.name, e.reason)
+				break
+			}
+			if e.reason != tt.reason {
+				t.Errorf("%s: got reason %s, want %s", tt.name, e.reason, tt.reason)
+			}
+			if e.info.User != "fred" || e.info.Prompts != tt.prompts {
+				t.Errorf("%s: got user %q prompts %d, want fred and %d", tt.name, e.info.User, e.info.Prompts, tt.prompts)
+			}
+			if e.info.Err == nil {
+				t.Errorf("%s: no error", tt.name)
+			}
+		case <-time.After(5 * timeScale):
+			if tt.reason >= 0 {
+				t.Errorf("%s: OnSessionAbort not called", tt.name)
+			}
+		}
+		cs.Close()
+	}
+}
+
+func TestAbortReason(t *testing.T) {
+	tests := []struct {
+		err  error
+		want AbortReason
+	}{
+		{nil, AbortNoReply},
+		{&AbortError{}, AbortClient},
+		{errAuthenTooLong, AbortTimeout},
+		{errHandlerTimeout, AbortTimeout},
+		{&ConnClosedError{Reason: ClosePeer}, AbortConnLost},
+		{errSessionClosed, AbortConnLost},
+	}
+	for _, tt := range tests {
+		if got := abortReason(tt.err); got != tt.want {
+			t.Errorf("abortReason(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package tacplus
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// AcceptAction is the action Server.Serve takes after an error accepting a connection.
+type AcceptAction int
+
+// AcceptAction values
+const (
+	AcceptStop  AcceptAction = iota // close the listener and return the error
+	AcceptRetry                     // retry after a delay
+	AcceptShed                      // close the oldest idle connection, then retry after a delay
+)
+
+// AcceptErrorPolicy decides how Server.Serve handles an error from Accept, given the
+// number of consecutive failures. It returns the action to take and the delay before
+// retrying.
+type AcceptErrorPolicy func(err error, failures int) (AcceptAction, time.Duration)
+
+// DefaultAcceptErrorPolicy retries timeouts, aborted connections and errors reporting
+// themselves as temporary, backing off from 5ms up to 1s. When the process or system
+// has run out of file descriptors it also sheds the oldest idle connection, freeing a
+// descriptor for the new one. Other errors stop the server.
+func DefaultAcceptErrorPolicy(err error, failures int) (AcceptAction, time.Duration) {
+	delay := 5 * time.Millisecond
+	for i := 1; i < failures && delay < time.Second; i++ {
+		delay *= 2
+	}
+	if max := 1 * time.Second; delay > max {
+		delay = max
+	}
+	if action := errnoAction(err); action != AcceptStop {
+		return action, delay
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && (ne.Timeout() || isTemporary(ne)) {
+		return AcceptRetry, delay
+	}
+	return AcceptStop, 0
+}
+
+// isTemporary reports whether err reports itself as temporary. Temporary is
+// deprecated, but some listeners still only signal retryable errors with it.
+func isTemporary(err net.Error) bool {
+	t, ok := err.(interface{ Temporary() bool })
+	return ok && t.Temporary()
+}
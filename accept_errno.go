@@ -0,0 +1,19 @@
+//go:build !plan9
+
+package tacplus
+
+import (
+	"errors"
+	"syscall"
+)
+
+// errnoAction returns the AcceptAction for system errors from Accept.
+func errnoAction(err error) AcceptAction {
+	switch {
+	case errors.Is(err, syscall.EMFILE), errors.Is(err, syscall.ENFILE):
+		return AcceptShed
+	case errors.Is(err, syscall.ECONNABORTED), errors.Is(err, syscall.ECONNRESET):
+		return AcceptRetry
+	}
+	return AcceptStop
+}
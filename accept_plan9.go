@@ -0,0 +1,5 @@
+package tacplus
+
+// errnoAction returns the AcceptAction for system errors from Accept.
+// Plan 9 has no error numbers to check.
+func errnoAction(err error) AcceptAction { return AcceptStop }
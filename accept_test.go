@@ -0,0 +1,92 @@
+package tacplus
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDefaultAcceptErrorPolicy(t *testing.T) {
+	emfile := &net.OpError{Op: "accept", Err: os.NewSyscallError("accept", syscall.EMFILE)}
+	for _, test := range []struct {
+		err      error
+		failures int
+		action   AcceptAction
+		delay    time.Duration
+	}{
+		{emfile, 1, AcceptShed, 5 * time.Millisecond},
+		{emfile, 3, AcceptShed, 20 * time.Millisecond},
+		{&net.OpError{Op: "accept", Err: syscall.ECONNABORTED}, 1, AcceptRetry, 5 * time.Millisecond},
+		{&net.OpError{Op: "accept", Err: os.ErrDeadlineExceeded}, 50, AcceptRetry, time.Second},
+		{errors.New("listener broken"), 1, AcceptStop, 0},
+	} {
+		action, delay := DefaultAcceptErrorPolicy(test.err, test.failures)
+		if action != test.action || delay != test.delay {
+			t.Errorf("%v after %d failures: got %d, %v want %d, %v", test.err, test.failures, action, delay, test.action, test.delay)
+		}
+	}
+}
+
+// chanListener is a net.Listener returning the connections and errors sent on ch.
+type chanListener struct {
+	ch   chan interface{}
+	done chan struct{}
+}
+
+func (l *chanListener) Accept() (net.Conn, error) {
+	select {
+	case v := <-l.ch:
+		if err, ok := v.(error); ok {
+			return nil, err
+		}
+		return v.(net.Conn), nil
+	case <-l.done:
+		return nil, errors.New("listener closed")
+	}
+}
+
+func (l *chanListener) Close() error {
+	select {
+	case <-l.done:
+	default:
+		close(l.done)
+	}
+	return nil
+}
+
+func (l *chanListener) Addr() net.Addr { return &net.TCPAddr{} }
+
+func TestServerShedIdle(t *testing.T) {
+	skipWithoutMD5(t)
+	h := testHandler
+	srv := &Server{ServeConnContext: h.ServeContext, Log: func(...interface{}) {}}
+	l := &chanListener{ch: make(chan interface{}), done: make(chan struct{})}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(l) }()
+	defer srv.Close()
+
+	cc, sc := net.Pipe()
+	defer cc.Close()
+	l.ch <- sc
+	for len(srv.Connections()) == 0 || srv.Connections()[0].Sessions != 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// running out of file descriptors closes the idle connection
+	l.ch <- &net.OpError{Op: "accept", Err: os.NewSyscallError("accept", syscall.EMFILE)}
+	_ = cc.SetReadDeadline(time.Now().Add(10 * timeScale))
+	if _, err := cc.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("got read error %v, want %v", err, io.EOF)
+	}
+
+	// other errors stop the server
+	stop := errors.New("listener broken")
+	l.ch <- stop
+	if err := <-served; err != stop {
+		t.Errorf("Serve returned %v, want %v", err, stop)
+	}
+}
@@ -0,0 +1,88 @@
+package tacplus
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Account errors returned by an AccountChecker. Their text is used as the
+// DenyReason of replies refusing the user.
+var (
+	ErrAccountDisabled  = errors.New("account disabled")
+	ErrAccountExpired   = errors.New("account expired")
+	ErrOutsideLoginTime = errors.New("outside allowed login times")
+)
+
+// An AccountChecker is an Authenticator with restrictions on when accounts can
+// be used. A LoginHandler checks the account of a user after their password is
+// accepted, and before passing authorization requests to its Handler.
+type AccountChecker interface {
+	// CheckAccount returns an error, such as ErrAccountDisabled, if user may not
+	// make the request described by ri. Users the checker doesn't know are allowed.
+	CheckAccount(ctx context.Context, user string, ri *RequestInfo) error
+}
+
+// Account is the restrictions on using a UserStore account.
+type Account struct {
+	Disabled bool      // refuse all logins and authorization
+	Expires  time.Time // time the account stops working, never if zero
+
+	// Conditions, such as TimeWindow, of which one must be met to log in or be
+	// authorized. Allowed at any time if empty.
+	Windows []Condition
+}
+
+// check returns the error for a request described by ri using the account.
+func (a *Account) check(ri *RequestInfo) error {
+	switch {
+	case a.Disabled:
+		return ErrAccountDisabled
+	case !a.Expires.IsZero() && !ri.Time.Before(a.Expires):
+		return ErrAccountExpired
+	case len(a.Windows) > 0 && !Any(a.Windows...)(ri):
+		return ErrOutsideLoginTime
+	}
+	return nil
+}
+
+// SetAccount sets the account restrictions of user, returning false if the
+// user doesn't exist.
+func (u *UserStore) SetAccount(user string, a Account) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	su := u.users[user]
+	if su == nil {
+		return false
+	}
+	su.account = a
+	return true
+}
+
+// CheckAccount returns an error if the account of user is disabled, has
+// expired, or ri is outside its login windows.
+func (u *UserStore) CheckAccount(ctx context.Context, user string, ri *RequestInfo) error {
+	u.mu.Lock()
+	su := u.users[user]
+	var a Account
+	if su != nil {
+		a = su.account
+	}
+	u.mu.Unlock()
+	return a.check(ri)
+}
+
+// isAccountError reports whether err refuses an account, rather than being a
+// failure to check it.
+func isAccountError(err error) bool {
+	return errors.Is(err, ErrAccountDisabled) || errors.Is(err, ErrAccountExpired) ||
+		errors.Is(err, ErrOutsideLoginTime)
+}
+
+// accountDenied returns the error from the AccountChecker of auth, if it is one.
+func accountDenied(ctx context.Context, auth Authenticator, user string, ri *RequestInfo) error {
+	if ac, ok := auth.(AccountChecker); ok {
+		return ac.CheckAccount(ctx, user, ri)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package tacplus
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAccountCheck(t *testing.T) {
+	now := time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC)
+	office := TimeWindow(9*time.Hour, 17*time.Hour, time.UTC)
+	tests := []struct {
+		acct Account
+		t    time.Time
+		want error
+	}{
+		{Account{}, now, nil},
+		{Account{Disabled: true}, now, ErrAccountDisabled},
+		{Account{Expires: now}, now, ErrAccountExpired},
+		{Account{Expires: now.Add(time.Hour)}, now, nil},
+		{Account{Windows: []Condition{office}}, now, nil},
+		{Account{Windows: []Condition{office}}, now.Add(8 * time.Hour), ErrOutsideLoginTime},
+	}
+	for i, tt := range tests {
+		if err := tt.acct.check(&RequestInfo{Time: tt.t}); err != tt.want {
+			t.Errorf("%d: got %v, want %v", i, err, tt.want)
+		}
+	}
+}
+
+func TestLoginAccounts(t *testing.T) {
+	skipWithoutMD5(t)
+	users := new(UserStore)
+	for _, u := range []string{"alice", "bob", "carol", "dave"} {
+		users.Set(u, "pass", time.Now())
+	}
+	users.SetAccount("bob", Account{Disabled: true})
+	users.SetAccount("carol", Account{Expires: time.Now().Add(-time.Minute)})
+	users.SetAccount("dave", Account{Windows: []Condition{func(*RequestInfo) bool { return false }}})
+	if users.SetAccount("nobody", Account{}) {
+		t.Error("SetAccount succeeded for missing user")
+	}
+
+	denials := make(chan Denial, 2)
+	h := testHandler
+	h.Handler = &LoginHandler{
+		Primary:     users,
+		Passthrough: Passthrough{&testRequestHandler{"alice": {args: []string{"priv-lvl=15"}}}},
+	}
+	h.ConnConfig.OnDeny = func(d Denial) { denials <- d }
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	defer c.Close()
+
+	ctx := context.Background()
+	for user, reason := range map[string]error{
+		"alice": nil,
+		"bob":   ErrAccountDisabled,
+		"carol": ErrAccountExpired,
+		"dave":  ErrOutsideLoginTime,
+	} {
+		rep, err := c.SendAuthenStartAuto(ctx, testAuthStart, user, "pass")
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := *testAuthorReq
+		req.User = user
+		resp, err := c.SendAuthorRequest(ctx, &req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reason == nil {
+			if rep.Status != AuthenStatusPass || resp.Status != AuthorStatusPassAdd {
+				t.Errorf("%s: got status %d and %d", user, rep.Status, resp.Status)
+			}
+			continue
+		}
+		if rep.Status != AuthenStatusFail || resp.Status != AuthorStatusFail {
+			t.Errorf("%s: got status %d and %d, want failures", user, rep.Status, resp.Status)
+		}
+		if rep.ServerMsg != "" || resp.ServerMsg != "" {
+			t.Errorf("%s: reason sent to client", user)
+		}
+		for _, typ := range []uint8{TypeAuthen, TypeAuthor} {
+			if d := <-denials; d.Type != typ || d.User != user || d.Reason != reason.Error() {
+				t.Errorf("%s: got denial %+v, want type %d reason %q", user, d, typ, reason)
+			}
+		}
+	}
+	if err = s.err(); err != nil {
+		t.Error(err)
+	}
+}
+
+// downDirectory is an Authenticator and AccountChecker whose backend is
+// unreachable.
+type downDirectory struct{}
+
+var errDirectoryDown = errors.New("ldap: connection refused")
+
+func (downDirectory) Authenticate(context.Context, string, string) (bool, error) {
+	return false, errDirectoryDown
+}
+
+func (downDirectory) CheckAccount(context.Context, string, *RequestInfo) error {
+	return errDirectoryDown
+}
+
+func TestLoginAccountsFallback(t *testing.T) {
+	skipWithoutMD5(t)
+	users := new(UserStore)
+	users.Set("alice", "pass", time.Now())
+	users.Set("bob", "pass", time.Now())
+	users.SetAccount("bob", Account{Disabled: true})
+
+	fallbacks := make(chan string, 2)
+	lh := &LoginHandler{
+		Primary:     downDirectory{},
+		Fallback:    users,
+		Passthrough: Passthrough{&testRequestHandler{"alice": {args: []string{"priv-lvl=15"}}}},
+		OnFallback:  func(user string, err error) { fallbacks <- user },
+	}
+	h := testHandler
+	h.Handler = lh
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	defer c.Close()
+
+	ctx := context.Background()
+	for user, want := range map[string]uint8{"alice": AuthorStatusPassAdd, "bob": AuthorStatusFail} {
+		req := *testAuthorReq
+		req.User = user
+		resp, err := c.SendAuthorRequest(ctx, &req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != want {
+			t.Errorf("%s: got status %d, want %d", user, resp.Status, want)
+		}
+		select {
+		case u := <-fallbacks:
+			if u != user {
+				t.Errorf("%s: got fallback for %s", user, u)
+			}
+		default:
+			t.Errorf("%s: fallback not used", user)
+		}
+	}
+
+	// without a fallback the primary error is sent to the client
+	lh.Fallback = nil
+	req := *testAuthorReq
+	req.User = "alice"
+	resp, err := c.SendAuthorRequest(ctx, &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != AuthorStatusError {
+		t.Errorf("got status %d, want %d", resp.Status, AuthorStatusError)
+	}
+}
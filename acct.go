@@ -0,0 +1,394 @@
+package tacplus
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// argValue returns the value of the first argument named name in args.
+func argValue(args []string, name string) (string, bool) {
+	for _, a := range args {
+		if len(a) > len(name) && strings.HasPrefix(a, name) {
+			if c := a[len(name)]; c == '=' || c == '*' {
+				return a[len(name)+1:], true
+			}
+		}
+	}
+	return "", false
+}
+
+// remoteHost returns the host part of the remote address of s.
+func remoteHost(s *ServerSession) string {
+	addr := s.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// AcctDedup is a RequestHandler that suppresses retransmitted accounting requests.
+//
+// Devices retransmit accounting records, such as stops, when a reply times out.
+// A request from the same peer host with the same task_id, flags, user, port and
+// start_time, stop_time and timestamp arguments as a request that succeeded within
+// Window is answered with a Success reply without calling Handler. Requests without
+// a task_id argument are always passed to Handler.
+//
+// Key can be set to identify requests by other fields. With Fingerprint, a resent
+// record is only suppressed if the device repeats it unchanged, so a stop with
+// updated byte counts is passed to Handler.
+type AcctDedup struct {
+	Passthrough               // handler for requests
+	Window      time.Duration // time to remember successful accounting requests
+
+	// Optional function returning the key identifying a request with a task_id
+	// argument, such as (*AcctRequest).Fingerprint. The peer host is added to it.
+	Key func(*AcctRequest) string
+
+	mu    sync.Mutex
+	seen  map[string]time.Time // time requests were seen, by key
+	swept time.Time            // last time expired entries were removed
+}
+
+// NewAcctDedup returns an AcctDedup for h that remembers requests for window.
+func NewAcctDedup(h RequestHandler, window time.Duration) *AcctDedup {
+	return &AcctDedup{Passthrough: Passthrough{h}, Window: window}
+}
+
+// dedupKey returns the key identifying the accounting request a from host,
+// or an empty string if it has no task_id.
+func (d *AcctDedup) dedupKey(host string, a *AcctRequest) string {
+	id, ok := argValue(a.Arg, "task_id")
+	if !ok {
+		return ""
+	}
+	if d.Key != nil {
+		return host + "\x00" + d.Key(a)
+	}
+	var b strings.Builder
+	b.WriteString(host)
+	for _, s := range []string{id, strconv.Itoa(int(a.Flags)), a.User, a.Port} {
+		b.WriteByte(0)
+		b.WriteString(s)
+	}
+	for _, name := range []string{"start_time", "stop_time", "timestamp"} {
+		v, _ := argValue(a.Arg, name)
+		b.WriteByte(0)
+		b.WriteString(v)
+	}
+	return b.String()
+}
+
+// duplicate reports whether key was seen within the window before now.
+func (d *AcctDedup) duplicate(key string, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	t, ok := d.seen[key]
+	return ok && now.Sub(t) < d.Window
+}
+
+// remember records a successful request with key at time now,
+// removing expired entries once per window.
+func (d *AcctDedup) remember(key string, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.seen == nil {
+		d.seen = make(map[string]time.Time)
+	}
+	if now.Sub(d.swept) >= d.Window {
+		for k, t := range d.seen {
+			if now.Sub(t) >= d.Window {
+				delete(d.seen, k)
+			}
+		}
+		d.swept = now
+	}
+	d.seen[key] = now
+}
+
+// HandleAcctRequest answers duplicate requests with Success, passing others to Handler.
+func (d *AcctDedup) HandleAcctRequest(ctx context.Context, a *AcctRequest, s *ServerSession) *AcctReply {
+	key := d.dedupKey(remoteHost(s), a)
+	if key == "" || d.Window <= 0 {
+		return d.Passthrough.HandleAcctRequest(ctx, a, s)
+	}
+	if d.duplicate(key, time.Now()) {
+		return &AcctReply{Status: AcctStatusSuccess}
+	}
+	r := d.Passthrough.HandleAcctRequest(ctx, a, s)
+	if r != nil && r.Status == AcctStatusSuccess {
+		d.remember(key, time.Now())
+	}
+	return r
+}
+
+// LiveSession is a device session in progress, tracked from accounting records.
+type LiveSession struct {
+	Host     string    // address of the device sending the records
+	TaskID   string    // task_id argument
+	User     string    // user name
+	Port     string    // device port
+	RemAddr  string    // remote address of the user
+	Service  string    // service argument
+	Start    time.Time // time the first record was seen
+	Updated  time.Time // time the last record was seen
+	Stopped  bool      // set when the stop record has been seen
+	BytesIn  int64     // bytes_in argument
+	BytesOut int64     // bytes_out argument
+	PaksIn   int64     // paks_in argument
+	PaksOut  int64     // paks_out argument
+	Arg      []string  // arguments of the last record, merged with earlier arguments
+}
+
+// update folds the accounting request a seen at now into the session.
+func (l *LiveSession) update(a *AcctRequest, now time.Time) {
+	l.User, l.Port, l.RemAddr = a.User, a.Port, a.RemAddr
+	l.Updated = now
+	if v, ok := argValue(a.Arg, "service"); ok {
+		l.Service = v
+	}
+	for _, c := range []struct {
+		name string
+		n    *int64
+	}{
+		{"bytes_in", &l.BytesIn},
+		{"bytes_out", &l.BytesOut},
+		{"paks_in", &l.PaksIn},
+		{"paks_out", &l.PaksOut},
+	} {
+		if v, ok := argValue(a.Arg, c.name); ok {
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				*c.n = n
+			}
+		}
+	}
+	l.Arg = mergeArgs(l.Arg, a.Arg)
+}
+
+// argName returns the attribute name of argument a.
+func argName(a string) string {
+	if i := strings.IndexAny(a, "=*"); i >= 0 {
+		return a[:i]
+	}
+	return a
+}
+
+// mergeArgs returns old with arguments replaced or added by those in args.
+func mergeArgs(old, args []string) []string {
+	merged := append([]string(nil), old...)
+	for _, a := range args {
+		name := argName(a)
+		found := false
+		for i, m := range merged {
+			if argName(m) == name {
+				merged[i] = a
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, a)
+		}
+	}
+	return merged
+}
+
+// AcctTracker is a RequestHandler that folds accounting start, watchdog and
+// stop records into a table of live device sessions keyed by peer host and task_id.
+//
+// Records are tracked if Handler replies with Success, or if Handler is nil in which
+// case all accounting requests are answered with Success. Authentication and
+// authorization requests are passed to Handler.
+type AcctTracker struct {
+	Passthrough // optional handler for requests
+
+	// Optional function called with the consolidated record when a session stops.
+	OnStop func(LiveSession)
+
+	// Sessions without an update for Timeout are discarded. Ignored if zero,
+	// in which case sessions whose stop record is lost are kept forever.
+	Timeout time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*LiveSession
+	expired  time.Time // time of the last expire
+}
+
+// Sessions returns the sessions in progress.
+func (t *AcctTracker) Sessions() []LiveSession {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire(time.Now())
+	ls := make([]LiveSession, 0, len(t.sessions))
+	for _, l := range t.sessions {
+		ls = append(ls, *l)
+	}
+	return ls
+}
+
+// expire removes sessions not updated within the Timeout.
+func (t *AcctTracker) expire(now time.Time) {
+	if t.Timeout <= 0 {
+		return
+	}
+	t.expired = now
+	for k, l := range t.sessions {
+		if now.Sub(l.Updated) >= t.Timeout {
+			delete(t.sessions, k)
+		}
+	}
+}
+
+// track folds the accounting request a from host into the session table.
+func (t *AcctTracker) track(host string, a *AcctRequest) {
+	id, ok := argValue(a.Arg, "task_id")
+	if !ok {
+		return
+	}
+	key := host + "\x00" + id
+	now := time.Now()
+
+	t.mu.Lock()
+	if t.sessions == nil {
+		t.sessions = make(map[string]*LiveSession)
+	}
+	// sessions that never stop are discarded even if Sessions isn't called
+	if now.Sub(t.expired) >= t.Timeout {
+		t.expire(now)
+	}
+	l := t.sessions[key]
+	if l == nil {
+		l = &LiveSession{Host: host, TaskID: id, Start: now}
+		t.sessions[key] = l
+	}
+	l.update(a, now)
+	stopped := a.Flags&AcctFlagStop > 0
+	if stopped {
+		l.Stopped = true
+		delete(t.sessions, key)
+	}
+	t.mu.Unlock()
+
+	if stopped && t.OnStop != nil {
+		t.OnStop(*l)
+	}
+}
+
+// HandleAcctRequest tracks the request if Handler replies with Success.
+func (t *AcctTracker) HandleAcctRequest(ctx context.Context, a *AcctRequest, s *ServerSession) *AcctReply {
+	r := &AcctReply{Status: AcctStatusSuccess}
+	if t.Handler != nil {
+		r = t.Handler.HandleAcctRequest(ctx, a, s)
+	}
+	if r != nil && r.Status == AcctStatusSuccess {
+		t.track(remoteHost(s), a)
+	}
+	return r
+}
+
+// CommandRecord is a command run on a device, taken from an accounting record.
+type CommandRecord struct {
+	Time    time.Time `json:"time"`
+	Host    string    `json:"host"`
+	Device  string    `json:"device,omitempty"`
+	User    string    `json:"user"`
+	Port    string    `json:"port,omitempty"`
+	RemAddr string    `json:"rem_addr,omitempty"`
+	PrivLvl uint8     `json:"priv_lvl"`
+	TaskID  string    `json:"task_id,omitempty"`
+	Command string    `json:"command"`
+	Groups  []string  `json:"groups,omitempty"` // group chain of the user
+}
+
+// commandRecord returns the command in the accounting request a from host,
+// or false if a isn't a command accounting record.
+func commandRecord(host string, a *AcctRequest, now time.Time) (CommandRecord, bool) {
+	cmd, ok := CommandLine(a.Arg)
+	if !ok {
+		return CommandRecord{}, false
+	}
+	cmd = strings.TrimSpace(strings.TrimSuffix(cmd, "<cr>"))
+	id, _ := argValue(a.Arg, "task_id")
+	return CommandRecord{
+		Time:    now,
+		Host:    host,
+		User:    a.User,
+		Port:    a.Port,
+		RemAddr: a.RemAddr,
+		PrivLvl: a.PrivLvl,
+		TaskID:  id,
+		Command: cmd,
+	}, true
+}
+
+// CommandLog is a RequestHandler that records command accounting requests
+// (those with a cmd argument) as a per-user command history.
+//
+// Records are written to W as JSON lines and passed to Record, if set. As with
+// AcctTracker, records are logged if Handler replies with Success, or if Handler
+// is nil in which case all accounting requests are answered with Success.
+type CommandLog struct {
+	Passthrough           // optional handler for requests
+	W           io.Writer // optional writer for JSON lines
+
+	// Optional function called with each record, e.g. to store it in a database.
+	// A returned error is logged.
+	Record func(CommandRecord) error
+
+	// Optional policy whose group chain of the user is added to records.
+	Groups *GroupPolicy
+
+	mu sync.Mutex // serializes writes to W
+}
+
+func (l *CommandLog) write(r CommandRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.W.Write(append(b, '\n'))
+	return err
+}
+
+// HandleAcctRequest records command accounting requests if Handler replies with Success.
+func (l *CommandLog) HandleAcctRequest(ctx context.Context, a *AcctRequest, s *ServerSession) *AcctReply {
+	r := &AcctReply{Status: AcctStatusSuccess}
+	if l.Handler != nil {
+		r = l.Handler.HandleAcctRequest(ctx, a, s)
+	}
+	if r == nil || r.Status != AcctStatusSuccess {
+		return r
+	}
+	rec, ok := commandRecord(remoteHost(s), a, time.Now())
+	if !ok {
+		return r
+	}
+	if dev := s.Device(); dev != nil {
+		rec.Device = dev.Name
+	}
+	if l.Groups != nil {
+		var err error
+		if rec.Groups, err = l.Groups.Chain(ctx, rec.User); err != nil {
+			s.Log(err)
+		}
+	}
+	if l.W != nil {
+		if err := l.write(rec); err != nil {
+			s.Log(err)
+		}
+	}
+	if l.Record != nil {
+		if err := l.Record(rec); err != nil {
+			s.Log(err)
+		}
+	}
+	return r
+}
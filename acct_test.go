@@ -0,0 +1,260 @@
+package tacplus
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countAcctHandler counts the accounting requests it handles.
+type countAcctHandler struct {
+	RequestHandler
+	n int32
+}
+
+func (h *countAcctHandler) HandleAcctRequest(ctx context.Context, a *AcctRequest, s *ServerSession) *AcctReply {
+	atomic.AddInt32(&h.n, 1)
+	return h.RequestHandler.HandleAcctRequest(ctx, a, s)
+}
+
+func TestArgValue(t *testing.T) {
+	args := []string{"task_id=1", "service*shell", "cmd=", "task=2"}
+	for _, test := range []struct {
+		name, value string
+		ok          bool
+	}{
+		{"task_id", "1", true},
+		{"service", "shell", true},
+		{"cmd", "", true},
+		{"task", "2", true},
+		{"tas", "", false},
+	} {
+		v, ok := argValue(args, test.name)
+		if v != test.value || ok != test.ok {
+			t.Errorf("argValue(%q) = %q, %v; want %q, %v", test.name, v, ok, test.value, test.ok)
+		}
+	}
+}
+
+func TestAcctDedup(t *testing.T) {
+	skipWithoutMD5(t)
+	ch := &countAcctHandler{RequestHandler: testHandler.Handler}
+	h := testHandler
+	h.Handler = NewAcctDedup(ch, time.Minute)
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	stop := func(id string) *AcctRequest {
+		return &AcctRequest{
+			Flags:         AcctFlagStop,
+			AuthenMethod:  AuthenMethodTACACSPlus,
+			AuthenType:    AuthenTypeASCII,
+			AuthenService: AuthenServiceLogin,
+			User:          "user",
+			Port:          "tty1",
+			Arg:           []string{"task_id=" + id, "stop_time=1000"},
+		}
+	}
+	ctx := context.Background()
+	for _, req := range []*AcctRequest{stop("1"), stop("1"), stop("2"), testAcctReq, testAcctReq} {
+		rep, err := c.SendAcctRequest(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rep.Status != AcctStatusSuccess {
+			t.Errorf("got status %d, want %d", rep.Status, AcctStatusSuccess)
+		}
+	}
+	// duplicate stop suppressed, requests without task_id passed through
+	if n := atomic.LoadInt32(&ch.n); n != 4 {
+		t.Errorf("handler called %d times, want 4", n)
+	}
+	if err = s.err(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAcctDedupKey(t *testing.T) {
+	skipWithoutMD5(t)
+	stop := func(id, remAddr string, args ...string) *AcctRequest {
+		return &AcctRequest{
+			Flags:         AcctFlagStop,
+			AuthenMethod:  AuthenMethodTACACSPlus,
+			AuthenType:    AuthenTypeASCII,
+			AuthenService: AuthenServiceLogin,
+			User:          "user",
+			Port:          "tty1",
+			RemAddr:       remAddr,
+			Arg:           append([]string{"task_id=" + id, "stop_time=1000"}, args...),
+		}
+	}
+	reqs := []*AcctRequest{
+		stop("1", ""),
+		stop("1", ""),                   // duplicate
+		stop("1", "", "elapsed_time=5"), // duplicate, elapsed_time is ignored
+		stop("1", "", "bytes_in=10"),    // counters are only compared by Fingerprint
+		stop("1", "192.0.2.1"),          // as is rem_addr
+		stop("2", ""),
+	}
+	for _, test := range []struct {
+		key  func(*AcctRequest) string
+		want int32
+	}{
+		{nil, 2},
+		{(*AcctRequest).Fingerprint, 4},
+	} {
+		ch := &countAcctHandler{RequestHandler: testHandler.Handler}
+		h := testHandler
+		d := NewAcctDedup(ch, time.Minute)
+		d.Key = test.key
+		h.Handler = d
+		s, c, err := newTestInstance(&h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := context.Background()
+		for _, req := range reqs {
+			if _, err = c.SendAcctRequest(ctx, req); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if n := atomic.LoadInt32(&ch.n); n != test.want {
+			t.Errorf("handler called %d times, want %d", n, test.want)
+		}
+		s.close()
+	}
+}
+
+func TestAcctTracker(t *testing.T) {
+	skipWithoutMD5(t)
+	stopped := make(chan LiveSession, 1)
+	tr := &AcctTracker{OnStop: func(l LiveSession) { stopped <- l }}
+	h := testHandler
+	h.Handler = tr
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	req := func(flags uint8, args ...string) *AcctRequest {
+		return &AcctRequest{
+			Flags:        flags,
+			AuthenMethod: AuthenMethodTACACSPlus,
+			User:         "user",
+			Port:         "tty1",
+			Arg:          append([]string{"task_id=7"}, args...),
+		}
+	}
+	ctx := context.Background()
+	send := func(r *AcctRequest) {
+		if _, err := c.SendAcctRequest(ctx, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	send(req(AcctFlagStart, "service=shell"))
+	send(req(AcctFlagWatchdog, "bytes_in=10", "bytes_out=20"))
+	ls := tr.Sessions()
+	if len(ls) != 1 {
+		t.Fatalf("got %d live sessions, want 1", len(ls))
+	}
+	if l := ls[0]; l.TaskID != "7" || l.User != "user" || l.Service != "shell" || l.BytesIn != 10 || l.BytesOut != 20 {
+		t.Errorf("unexpected live session %+v", l)
+	}
+
+	send(req(AcctFlagStop, "bytes_in=15", "elapsed_time=5"))
+	select {
+	case l := <-stopped:
+		if !l.Stopped || l.BytesIn != 15 || l.BytesOut != 20 || len(l.Arg) != 5 {
+			t.Errorf("unexpected stop record %+v", l)
+		}
+	default:
+		t.Error("OnStop not called")
+	}
+	if ls = tr.Sessions(); len(ls) != 0 {
+		t.Errorf("got %d live sessions after stop, want 0", len(ls))
+	}
+}
+
+func TestAcctTrackerExpireOnTrack(t *testing.T) {
+	tr := &AcctTracker{Timeout: timeScale}
+	start := func(id string) {
+		tr.track("192.0.2.1", &AcctRequest{Flags: AcctFlagStart, Arg: []string{"task_id=" + id}})
+	}
+	for i := 0; i < 10; i++ {
+		start(strconv.Itoa(i))
+	}
+	time.Sleep(timeScale)
+	start("new")
+	tr.mu.Lock()
+	n := len(tr.sessions)
+	tr.mu.Unlock()
+	if n != 1 {
+		t.Errorf("got %d sessions tracked, want 1", n)
+	}
+}
+
+func TestCommandLog(t *testing.T) {
+	skipWithoutMD5(t)
+	var buf bytes.Buffer
+	var recs []CommandRecord
+	h := testHandler
+	h.Handler = &CommandLog{
+		Passthrough: Passthrough{testHandler.Handler},
+		W:           &buf,
+		Record: func(r CommandRecord) error {
+			recs = append(recs, r)
+			return nil
+		},
+	}
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	ctx := context.Background()
+	for _, req := range []*AcctRequest{
+		{Flags: AcctFlagStop, User: "user", PrivLvl: 15, Arg: []string{"task_id=3", "cmd=show running-config <cr>"}},
+		{Flags: AcctFlagStop, User: "fred", PrivLvl: 1, Arg: []string{"cmd=show", "cmd-arg=version", "cmd-arg=<cr>"}},
+		{Flags: AcctFlagStop, User: "joe", PrivLvl: 1, Arg: []string{"cmd=show", "cmd-arg", "cmd-arg="}},
+		testAcctReq,
+	} {
+		if _, err = c.SendAcctRequest(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []struct{ user, cmd string }{
+		{"user", "show running-config"},
+		{"fred", "show version"},
+		{"joe", "show"},
+	}
+	if len(recs) != len(want) {
+		t.Fatalf("got %d records, want %d", len(recs), len(want))
+	}
+	dec := json.NewDecoder(&buf)
+	for i, w := range want {
+		if recs[i].User != w.user || recs[i].Command != w.cmd || recs[i].Host != "127.0.0.1" {
+			t.Errorf("unexpected record %+v", recs[i])
+		}
+		var r CommandRecord
+		if err = dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		if r.User != w.user || r.Command != w.cmd {
+			t.Errorf("unexpected JSON record %+v", r)
+		}
+	}
+	if dec.More() {
+		t.Error("unexpected extra JSON records")
+	}
+}
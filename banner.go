@@ -0,0 +1,21 @@
+package tacplus
+
+import "context"
+
+// banner shows the Banner before the first prompt r of the session, by sending
+// it as a separate GetData prompt if BannerPrompt is set, or by prepending it to
+// the message of r. The session turn must be held.
+func (s *ServerSession) banner(ctx context.Context, r *AuthenReply) (*AuthenContinue, error) {
+	if s.rounds > 0 {
+		return nil, nil
+	}
+	b := s.message("authen-banner", s.c.Banner)
+	if b == "" {
+		return nil, nil
+	}
+	if !s.c.BannerPrompt {
+		r.ServerMsg = b + "\n" + r.ServerMsg
+		return nil, nil
+	}
+	return s.exchange(ctx, &AuthenReply{Status: AuthenStatusGetData, ServerMsg: b})
+}
@@ -0,0 +1,90 @@
+//go:build !tacplus_nomd5
+
+package tacplus
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+const testBanner = "Authorized use only"
+
+func TestBanner(t *testing.T) {
+	h := testHandler
+	h.ConnConfig.Banner = testBanner
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	defer c.Close()
+
+	ctx := context.Background()
+	rep, cs, err := c.SendAuthenStart(ctx, testAuthStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Close()
+	if want := testBanner + "\nUsername:"; rep.Status != AuthenStatusGetUser || rep.ServerMsg != want {
+		t.Errorf("got status %d message %q, want %d %q", rep.Status, rep.ServerMsg, AuthenStatusGetUser, want)
+	}
+	// only the first prompt has the banner
+	if rep, err = cs.Continue(ctx, "fred"); err != nil {
+		t.Fatal(err)
+	}
+	if rep.ServerMsg != "Password:" {
+		t.Errorf("got message %q, want %q", rep.ServerMsg, "Password:")
+	}
+	if err = s.err(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBannerPrompt(t *testing.T) {
+	h := testHandler
+	h.ConnConfig.Banner = testBanner
+	h.ConnConfig.BannerPrompt = true
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	defer c.Close()
+
+	ctx := context.Background()
+	rep, cs, err := c.SendAuthenStart(ctx, testAuthStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Status != AuthenStatusGetData || rep.NoEcho || rep.ServerMsg != testBanner {
+		t.Errorf("got reply %+v, want banner prompt", rep)
+	}
+	if rep, err = cs.Continue(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	if rep.Status != AuthenStatusGetUser || rep.ServerMsg != "Username:" {
+		t.Errorf("got status %d message %q after banner", rep.Status, rep.ServerMsg)
+	}
+	cs.Close()
+
+	// without OnBanner the banner prompt is unexpected
+	if _, err = c.SendAuthenStartAuto(ctx, testAuthStart, "fred", "@password@"); !errors.Is(err, errUnexpectedPrompt) {
+		t.Errorf("got error %v, want %v", err, errUnexpectedPrompt)
+	}
+
+	var banners []string
+	c.OnBanner = func(b string) { banners = append(banners, b) }
+	if rep, err = c.SendAuthenStartAuto(ctx, testAuthStart, "fred", "@password@"); err != nil {
+		t.Fatal(err)
+	}
+	if rep.Status != AuthenStatusPass {
+		t.Errorf("got status %d, want %d", rep.Status, AuthenStatusPass)
+	}
+	if len(banners) != 1 || banners[0] != testBanner {
+		t.Errorf("got banners %q", banners)
+	}
+	if err = s.err(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,147 @@
+package tacplus
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by a Client when requests to a server are not being
+// attempted because of too many consecutive connection failures.
+var ErrCircuitOpen = errors.New("circuit open, too many server connection failures")
+
+// A BreakerState is the state of a Client's circuit breaker for a server address.
+type BreakerState int
+
+// BreakerState values
+const (
+	BreakerClosed   BreakerState = iota // requests are allowed
+	BreakerOpen                         // requests fail with ErrCircuitOpen
+	BreakerHalfOpen                     // a single request is allowed to test the server
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	default:
+		return "half-open"
+	}
+}
+
+// breaker is a circuit breaker for a single server address.
+type breaker struct {
+	mu       sync.Mutex
+	state    BreakerState
+	failures int       // consecutive failures
+	until    time.Time // time open state ends
+	probing  bool      // half-open test request in progress
+}
+
+// allow returns whether a request may be attempted. The returned state is
+// the new state if it changed, or -1.
+func (b *breaker) allow(now time.Time) (bool, BreakerState) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if now.Before(b.until) {
+			return false, -1
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return true, BreakerHalfOpen
+	case BreakerHalfOpen:
+		if b.probing {
+			return false, -1
+		}
+		b.probing = true
+	}
+	return true, -1
+}
+
+// report records the result of a request. failed is set if the request failed
+// because the server could not be reached, ok if a reply was received.
+// The returned state is the new state if it changed, or -1.
+func (b *breaker) report(now time.Time, failed, ok bool, threshold int, cooldown time.Duration) BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	switch {
+	case ok:
+		b.failures = 0
+		if b.state != BreakerClosed {
+			b.state = BreakerClosed
+			return BreakerClosed
+		}
+	case failed:
+		b.failures++
+		if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= threshold) {
+			b.state = BreakerOpen
+			b.until = now.Add(cooldown)
+			return BreakerOpen
+		}
+	}
+	return -1
+}
+
+// breaker returns the circuit breaker for addr, or nil if not enabled.
+func (c *Client) breaker(addr string) *breaker {
+	if c.BreakerThreshold <= 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b := c.breakers[addr]
+	if b == nil {
+		if c.breakers == nil {
+			c.breakers = make(map[string]*breaker)
+		}
+		b = new(breaker)
+		c.breakers[addr] = b
+	}
+	return b
+}
+
+func (c *Client) breakerChange(addr string, s BreakerState) {
+	if s >= 0 && c.OnBreakerChange != nil {
+		c.OnBreakerChange(addr, s)
+	}
+}
+
+// breakerAllow returns ErrCircuitOpen if requests to addr should not be attempted.
+func (c *Client) breakerAllow(addr string) error {
+	b := c.breaker(addr)
+	if b == nil {
+		return nil
+	}
+	ok, s := b.allow(time.Now())
+	c.breakerChange(addr, s)
+	if !ok {
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// breakerReport records the result err of a request to addr.
+func (c *Client) breakerReport(addr string, err error) {
+	b := c.breaker(addr)
+	if b == nil {
+		return
+	}
+	var de *dialError
+	var ce *ConnClosedError
+	failed := errors.As(err, &de) || (errors.As(err, &ce) && !ce.Reason.local())
+	s := b.report(time.Now(), failed, err == nil, c.BreakerThreshold, c.BreakerCooldown)
+	c.breakerChange(addr, s)
+}
+
+// dialError is an error creating a new connection.
+type dialError struct {
+	err error
+}
+
+func (e *dialError) Error() string { return e.err.Error() }
+func (e *dialError) Unwrap() error { return e.err }
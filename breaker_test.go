@@ -0,0 +1,87 @@
+//go:build !tacplus_nomd5
+
+package tacplus
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientBreaker(t *testing.T) {
+	l, c, err := newTestInstance(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.close()
+	defer c.Close()
+
+	var mu sync.Mutex
+	down := true
+	dials := 0
+	var states []BreakerState
+	c.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		dials++
+		if down {
+			return nil, errors.New("connection refused")
+		}
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+	c.BreakerThreshold = 2
+	c.BreakerCooldown = 2 * timeScale
+	c.OnBreakerChange = func(addr string, s BreakerState) {
+		if addr != c.Addr {
+			t.Errorf("unexpected address %s", addr)
+		}
+		mu.Lock()
+		states = append(states, s)
+		mu.Unlock()
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 4; i++ {
+		_, err = c.SendAcctRequest(ctx, testAcctReq)
+		if err == nil {
+			t.Fatal("unexpected request success")
+		}
+	}
+	if err != ErrCircuitOpen {
+		t.Errorf("want %v: got %v", ErrCircuitOpen, err)
+	}
+	if dials != 2 {
+		t.Errorf("want 2 dials: got %d", dials)
+	}
+
+	// failed test request reopens the circuit
+	time.Sleep(3 * timeScale)
+	if _, err = c.SendAcctRequest(ctx, testAcctReq); err == nil || err == ErrCircuitOpen {
+		t.Errorf("want dial error: got %v", err)
+	}
+
+	// successful test request closes the circuit
+	mu.Lock()
+	down = false
+	mu.Unlock()
+	time.Sleep(3 * timeScale)
+	if _, err = c.SendAcctRequest(ctx, testAcctReq); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerOpen, BreakerHalfOpen, BreakerClosed}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(states) != len(want) {
+		t.Fatalf("want states %v: got %v", want, states)
+	}
+	for i := range want {
+		if states[i] != want[i] {
+			t.Fatalf("want states %v: got %v", want, states)
+		}
+	}
+}
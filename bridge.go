@@ -0,0 +1,58 @@
+package tacplus
+
+import "context"
+
+// NewRekeyBridge returns a ServerConnHandler that accepts requests using the
+// secrets in cfg and forwards them to the server at addr using secret instead.
+// It allows devices to keep using an old secret while the server is moved to a
+// new one, or the reverse, during a staged secret rotation.
+//
+// Requests are forwarded with a Proxy. Sessions of other packet types are relayed
+// packet by packet until either side closes the session, or the HandlerTimeout of
+// cfg expires. The upstream connection uses the multiplexing settings and the
+// idle, read and write timeouts of cfg; server settings such as OnDeny and
+// Banner apply only to the accepted connections.
+func NewRekeyBridge(cfg ConnConfig, addr string, secret []byte) *ServerConnHandler {
+	up := &Client{Addr: addr, ConnConfig: ConnConfig{
+		Mux:          cfg.Mux,
+		LegacyMux:    cfg.LegacyMux,
+		Secret:       secret,
+		IdleTimeout:  cfg.IdleTimeout,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+	}}
+	return &ServerConnHandler{
+		Handler:    &Proxy{Upstream: up},
+		ConnConfig: cfg,
+		RawHandler: relayRaw(up),
+	}
+}
+
+// relayRaw returns a RawHandler that relays sessions to a server using c.
+func relayRaw(c *Client) RawHandler {
+	return func(ctx context.Context, p *RawPacket, s *RawSession) {
+		us, err := c.NewRawSession(ctx, p.Version, p.Type)
+		if err != nil {
+			s.Log(err)
+			return
+		}
+		defer us.Close()
+
+		// packets alternate between client and server
+		for {
+			if err = us.WritePacket(ctx, p.Body); err != nil {
+				return
+			}
+			up, err := us.ReadPacket(ctx)
+			if err != nil {
+				return
+			}
+			if err = s.WritePacket(ctx, up.Body); err != nil {
+				return
+			}
+			if p, err = s.ReadPacket(ctx); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+//go:build !tacplus_nomd5
+
+package tacplus
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRekeyBridge(t *testing.T) {
+	h := testHandler
+	h.Custom = map[uint8]CustomHandler{
+		testCustomType: {
+			NewRequest: func() Packet { return new(testMessage) },
+			Handle: func(ctx context.Context, req Packet, s *RawSession) (Packet, error) {
+				return req, nil
+			},
+		},
+	}
+	s, up, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	oldSecret := []byte("old secret")
+	bridge := NewRekeyBridge(ConnConfig{Secret: oldSecret, Log: s.log}, up.Addr, testSecret)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() { _ = (&Server{ServeConn: bridge.Serve}).Serve(l) }()
+
+	c := &Client{Addr: l.Addr().String(), ConnConfig: ConnConfig{Secret: oldSecret}}
+	defer c.Close()
+	ctx := context.Background()
+	resp, err := c.SendAuthorRequest(ctx, testAuthorReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != AuthorStatusPassAdd {
+		t.Errorf("got status %d, want %d", resp.Status, AuthorStatusPassAdd)
+	}
+	rep, err := authenticate(ctx, c, "user", "password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Status != AuthenStatusPass {
+		t.Errorf("got authentication status %d, want %d", rep.Status, AuthenStatusPass)
+	}
+	msg := new(testMessage)
+	if err = c.SendCustom(ctx, verDefault, testCustomType, &testMessage{"custom"}, msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.s != "custom" {
+		t.Errorf("got custom reply %q, want %q", msg.s, "custom")
+	}
+	if err = s.err(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRekeyBridgeUpstreamConfig(t *testing.T) {
+	cfg := ConnConfig{
+		Mux:            true,
+		LegacyMux:      true,
+		Secrets:        []KeyedSecret{{ID: "old", Secret: []byte("old secret")}},
+		IdleTimeout:    time.Minute,
+		ReadTimeout:    time.Second,
+		WriteTimeout:   2 * time.Second,
+		HandlerTimeout: time.Second,
+		Banner:         "authorized use only",
+		OnDeny:         func(Denial) {},
+		PacketTrace:    func(TracedPacket) {},
+	}
+	bridge := NewRekeyBridge(cfg, "127.0.0.1:49", testSecret)
+	up := bridge.Handler.(*Proxy).Upstream.(*Client).ConnConfig
+	if !up.Mux || !up.LegacyMux || up.IdleTimeout != cfg.IdleTimeout ||
+		up.ReadTimeout != cfg.ReadTimeout || up.WriteTimeout != cfg.WriteTimeout {
+		t.Errorf("transport settings not copied upstream: %+v", up)
+	}
+	if string(up.Secret) != string(testSecret) || up.Secrets != nil {
+		t.Errorf("got upstream secrets %q %v, want %q", up.Secret, up.Secrets, testSecret)
+	}
+	if up.HandlerTimeout != 0 || up.Banner != "" || up.OnDeny != nil || up.PacketTrace != nil {
+		t.Errorf("server settings copied upstream: %+v", up)
+	}
+	if bridge.ConnConfig.Banner != cfg.Banner || bridge.ConnConfig.OnDeny == nil {
+		t.Error("server settings not kept for accepted connections")
+	}
+}
@@ -0,0 +1,134 @@
+package tacplus
+
+import "sync"
+
+// BufferSizes are the initial sizes of the buffers used to build packets sent
+// by each session type. A client uses them for requests and a server uses them
+// as the minimum size of its reply buffers. Packets larger than the buffer
+// still work, but cause an extra allocation. Zero values use the defaults of
+// 256 bytes for authentication, 1024 bytes for authorization and 512 bytes for
+// accounting.
+type BufferSizes struct {
+	Authen int
+	Author int
+	Acct   int
+}
+
+// bufferSize returns the initial packet buffer size for session type t.
+func (c *ConnConfig) bufferSize(t uint8) int {
+	var n, def int
+	switch t {
+	case sessTypeAuthen:
+		n, def = c.BufferSizes.Authen, 256
+	case sessTypeAuthor:
+		n, def = c.BufferSizes.Author, 1024
+	case sessTypeAcct:
+		n, def = c.BufferSizes.Acct, 512
+	default:
+		def = 1024
+	}
+	if n > 0 {
+		return n
+	}
+	return def
+}
+
+// Packet buffers are pooled by size class, so memory held by the pools follows
+// the sizes of packets actually being sent and received.
+var bufClasses = [...]int{256, 1024, 4096, 16384, 65536, hdrLen + maxBodyLen}
+
+var bufPools [len(bufClasses)]sync.Pool
+
+// getBuf returns an empty buffer with a capacity of at least n bytes.
+func getBuf(n int) []byte {
+	for i, size := range bufClasses {
+		if n <= size {
+			if b, ok := bufPools[i].Get().(*[]byte); ok {
+				return (*b)[:0]
+			}
+			return make([]byte, 0, size)
+		}
+	}
+	return make([]byte, 0, n)
+}
+
+// putBuf returns b to the pool for the largest size class it can hold.
+// b must not be used after the call.
+func putBuf(b []byte) {
+	for i := len(bufClasses) - 1; i >= 0; i-- {
+		if cap(b) >= bufClasses[i] {
+			b = b[:0]
+			bufPools[i].Put(&b)
+			return
+		}
+	}
+}
+
+// zeroHdr is used to start a new packet with a cleared header.
+var zeroHdr [hdrLen]byte
+
+// budget limits the bytes of packets buffered by a connection. A nil budget
+// has no limit.
+type budget struct {
+	max int
+
+	mu    sync.Mutex
+	used  int
+	avail chan struct{} // closed when used is back within max
+}
+
+func newBudget(max int) *budget {
+	if max <= 0 {
+		return nil
+	}
+	return &budget{max: max}
+}
+
+// add counts n more bytes as buffered.
+func (b *budget) add(n int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.used += n
+	b.mu.Unlock()
+}
+
+// release stops counting n buffered bytes, waking any waiter if the budget
+// is no longer exceeded.
+func (b *budget) release(n int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.used -= n
+	if b.used <= b.max && b.avail != nil {
+		close(b.avail)
+		b.avail = nil
+	}
+	b.mu.Unlock()
+}
+
+// wait blocks while the budget is exceeded. It returns false if done is
+// closed first.
+func (b *budget) wait(done <-chan struct{}) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	if b.used <= b.max {
+		b.mu.Unlock()
+		return true
+	}
+	if b.avail == nil {
+		b.avail = make(chan struct{})
+	}
+	avail := b.avail
+	b.mu.Unlock()
+	select {
+	case <-avail:
+		return true
+	case <-done:
+		return false
+	}
+}
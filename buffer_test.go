@@ -0,0 +1,157 @@
+package tacplus
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+// blockAcctHandler blocks accounting requests until unblock is closed.
+type blockAcctHandler struct {
+	RequestHandler
+	started chan struct{}
+	unblock chan struct{}
+}
+
+func (h *blockAcctHandler) HandleAcctRequest(ctx context.Context, a *AcctRequest, s *ServerSession) *AcctReply {
+	close(h.started)
+	<-h.unblock
+	return &AcctReply{Status: AcctStatusSuccess}
+}
+
+// testAcctPacket returns an encrypted accounting request packet for session 1.
+func testAcctPacket(req *AcctRequest, seq uint8) []byte {
+	p, _ := req.marshal(make([]byte, hdrLen))
+	p[hdrVer] = verDefault
+	p[hdrType] = sessTypeAcct
+	p[hdrSeqNo] = seq
+	binary.BigEndian.PutUint32(p[hdrID:], 1)
+	binary.BigEndian.PutUint32(p[hdrBodyLen:], uint32(len(p)-hdrLen))
+	crypt(p, testSecret)
+	return p
+}
+
+func TestBufferSize(t *testing.T) {
+	var c ConnConfig
+	if n := c.bufferSize(sessTypeAcct); n != 512 {
+		t.Errorf("default acct size = %d, want 512", n)
+	}
+	c.BufferSizes.Author = 8192
+	if n := c.bufferSize(sessTypeAuthor); n != 8192 {
+		t.Errorf("author size = %d, want 8192", n)
+	}
+}
+
+func TestBufPool(t *testing.T) {
+	for _, n := range []int{1, 256, 257, 5000, hdrLen + maxBodyLen} {
+		b := getBuf(n)
+		if len(b) != 0 || cap(b) < n {
+			t.Fatalf("getBuf(%d) len %d cap %d", n, len(b), cap(b))
+		}
+		putBuf(append(b, 1))
+	}
+	// buffers grown past a size class are reused for that class
+	putBuf(make([]byte, 3000))
+	if b := getBuf(1024); cap(b) < 1024 {
+		t.Errorf("getBuf(1024) cap %d", cap(b))
+	}
+}
+
+func TestSmallBufferSizes(t *testing.T) {
+	skipWithoutMD5(t)
+	h := testHandler
+	h.ConnConfig.BufferSizes = BufferSizes{Authen: 1, Author: 1, Acct: 1}
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	c.ConnConfig.BufferSizes = h.ConnConfig.BufferSizes
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		resp, err := c.SendAuthorRequest(ctx, testAuthorReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != AuthorStatusPassAdd || len(resp.Arg) != 1 || resp.Arg[0] != "priv-lvl=5" {
+			t.Errorf("unexpected response %+v", resp)
+		}
+		if _, err = c.SendAcctRequest(ctx, testAcctReq); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.err(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBudget(t *testing.T) {
+	b := newBudget(10)
+	b.add(20)
+	done := make(chan struct{})
+	waited := make(chan bool)
+	go func() { waited <- b.wait(done) }()
+	select {
+	case <-waited:
+		t.Fatal("wait returned while budget exceeded")
+	case <-time.After(timeScale):
+	}
+	b.release(15)
+	if !<-waited {
+		t.Error("wait returned false after release")
+	}
+	b.add(10)
+	close(done)
+	if b.wait(done) {
+		t.Error("wait returned true after done closed")
+	}
+	if newBudget(0).wait(nil) != true {
+		t.Error("nil budget wait returned false")
+	}
+}
+
+func TestMaxBufferedBytes(t *testing.T) {
+	skipWithoutMD5(t)
+	bh := &blockAcctHandler{
+		RequestHandler: testHandler.Handler,
+		started:        make(chan struct{}),
+		unblock:        make(chan struct{}),
+	}
+	h := &ServerConnHandler{Handler: bh, ConnConfig: testHandler.ConnConfig}
+	h.ConnConfig.MaxBufferedBytes = 100
+
+	cc, sc := net.Pipe()
+	defer cc.Close()
+	go h.Serve(sc)
+
+	req := *testAcctReq
+	req.Arg = []string{"data=" + string(make([]byte, 200))}
+	packet := func(seq uint8) []byte { return testAcctPacket(&req, seq) }
+
+	if _, err := cc.Write(packet(1)); err != nil {
+		t.Fatal(err)
+	}
+	<-bh.started
+	// queued while the handler is busy, exceeding the budget
+	if _, err := cc.Write(packet(3)); err != nil {
+		t.Fatal(err)
+	}
+	// reading has paused, so this write can't complete
+	_ = cc.SetWriteDeadline(time.Now().Add(2 * timeScale))
+	p := packet(5)
+	n, err := cc.Write(p)
+	if err == nil {
+		t.Fatal("write completed while budget exceeded")
+	}
+	p = p[n:]
+	// finishing the session frees the queued packet and reading resumes
+	close(bh.unblock)
+	_ = cc.SetDeadline(time.Now().Add(10 * timeScale))
+	go func() { _, _ = cc.Write(p) }()
+	if _, err = cc.Read(make([]byte, 1024)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,234 @@
+package tacplus
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	pcapLinkTypeRaw   = 101     // raw IPv4 or IPv6 packets
+	defaultCaptureMax = 1 << 20 // default PacketCapture buffer size
+	maxSegment        = 65000   // maximum TCP payload per captured packet
+)
+
+// PacketCapture records the raw, still encrypted, network traffic of selected
+// connections into an in-memory ring buffer, which can be written out as a pcap
+// file for analysis with tools such as Wireshark. It allows interoperability
+// problems to be captured without access to tcpdump.
+//
+// Connections are selected by wrapping them with Conn, for example in the
+// Server ServeConn function. Traffic is only recorded while the capture is enabled.
+type PacketCapture struct {
+	// Maximum number of bytes of packets kept. The oldest packets are
+	// discarded when it is exceeded. Defaults to 1MiB if zero.
+	MaxBytes int
+
+	// Optional function to select connections to capture by remote address.
+	Filter func(remote net.Addr) bool
+
+	mu      sync.Mutex
+	enabled bool
+	records [][]byte
+	size    int
+}
+
+// SetEnabled starts or stops recording traffic.
+func (pc *PacketCapture) SetEnabled(enabled bool) {
+	pc.mu.Lock()
+	pc.enabled = enabled
+	pc.mu.Unlock()
+}
+
+// Reset discards all recorded packets.
+func (pc *PacketCapture) Reset() {
+	pc.mu.Lock()
+	pc.records = nil
+	pc.size = 0
+	pc.mu.Unlock()
+}
+
+// Conn returns nc wrapped so its traffic is recorded, or nc if it
+// isn't selected by Filter.
+func (pc *PacketCapture) Conn(nc net.Conn) net.Conn {
+	if pc.Filter != nil && !pc.Filter(nc.RemoteAddr()) {
+		return nc
+	}
+	return &captureConn{
+		Conn:   nc,
+		pc:     pc,
+		local:  endpointOf(nc.LocalAddr()),
+		remote: endpointOf(nc.RemoteAddr()),
+	}
+}
+
+// WriteTo writes the recorded packets to w as a pcap file.
+func (pc *PacketCapture) WriteTo(w io.Writer) (int64, error) {
+	pc.mu.Lock()
+	records := append([][]byte(nil), pc.records...)
+	pc.mu.Unlock()
+
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr[0:], 0xa1b2c3d4) // magic
+	binary.LittleEndian.PutUint16(hdr[4:], 2)          // version major
+	binary.LittleEndian.PutUint16(hdr[6:], 4)          // version minor
+	binary.LittleEndian.PutUint32(hdr[16:], 1<<18)     // snap length
+	binary.LittleEndian.PutUint32(hdr[20:], pcapLinkTypeRaw)
+	n, err := w.Write(hdr)
+	total := int64(n)
+	for _, r := range records {
+		if err != nil {
+			break
+		}
+		n, err = w.Write(r)
+		total += int64(n)
+	}
+	return total, err
+}
+
+// add records a pcap record if the capture is enabled.
+func (pc *PacketCapture) add(r []byte) {
+	max := pc.MaxBytes
+	if max <= 0 {
+		max = defaultCaptureMax
+	}
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.records = append(pc.records, r)
+	pc.size += len(r)
+	for pc.size > max && len(pc.records) > 0 {
+		pc.size -= len(pc.records[0])
+		pc.records[0] = nil
+		pc.records = pc.records[1:]
+	}
+}
+
+func (pc *PacketCapture) isEnabled() bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.enabled
+}
+
+// endpoint is an IP address and port of a captured connection.
+type endpoint struct {
+	ip   net.IP
+	port uint16
+	seq  uint32 // next TCP sequence number sent from this endpoint
+}
+
+func endpointOf(addr net.Addr) *endpoint {
+	e := &endpoint{ip: net.IPv4zero.To4()}
+	if ta, ok := addr.(*net.TCPAddr); ok {
+		if ip4 := ta.IP.To4(); ip4 != nil {
+			e.ip = ip4
+		} else if ta.IP != nil {
+			e.ip = ta.IP.To16()
+		}
+		e.port = uint16(ta.Port)
+	}
+	return e
+}
+
+// captureConn is a net.Conn whose traffic is recorded by a PacketCapture.
+type captureConn struct {
+	net.Conn
+	pc *PacketCapture
+
+	mu            sync.Mutex // protects the endpoints
+	local, remote *endpoint
+}
+
+func (c *captureConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.record(c.remote, c.local, b[:n])
+	}
+	return n, err
+}
+
+func (c *captureConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.record(c.local, c.remote, b[:n])
+	}
+	return n, err
+}
+
+// record adds TCP segments carrying data from src to dst to the capture.
+func (c *captureConn) record(src, dst *endpoint, data []byte) {
+	if !c.pc.isEnabled() {
+		return
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(data) > 0 {
+		n := len(data)
+		if n > maxSegment {
+			n = maxSegment
+		}
+		c.pc.add(pcapRecord(now, src, dst, data[:n]))
+		src.seq += uint32(n)
+		data = data[n:]
+	}
+}
+
+// pcapRecord returns a pcap record of an IP packet holding a TCP segment with data.
+func pcapRecord(t time.Time, src, dst *endpoint, data []byte) []byte {
+	v6 := len(src.ip) == net.IPv6len || len(dst.ip) == net.IPv6len
+	ipLen := 20
+	if v6 {
+		ipLen = 40
+	}
+	plen := ipLen + 20 + len(data)
+	b := make([]byte, 16+plen)
+
+	// record header
+	binary.LittleEndian.PutUint32(b[0:], uint32(t.Unix()))
+	binary.LittleEndian.PutUint32(b[4:], uint32(t.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(b[8:], uint32(plen))
+	binary.LittleEndian.PutUint32(b[12:], uint32(plen))
+
+	ip := b[16:]
+	if v6 {
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:], uint16(20+len(data)))
+		ip[6] = 6 // TCP
+		ip[7] = 64
+		copy(ip[8:24], src.ip.To16())
+		copy(ip[24:40], dst.ip.To16())
+	} else {
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(plen))
+		ip[6] = 0x40 // don't fragment
+		ip[8] = 64
+		ip[9] = 6 // TCP
+		copy(ip[12:16], src.ip)
+		copy(ip[16:20], dst.ip)
+		binary.BigEndian.PutUint16(ip[10:], ipChecksum(ip[:20]))
+	}
+
+	tcp := ip[ipLen:]
+	binary.BigEndian.PutUint16(tcp[0:], src.port)
+	binary.BigEndian.PutUint16(tcp[2:], dst.port)
+	binary.BigEndian.PutUint32(tcp[4:], src.seq)
+	binary.BigEndian.PutUint32(tcp[8:], dst.seq)
+	tcp[12] = 5 << 4
+	tcp[13] = 0x18 // PSH, ACK
+	binary.BigEndian.PutUint16(tcp[14:], 0xffff)
+	copy(tcp[20:], data)
+	return b
+}
+
+func ipChecksum(h []byte) uint16 {
+	var sum uint32
+	for i := 0; i < len(h); i += 2 {
+		sum += uint32(h[i])<<8 | uint32(h[i+1])
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,75 @@
+//go:build !tacplus_nomd5
+
+package tacplus
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func TestPacketCapture(t *testing.T) {
+	pc := &PacketCapture{}
+	pc.SetEnabled(true)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	h := testHandler
+	srv := &Server{ServeConn: func(nc net.Conn) { h.Serve(pc.Conn(nc)) }}
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Close()
+
+	c := &Client{Addr: l.Addr().String(), ConnConfig: ConnConfig{Secret: testSecret}}
+	if _, err = c.SendAcctRequest(context.Background(), testAcctReq); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if _, err = pc.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	if len(b) < 24 || binary.LittleEndian.Uint32(b) != 0xa1b2c3d4 || binary.LittleEndian.Uint32(b[20:]) != pcapLinkTypeRaw {
+		t.Fatal("bad pcap header")
+	}
+	b = b[24:]
+	var payload []byte
+	var ports []uint16
+	for len(b) > 0 {
+		n := int(binary.LittleEndian.Uint32(b[8:]))
+		ip := b[16 : 16+n]
+		if ip[0] != 0x45 || ipChecksum(ip[:20]) != 0 {
+			t.Fatal("bad IPv4 header")
+		}
+		tcp := ip[20:]
+		ports = append(ports, binary.BigEndian.Uint16(tcp[2:]))
+		payload = append(payload, tcp[20:]...)
+		b = b[16+n:]
+	}
+	// request read by server then reply written
+	if len(ports) < 2 || ports[0] != uint16(l.Addr().(*net.TCPAddr).Port) {
+		t.Errorf("unexpected destination ports %v", ports)
+	}
+	hdr, err := ParseHeader(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Type != TypeAcct || hdr.SeqNo != 1 {
+		t.Errorf("unexpected captured header %+v", hdr)
+	}
+
+	pc.Reset()
+	pc.SetEnabled(false)
+	if _, err = c.SendAcctRequest(context.Background(), testAcctReq); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if n, _ := pc.WriteTo(&buf); n != 24 {
+		t.Errorf("captured %d bytes while disabled", n-24)
+	}
+}
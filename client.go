@@ -2,10 +2,14 @@ package tacplus
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"net"
+	"net/url"
 	"sync"
+	"time"
 )
 
 // ClientSession is a TACACS+ client session.
@@ -16,6 +20,9 @@ type ClientSession struct {
 
 // Close closes the client session.
 func (c *ClientSession) Close() {
+	if c.p != nil {
+		putBuf(c.p)
+	}
 	c.p = nil
 	c.close()
 }
@@ -36,7 +43,7 @@ func (c *ClientSession) Continue(ctx context.Context, msg string) (*AuthenReply,
 	// sequence number too large to continue
 	if c.seq >= 0xfe {
 		_ = c.Abort(ctx, "")
-		return nil, errors.New("session aborted, too many packets")
+		return nil, errSeqOverflow
 	}
 
 	rep := new(AuthenReply)
@@ -50,6 +57,28 @@ func (c *ClientSession) Continue(ctx context.Context, msg string) (*AuthenReply,
 	return rep, nil
 }
 
+// RemoteAddr returns the remote network address of the server for the session.
+func (c *ClientSession) RemoteAddr() net.Addr {
+	return c.session.c.nc.RemoteAddr()
+}
+
+// LocalAddr returns the local network address for the session.
+func (c *ClientSession) LocalAddr() net.Addr {
+	return c.session.c.nc.LocalAddr()
+}
+
+// ConnectionState returns the TLS connection state for the session,
+// or nil if the session is not using a TLS connection.
+func (c *ClientSession) ConnectionState() *tls.ConnectionState {
+	return c.session.c.tlsState()
+}
+
+// SecretID returns the ID of the KeyedSecret used by the session,
+// or an empty string if the ConnConfig Secret is being used.
+func (c *ClientSession) SecretID() string {
+	return c.keyID()
+}
+
 func (c *ClientSession) sendRequest(ctx context.Context, req, rep packet) error {
 	if c.p == nil {
 		return errSessionClosed
@@ -59,12 +88,29 @@ func (c *ClientSession) sendRequest(ctx context.Context, req, rep packet) error
 		return err
 	}
 	err = c.writePacket(ctx, p)
-	if err != nil || rep == nil {
+	if err != nil {
+		// the packet may still be queued for writing, so don't reuse it
+		c.p = nil
 		return err
 	}
+	if rep == nil {
+		return nil
+	}
+	putBuf(p)
 	c.p, err = c.readPacket(ctx)
+	if rt := timingFromContext(ctx); rt != nil && rt.FirstByte == 0 {
+		rt.FirstByte = time.Since(rt.start)
+	}
 	if err == nil {
-		err = rep.unmarshal(c.p[hdrLen:])
+		if ar, ok := rep.(*AcctReply); ok && len(c.p) == hdrLen && c.c.quirks.Has(QuirkEmptyAcctReply) {
+			*ar = AcctReply{Status: AcctStatusSuccess}
+			return nil
+		}
+		if err = c.c.truncated(c.p, rep.unmarshal(c.p[hdrLen:])); err != nil {
+			err = c.c.decodeError(c.p, err)
+		} else if ar, ok := rep.(*AuthenReply); ok && c.c.StrictFlags {
+			err = ar.checkFlags()
+		}
 	}
 	return err
 }
@@ -80,89 +126,296 @@ type Client struct {
 	// Optional DialContext function used to create the network connection.
 	DialContext func(ctx context.Context, net, addr string) (net.Conn, error)
 
-	mu   sync.Mutex // protects access to conn
-	conn *conn      // current cached mux connection
+	// Optional function returning the proxy to connect to Addr through, or nil
+	// for a direct connection. Proxies are reached with DialContext if it is set.
+	// Supported proxy URL schemes are socks5, socks5h and http, using CONNECT.
+	// ProxyFromEnvironment uses the standard environment variables.
+	Proxy func(addr string) (*url.URL, error)
+
+	// By default if the cached connection closes before the first reply of an
+	// authorization or accounting session is read, the request is retried once
+	// on a new connection. Authentication starts aren't retried, as a server may
+	// count them, such as against a one-time password. Set NoRetry to disable this.
+	NoRetry bool
+
+	// Optional circuit breaker. If BreakerThreshold is set, after that many consecutive
+	// connection failures to a server address, requests to it fail with ErrCircuitOpen
+	// until BreakerCooldown has passed. A single request is then let through to test the
+	// server, closing the circuit if it succeeds.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// Optional function called when the circuit breaker state for an address changes.
+	OnBreakerChange func(addr string, state BreakerState)
+
+	// Optional functions called when a connection to the server is opened and closed.
+	// OnConnect is also called if the connection fails, with the error set.
+	OnConnect    func(ConnEvent)
+	OnDisconnect func(ConnEvent)
+
+	// Optional function returning a key for the request context ctx. Multiplexed
+	// connections are only shared by requests with the same key, so DialContext
+	// and the connection hooks see the context values of a request with each key,
+	// allowing multi-tenant clients to choose credentials or routes per request.
+	ConnKey func(ctx context.Context) string
+
+	// Optional function called by SendAuthenStartAuto with a banner sent by the
+	// server as a GetData prompt, echoing the answer, before the first GetUser or
+	// GetPass prompt, such as a server with BannerPrompt set. The prompt is
+	// answered with an empty message. If not set the prompt is unexpected.
+	OnBanner func(banner string)
+
+	// Optional function called with the timing of each request once its first
+	// reply is received or it fails.
+	OnRequest func(RequestTiming)
+
+	// Requests taking longer than SlowRequestThreshold are logged with the
+	// ConnConfig Log function. Ignored if zero.
+	SlowRequestThreshold time.Duration
+
+	// Maximum number of sessions in progress at once. Further requests wait in
+	// order for a session to finish, or until their context is done.
+	// It must not be changed after the first request. Ignored if zero.
+	MaxSessions int
+
+	// Interval between watchdog accounting updates sent for tasks started with
+	// StartTask. Tasks get no watchdog updates if zero.
+	WatchdogInterval time.Duration
+
+	// Optional function called when a watchdog update fails. If not set errors
+	// are logged with the ConnConfig Log function.
+	OnWatchdogError func(t *AcctTask, err error)
+
+	// Optional name of an argument carrying the correlation ID of the request
+	// context, set with WithTraceID, in authorization and accounting requests,
+	// such as "trace-id". Requests that already have the argument are unchanged.
+	TraceArg string
+
+	mu       sync.Mutex          // protects access to conns, breakers and sem
+	conns    map[string]*conn    // cached mux connections by ConnKey
+	breakers map[string]*breaker // circuit breakers by server address
+	sem      chan struct{}       // limits sessions in progress to MaxSessions
+
+	statsMu sync.Mutex  // protects stats
+	stats   ClientStats // request latency histograms
 }
 
-// Close closes the cached connection.
+// Close closes the cached connections.
 func (c *Client) Close() {
 	c.mu.Lock()
-	conn := c.conn
+	conns := make([]*conn, 0, len(c.conns))
+	for _, conn := range c.conns {
+		conns = append(conns, conn)
+	}
 	c.mu.Unlock()
-	if conn != nil {
+	for _, conn := range conns {
 		conn.close()
 	}
 }
 
+// ConnEvent describes a Client or server connection being opened or closed.
+type ConnEvent struct {
+	Addr     string        // server address, or client address for a server connection
+	Mux      bool          // connection allows session multiplexing
+	Duration time.Duration // time taken to connect, or time connected for
+	Err      error         // connection error or close reason
+
+	// Reason the connection closed, and the number of sessions started on it,
+	// when it is closed.
+	Reason   CloseReason
+	Sessions int
+
+	// Context of the request that opened the connection, carrying its values,
+	// or the context of a server connection.
+	Context context.Context
+}
+
 var zeroDialer net.Dialer
 
 func (c *Client) dial(ctx context.Context) (net.Conn, error) {
-	if c.DialContext != nil {
-		return c.DialContext(ctx, "tcp", c.Addr)
+	dial := c.DialContext
+	if dial == nil {
+		dial = zeroDialer.DialContext
 	}
-	return zeroDialer.DialContext(ctx, "tcp", c.Addr)
+	if c.Proxy != nil {
+		u, err := c.Proxy(c.Addr)
+		if err != nil {
+			return nil, err
+		}
+		if u != nil {
+			return dialProxy(ctx, dial, u, c.Addr)
+		}
+	}
+	return dial(ctx, "tcp", c.Addr)
 }
 
-func (c *Client) newSession(ctx context.Context) (*session, error) {
+// newSession creates a new session, using the cached multiplexed connection if
+// useCache is set. It reports whether the cached connection was used.
+func (c *Client) newSession(ctx context.Context, useCache bool) (*session, bool, error) {
 	mux := c.ConnConfig.Mux || c.ConnConfig.LegacyMux
-	if mux {
+	var key string
+	if c.ConnKey != nil {
+		key = c.ConnKey(ctx)
+	}
+	if mux && useCache {
 		// try to use existing cached connection
 		c.mu.Lock()
-		conn := c.conn
+		conn := c.conns[key]
 		c.mu.Unlock()
 		if conn != nil {
 			if s, _ := conn.newClientSession(ctx); s != nil {
-				return s, nil
+				return s, true, nil
 			}
 		}
 	}
 
 	// create new connection
+	addr := c.Addr
+	start := time.Now()
 	nc, err := c.dial(ctx)
+	if rt := timingFromContext(ctx); rt != nil {
+		rt.Dial += time.Since(start)
+	}
+	if c.OnConnect != nil {
+		c.OnConnect(ConnEvent{Addr: addr, Mux: mux, Duration: time.Since(start), Err: err, Context: ctx})
+	}
 	if err != nil {
-		return nil, err
+		return nil, false, &dialError{err}
 	}
 	conn := newConn(nc, nil, c.ConnConfig)
+	if c.OnDisconnect != nil {
+		start = time.Now()
+		conn.onClose = func(err error) {
+			c.OnDisconnect(ConnEvent{Addr: addr, Mux: mux, Duration: time.Since(start), Err: err,
+				Reason: closeReason(err), Sessions: conn.started, Context: ctx})
+		}
+	}
 	go conn.serve()
 
 	s, err := conn.newClientSession(ctx)
 	if err != nil {
 		conn.close()
-		return nil, err
+		return nil, false, err
+	}
+	if mux && !c.cacheConn(key, conn) {
+		// already cached one connection, so create goroutine
+		// that closes connection when session is closed so
+		// we don't leak idle connections.
+		go func() {
+			<-s.done
+			conn.close()
+		}()
 	}
-	if mux {
-		// attempt to cache multiplexed connection
+	return s, false, nil
+}
+
+// cacheConn caches the multiplexed connection cn for key, unless an open
+// connection is already cached. It reports whether cn was cached.
+func (c *Client) cacheConn(key string, cn *conn) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cached := c.conns[key]; cached != nil && !cached.closed() {
+		return false
+	}
+	if c.conns == nil {
+		c.conns = make(map[string]*conn)
+	}
+	c.conns[key] = cn
+	go func() {
+		// clear cached reference when cn closes
+		<-cn.done
 		c.mu.Lock()
-		defer c.mu.Unlock()
-		if c.conn == nil {
-			// cache this connection
-			c.conn = conn
-			go func() {
-				// clear cached reference when conn closes
-				<-conn.done
-				c.mu.Lock()
-				c.conn = nil
-				c.mu.Unlock()
-			}()
-		} else {
-			// already cached one connection, so create goroutine
-			// that closes connection when session is closed so
-			// we don't leak idle connections.
-			go func() {
-				<-s.done
-				conn.close()
-			}()
+		if c.conns[key] == cn {
+			delete(c.conns, key)
 		}
+		c.mu.Unlock()
+	}()
+	return true
+}
+
+// retryable returns whether a start of a session of type t that failed with
+// err should be retried on a new connection.
+func (c *Client) retryable(ctx context.Context, t uint8, err error) bool {
+	if c.NoRetry || (t != sessTypeAuthor && t != sessTypeAcct) || ctx.Err() != nil {
+		return false
+	}
+	var ce *ConnClosedError
+	return errors.As(err, &ce) && !ce.Reason.local()
+}
+
+// acquire waits for a free session slot if MaxSessions is set.
+// It returns a function to release the slot.
+func (c *Client) acquire(ctx context.Context) (func(), error) {
+	if c.MaxSessions <= 0 {
+		return func() {}, nil
+	}
+	c.mu.Lock()
+	if c.sem == nil {
+		c.sem = make(chan struct{}, c.MaxSessions)
+	}
+	sem := c.sem
+	c.mu.Unlock()
+
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
-	return s, nil
 }
 
-func (c *Client) startSession(ctx context.Context, ver, t uint8, req, rep packet) (*ClientSession, error) {
-	s, err := c.newSession(ctx)
+func (c *Client) startSession(ctx context.Context, ver, t uint8, req, rep packet) (cs *ClientSession, err error) {
+	if c.OnRequest != nil || c.SlowRequestThreshold > 0 {
+		rt := &RequestTiming{Addr: c.Addr, Type: t, User: requestUser(req), start: time.Now()}
+		ctx = context.WithValue(ctx, requestTimingKey{}, rt)
+		defer func() {
+			rt.Err = err
+			c.reportTiming(rt)
+		}()
+	}
+	release, err := c.acquire(ctx)
 	if err != nil {
 		return nil, err
 	}
-	p := make([]byte, 1024)
+	start := time.Now()
+	cs, err = c.openSession(ctx, ver, t, req, rep)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	c.recordLatency(t, time.Since(start))
+	go func() {
+		<-cs.done
+		release()
+	}()
+	return cs, nil
+}
+
+// openSession starts a session by sending req and reading the reply into rep.
+func (c *Client) openSession(ctx context.Context, ver, t uint8, req, rep packet) (*ClientSession, error) {
+	addr := c.Addr
+	if err := c.breakerAllow(addr); err != nil {
+		return nil, err
+	}
+	cs, cached, err := c.trySession(ctx, ver, t, req, rep, true)
+	if err != nil && cached && c.retryable(ctx, t, err) {
+		// cached connection closed before the request completed,
+		// so retry once on a new connection
+		cs, _, err = c.trySession(ctx, ver, t, req, rep, false)
+	}
+	c.breakerReport(addr, err)
+	if de, ok := err.(*dialError); ok {
+		err = de.err
+	}
+	return cs, err
+}
+
+func (c *Client) trySession(ctx context.Context, ver, t uint8, req, rep packet, useCache bool) (*ClientSession, bool, error) {
+	s, cached, err := c.newSession(ctx, useCache)
+	if err != nil {
+		return nil, false, err
+	}
+	p := append(getBuf(c.ConnConfig.bufferSize(t)), zeroHdr[:]...)
 	p[hdrVer] = ver
 	p[hdrType] = t
 	if s.c.Mux && !s.c.LegacyMux {
@@ -172,30 +425,40 @@ func (c *Client) startSession(ctx context.Context, ver, t uint8, req, rep packet
 	cs := &ClientSession{s, p}
 	if err = cs.sendRequest(ctx, req, rep); err != nil {
 		cs.close()
-		return nil, err
+		return nil, cached, err
 	}
-	return cs, nil
+	return cs, cached, nil
 }
 
 // SendAcctRequest sends an AcctRequest to the server returning an AcctReply or error.
 func (c *Client) SendAcctRequest(ctx context.Context, req *AcctRequest) (*AcctReply, error) {
+	if args := withTraceArg(ctx, c.TraceArg, req.Arg, false); len(args) != len(req.Arg) {
+		r := *req
+		r.Arg = args
+		req = &r
+	}
 	rep := new(AcctReply)
 	s, err := c.startSession(ctx, verDefault, sessTypeAcct, req, rep)
 	if err != nil {
 		return nil, err
 	}
-	s.close()
+	s.Close()
 	return rep, nil
 }
 
 // SendAuthorRequest sends an AuthorRequest to the server returning an AuthorResponse or error.
 func (c *Client) SendAuthorRequest(ctx context.Context, req *AuthorRequest) (*AuthorResponse, error) {
+	if args := withTraceArg(ctx, c.TraceArg, req.Arg, true); len(args) != len(req.Arg) {
+		r := *req
+		r.Arg = args
+		req = &r
+	}
 	resp := new(AuthorResponse)
 	s, err := c.startSession(ctx, verDefault, sessTypeAuthor, req, resp)
 	if err != nil {
 		return nil, err
 	}
-	s.close()
+	s.Close()
 	return resp, nil
 }
 
@@ -209,8 +472,39 @@ func (c *Client) SendAuthenStart(ctx context.Context, as *AuthenStart) (*AuthenR
 		return nil, nil, err
 	}
 	if rep.last() {
-		s.close()
+		s.Close()
 		return rep, nil, nil
 	}
 	return rep, s, nil
 }
+
+// SendAuthenStartAuto sends an AuthenStart to the server, answering the first
+// GetUser prompt with user and the first GetPass prompt with pass, and returns
+// the final AuthenReply. A banner prompt is passed to OnBanner, if set. Any other
+// prompt, or a repeated one, aborts the session with an error.
+func (c *Client) SendAuthenStartAuto(ctx context.Context, as *AuthenStart, user, pass string) (*AuthenReply, error) {
+	rep, s, err := c.SendAuthenStart(ctx, as)
+	if err != nil {
+		return nil, err
+	}
+	var sentBanner, sentUser, sentPass bool
+	for s != nil && !rep.last() {
+		var msg string
+		switch {
+		case c.OnBanner != nil && rep.Status == AuthenStatusGetData && !rep.NoEcho && !sentBanner && !sentUser && !sentPass:
+			c.OnBanner(rep.ServerMsg)
+			sentBanner = true
+		case rep.Status == AuthenStatusGetUser && !sentUser:
+			msg, sentUser = user, true
+		case rep.Status == AuthenStatusGetPass && !sentPass:
+			msg, sentPass = pass, true
+		default:
+			_ = s.Abort(ctx, "unexpected prompt")
+			return nil, fmt.Errorf("%w: %q", errUnexpectedPrompt, rep.ServerMsg)
+		}
+		if rep, err = s.Continue(ctx, msg); err != nil {
+			return nil, err
+		}
+	}
+	return rep, nil
+}
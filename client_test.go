@@ -1,8 +1,12 @@
+//go:build !tacplus_nomd5
+
 package tacplus
 
 import (
 	"context"
+	"errors"
 	"net"
+	"sync"
 	"testing"
 	"time"
 )
@@ -87,6 +91,49 @@ func TestClientSession(t *testing.T) {
 	}
 }
 
+func TestClientAuthenStartAuto(t *testing.T) {
+	l, c, err := newTestInstance(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.close()
+	defer c.Close()
+
+	ctx := context.Background()
+	for _, u := range []struct {
+		name, pass string
+		status     uint8
+	}{
+		{"user", "password321", AuthenStatusFail},
+		{"user", "password123", AuthenStatusPass},
+	} {
+		rep, err := c.SendAuthenStartAuto(ctx, testAuthStart, u.name, u.pass)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rep.Status != u.status {
+			t.Errorf("user=%s pass=%s got status %d, want %d", u.name, u.pass, rep.Status, u.status)
+		}
+	}
+
+	// a handler asking for anything else is aborted
+	ph := &promptLoopHandler{RequestHandler: testHandler.Handler, errs: make(chan error, 1)}
+	h := testHandler
+	h.Handler = ph
+	pl, pc, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pl.close()
+	if _, err = pc.SendAuthenStartAuto(ctx, testAuthStart, "user", "password123"); !errors.Is(err, errUnexpectedPrompt) {
+		t.Errorf("got error %v, want %v", err, errUnexpectedPrompt)
+	}
+	var ae *AbortError
+	if err = <-ph.errs; !errors.As(err, &ae) {
+		t.Errorf("handler got error %v, want *AbortError", err)
+	}
+}
+
 func TestClientRequestTimeout(t *testing.T) {
 	l, c, err := newTestInstance(&delayHandler)
 	if err != nil {
@@ -147,3 +194,268 @@ func TestClientIdleTimeout(t *testing.T) {
 		t.Fatal("unexpected server/client error:", err)
 	}
 }
+
+func TestClientSessionAddr(t *testing.T) {
+	l, c, err := newTestInstance(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.close()
+	defer c.Close()
+
+	_, sess, err := c.SendAuthenStart(context.Background(), testAuthStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Close()
+
+	if a := sess.RemoteAddr().String(); a != c.Addr {
+		t.Errorf("want remote address %s: got %s", c.Addr, a)
+	}
+	if sess.LocalAddr() == nil {
+		t.Error("missing local address")
+	}
+	if cs := sess.ConnectionState(); cs != nil {
+		t.Error("unexpected TLS connection state")
+	}
+}
+
+// dropRequestHandler closes the server connections instead of replying to
+// the first accounting request from user "drop".
+type dropRequestHandler struct {
+	RequestHandler
+	mu      sync.Mutex
+	dropped bool
+	starts  int // authentication starts for drop
+	drop    func()
+}
+
+func (h *dropRequestHandler) HandleAuthenStart(ctx context.Context, a *AuthenStart, s *ServerSession) *AuthenReply {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if a.User == "drop" {
+		h.starts++
+		h.drop()
+		return nil
+	}
+	return h.RequestHandler.HandleAuthenStart(ctx, a, s)
+}
+
+func (h *dropRequestHandler) HandleAcctRequest(ctx context.Context, a *AcctRequest, s *ServerSession) *AcctReply {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if a.User == "drop" && !h.dropped {
+		h.dropped = true
+		h.drop()
+		return nil
+	}
+	return h.RequestHandler.HandleAcctRequest(ctx, a, s)
+}
+
+func TestClientRetry(t *testing.T) {
+	for _, noRetry := range []bool{false, true} {
+		dh := &dropRequestHandler{RequestHandler: testHandler.Handler}
+		h := ServerConnHandler{Handler: dh, ConnConfig: testHandler.ConnConfig}
+		l, c, err := newTestInstance(&h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dh.drop = l.closeConns
+		c.NoRetry = noRetry
+
+		ctx := context.Background()
+		if _, err = c.SendAcctRequest(ctx, testAcctReq); err != nil {
+			t.Fatal(err)
+		}
+		req := *testAcctReq
+		req.User = "drop"
+		_, err = c.SendAcctRequest(ctx, &req)
+		if noRetry {
+			if !errors.Is(err, ErrConnClosed) {
+				t.Errorf("want %v: got %v", ErrConnClosed, err)
+			}
+		} else if err != nil {
+			t.Error("request not retried:", err)
+		} else if n := l.connCount(); n != 2 {
+			t.Errorf("want 2 connections: got %d", n)
+		}
+		c.Close()
+		l.close()
+	}
+}
+
+func TestClientNoAuthenRetry(t *testing.T) {
+	dh := &dropRequestHandler{RequestHandler: testHandler.Handler}
+	h := ServerConnHandler{Handler: dh, ConnConfig: testHandler.ConnConfig}
+	l, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.close()
+	defer c.Close()
+	dh.drop = l.closeConns
+
+	ctx := context.Background()
+	if _, err = c.SendAcctRequest(ctx, testAcctReq); err != nil {
+		t.Fatal(err)
+	}
+	start := *testAuthStart
+	start.User = "drop"
+	if _, _, err = c.SendAuthenStart(ctx, &start); !errors.Is(err, ErrConnClosed) {
+		t.Errorf("want %v: got %v", ErrConnClosed, err)
+	}
+	dh.mu.Lock()
+	defer dh.mu.Unlock()
+	if dh.starts != 1 {
+		t.Errorf("authentication start sent %d times, want 1", dh.starts)
+	}
+}
+
+func TestClientMaxSessions(t *testing.T) {
+	l, c, err := newTestInstance(&delayHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.close()
+	defer c.Close()
+	c.MaxSessions = 1
+
+	ctx := context.Background()
+	_, sess, err := c.SendAuthenStart(ctx, testAuthStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// session slot is held by the interactive session
+	tctx, cancel := context.WithTimeout(ctx, timeScale)
+	_, err = c.SendAcctRequest(tctx, testAcctReq)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Fatalf("want %v: got %v", context.DeadlineExceeded, err)
+	}
+
+	sess.Close()
+	if _, err = c.SendAcctRequest(ctx, testAcctReq); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.err(); err != nil {
+		t.Fatal("unexpected server/client error:", err)
+	}
+}
+
+func TestClientConnEvents(t *testing.T) {
+	l, c, err := newTestInstance(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.close()
+	defer c.Close()
+
+	connected := make(chan ConnEvent, 1)
+	disconnected := make(chan ConnEvent, 1)
+	c.OnConnect = func(e ConnEvent) { connected <- e }
+	c.OnDisconnect = func(e ConnEvent) { disconnected <- e }
+
+	if _, err = c.SendAcctRequest(context.Background(), testAcctReq); err != nil {
+		t.Fatal(err)
+	}
+	e := <-connected
+	if e.Addr != c.Addr || !e.Mux || e.Err != nil {
+		t.Errorf("unexpected connect event: %+v", e)
+	}
+
+	l.closeConns()
+	select {
+	case e = <-disconnected:
+	case <-time.After(5 * timeScale):
+		t.Fatal("disconnect event not received")
+	}
+	var ce *ConnClosedError
+	if !errors.As(e.Err, &ce) || ce.Reason != ClosePeer || e.Reason != ClosePeer {
+		t.Errorf("want close reason %v: got %v", ClosePeer, e.Err)
+	}
+	if e.Sessions != 1 {
+		t.Errorf("want 1 session: got %d", e.Sessions)
+	}
+}
+
+type tenantKey struct{}
+
+func TestClientConnKey(t *testing.T) {
+	l, c, err := newTestInstance(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.close()
+	defer c.Close()
+
+	var mu sync.Mutex
+	var dialed []string
+	c.ConnKey = func(ctx context.Context) string {
+		tenant, _ := ctx.Value(tenantKey{}).(string)
+		return tenant
+	}
+	c.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		mu.Lock()
+		dialed = append(dialed, ctx.Value(tenantKey{}).(string))
+		mu.Unlock()
+		d := new(net.Dialer)
+		return d.DialContext(ctx, network, addr)
+	}
+	connected := make(chan ConnEvent, 2)
+	c.OnConnect = func(e ConnEvent) { connected <- e }
+
+	for _, tenant := range []string{"red", "blue", "red", "blue"} {
+		ctx := context.WithValue(context.Background(), tenantKey{}, tenant)
+		if _, err = c.SendAcctRequest(ctx, testAcctReq); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(dialed) != 2 || dialed[0] != "red" || dialed[1] != "blue" {
+		t.Errorf("got dials for %v, want [red blue]", dialed)
+	}
+	if e := <-connected; e.Context == nil || e.Context.Value(tenantKey{}) != "red" {
+		t.Errorf("connect event missing request context values")
+	}
+	if n := l.connCount(); n != 2 {
+		t.Errorf("got %d connections, want 2", n)
+	}
+}
+
+// flagsHandler replies to authentication requests with the reply flags set to flags.
+type flagsHandler struct {
+	RequestHandler
+	flags uint8
+}
+
+func (h flagsHandler) HandleAuthenStart(ctx context.Context, a *AuthenStart, s *ServerSession) *AuthenReply {
+	return &AuthenReply{Status: AuthenStatusPass, Flags: h.flags}
+}
+
+func TestClientStrictFlags(t *testing.T) {
+	h := testHandler
+	h.Handler = flagsHandler{testHandler.Handler, 0x81}
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	defer c.Close()
+
+	// unknown flags are accepted by default
+	rep, _, err := c.SendAuthenStart(context.Background(), testAuthStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Flags != 0x81 || !rep.NoEcho {
+		t.Errorf("got flags %#02x no echo %v, want 0x81 true", rep.Flags, rep.NoEcho)
+	}
+
+	c.Close()
+	c.ConnConfig.StrictFlags = true
+	if _, _, err = c.SendAuthenStart(context.Background(), testAuthStart); !errors.Is(err, ErrUnknownFlags) {
+		t.Errorf("got error %v, want %v", err, ErrUnknownFlags)
+	}
+}
@@ -0,0 +1,128 @@
+// Command tacdryrun evaluates a shell command authorization request against
+// command rules, or a login against a user store, without any network,
+// printing the decision and the rule that decided it. It can be used to check
+// a rule file or user store before deploying it.
+//
+// Usage:
+//
+//	tacdryrun -rules file [-user name] [-priv-lvl n] command [args...]
+//	tacdryrun -users file -user name -pass password
+//
+// The rule file has one rule per line, as parsed by tacplus.ParseCommandRules.
+// The user store file is as loaded by tacplus.UserStore.Load. For example:
+//
+//	tacdryrun -rules /etc/tacplus/commands.conf -user alice show running-config
+//	tacdryrun -users /etc/tacplus/users.json -user alice -pass secret
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/nwaples/tacplus"
+)
+
+func main() {
+	rules := flag.String("rules", "", "command rule file")
+	user := flag.String("user", "", "user of the request")
+	privLvl := flag.Uint("priv-lvl", 1, "privilege level of the request")
+	users := flag.String("users", "", "user store file to log in against, instead of command rules")
+	pass := flag.String("pass", "", "password to log in with")
+	flag.Parse()
+
+	log.SetFlags(0)
+	if *users != "" {
+		if *rules != "" || *user == "" || flag.NArg() > 0 || *privLvl > tacplus.PrivLvlRoot {
+			flag.Usage()
+			os.Exit(2)
+		}
+		login(*users, *user, *pass, uint8(*privLvl))
+		return
+	}
+	if *rules == "" || flag.NArg() == 0 || *privLvl > tacplus.PrivLvlRoot {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	b, err := os.ReadFile(*rules)
+	if err != nil {
+		log.Fatal(err)
+	}
+	parsed, err := tacplus.ParseCommandRules(string(b))
+	if err != nil {
+		log.Fatalf("%s: %v", *rules, err)
+	}
+	m, err := tacplus.NewCommandMatcher(parsed...)
+	if err != nil {
+		log.Fatalf("%s: %v", *rules, err)
+	}
+
+	args := []string{"service=shell", "cmd=" + flag.Arg(0)}
+	for _, a := range flag.Args()[1:] {
+		args = append(args, "cmd-arg="+a)
+	}
+	req := &tacplus.AuthorRequest{
+		AuthenMethod:  tacplus.AuthenMethodTACACSPlus,
+		PrivLvl:       uint8(*privLvl),
+		AuthenType:    tacplus.AuthenTypeASCII,
+		AuthenService: tacplus.AuthenServiceLogin,
+		User:          *user,
+		Arg:           args,
+	}
+	h := &tacplus.ServerConnHandler{Handler: &tacplus.PolicyHandler{Policy: m}}
+	resp, matched, err := tacplus.DryRunAuthor(context.Background(), h, req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	decision := "deny"
+	if resp.Status == tacplus.AuthorStatusPassAdd || resp.Status == tacplus.AuthorStatusPassRepl {
+		decision = "permit"
+	}
+	fmt.Println(decision)
+	for _, r := range matched {
+		fmt.Println(r)
+	}
+}
+
+// login dry runs an ASCII login of user with pass against the user store file,
+// printing the decision and the rules that decided it.
+func login(file, user, pass string, privLvl uint8) {
+	f, err := os.Open(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	u := new(tacplus.UserStore)
+	err = u.Load(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("%s: %v", file, err)
+	}
+	start := &tacplus.AuthenStart{
+		Action:        tacplus.AuthenActionLogin,
+		PrivLvl:       privLvl,
+		AuthenType:    tacplus.AuthenTypeASCII,
+		AuthenService: tacplus.AuthenServiceLogin,
+		User:          user,
+	}
+	h := &tacplus.ServerConnHandler{Handler: &tacplus.LoginHandler{Primary: u}}
+	rep, matched, err := tacplus.DryRunAuthen(context.Background(), h, start, pass)
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch rep.Status {
+	case tacplus.AuthenStatusPass:
+		fmt.Println("pass")
+	case tacplus.AuthenStatusFail:
+		fmt.Println("fail")
+	case tacplus.AuthenStatusGetData, tacplus.AuthenStatusGetUser, tacplus.AuthenStatusGetPass:
+		fmt.Printf("prompt %q\n", rep.ServerMsg)
+	default:
+		fmt.Printf("error %q\n", rep.ServerMsg)
+	}
+	for _, r := range matched {
+		fmt.Println(r)
+	}
+}
@@ -0,0 +1,305 @@
+// Command tacload generates TACACS+ load against a server, sending a mix of
+// authentication, authorization and accounting requests at a target rate and
+// reporting latency percentiles for each request type.
+//
+// Usage:
+//
+//	tacload -addr host:port -secret key [flags]
+//
+// For example, to send 500 requests a second for a minute over multiplexed
+// connections, mostly accounting:
+//
+//	tacload -addr 192.0.2.1:49 -secret key -mux -rate 500 -d 1m -mix authen=1,author=2,acct=7
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nwaples/tacplus"
+)
+
+// request types
+const (
+	authen = iota
+	author
+	acct
+	numTypes
+)
+
+var typeNames = [numTypes]string{"authen", "author", "acct"}
+
+// counts are the results of the requests of one type.
+type counts struct {
+	sent   int // requests sent
+	failed int // requests answered with a failure status
+	errs   int // requests failing with an error
+}
+
+type loader struct {
+	client  *tacplus.Client
+	weights [numTypes]int
+	total   int
+	user    string
+	pass    string
+	timeout time.Duration
+
+	mu     sync.Mutex
+	counts [numTypes]counts
+	missed int            // requests not sent as all workers were busy
+	errLog map[string]int // errors seen, by message
+}
+
+// parseMix parses a request mix such as "authen=1,author=2,acct=7".
+func parseMix(s string) ([numTypes]int, error) {
+	var w [numTypes]int
+	for _, f := range strings.Split(s, ",") {
+		name, v, ok := strings.Cut(strings.TrimSpace(f), "=")
+		n, err := strconv.Atoi(v)
+		if !ok || err != nil || n < 0 {
+			return w, fmt.Errorf("invalid mix entry %q", f)
+		}
+		i := 0
+		for i < numTypes && typeNames[i] != name {
+			i++
+		}
+		if i == numTypes {
+			return w, fmt.Errorf("unknown request type %q", name)
+		}
+		w[i] = n
+	}
+	if w[authen]+w[author]+w[acct] == 0 {
+		return w, fmt.Errorf("mix has no requests")
+	}
+	return w, nil
+}
+
+// pick chooses a request type with probability proportional to its weight.
+func (l *loader) pick(r *rand.Rand) int {
+	n := r.Intn(l.total)
+	for t, w := range l.weights {
+		if n < w {
+			return t
+		}
+		n -= w
+	}
+	return acct
+}
+
+// send sends one request of type t, returning whether it passed.
+func (l *loader) send(t int, seq int) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), l.timeout)
+	defer cancel()
+	port := "tty" + strconv.Itoa(seq%1000)
+	switch t {
+	case authen:
+		rep, err := l.client.SendAuthenStartAuto(ctx, &tacplus.AuthenStart{
+			Action:        tacplus.AuthenActionLogin,
+			AuthenType:    tacplus.AuthenTypePAP,
+			AuthenService: tacplus.AuthenServiceLogin,
+			PrivLvl:       1,
+			User:          l.user,
+			Port:          port,
+			Data:          []byte(l.pass),
+		}, l.user, l.pass)
+		if err != nil {
+			return false, err
+		}
+		return rep.Status == tacplus.AuthenStatusPass, nil
+	case author:
+		resp, err := l.client.SendAuthorRequest(ctx, &tacplus.AuthorRequest{
+			AuthenMethod:  tacplus.AuthenMethodTACACSPlus,
+			PrivLvl:       1,
+			AuthenType:    tacplus.AuthenTypePAP,
+			AuthenService: tacplus.AuthenServiceLogin,
+			User:          l.user,
+			Port:          port,
+			Arg:           []string{"service=shell", "cmd=show", "cmd-arg=version", "cmd-arg=<cr>"},
+		})
+		if err != nil {
+			return false, err
+		}
+		return resp.Status == tacplus.AuthorStatusPassAdd || resp.Status == tacplus.AuthorStatusPassRepl, nil
+	default:
+		rep, err := l.client.SendAcctRequest(ctx, &tacplus.AcctRequest{
+			Flags:         tacplus.AcctFlagStop,
+			AuthenMethod:  tacplus.AuthenMethodTACACSPlus,
+			PrivLvl:       1,
+			AuthenType:    tacplus.AuthenTypePAP,
+			AuthenService: tacplus.AuthenServiceLogin,
+			User:          l.user,
+			Port:          port,
+			Arg:           []string{"task_id=" + strconv.Itoa(seq), "service=shell", "cmd=show version <cr>"},
+		})
+		if err != nil {
+			return false, err
+		}
+		return rep.Status == tacplus.AcctStatusSuccess, nil
+	}
+}
+
+func (l *loader) record(t int, pass bool, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c := &l.counts[t]
+	c.sent++
+	if err != nil {
+		c.errs++
+		l.errLog[err.Error()]++
+	} else if !pass {
+		c.failed++
+	}
+}
+
+// run sends requests from concurrency workers until ctx is done. If rate is
+// positive requests are started at that many per second, otherwise each worker
+// sends its next request as soon as the last is answered.
+func (l *loader) run(ctx context.Context, concurrency int, rate float64) {
+	var tokens chan int
+	seq := 0
+	if rate > 0 {
+		tokens = make(chan int, concurrency)
+		go func() {
+			defer close(tokens)
+			tick := time.Duration(float64(time.Second) / rate)
+			if tick < time.Millisecond {
+				tick = time.Millisecond
+			}
+			t := time.NewTicker(tick)
+			defer t.Stop()
+			start := time.Now()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-t.C:
+				}
+				// start the requests due since the last tick
+				for due := int(time.Since(start).Seconds() * rate); seq < due; {
+					seq++
+					select {
+					case tokens <- seq:
+					default:
+						// all workers busy, so the target rate isn't reached
+						l.mu.Lock()
+						l.missed++
+						l.mu.Unlock()
+					}
+				}
+			}
+		}()
+	}
+
+	var wg sync.WaitGroup
+	var seqMu sync.Mutex
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(time.Now().UnixNano() + int64(i)))
+			for {
+				var n int
+				if tokens != nil {
+					var ok bool
+					if n, ok = <-tokens; !ok {
+						return
+					}
+				} else {
+					if ctx.Err() != nil {
+						return
+					}
+					seqMu.Lock()
+					seq++
+					n = seq
+					seqMu.Unlock()
+				}
+				t := l.pick(r)
+				pass, err := l.send(t, n)
+				l.record(t, pass, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func (l *loader) report(elapsed time.Duration) {
+	st := l.client.Stats()
+	hists := [numTypes]*tacplus.LatencyHistogram{&st.Authen, &st.Author, &st.Acct}
+	fmt.Printf("%-7s %8s %9s %7s %7s %9s %9s %9s %9s %9s\n",
+		"type", "sent", "rate/s", "failed", "errors", "mean", "p50", "p90", "p99", "max")
+	for t := 0; t < numTypes; t++ {
+		c := l.counts[t]
+		if c.sent == 0 {
+			continue
+		}
+		h := hists[t]
+		fmt.Printf("%-7s %8d %9.1f %7d %7d %9s %9s %9s %9s %9s\n",
+			typeNames[t], c.sent, float64(c.sent)/elapsed.Seconds(), c.failed, c.errs,
+			round(h.Mean()), round(h.Quantile(0.5)), round(h.Quantile(0.9)),
+			round(h.Quantile(0.99)), round(h.Max()))
+	}
+	if l.missed > 0 {
+		fmt.Printf("%d requests not sent with all workers busy, try a higher -c\n", l.missed)
+	}
+	for msg, n := range l.errLog {
+		fmt.Printf("error (%d): %s\n", n, msg)
+	}
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(10 * time.Microsecond)
+}
+
+func main() {
+	addr := flag.String("addr", "", "server address, host:port")
+	secret := flag.String("secret", "", "shared secret")
+	mux := flag.Bool("mux", false, "multiplex sessions over single connections")
+	rate := flag.Float64("rate", 0, "requests per second, or as fast as possible if zero")
+	concurrency := flag.Int("c", 8, "requests in progress at once")
+	duration := flag.Duration("d", 10*time.Second, "time to send requests for")
+	timeout := flag.Duration("timeout", 5*time.Second, "request timeout")
+	mix := flag.String("mix", "authen=1,author=1,acct=1", "relative weights of request types")
+	user := flag.String("user", "user", "user name for requests")
+	pass := flag.String("pass", "password", "password for authentication requests")
+	flag.Parse()
+
+	if *addr == "" || *concurrency <= 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	weights, err := parseMix(*mix)
+	if err != nil {
+		log.Fatal(err)
+	}
+	l := &loader{
+		client: &tacplus.Client{
+			Addr: *addr,
+			ConnConfig: tacplus.ConnConfig{
+				Secret: []byte(*secret),
+				Mux:    *mux,
+				Log:    func(...interface{}) {},
+			},
+		},
+		weights: weights,
+		total:   weights[authen] + weights[author] + weights[acct],
+		user:    *user,
+		pass:    *pass,
+		timeout: *timeout,
+		errLog:  make(map[string]int),
+	}
+	defer l.client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+	start := time.Now()
+	l.run(ctx, *concurrency, *rate)
+	l.report(time.Since(start))
+}
@@ -0,0 +1,124 @@
+// Command tacusers imports users from htpasswd and shadow password files into
+// a user store file, as loaded by tacplus.UserStore.Load, keeping their
+// password hashes where the hash scheme is supported. Users that can't be
+// imported are listed on standard error.
+//
+// Usage:
+//
+//	tacusers [-htpasswd file] [-shadow file] [-o users.json] [-key-env name]
+//
+// Users already in the output file are kept, with imported users replacing
+// those of the same name. For example, to add the local users of a host to a
+// user store, as root:
+//
+//	tacusers -shadow /etc/shadow -o /etc/tacplus/users.json
+//
+// With -key-env, the user store is encrypted with the base64 AES key in the
+// named environment variable, as read by tacplus.UserStore.LoadEncrypted.
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/nwaples/tacplus"
+)
+
+// importFile adds the users of file to u with fn, reporting skipped users.
+func importFile(u *tacplus.UserStore, file string, fn func(*tacplus.UserStore, io.Reader) ([]string, error)) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	skipped, err := fn(u, f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	if len(skipped) > 0 {
+		fmt.Fprintf(os.Stderr, "%s: skipped users without a supported password hash: %s\n",
+			file, strings.Join(skipped, ", "))
+	}
+	return nil
+}
+
+func main() {
+	htpasswd := flag.String("htpasswd", "", "htpasswd file to import")
+	shadow := flag.String("shadow", "", "shadow password file to import, such as /etc/shadow")
+	out := flag.String("o", "", "user store file to update, or standard output if empty")
+	keyEnv := flag.String("key-env", "", "environment variable holding the key to encrypt the user store with")
+	flag.Parse()
+
+	if (*htpasswd == "" && *shadow == "") || flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	log.SetFlags(0)
+
+	ctx := context.Background()
+	var key tacplus.AESKey
+	if *keyEnv != "" {
+		var err error
+		if key, err = tacplus.KeyFromEnv(*keyEnv); err != nil {
+			log.Fatal(err)
+		}
+	}
+	save := func(w io.Writer, u *tacplus.UserStore) error {
+		if key != nil {
+			return u.SaveEncrypted(ctx, w, key)
+		}
+		return u.Save(w)
+	}
+
+	u := new(tacplus.UserStore)
+	if *out != "" {
+		b, err := os.ReadFile(*out)
+		if err == nil && key != nil {
+			err = u.LoadEncrypted(ctx, bytes.NewReader(b), key)
+		} else if err == nil {
+			err = u.Load(bytes.NewReader(b))
+		} else if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *htpasswd != "" {
+		if err := importFile(u, *htpasswd, tacplus.ImportHtpasswd); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *shadow != "" {
+		if err := importFile(u, *shadow, tacplus.ImportShadow); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *out == "" {
+		if err := save(os.Stdout, u); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	// the store holds password hashes, so is only readable by its owner
+	var b bytes.Buffer
+	if err := save(&b, u); err != nil {
+		log.Fatal(err)
+	}
+	tmp := *out + ".tmp"
+	if err := os.WriteFile(tmp, b.Bytes(), 0600); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Rename(tmp, *out); err != nil {
+		os.Remove(tmp)
+		log.Fatal(err)
+	}
+}
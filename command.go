@@ -0,0 +1,148 @@
+package tacplus
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// CommandLine returns the command line of a shell command authorization request
+// with arguments args, joining the cmd argument and its cmd-arg arguments with
+// spaces. The "<cr>" argument Cisco devices send at the end of a command is left
+// out. It returns false if there is no cmd argument, or it is empty as in a
+// request to start a shell.
+func CommandLine(args []string) (string, bool) {
+	cmd, ok := argValue(args, "cmd")
+	if !ok || cmd == "" {
+		return "", false
+	}
+	var b strings.Builder
+	b.WriteString(cmd)
+	for _, a := range args {
+		if argName(a) != "cmd-arg" || len(a) == len("cmd-arg") {
+			continue
+		}
+		if v := a[len("cmd-arg")+1:]; v != "<cr>" {
+			b.WriteByte(' ')
+			b.WriteString(v)
+		}
+	}
+	return b.String(), true
+}
+
+// A CommandRule permits or denies commands matching a regular expression.
+type CommandRule struct {
+	Permit  bool
+	Pattern string
+}
+
+// CommandMatcher decides whether shell commands are authorized with an ordered
+// list of permit and deny rules, in the style of tac_plus and do_auth.
+//
+// Rules are tried in order against the command line from CommandLine and the
+// first with a matching pattern decides, and is reported to a rule trace. As in tac_plus, patterns are not
+// anchored, so "^" and "$" should be used to match whole words or commands.
+// Commands matching no rule are denied.
+type CommandMatcher struct {
+	rules []commandRule
+}
+
+type commandRule struct {
+	permit bool
+	re     *regexp.Regexp
+}
+
+// NewCommandMatcher returns a CommandMatcher for rules, or an error if a
+// pattern is not a valid regular expression.
+func NewCommandMatcher(rules ...CommandRule) (*CommandMatcher, error) {
+	m := &CommandMatcher{rules: make([]commandRule, len(rules))}
+	for i, r := range rules {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("command rule %d: %w", i+1, err)
+		}
+		m.rules[i] = commandRule{permit: r.Permit, re: re}
+	}
+	return m, nil
+}
+
+// ParseCommandRules parses CommandRules from a configuration string with one
+// rule per line. Each rule is "permit" or "deny" followed by white space and a
+// pattern, which extends to the end of the line. Empty lines and lines starting
+// with "#" are ignored.
+func ParseCommandRules(s string) ([]CommandRule, error) {
+	var rules []CommandRule
+	for n, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		action, pattern := line, ""
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			action, pattern = line[:i], strings.TrimSpace(line[i:])
+		}
+		var r CommandRule
+		switch action {
+		case "permit":
+			r.Permit = true
+		case "deny":
+		default:
+			return nil, fmt.Errorf("line %d: invalid command rule %q", n+1, line)
+		}
+		if pattern == "" {
+			return nil, fmt.Errorf("line %d: command rule has no pattern", n+1)
+		}
+		r.Pattern = pattern
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// Match returns whether the command line cmd is permitted, and whether a rule
+// matched it.
+func (m *CommandMatcher) Match(cmd string) (permit, matched bool) {
+	if i := m.match(cmd); i >= 0 {
+		return m.rules[i].permit, true
+	}
+	return false, false
+}
+
+// match returns the index of the first rule matching cmd, or -1 if none match.
+func (m *CommandMatcher) match(cmd string) int {
+	for i, r := range m.rules {
+		if r.re.MatchString(cmd) {
+			return i
+		}
+	}
+	return -1
+}
+
+// ruleName describes rule i for a rule trace, such as "command rule 2: permit ^show".
+func (m *CommandMatcher) ruleName(i int) string {
+	action := "deny"
+	if m.rules[i].permit {
+		action = "permit"
+	}
+	return fmt.Sprintf("command rule %d: %s %s", i+1, action, m.rules[i].re)
+}
+
+// Authorize implements Policy, permitting or failing shell command requests.
+// Requests that aren't for a command, such as starting a shell, are left for
+// the next handler with no decision.
+func (m *CommandMatcher) Authorize(ctx context.Context, req *AuthorRequest, s *ServerSession) (*AuthorResponse, error) {
+	cmd, ok := CommandLine(req.Arg)
+	if !ok {
+		return nil, nil
+	}
+	i := m.match(cmd)
+	if i < 0 {
+		traceRule(ctx, "no command rule matched")
+		return &AuthorResponse{Status: AuthorStatusFail}, nil
+	}
+	traceRule(ctx, m.ruleName(i))
+	if m.rules[i].permit {
+		return &AuthorResponse{Status: AuthorStatusPassAdd}, nil
+	}
+	return &AuthorResponse{Status: AuthorStatusFail}, nil
+}
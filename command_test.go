@@ -0,0 +1,120 @@
+package tacplus
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCommandLine(t *testing.T) {
+	for _, test := range []struct {
+		args []string
+		want string
+		ok   bool
+	}{
+		// IOS
+		{[]string{"service=shell", "cmd=show", "cmd-arg=running-config", "cmd-arg=<cr>"}, "show running-config", true},
+		{[]string{"service=shell", "cmd=configure", "cmd-arg=terminal", "cmd-arg=<cr>"}, "configure terminal", true},
+		{[]string{"service=shell", "cmd=interface", "cmd-arg=GigabitEthernet0/1", "cmd-arg=<cr>"}, "interface GigabitEthernet0/1", true},
+		// NX-OS sends the whole command line in cmd
+		{[]string{"service=shell", "cmd=show running-config interface ethernet1/1"}, "show running-config interface ethernet1/1", true},
+		// EOS
+		{[]string{"service=shell", "priv-lvl=15", "cmd=show", "cmd-arg=version"}, "show version", true},
+		// shell start
+		{[]string{"service=shell", "cmd="}, "", false},
+		{[]string{"service=shell", "cmd*"}, "", false},
+		{[]string{"service=ppp", "protocol=ip"}, "", false},
+	} {
+		got, ok := CommandLine(test.args)
+		if got != test.want || ok != test.ok {
+			t.Errorf("%q: got %q %v, want %q %v", test.args, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestCommandMatcher(t *testing.T) {
+	rules, err := ParseCommandRules(`
+		# operators
+		deny ^show running-config
+		permit ^show
+		permit	^(ping|traceroute)
+		deny ^configure
+		permit ^interface (Gi|Te)[a-zA-Z]*[0-9/]+$
+		permit ^exit$
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := NewCommandMatcher(rules...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		cmd           string
+		permit, match bool
+	}{
+		{"show version", true, true},
+		{"show ip interface brief", true, true},
+		{"show running-config", false, true},
+		{"show running-config interface ethernet1/1", false, true},
+		{"show startup-config", true, true},
+		{"ping 192.0.2.1", true, true},
+		{"traceroute vrf mgmt 192.0.2.1", true, true},
+		{"configure terminal", false, true},
+		{"interface GigabitEthernet0/1", true, true},
+		{"interface TenGigabitEthernet1/0/1", true, true},
+		{"interface Vlan10", false, false},
+		{"exit", true, true},
+		{"exit now", false, false},
+		{"reload", false, false},
+		{"write memory", false, false},
+		{"no shutdown", false, false},
+	} {
+		permit, match := m.Match(test.cmd)
+		if permit != test.permit || match != test.match {
+			t.Errorf("%q: got %v %v, want %v %v", test.cmd, permit, match, test.permit, test.match)
+		}
+	}
+
+	for _, bad := range []string{"allow .*", "permit", "deny   ", "deny\t"} {
+		if _, err := ParseCommandRules(bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+	if _, err := NewCommandMatcher(CommandRule{Pattern: "("}); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+}
+
+func TestCommandMatcherPolicy(t *testing.T) {
+	skipWithoutMD5(t)
+	m, err := NewCommandMatcher(CommandRule{Permit: true, Pattern: "^show "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := testHandler
+	h.Handler = &PolicyHandler{Passthrough: Passthrough{testHandler.Handler}, Policy: m}
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	for _, test := range []struct {
+		args   []string
+		status uint8
+	}{
+		{[]string{"service=shell", "cmd=show", "cmd-arg=version", "cmd-arg=<cr>"}, AuthorStatusPassAdd},
+		{[]string{"service=shell", "cmd=reload", "cmd-arg=<cr>"}, AuthorStatusFail},
+		{[]string{"service=shell", "cmd="}, AuthorStatusPassAdd}, // passed to handler
+	} {
+		req := *testAuthorReq
+		req.Arg = test.args
+		resp, err := c.SendAuthorRequest(context.Background(), &req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != test.status {
+			t.Errorf("%q: got status %d, want %d", test.args, resp.Status, test.status)
+		}
+	}
+}
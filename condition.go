@@ -0,0 +1,275 @@
+package tacplus
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// RequestInfo describes the circumstances of an authentication or authorization
+// request, for testing with a Condition.
+type RequestInfo struct {
+	Time    time.Time // time the request was received
+	NAS     net.IP    // address of the client device, nil if not an IP connection
+	User    string    // user name, empty for ASCII logins that prompt for it
+	Port    string    // user port on the client device
+	RemAddr string    // remote address of the user
+
+	Device *DeviceConfig // client device configuration, nil if not known
+}
+
+func newRequestInfo(s *ServerSession, user, port, remAddr string) *RequestInfo {
+	return &RequestInfo{
+		Time:    time.Now(),
+		NAS:     addrIP(s.RemoteAddr()),
+		Device:  s.Device(),
+		User:    user,
+		Port:    port,
+		RemAddr: remAddr,
+	}
+}
+
+// A Condition reports whether a request meets some requirement.
+type Condition func(*RequestInfo) bool
+
+// All returns a Condition met when all of conds are met.
+func All(conds ...Condition) Condition {
+	return func(ri *RequestInfo) bool {
+		for _, c := range conds {
+			if !c(ri) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Any returns a Condition met when any of conds is met.
+func Any(conds ...Condition) Condition {
+	return func(ri *RequestInfo) bool {
+		for _, c := range conds {
+			if c(ri) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not returns a Condition met when c is not.
+func Not(c Condition) Condition {
+	return func(ri *RequestInfo) bool { return !c(ri) }
+}
+
+// TimeWindow returns a Condition met between the times of day start (inclusive)
+// and end (exclusive) in loc, given as offsets from midnight. The window wraps
+// past midnight if end is before start. A nil loc means local time.
+func TimeWindow(start, end time.Duration, loc *time.Location) Condition {
+	if loc == nil {
+		loc = time.Local
+	}
+	return func(ri *RequestInfo) bool {
+		t := ri.Time.In(loc)
+		h, m, s := t.Clock()
+		d := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+		if start <= end {
+			return d >= start && d < end
+		}
+		return d >= start || d < end
+	}
+}
+
+// Weekdays returns a Condition met on the given days of the week in loc.
+// A nil loc means local time.
+func Weekdays(loc *time.Location, days ...time.Weekday) Condition {
+	if loc == nil {
+		loc = time.Local
+	}
+	var set [7]bool
+	for _, d := range days {
+		set[d%7] = true
+	}
+	return func(ri *RequestInfo) bool { return set[ri.Time.In(loc).Weekday()] }
+}
+
+// NASNetworks returns a Condition met when the client device address is in one
+// of nets, each an IP address or CIDR range.
+func NASNetworks(nets ...string) (Condition, error) {
+	var ns []*net.IPNet
+	for _, s := range nets {
+		n, err := parseNetwork(s)
+		if err != nil {
+			return nil, err
+		}
+		ns = append(ns, n)
+	}
+	return func(ri *RequestInfo) bool {
+		for _, n := range ns {
+			if ri.NAS != nil && n.Contains(ri.NAS) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// PortMatch returns a Condition met when the user port matches the regular
+// expression pattern.
+func PortMatch(pattern string) (Condition, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return func(ri *RequestInfo) bool { return re.MatchString(ri.Port) }, nil
+}
+
+// Users returns a Condition met for requests from any of the named users.
+func Users(names ...string) Condition {
+	set := make(map[string]bool, len(names))
+	for _, n := range names {
+		set[n] = true
+	}
+	return func(ri *RequestInfo) bool { return set[ri.User] }
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseCondition parses a Condition from a configuration string of space
+// separated terms, all of which must be met. A term prefixed by "!" must not be
+// met. The terms are:
+//
+//	time=09:00-17:00       time of day window, see TimeWindow
+//	days=mon-fri,sun       days of the week, as ranges or single days
+//	nas=10.0.0.0/8,1.2.3.4 client device addresses, see NASNetworks
+//	port=^tty[0-9]+$       user port regular expression, see PortMatch
+//	user=alice,bob         user names
+//	group=core,edge        client device groups, see DeviceGroups
+//
+// Times and days are in loc, or local time if loc is nil. An empty string is
+// always met.
+func ParseCondition(s string, loc *time.Location) (Condition, error) {
+	var conds []Condition
+	for _, term := range strings.Fields(s) {
+		neg := strings.HasPrefix(term, "!")
+		key, val, ok := strings.Cut(strings.TrimPrefix(term, "!"), "=")
+		if !ok || val == "" {
+			return nil, fmt.Errorf("invalid condition %q", term)
+		}
+		var c Condition
+		var err error
+		switch key {
+		case "time":
+			c, err = parseTimeWindow(val, loc)
+		case "days":
+			c, err = parseWeekdays(val, loc)
+		case "nas":
+			c, err = NASNetworks(strings.Split(val, ",")...)
+		case "port":
+			c, err = PortMatch(val)
+		case "user":
+			c = Users(strings.Split(val, ",")...)
+		case "group":
+			c = DeviceGroups(strings.Split(val, ",")...)
+		default:
+			err = fmt.Errorf("unknown condition %q", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("condition %q: %w", term, err)
+		}
+		if neg {
+			c = Not(c)
+		}
+		conds = append(conds, c)
+	}
+	return All(conds...), nil
+}
+
+func parseTimeWindow(s string, loc *time.Location) (Condition, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid time window %q", s)
+	}
+	start, err := parseClock(from)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseClock(to)
+	if err != nil {
+		return nil, err
+	}
+	return TimeWindow(start, end, loc), nil
+}
+
+// parseClock parses a 24 hour HH:MM time of day, returning the offset from midnight.
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		if s != "24:00" {
+			return 0, fmt.Errorf("invalid time of day %q", s)
+		}
+		return 24 * time.Hour, nil
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func parseWeekdays(s string, loc *time.Location) (Condition, error) {
+	var days []time.Weekday
+	for _, r := range strings.Split(s, ",") {
+		from, to, isRange := strings.Cut(strings.ToLower(r), "-")
+		first, ok := weekdayNames[from]
+		if !ok {
+			return nil, fmt.Errorf("invalid weekday %q", from)
+		}
+		last := first
+		if isRange {
+			if last, ok = weekdayNames[to]; !ok {
+				return nil, fmt.Errorf("invalid weekday %q", to)
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			days = append(days, d)
+			if d == last {
+				break
+			}
+		}
+	}
+	return Weekdays(loc, days...), nil
+}
+
+// ConditionHandler is a RequestHandler that fails authentication and
+// authorization requests when Allow is not met, passing the rest, and all
+// accounting requests, to Handler.
+//
+// Authentication is checked when the session starts, so ASCII logins that
+// prompt for the user name are checked with an empty User.
+type ConditionHandler struct {
+	Passthrough
+	Allow Condition
+
+	// Message sent to the client with a denial. Optional.
+	Message string
+}
+
+// HandleAuthenStart fails the request if Allow is not met, and otherwise calls
+// the HandleAuthenStart method of Handler.
+func (h *ConditionHandler) HandleAuthenStart(ctx context.Context, a *AuthenStart, s *ServerSession) *AuthenReply {
+	if !h.Allow(newRequestInfo(s, a.User, a.Port, a.RemAddr)) {
+		return &AuthenReply{Status: AuthenStatusFail, ServerMsg: h.Message}
+	}
+	return h.Passthrough.HandleAuthenStart(ctx, a, s)
+}
+
+// HandleAuthorRequest fails the request if Allow is not met, and otherwise calls
+// the HandleAuthorRequest method of Handler.
+func (h *ConditionHandler) HandleAuthorRequest(ctx context.Context, a *AuthorRequest, s *ServerSession) *AuthorResponse {
+	if !h.Allow(newRequestInfo(s, a.User, a.Port, a.RemAddr)) {
+		return &AuthorResponse{Status: AuthorStatusFail, ServerMsg: h.Message}
+	}
+	return h.Passthrough.HandleAuthorRequest(ctx, a, s)
+}
@@ -0,0 +1,100 @@
+package tacplus
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseCondition(t *testing.T) {
+	// Wednesday 2024-01-10
+	wed := func(clock string) time.Time {
+		tm, err := time.ParseInLocation("2006-01-02 15:04", "2024-01-10 "+clock, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	ri := func(tm time.Time, nas, user, port string) *RequestInfo {
+		return &RequestInfo{Time: tm, NAS: net.ParseIP(nas), User: user, Port: port}
+	}
+	contractors := "!user=carl,cora"
+	business := "time=09:00-17:00 days=mon-fri nas=10.1.0.0/16,192.0.2.7"
+	for _, test := range []struct {
+		cond string
+		ri   *RequestInfo
+		want bool
+	}{
+		{"", ri(wed("03:00"), "", "", ""), true},
+		{business, ri(wed("09:00"), "10.1.2.3", "carl", ""), true},
+		{business, ri(wed("17:00"), "10.1.2.3", "carl", ""), false},
+		{business, ri(wed("12:00"), "10.2.2.3", "carl", ""), false},
+		{business, ri(wed("12:00"), "192.0.2.7", "carl", ""), true},
+		{business, ri(wed("12:00").AddDate(0, 0, 3), "10.1.2.3", "carl", ""), false},
+		{"time=22:00-06:00", ri(wed("23:30"), "", "", ""), true},
+		{"time=22:00-06:00", ri(wed("05:59"), "", "", ""), true},
+		{"time=22:00-06:00", ri(wed("06:00"), "", "", ""), false},
+		{"days=fri-mon", ri(wed("12:00").AddDate(0, 0, 4), "", "", ""), true},
+		{"days=fri-mon", ri(wed("12:00"), "", "", ""), false},
+		{"days=sun,wed", ri(wed("12:00"), "", "", ""), true},
+		{contractors, ri(wed("12:00"), "", "cora", ""), false},
+		{contractors, ri(wed("12:00"), "", "alice", ""), true},
+		{"port=^tty[0-9]+$", ri(wed("12:00"), "", "", "tty12"), true},
+		{"port=^tty[0-9]+$", ri(wed("12:00"), "", "", "vty0"), false},
+		{"nas=10.0.0.0/8", ri(wed("12:00"), "", "", ""), false},
+	} {
+		c, err := ParseCondition(test.cond, time.UTC)
+		if err != nil {
+			t.Fatalf("%q: %v", test.cond, err)
+		}
+		if got := c(test.ri); got != test.want {
+			t.Errorf("%q at %v from %v user %q: got %v, want %v", test.cond, test.ri.Time, test.ri.NAS, test.ri.User, got, test.want)
+		}
+	}
+
+	for _, bad := range []string{"time", "time=9-5", "days=mon-xyz", "nas=10.0.0.0/33", "port=(", "color=red"} {
+		if _, err := ParseCondition(bad, nil); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestConditionHandler(t *testing.T) {
+	skipWithoutMD5(t)
+	allow, err := NASNetworks("127.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		allow  Condition
+		authen uint8
+		author uint8
+	}{
+		{allow, AuthenStatusPass, AuthorStatusPassAdd},
+		{Not(allow), AuthenStatusFail, AuthorStatusFail},
+	} {
+		h := testHandler
+		h.Handler = &ConditionHandler{Passthrough: Passthrough{testHandler.Handler}, Allow: test.allow, Message: "denied"}
+		s, c, err := newTestInstance(&h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := context.Background()
+		rep, err := c.SendAuthenStartAuto(ctx, testAuthStart, "user", "password123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rep.Status != test.authen {
+			t.Errorf("got authen status %d, want %d", rep.Status, test.authen)
+		}
+		resp, err := c.SendAuthorRequest(ctx, testAuthorReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != test.author {
+			t.Errorf("got author status %d, want %d", resp.Status, test.author)
+		}
+		s.close()
+	}
+}
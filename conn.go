@@ -2,16 +2,18 @@
 package tacplus
 
 import (
+	"bufio"
 	"context"
-	"crypto/md5"
 	"crypto/rand"
+	"crypto/tls"
 	"encoding/binary"
 	"errors"
-	"fmt"
 	"io"
 	"log"
 	"net"
+	"runtime/pprof"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -38,56 +40,27 @@ const (
 var (
 	errSessionClosed    = errors.New("session closed")
 	errSessionIDInUse   = errors.New("session id in use")
-	errConnectionClosed = errors.New("connection closed")
-	errInvalidSeqNo     = errors.New("invalid sequence number")
 	errSessionNotFound  = errors.New("session not found or timed out")
 	errUnexpectedEOF    = errors.New("unexpected EOF")
-	errPacketQueueFull  = errors.New("packet queue full")
+	errHandlerTimeout   = errors.New("request handler timed out")
+	errPacketTooLarge   = errors.New("packet too large")
+	errBadVersion       = errors.New("unsupported major version")
+	errTooManyPrompts   = errors.New("too many authentication prompts")
+	errAuthenTooLong    = errors.New("authentication took too long")
+	errSeqOverflow      = errors.New("session sequence number overflow")
+	errUnexpectedPrompt = errors.New("unexpected authentication prompt")
+	errNoMD5            = errors.New("tacplus: packet obfuscation needs MD5, which the tacplus_nomd5 build leaves out; use Secretless")
 )
 
-// doneContext allows a done channel to be used as a context.Context
-type doneContext <-chan struct{}
-
-func (d doneContext) Deadline() (deadline time.Time, ok bool) { return }
-func (d doneContext) Done() <-chan struct{}                   { return d }
-func (d doneContext) Value(key interface{}) interface{}       { return nil }
-
-func (d doneContext) Err() error {
-	select {
-	case <-d:
-		return context.Canceled
-	default:
-		return nil
-	}
+// valuesContext is a context.Context carrying the values of its parent, but
+// not its deadline or cancellation.
+type valuesContext struct {
+	context.Context
 }
 
-// crypt encrypts or decrypts the body of a TACACS+ packet.
-func crypt(p, key []byte) {
-	buf := make([]byte, len(key)+6)
-	copy(buf, p[4:8])      // session id
-	copy(buf[4:], key)     // shared secret
-	buf[len(buf)-2] = p[0] // version
-	buf[len(buf)-1] = p[2] // sequence number
-
-	var sum []byte
-
-	h := md5.New()
-	body := p[hdrLen:]
-	for len(body) > 0 {
-		h.Reset()
-		// write will always succeed, ignore errors
-		_, _ = h.Write(buf)
-		_, _ = h.Write(sum)
-		sum = h.Sum(nil)
-		if len(body) < len(sum) {
-			sum = sum[:len(body)]
-		}
-		for i, c := range sum {
-			body[i] ^= c
-		}
-		body = body[len(sum):]
-	}
-}
+func (valuesContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (valuesContext) Done() <-chan struct{}       { return nil }
+func (valuesContext) Err() error                  { return nil }
 
 // a packet can be marshalled to and from raw bytes
 type packet interface {
@@ -95,9 +68,11 @@ type packet interface {
 	unmarshal([]byte) error         // decodes the packet
 }
 
-// writeRequest is a request to write a raw TACACS+ packet
+// writeRequest is a request to write a raw TACACS+ packet.
+// The write loop sets the body length and encrypts the packet.
 type writeRequest struct {
 	p        []byte     // raw packet
+	key      []byte     // secret key to encrypt the packet with
 	deadline time.Time  // deadline for write
 	ec       chan error // write result is returned on this channel
 }
@@ -109,6 +84,11 @@ type session struct {
 	in   chan []byte   // Buffered channel for incoming raw packet
 	c    *conn         // Connection for session
 	done chan struct{} // close channel to close session
+	key  *KeyedSecret  // Secret key for session, chosen on first packet by a server
+
+	// context canceled when a server session closes, nil for client sessions
+	ctx    context.Context
+	cancel context.CancelCauseFunc
 
 	mu  sync.Mutex // Guards the following
 	err error      // last seen error
@@ -144,27 +124,53 @@ func (s *session) readErr() error {
 	return errSessionClosed
 }
 
-// context returns a context.Context that is canceled when the session is closed
+// secret returns the shared secret for the session.
+func (s *session) secret() []byte {
+	if s.key != nil {
+		return s.key.Secret
+	}
+	// key not chosen because first packet was invalid
+	if keys := s.c.keys(time.Now()); len(keys) > 0 {
+		return keys[0].Secret
+	}
+	return s.c.Secret
+}
+
+// keyID returns the ID of the KeyedSecret used by the session.
+func (s *session) keyID() string {
+	if s.key == nil {
+		return ""
+	}
+	return s.key.ID
+}
+
+// context returns a context.Context that is canceled when the session is closed,
+// with the reason it closed as the cause. It carries the values of the connection's
+// context.
 func (s *session) context() context.Context {
-	return doneContext(s.done)
+	return s.ctx
 }
 
 func (s *session) readPacket(ctx context.Context) ([]byte, error) {
 	var p []byte
 
-	// get raw packet from session in channel
-	select {
-	case p = <-s.in:
-	case <-ctx.Done():
-		return nil, ctx.Err()
-	}
-	if p == nil {
-		return nil, s.readErr()
-	}
+	for {
+		// get raw packet from session in channel
+		select {
+		case p = <-s.in:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if p == nil {
+			return nil, s.readErr()
+		}
+		s.c.budget.release(len(p))
 
-	// check sequence number
-	seq := p[hdrSeqNo] // packet seqno
-	if seq != s.seq+1 {
+		// check sequence number
+		seq := p[hdrSeqNo] // packet seqno
+		if seq == s.seq+1 {
+			break
+		}
 		// sequence number not the same as expected
 
 		if s.seq == 0 {
@@ -172,15 +178,39 @@ func (s *session) readPacket(ctx context.Context) ([]byte, error) {
 			// session timing out
 			return p, errSessionNotFound
 		}
-		return p, errInvalidSeqNo
+		if s.c.TolerateSeqRetransmit && seq+1 == s.seq {
+			// a retransmission of the peer's last packet, which has
+			// already been answered
+			putBuf(p)
+			continue
+		}
+		err := &SeqError{SeqNo: seq, Want: s.seq + 1}
+		s.c.seqError(EventBadSeqNo, p, err)
+		return p, err
 	}
 
 	// check parity of received packet
-	if seq&0x1 == s.c.parity {
-		return p, errInvalidSeqNo
+	if seq := p[hdrSeqNo]; seq&0x1 == s.c.parity {
+		err := &SeqError{SeqNo: seq, Want: seq + 1}
+		s.c.seqError(EventBadParity, p, err)
+		return p, err
 	}
 
-	crypt(p, s.c.Secret)
+	if s.c.Secretless {
+		if p[hdrFlags]&FlagUnencrypted == 0 {
+			return p, errObfuscated
+		}
+	} else {
+		if s.key == nil {
+			k, err := s.c.serverKey(p, s.c.quirks.Has(QuirkTruncatedArgs))
+			if err != nil {
+				return p, err
+			}
+			s.key = k
+		}
+		crypt(p, s.key.Secret)
+	}
+	s.c.trace(DirIn, p)
 	return p, nil
 }
 
@@ -195,20 +225,19 @@ func (s *session) writePacket(ctx context.Context, p []byte) error {
 	p[hdrSeqNo]++
 	s.seq = p[hdrSeqNo]
 
-	// set body size
-	binary.BigEndian.PutUint32(p[hdrBodyLen:], uint32(len(p)-hdrLen))
-	crypt(p, s.c.Secret)
-
-	wr := writeRequest{p: p, ec: make(chan error, 1)}
+	wr := writeRequest{p: p, key: s.secret(), ec: make(chan error, 1)}
 	if deadline, ok := ctx.Deadline(); ok {
 		wr.deadline = deadline
 	}
 
-	// send write request
+	// send write request, counting the packet as buffered until written
+	s.c.budget.add(len(p))
 	select {
 	case <-s.done:
+		s.c.budget.release(len(p))
 		return s.readErr()
 	case <-ctx.Done():
+		s.c.budget.release(len(p))
 		return ctx.Err()
 	case s.c.wc <- wr:
 	}
@@ -222,6 +251,13 @@ func (s *session) writePacket(ctx context.Context, p []byte) error {
 	}
 }
 
+// cancelCause cancels the session context, if it has one, with cause.
+func (s *session) cancelCause(cause error) {
+	if s.cancel != nil {
+		s.cancel(cause)
+	}
+}
+
 func newSession(c *conn, id uint32) *session {
 	s := &session{id: id, c: c}
 	s.in = make(chan []byte, 1)
@@ -241,6 +277,17 @@ type sessRequest struct {
 	reply chan sessReply // result of request is sent to this channel
 }
 
+// VersionPolicy controls how a server handles requests with a minor version
+// that doesn't match the one expected for the request.
+type VersionPolicy int
+
+// VersionPolicy values
+const (
+	VersionStrict VersionPolicy = iota // send an error reply
+	VersionAccept                      // log the mismatch and reply with the received version
+	VersionRemap                       // reply with the expected version
+)
+
 // ConnConfig specifies configuration parameters for a TACACS+ connection.
 //
 // Setting Mux or LegacyMux allows multiplexing multiple sessions over a single network connection.
@@ -254,7 +301,7 @@ type sessRequest struct {
 // A mismatch between the client and server on the multiplex type can cause problems. This software
 // tries to deal gracefully with some of these situations.
 // A server connection will accept multiplexed sessions even if multiplexing was not set or
-// negotiated, but will close the connection immediately when there are no more sessions.
+// negotiated, but will close the connection when there are no more sessions, after NonMuxLinger.
 // A LegacyMux server connection will set the single-connection header flag if the client does,
 // allowing a Mux client to multiplex to a LegacyMux server.
 //
@@ -263,14 +310,147 @@ type ConnConfig struct {
 	Mux          bool          // Allow sessions to be multiplexed over a single connection
 	LegacyMux    bool          // Allow session multiplexing without setting the single-connection header flag
 	Secret       []byte        // Shared secret key
+	Secrets      []KeyedSecret // Shared secret keys with IDs, used instead of Secret if set
 	IdleTimeout  time.Duration // Time before closing an idle multiplexed connection with no sessions
 	ReadTimeout  time.Duration // Maximum time to read a packet (not including waiting for first byte)
 	WriteTimeout time.Duration // Maximum time to write a packet
 
+	// Maximum time a server connection waits for its first packet, before any
+	// session has started or multiplexing is negotiated, so connections that send
+	// nothing, or only part of a packet, are closed. If ReadTimeout is set it
+	// limits reading the rest of the packet after the first byte instead.
+	FirstByteTimeout time.Duration
+
+	// Time a connection that isn't multiplexed stays open after its last session
+	// closes, for peers that start another session on it. A new session cancels
+	// the close. Closed immediately if zero.
+	NonMuxLinger time.Duration
+
+	// Time sessions in progress have to send their replies after the peer closes
+	// its side of the connection, for peers that half-close after sending their
+	// last request. Sessions still open after the grace period are closed. The
+	// connection closes as soon as the peer closes if zero.
+	HalfCloseGrace time.Duration
+
+	// Interval between checks of the connection's internal bookkeeping, for
+	// diagnosing suspected leaks. Problems are logged, or cause a panic if
+	// SelfCheckPanic is set. Ignored if zero.
+	SelfCheckInterval time.Duration
+	SelfCheckPanic    bool
+
+	// Size of the connection read buffer. Larger buffers reduce the number of
+	// reads for large packets. Defaults to 4096 bytes if zero.
+	ReadBufferSize int
+
+	// Initial sizes of packet buffers for each session type.
+	BufferSizes BufferSizes
+
+	// Maximum bytes of packets buffered by a connection, counting packets read
+	// but not yet handled and packets waiting to be written. Reading from the
+	// connection pauses while it is exceeded, pushing back on the peer. A single
+	// packet larger than the limit can still be read. Ignored if zero.
+	MaxBufferedBytes int
+
+	// Maximum time a server session waits to send an error reply before closing.
+	ErrorReplyTimeout time.Duration
+
+	// Maximum time a server request handler has to return a reply. The context passed
+	// to the handler has this deadline. If it expires an error reply is sent and the
+	// session is closed.
+	HandlerTimeout time.Duration
+
+	// Limits on interactive authentication by a server. A session may send at most
+	// MaxAuthenPrompts prompts with GetData, GetUser or GetPass, and must finish
+	// within MaxAuthenDuration. A prompt exceeding a limit sends the client a Fail
+	// reply instead, closes the session and returns an error. If the client hasn't
+	// answered a prompt when MaxAuthenDuration expires the session is closed without
+	// a reply. Ignored if zero.
+	MaxAuthenPrompts  int
+	MaxAuthenDuration time.Duration
+
+	// Optional banner, such as a legal notice, shown to users before the first
+	// prompt of an interactive authentication by a server. It is prepended to
+	// the message of the first prompt, normally GetUser, on a line of its own.
+	// If BannerPrompt is set it is sent as a separate GetData prompt instead,
+	// echoing the answer, which is ignored; this prompt counts towards
+	// MaxAuthenPrompts. The banner is rendered with the authen-banner template
+	// of Messages if there is one.
+	Banner       string
+	BannerPrompt bool
+
+	// Ignore packets repeating the sequence number of the peer's previous packet,
+	// instead of closing the session. Some devices resend their last packet
+	// after a TCP retransmission.
+	TolerateSeqRetransmit bool
+
+	// Fail authentication replies received by a client with flag bits other than
+	// NoEcho set with ErrUnknownFlags, instead of accepting them. The bits are
+	// available from the reply's Flags field either way.
+	StrictFlags bool
+
+	// Handling of requests with an unexpected minor version by a server.
+	VersionPolicy VersionPolicy
+
+	// Reject authentication requests using features deprecated by RFC 8907, ARAP
+	// and the SENDPASS action, with an error reply naming the feature, so devices
+	// with obsolete configurations are easy to identify.
+	RejectDeprecated bool
+
+	// Workarounds for peers that deviate from the protocol. QuirksFor is an
+	// optional function returning additional quirks for a peer's address.
+	Quirks    Quirks
+	QuirksFor func(addr net.Addr) Quirks
+
+	// Don't obfuscate packet bodies, setting the unencrypted header flag instead,
+	// and reject obfuscated packets. Only use this over TLS.
+	Secretless bool
+
+	// Optional function called with protocol anomalies seen on the connection.
+	OnSecurityEvent SecurityEventFunc
+
+	// Optional function called when a server authentication session that has
+	// prompted the client ends without a Pass or Fail reply, such as when the
+	// client aborts, stops answering or disconnects, for tracking abandoned
+	// logins. It is called synchronously so should not block.
+	OnSessionAbort func(AbandonedSession, AbortReason)
+
+	// Optional function called when a server sends a Fail or Error reply with
+	// a DenyReason, for audit logs. The reason is never sent to the client.
+	OnDeny func(Denial)
+
+	// Optional counts of the Fail and Error replies sent by a server with a
+	// DenyReason, by reason. It can be shared by the connections of several
+	// servers.
+	DenyCounts *DenyCounts
+
+	// Optional templates for the ServerMsg of replies sent by a server.
+	Messages *MessageTemplates
+
+	// Optional function called with each packet sent or received by a session.
+	PacketTrace func(TracedPacket)
+
+	// Log whether the connection multiplexes sessions, and why, when the first
+	// packet is received. NegotiateMux checks a client and server configuration
+	// pair without connecting.
+	LogMux bool
+
+	// Tag connection and session goroutines with pprof labels, attributing CPU
+	// profiles to peers and session types. Connection goroutines are labeled
+	// with tacplus_peer, and session goroutines also with tacplus_session_type.
+	// Server session contexts carry the connection labels, for use with pprof.Do.
+	ProfileLabels bool
+
 	// Optional function to log errors. If not defined log.Print will be used.
 	Log func(v ...interface{})
 }
 
+func (c *ConnConfig) readBufferSize() int {
+	if c.ReadBufferSize > 0 {
+		return c.ReadBufferSize
+	}
+	return 4096
+}
+
 func (c *ConnConfig) log(v ...interface{}) {
 	if c == nil || c.Log == nil {
 		log.Print(v...)
@@ -283,20 +463,38 @@ func (c *ConnConfig) log(v ...interface{}) {
 type conn struct {
 	ConnConfig
 
-	nc     net.Conn
-	handle func(*session) // function that processes incoming sessions
-
-	sess     map[uint32]*session // session store
-	parity   uint8               // parity of sequence number for incoming packets
-	mux      bool                // connection multiplexing status
-	checkMux bool                // connection multiplexing to be negotatied
-	idleT    *time.Timer         // idle timer
+	nc      net.Conn
+	br      *bufio.Reader   // buffered reader for nc
+	ctx     context.Context // connection closes when done
+	handle  func(*session)  // function that processes incoming sessions
+	onClose func(error)     // optional function called with the close reason when closed
+	stats   *connStats      // optional session count for a Server
+	quirks  Quirks          // workarounds enabled for the peer
+	budget  *budget         // limits buffered packet bytes, or nil
+	workers *WorkerPool     // optional pool for non-interactive sessions
+
+	sess       map[uint32]*session // session store
+	parity     uint8               // parity of sequence number for incoming packets
+	mux        bool                // connection multiplexing status
+	checkMux   bool                // connection multiplexing to be negotatied
+	idleT      *time.Timer         // idle timer
+	held       *session            // session with a full queue, or nil
+	heldP      []byte              // packet waiting to be queued for held
+	orphans    int                 // handlers outliving their sessions at the last self check
+	peerClosed bool                // peer has half-closed the connection
+	started    int                 // sessions started on the connection
+	gotPacket  bool                // a packet has been received
+
+	handlers int32 // running session handlers, accessed atomically
+	loops    int32 // running read and write loops, accessed atomically
 
 	// channels used for communicating with connection serving goroutines
 	sessReq   chan sessRequest  // send a request here to create a new session
 	sessClose chan *session     // send a session here to have it closed
 	rc        chan []byte       // channel for incoming raw byte packets
 	wc        chan writeRequest // send requests to write packets on this channel
+	wdone     chan struct{}     // closed when the write loop exits
+	eof       chan struct{}     // read loop sends here when the peer half-closes
 
 	mu   sync.Mutex    // protects the following
 	done chan struct{} // close channel to close connection
@@ -313,18 +511,56 @@ func (c *conn) close() {
 	}
 }
 
+// closed returns whether the connection has been closed.
+func (c *conn) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// setErr records the reason the connection closed. Only the first error is kept.
 func (c *conn) setErr(err error) {
 	c.mu.Lock()
-	c.err = err
+	if c.err == nil {
+		c.err = err
+	}
 	c.mu.Unlock()
 }
 
 func (c *conn) readErr() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	err := c.err
-	c.err = nil
-	return err
+	return c.err
+}
+
+// closeTimeout closes the connection because the idle timer expired.
+func (c *conn) closeTimeout() {
+	c.setErr(&ConnClosedError{Reason: CloseIdle})
+	c.close()
+}
+
+// closedError returns the error for the connection closing because of err,
+// with the reason given by a Server if it closed the connection.
+func (c *conn) closedError(err error) *ConnClosedError {
+	if c.stats != nil {
+		if r, ok := c.stats.closingReason(); ok {
+			return &ConnClosedError{Reason: r, Err: err}
+		}
+	}
+	return connClosedError(err)
+}
+
+// tlsState returns the TLS connection state if the network connection uses TLS.
+func (c *conn) tlsState() *tls.ConnectionState {
+	tc, ok := c.nc.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	cs := tc.ConnectionState()
+	return &cs
 }
 
 // newClientSession is called by a client to create a new session.
@@ -346,7 +582,7 @@ func (c *conn) newClientSession(ctx context.Context) (*session, error) {
 			if err := c.readErr(); err != nil {
 				return nil, err
 			}
-			return nil, errConnectionClosed
+			return nil, ErrConnClosed
 		case c.sessReq <- req:
 			reply := <-req.reply
 			if reply.err != errSessionIDInUse {
@@ -358,100 +594,107 @@ func (c *conn) newClientSession(ctx context.Context) (*session, error) {
 	}
 }
 
-// readPacketHeader reads the packet header and sets the deadline for
+// readPacketHeader reads the packet header into h and sets the deadline for
 // reading the body.
-func (c *conn) readPacketHeader() ([]byte, error) {
-	h := make([]byte, hdrLen, 1024)
-
-	var n int
-	var err error
-	for err == nil {
-		var nn int
-		nn, err = c.nc.Read(h[n:])
-		// set read deadline on first successful read
-		if n == 0 && nn > 0 && c.ReadTimeout > 0 && (err == nil || nn == hdrLen) {
-			deadline := time.Now().Add(c.ReadTimeout)
-			err = c.nc.SetReadDeadline(deadline)
-		}
-		n += nn
-		if n == hdrLen {
-			return h, nil
+func (c *conn) readPacketHeader(h []byte) error {
+	// wait for the first byte before setting the read deadline
+	if _, err := c.br.Peek(1); err != nil {
+		return err
+	}
+	if c.ReadTimeout > 0 {
+		if err := c.nc.SetReadDeadline(time.Now().Add(c.ReadTimeout)); err != nil {
+			return err
 		}
 	}
-	if err == io.EOF && n > 0 {
-		err = errUnexpectedEOF
+	if _, err := io.ReadFull(c.br, h); err != nil {
+		return unexpectedEOF(err)
 	}
-	return nil, err
+	return nil
 }
 
-func (c *conn) readPacketBody(h []byte) ([]byte, error) {
-	// check body size
-	s := binary.BigEndian.Uint32(h[hdrBodyLen:])
-	if s > maxBodyLen {
-		return nil, errors.New("packet too large")
-	} else if s == 0 {
-		// empty packet body, so return
-		return h, nil
+// readPacketBody reads a packet body of size s into a pooled buffer,
+// returning it appended to the header h.
+func (c *conn) readPacketBody(h []byte, s int) ([]byte, error) {
+	p := append(getBuf(len(h)+s), h...)
+	p = p[:len(h)+s]
+	if _, err := io.ReadFull(c.br, p[len(h):]); err != nil {
+		putBuf(p)
+		return nil, unexpectedEOF(err)
 	}
-	// expand packet to include body
-	p := append(h, make([]byte, s)...)
+	return p, nil
+}
 
-	// read packet body
-	var err error
-	n := len(h)
-	l := len(p)
-	for err == nil {
-		var nn int
-		nn, err = c.nc.Read(p[n:])
-		n += nn
-		if n == l {
-			return p, nil
-		}
+// unexpectedEOF returns errUnexpectedEOF if err shows the connection closed part way
+// through a packet.
+func unexpectedEOF(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return errUnexpectedEOF
 	}
-	if err == io.EOF {
-		err = errUnexpectedEOF
-	}
-	return nil, err
+	return err
 }
 
-// readPacket reads a raw TACACS+ packet or returns an error
-func (c *conn) readPacket() ([]byte, error) {
-	// clear read deadline
-	if c.ReadTimeout > 0 {
+// readPacket reads a raw TACACS+ packet or returns an error.
+// First is set for the first packet read from the connection.
+func (c *conn) readPacket(first bool) ([]byte, error) {
+	if first && c.FirstByteTimeout > 0 && c.parity == 0 {
+		// server connection waiting for its first packet
+		if err := c.nc.SetReadDeadline(time.Now().Add(c.FirstByteTimeout)); err != nil {
+			return nil, err
+		}
+	} else if c.ReadTimeout > 0 || c.FirstByteTimeout > 0 {
+		// clear read deadline
 		if err := c.nc.SetReadDeadline(time.Time{}); err != nil {
 			return nil, err
 		}
 	}
 	// read packet header
-	h, err := c.readPacketHeader()
-	if err != nil {
+	var hb [hdrLen]byte
+	h := hb[:]
+	if err := c.readPacketHeader(h); err != nil {
 		return nil, err
 	}
-	// check major version
-	v := h[hdrVer]
-	if v>>4 != verMajor {
-		return nil, fmt.Errorf("unsupported major version %d", v>>4)
+	// check major version and body size
+	hdr, _ := ParseHeader(h)
+	if err := hdr.Validate(); err != nil {
+		kind := EventBadVersion
+		if err == errPacketTooLarge {
+			kind = EventPacketTooLarge
+		}
+		c.securityEvent(kind, h, err)
+		return nil, err
 	}
 	// read packet body
-	return c.readPacketBody(h)
+	return c.readPacketBody(h, int(hdr.BodyLen))
 }
 
 // readLoop reads incoming packets sending them to the connection rc channel
 func (c *conn) readLoop() {
-	for {
-		p, err := c.readPacket()
+	for first := true; ; first = false {
+		// pause reading while too many bytes are buffered
+		if !c.budget.wait(c.done) {
+			return
+		}
+		p, err := c.readPacket(first)
+		if err == io.EOF && c.HalfCloseGrace > 0 {
+			// The peer has finished sending. Leave the connection open
+			// for sessions in progress to reply.
+			select {
+			case c.eof <- struct{}{}:
+			case <-c.done:
+			}
+			return
+		}
 		if err != nil {
 			select {
 			case <-c.done:
 				// connection already closed, ignore error
 			default:
-				if err != io.EOF {
-					c.setErr(err)
-				}
+				c.setErr(c.closedError(err))
 				c.close()
 			}
 			return
 		}
+		c.budget.add(len(p))
 		select {
 		case c.rc <- p:
 		case <-c.done:
@@ -462,10 +705,21 @@ func (c *conn) readLoop() {
 
 // writeLoop accepts and processes writeRequest's for the connection
 func (c *conn) writeLoop() {
+	defer close(c.wdone)
 	for {
 		select {
 		case req := <-c.wc:
 			deadline := req.deadline
+			if !deadline.IsZero() && !time.Now().Before(deadline) {
+				// The session's deadline passed while the request was queued.
+				// Nothing has been written, so skip it instead of failing the
+				// write and closing the connection.
+				c.budget.release(len(req.p))
+				req.ec <- context.DeadlineExceeded
+				continue
+			}
+			// WriteTimeout runs from here, so time spent queued behind
+			// other sessions' writes isn't charged to this one.
 			if c.WriteTimeout > 0 {
 				d := time.Now().Add(c.WriteTimeout)
 				if deadline.IsZero() || d.Before(deadline) {
@@ -473,12 +727,23 @@ func (c *conn) writeLoop() {
 				}
 			}
 
+			binary.BigEndian.PutUint32(req.p[hdrBodyLen:], uint32(len(req.p)-hdrLen))
+			if c.Secretless {
+				req.p[hdrFlags] |= FlagUnencrypted
+				c.trace(DirOut, req.p)
+			} else {
+				c.trace(DirOut, req.p)
+				crypt(req.p, req.key)
+			}
+
 			err := c.nc.SetWriteDeadline(deadline)
 			if err == nil {
 				_, err = c.nc.Write(req.p)
 			}
+			c.budget.release(len(req.p))
 			req.ec <- err
 			if err != nil {
+				c.setErr(c.closedError(err))
 				c.close()
 				return
 			}
@@ -494,9 +759,13 @@ func (c *conn) writeLoop() {
 func (c *conn) processPacket(p []byte) {
 	// on first packet read get mux status
 	if c.checkMux {
-		c.mux = p[hdrFlags]&hdrFlagSingleConnect > 0
+		c.mux = p[hdrFlags]&hdrFlagSingleConnect > 0 && !c.quirks.Has(QuirkSingleConnect)
 		c.checkMux = false
 	}
+	if !c.gotPacket {
+		c.gotPacket = true
+		c.logMux(p)
+	}
 
 	id := binary.BigEndian.Uint32(p[hdrID:])
 	s := c.sess[id]
@@ -504,22 +773,53 @@ func (c *conn) processPacket(p []byte) {
 		// stop idle timer if connection has no sessions
 		if len(c.sess) == 0 && c.idleT != nil && !c.idleT.Stop() {
 			// idle timer already triggered, return and let connection close
+			c.budget.release(len(p))
 			return
 		}
 		// create new session
 		s = newSession(c, id)
+		s.ctx, s.cancel = context.WithCancelCause(c.ctx)
 		c.sess[id] = s
-		// start session handler goroutine
-		go c.handle(s)
+		c.started++
+		addSession(1)
+		c.countSessions()
+		// start session handler, on a worker if possible
+		if !c.dispatch(s, p[hdrType]) {
+			go c.handleSession(s, p[hdrType])
+		}
 	}
 	// queue packet
 	select {
 	case s.in <- p:
 	default:
-		// Full packet queue should not happen. Close session if it does.
-		c.closeSession(s)
-		s.setErr(errPacketQueueFull)
+		// The session hasn't read its last packet yet. Hold this one and stop
+		// reading from the connection until the session has room for it.
+		c.held, c.heldP = s, p
+	}
+}
+
+// dispatch runs the handler for new session s of type t on a worker,
+// returning false if it wasn't possible.
+func (c *conn) dispatch(s *session, t uint8) bool {
+	if c.workers == nil || (t != sessTypeAuthor && t != sessTypeAcct) {
+		return false
+	}
+	return c.workers.submit(func() { c.handleSession(s, t) })
+}
+
+// handleSession runs the session handler for new session s of type t.
+func (c *conn) handleSession(s *session, t uint8) {
+	atomic.AddInt32(&c.handlers, 1)
+	atomic.AddInt64(&live.handlers, 1)
+	defer atomic.AddInt64(&live.handlers, -1)
+	defer atomic.AddInt32(&c.handlers, -1)
+	if !c.ProfileLabels {
+		c.handle(s)
+		return
 	}
+	pprof.Do(c.ctx, pprof.Labels("tacplus_session_type", sessTypeName(t)), func(context.Context) {
+		c.handle(s)
+	})
 }
 
 // newSession processes a client session create request and sends
@@ -533,10 +833,15 @@ func (c *conn) newSession(sr sessRequest) {
 	} else if len(c.sess) == 0 && c.idleT != nil && !c.idleT.Stop() {
 		// Stopped running idle timer but it had already triggered.
 		// Return error and allow connection to close.
-		r.err = errConnectionClosed
+		r.err = ErrConnClosed
+	} else if k, err := c.clientKey(); err != nil {
+		r.err = err
 	} else {
 		r.s = newSession(c, sr.id)
+		r.s.key = k
 		c.sess[sr.id] = r.s
+		c.started++
+		addSession(1)
 	}
 	sr.reply <- r
 }
@@ -547,26 +852,80 @@ func (c *conn) closeSession(s *session) {
 		return
 	}
 	delete(c.sess, s.id)
+	addSession(-1)
+	c.countSessions()
+	if c.held == s {
+		// drop the held packet, resuming reads
+		c.budget.release(len(c.heldP))
+		c.held, c.heldP = nil, nil
+	}
+	s.mu.Lock()
+	cause := s.err
+	s.err = errSessionClosed
+	s.mu.Unlock()
+	if cause == nil {
+		cause = errSessionClosed
+	}
+	s.cancelCause(cause)
 	close(s.done)
 	close(s.in)
-	s.setErr(errSessionClosed)
+	// stop counting any packet left unread
+	for p := range s.in {
+		c.budget.release(len(p))
+	}
+	c.armIdle()
+}
+
+// armIdle starts the idle timer if the connection is multiplexed and has no sessions.
+func (c *conn) armIdle() {
 	if len(c.sess) == 0 && c.mux && c.IdleTimeout > 0 {
 		if c.idleT == nil {
 			// create idle timer that closes the connection when triggered
-			c.idleT = time.AfterFunc(c.IdleTimeout, c.close)
+			c.idleT = time.AfterFunc(c.IdleTimeout, c.closeTimeout)
 		} else {
 			c.idleT.Reset(c.IdleTimeout)
 		}
 	}
 }
 
+// defaultFlushTimeout is how long a closing connection waits for a write in
+// progress to finish if WriteTimeout isn't set.
+const defaultFlushTimeout = time.Second
+
+// flush waits for the write loop to finish any write in progress, so the last
+// reply on a connection isn't cut short by closing it. The wait is limited to
+// WriteTimeout, or defaultFlushTimeout if it isn't set.
+func (c *conn) flush() {
+	d := c.WriteTimeout
+	if d <= 0 {
+		d = defaultFlushTimeout
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-c.wdone:
+	case <-t.C:
+	}
+}
+
+// countSessions updates the Server session count for the connection.
+func (c *conn) countSessions() {
+	if c.stats != nil {
+		atomic.StoreInt32(&c.stats.sessions, int32(len(c.sess)))
+	}
+}
+
 func (c *conn) cleanup() {
 	// close connection done channel before session done channel
 	c.close()
+	// set close reason for remaining sessions if no error occurred
+	c.setErr(c.closedError(nil))
 	for _, s := range c.sess {
+		s.cancelCause(c.readErr())
 		close(s.done)
 		close(s.in)
 	}
+	c.flush()
 	err := c.nc.Close()
 	if err != nil {
 		c.log(err)
@@ -574,20 +933,76 @@ func (c *conn) cleanup() {
 	if c.idleT != nil {
 		c.idleT.Stop()
 	}
+	addSession(-int64(len(c.sess)))
+	atomic.AddInt64(&live.conns, -1)
+	if c.stats != nil {
+		c.stats.setClosed(c.readErr())
+	}
+	if c.SelfCheckInterval > 0 {
+		c.checkClosed()
+	}
+	if c.onClose != nil {
+		c.onClose(c.readErr())
+	}
 }
 
 // serve a TACACS+ connection.
 // serve multiplexes incoming packets, session create and session close requests.
 func (c *conn) serve() {
-	go c.readLoop()
-	go c.writeLoop()
+	if c.ProfileLabels {
+		c.ctx = pprof.WithLabels(c.ctx, pprof.Labels("tacplus_peer", c.nc.RemoteAddr().String()))
+		pprof.SetGoroutineLabels(c.ctx)
+	}
+	atomic.AddInt64(&live.conns, 1)
+	if !c.Secretless && !md5Available {
+		// refuse connections needing obfuscation before reading any packet
+		c.log(errNoMD5)
+		c.setErr(errNoMD5)
+		close(c.wdone) // no write loop to flush
+		c.cleanup()
+		return
+	}
+	c.loop(c.readLoop)
+	c.loop(c.writeLoop)
 	defer c.cleanup()
 
+	var checkC <-chan time.Time
+	if c.SelfCheckInterval > 0 {
+		t := time.NewTicker(c.SelfCheckInterval)
+		defer t.Stop()
+		checkC = t.C
+	}
+	// Close a multiplexed connection that never gets a session, such as a client
+	// connection whose first session request is abandoned.
+	c.armIdle()
+
+	// timer closing a non-mux connection with no sessions after NonMuxLinger
+	var linger *time.Timer
+	var lingerC <-chan time.Time
+	defer func() {
+		if linger != nil {
+			linger.Stop()
+		}
+	}()
+	// closes the connection when the grace period after the peer half-closes ends
+	var graceC <-chan time.Time
+
 	for {
+		// While a packet is held for a session, stop receiving packets so the
+		// read loop blocks, pushing back on the peer.
+		rc := c.rc
+		var in chan<- []byte
+		if c.held != nil {
+			rc = nil
+			in = c.held.in
+		}
 		select {
-		case p := <-c.rc:
+		case p := <-rc:
 			// process incoming packet
 			c.processPacket(p)
+		case in <- c.heldP:
+			// session has room for the held packet
+			c.held, c.heldP = nil, nil
 		case s := <-c.sessClose:
 			// session close request
 			c.closeSession(s)
@@ -597,10 +1012,42 @@ func (c *conn) serve() {
 		case <-c.done:
 			// close connection
 			return
+		case <-c.ctx.Done():
+			c.setErr(&ConnClosedError{Reason: CloseShutdown, Err: c.ctx.Err()})
+			return
+		case <-lingerC:
+			// no new session while lingering
+			c.setErr(&ConnClosedError{Reason: CloseDone})
+			return
+		case <-checkC:
+			c.reportCheck(c.selfCheck())
+		case <-c.eof:
+			c.setErr(connClosedError(io.EOF))
+			c.peerClosed = true
+			grace := time.NewTimer(c.HalfCloseGrace)
+			defer grace.Stop()
+			graceC = grace.C
+		case <-graceC:
+			c.log("peer closed connection with ", len(c.sess), " sessions in progress")
+			return
+		}
+		// close half-closed connections once sessions in progress finish
+		if c.peerClosed && len(c.sess) == 0 {
+			return
 		}
 		// close non-mux connections with no sessions
 		if len(c.sess) == 0 && !c.mux {
-			return
+			if c.NonMuxLinger <= 0 {
+				c.setErr(&ConnClosedError{Reason: CloseDone})
+				return
+			}
+			if lingerC == nil {
+				linger = time.NewTimer(c.NonMuxLinger)
+				lingerC = linger.C
+			}
+		} else if lingerC != nil {
+			linger.Stop()
+			lingerC = nil
 		}
 	}
 }
@@ -608,9 +1055,13 @@ func (c *conn) serve() {
 func newConn(nc net.Conn, h func(*session), cfg ConnConfig) *conn {
 	c := &conn{
 		nc:         nc,
+		br:         bufio.NewReaderSize(nc, cfg.readBufferSize()),
 		mux:        cfg.LegacyMux,             // For LegacyMux allow multiplexing regardless of header flags.
 		checkMux:   !cfg.LegacyMux && cfg.Mux, // For (draft) Mux check the first packet for the single-connection flag.
 		handle:     h,
+		ctx:        context.Background(),
+		quirks:     cfg.peerQuirks(nc.RemoteAddr()),
+		budget:     newBudget(cfg.MaxBufferedBytes),
 		ConnConfig: cfg,
 	}
 	if c.handle == nil {
@@ -627,6 +1078,8 @@ func newConn(nc net.Conn, h func(*session), cfg ConnConfig) *conn {
 	c.sessClose = make(chan *session)
 	c.rc = make(chan []byte)
 	c.wc = make(chan writeRequest)
+	c.wdone = make(chan struct{})
+	c.eof = make(chan struct{})
 	c.done = make(chan struct{})
 	c.sess = make(map[uint32]*session)
 
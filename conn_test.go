@@ -0,0 +1,446 @@
+//go:build !tacplus_nomd5
+
+package tacplus
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"io"
+	"net"
+	"runtime/pprof"
+	"testing"
+	"time"
+)
+
+// refCrypt is a direct implementation of the pad generation described in
+// RFC 8907 section 4.5, used to check crypt.
+func refCrypt(p, key []byte) {
+	var pad, prev []byte
+	for len(pad) < len(p)-hdrLen {
+		h := md5.New()
+		h.Write(p[hdrID : hdrID+4])
+		h.Write(key)
+		h.Write([]byte{p[hdrVer], p[hdrSeqNo]})
+		h.Write(prev)
+		prev = h.Sum(nil)
+		pad = append(pad, prev...)
+	}
+	for i := range p[hdrLen:] {
+		p[hdrLen+i] ^= pad[i]
+	}
+}
+
+func TestCrypt(t *testing.T) {
+	key := []byte("test key")
+	for _, n := range []int{0, 1, 15, 16, 17, 100, 1024} {
+		p := make([]byte, hdrLen+n)
+		p[hdrVer] = verDefault
+		p[hdrSeqNo] = 3
+		copy(p[hdrID:], []byte{1, 2, 3, 4})
+		for i := range p[hdrLen:] {
+			p[hdrLen+i] = byte(i)
+		}
+		want := append([]byte(nil), p...)
+		refCrypt(want, key)
+
+		got := append([]byte(nil), p...)
+		crypt(got, key)
+		if !bytes.Equal(got, want) {
+			t.Errorf("body length %d: crypt output differs from reference", n)
+		}
+		crypt(got, key)
+		if !bytes.Equal(got, p) {
+			t.Errorf("body length %d: crypt is not reversible", n)
+		}
+	}
+}
+
+func benchmarkCrypt(b *testing.B, n int) {
+	p := make([]byte, hdrLen+n)
+	key := []byte("benchmark secret")
+	b.SetBytes(int64(n))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		crypt(p, key)
+	}
+}
+
+func BenchmarkCrypt1K(b *testing.B)   { benchmarkCrypt(b, 1024) }
+func BenchmarkCrypt100K(b *testing.B) { benchmarkCrypt(b, 100*1024) }
+
+// loopReader endlessly repeats p, returning at most chunk bytes per Read
+// to simulate a slow link.
+type loopReader struct {
+	p     []byte
+	off   int
+	chunk int
+}
+
+func (r *loopReader) Read(b []byte) (int, error) {
+	if len(b) > r.chunk {
+		b = b[:r.chunk]
+	}
+	n := copy(b, r.p[r.off:])
+	r.off = (r.off + n) % len(r.p)
+	return n, nil
+}
+
+func benchmarkReadPacket(b *testing.B, size int) {
+	p := make([]byte, hdrLen+size)
+	h := Header{Version: verDefault, Type: TypeAcct, SeqNo: 1, BodyLen: uint32(size)}
+	h.AppendTo(p[:0])
+	c := &conn{br: bufio.NewReaderSize(&loopReader{p: p, chunk: 1460}, 64*1024)}
+	b.SetBytes(int64(len(p)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.readPacket(false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadPacket1K(b *testing.B)   { benchmarkReadPacket(b, 1024) }
+func BenchmarkReadPacket100K(b *testing.B) { benchmarkReadPacket(b, 100*1024) }
+
+func TestSessionQueueFlowControl(t *testing.T) {
+	bh := &blockAcctHandler{
+		RequestHandler: testHandler.Handler,
+		started:        make(chan struct{}),
+		unblock:        make(chan struct{}),
+	}
+	h := &ServerConnHandler{Handler: bh, ConnConfig: testHandler.ConnConfig}
+
+	cc, sc := net.Pipe()
+	defer cc.Close()
+	go h.Serve(sc)
+
+	if _, err := cc.Write(testAcctPacket(testAcctReq, 1)); err != nil {
+		t.Fatal(err)
+	}
+	<-bh.started
+	// fills the session queue, is held by the connection, then is read and
+	// waits to be passed on
+	for _, seq := range []uint8{3, 5, 7} {
+		if _, err := cc.Write(testAcctPacket(testAcctReq, seq)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// reading has paused rather than closing the session
+	_ = cc.SetWriteDeadline(time.Now().Add(2 * timeScale))
+	if _, err := cc.Write(testAcctPacket(testAcctReq, 9)); err == nil {
+		t.Fatal("write completed while session queue full")
+	}
+	close(bh.unblock)
+	_ = cc.SetReadDeadline(time.Now().Add(10 * timeScale))
+	p := make([]byte, 1024)
+	n, err := cc.Read(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p = p[:n]
+	crypt(p, testSecret)
+	rep := new(AcctReply)
+	if err = rep.unmarshal(p[hdrLen:]); err != nil {
+		t.Fatal(err)
+	}
+	if rep.Status != AcctStatusSuccess {
+		t.Errorf("reply status %d, want success", rep.Status)
+	}
+}
+
+func TestNonMuxLinger(t *testing.T) {
+	h := &ServerConnHandler{Handler: testHandler.Handler, ConnConfig: testHandler.ConnConfig}
+	h.ConnConfig.NonMuxLinger = 5 * timeScale
+
+	cc, sc := net.Pipe()
+	defer cc.Close()
+	go h.Serve(sc)
+
+	// a second session on the connection after the first closes is served
+	p := make([]byte, 1024)
+	for i := 0; i < 2; i++ {
+		if _, err := cc.Write(testAcctPacket(testAcctReq, 1)); err != nil {
+			t.Fatal(err)
+		}
+		_ = cc.SetReadDeadline(time.Now().Add(10 * timeScale))
+		if _, err := cc.Read(p); err != nil {
+			t.Fatalf("session %d: %v", i, err)
+		}
+		time.Sleep(timeScale)
+	}
+	// the connection closes once the linger time passes with no session
+	_ = cc.SetReadDeadline(time.Now().Add(20 * timeScale))
+	if _, err := cc.Read(p); err != io.EOF {
+		t.Errorf("got read error %v, want %v", err, io.EOF)
+	}
+}
+
+func TestIdleTimeoutNoSessions(t *testing.T) {
+	cc, sc := net.Pipe()
+	defer cc.Close()
+	c := newConn(sc, nil, ConnConfig{LegacyMux: true, IdleTimeout: 2 * timeScale})
+	closed := make(chan error, 1)
+	c.onClose = func(err error) { closed <- err }
+	go c.serve()
+
+	// a multiplexed connection that never gets a session is closed when idle
+	select {
+	case err := <-closed:
+		var ce *ConnClosedError
+		if !errors.As(err, &ce) || ce.Reason != CloseIdle {
+			t.Errorf("got close reason %v, want %v", err, CloseIdle)
+		}
+	case <-time.After(10 * timeScale):
+		t.Fatal("idle connection not closed")
+	}
+}
+
+func TestFirstByteTimeout(t *testing.T) {
+	// a connection that sends nothing, or only part of a header, is closed
+	for _, send := range [][]byte{nil, {verDefault, TypeAcct}} {
+		cc, sc := net.Pipe()
+		h := &ServerConnHandler{Handler: testHandler.Handler, ConnConfig: testHandler.ConnConfig}
+		h.ConnConfig.FirstByteTimeout = 2 * timeScale
+		h.ConnConfig.Log = func(...interface{}) {}
+		go h.Serve(sc)
+		if send != nil {
+			if _, err := cc.Write(send); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_ = cc.SetReadDeadline(time.Now().Add(10 * timeScale))
+		if _, err := cc.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("sent %v: got read error %v, want %v", send, err, io.EOF)
+		}
+		cc.Close()
+	}
+
+	// sessions on the connection aren't limited by it
+	h := testHandler
+	h.ConnConfig.FirstByteTimeout = timeScale
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, err = c.SendAcctRequest(ctx, testAcctReq); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * timeScale)
+	}
+}
+
+func TestCloseFlushesWrite(t *testing.T) {
+	cc, sc := net.Pipe()
+	defer cc.Close()
+	body := bytes.Repeat([]byte{'x'}, 100)
+	// the session stops waiting for its reply to be written and closes,
+	// closing the connection
+	h := func(s *session) {
+		if _, err := s.readPacket(context.Background()); err != nil {
+			t.Error(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(timeScale, cancel)
+		_ = s.writePacket(ctx, append(make([]byte, hdrLen), body...))
+		s.close()
+	}
+	c := newConn(sc, h, ConnConfig{Secret: testSecret, WriteTimeout: 10 * timeScale})
+	go c.serve()
+
+	if _, err := cc.Write(testAcctPacket(testAcctReq, 1)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(3 * timeScale)
+	p := make([]byte, hdrLen+len(body))
+	if _, err := io.ReadFull(cc, p); err != nil {
+		t.Fatal("reply cut short:", err)
+	}
+}
+
+func TestHalfCloseGrace(t *testing.T) {
+	bh := &blockAcctHandler{
+		RequestHandler: testHandler.Handler,
+		started:        make(chan struct{}),
+		unblock:        make(chan struct{}),
+	}
+	h := &ServerConnHandler{Handler: bh, ConnConfig: testHandler.ConnConfig}
+	h.ConnConfig.HalfCloseGrace = 10 * timeScale
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{ServeConnContext: h.ServeContext}
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Close()
+
+	nc, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	if _, err = nc.Write(testAcctPacket(testAcctReq, 1)); err != nil {
+		t.Fatal(err)
+	}
+	<-bh.started
+	// the reply is sent after the client finishes sending
+	if err = nc.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(timeScale)
+	close(bh.unblock)
+	_ = nc.SetReadDeadline(time.Now().Add(10 * timeScale))
+	p := make([]byte, 1024)
+	n, err := nc.Read(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p = p[:n]
+	crypt(p, testSecret)
+	rep := new(AcctReply)
+	if err = rep.unmarshal(p[hdrLen:]); err != nil || rep.Status != AcctStatusSuccess {
+		t.Fatalf("got reply %+v, error %v", rep, err)
+	}
+	// the server closes once the session is done
+	if _, err = nc.Read(p); err != io.EOF {
+		t.Fatalf("got read error %v, want %v", err, io.EOF)
+	}
+	for i := 0; i < 10 && srv.CloseCounts()[ClosePeer] == 0; i++ {
+		time.Sleep(timeScale / 4)
+	}
+	if counts := srv.CloseCounts(); counts[ClosePeer] != 1 || len(counts) != 1 {
+		t.Errorf("got close counts %v, want 1 closed by peer", counts)
+	}
+}
+
+func TestWriteLoop(t *testing.T) {
+	cc, sc := net.Pipe()
+	defer cc.Close()
+	c := newConn(sc, nil, ConnConfig{WriteTimeout: 10 * timeScale})
+	defer c.close()
+	go c.writeLoop()
+
+	p := append(make([]byte, hdrLen), "body"...)
+	p[hdrSeqNo] = 1
+
+	// a request whose deadline passed while queued is skipped
+	wr := writeRequest{p: p, key: testSecret, deadline: time.Now().Add(-time.Second), ec: make(chan error, 1)}
+	c.wc <- wr
+	if err := <-wr.ec; err != context.DeadlineExceeded {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if c.closed() {
+		t.Fatal("connection closed after skipped write")
+	}
+
+	// the write loop sets the body length and encrypts the packet
+	wr = writeRequest{p: p, key: testSecret, ec: make(chan error, 1)}
+	c.wc <- wr
+	b := make([]byte, 64)
+	n, err := cc.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = <-wr.ec; err != nil {
+		t.Fatal(err)
+	}
+	b = b[:n]
+	crypt(b, testSecret)
+	if h, _ := ParseHeader(b); h.BodyLen != 4 || string(b[hdrLen:]) != "body" {
+		t.Errorf("got packet %q with body length %d", b[hdrLen:], h.BodyLen)
+	}
+}
+
+// labelHandler records the pprof peer label of authorization request contexts.
+type labelHandler struct {
+	RequestHandler
+	peer chan string
+}
+
+func (h *labelHandler) HandleAuthorRequest(ctx context.Context, a *AuthorRequest, s *ServerSession) *AuthorResponse {
+	peer, _ := pprof.Label(ctx, "tacplus_peer")
+	h.peer <- peer
+	return h.RequestHandler.HandleAuthorRequest(ctx, a, s)
+}
+
+func TestProfileLabels(t *testing.T) {
+	lh := &labelHandler{RequestHandler: testHandler.Handler, peer: make(chan string, 1)}
+	h := testHandler
+	h.Handler = lh
+	h.ConnConfig.ProfileLabels = true
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	if _, err = c.SendAuthorRequest(context.Background(), testAuthorReq); err != nil {
+		t.Fatal(err)
+	}
+	if peer := <-lh.peer; peer == "" {
+		t.Error("session context has no peer label")
+	}
+}
+
+func TestSeqRetransmit(t *testing.T) {
+	for _, tolerate := range []bool{false, true} {
+		h := testHandler
+		h.ConnConfig.TolerateSeqRetransmit = tolerate
+		s, c, err := newTestInstance(&h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := context.Background()
+		rs, err := c.NewRawSession(ctx, verDefault, sessTypeAuthen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start, _ := testAuthStart.MarshalBinary()
+		if err = rs.WritePacket(ctx, start); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = rs.ReadPacket(ctx); err != nil {
+			t.Fatal(err)
+		}
+		// resend the start packet, then answer the prompt if it's ignored
+		before := DecodeErrors().BadSeqNo
+		rs.last = 0
+		if err = rs.WritePacket(ctx, start); err != nil {
+			t.Fatal(err)
+		}
+		if tolerate {
+			rs.last = 2
+			cont, _ := AuthenContinue{Message: "user"}.MarshalBinary()
+			if err = rs.WritePacket(ctx, cont); err != nil {
+				t.Fatal(err)
+			}
+		}
+		p, err := rs.ReadPacket(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rep := new(AuthenReply)
+		if err = rep.UnmarshalBinary(p.Body); err != nil {
+			t.Fatal(err)
+		}
+		if tolerate {
+			if rep.Status != AuthenStatusGetPass || p.Header.SeqNo != 4 {
+				t.Errorf("got status %d seq %d, want password prompt", rep.Status, p.Header.SeqNo)
+			}
+		} else {
+			if rep.Status != AuthenStatusError || rep.ServerMsg != "invalid sequence number 1, expected 3" {
+				t.Errorf("got status %d %q, want sequence number error", rep.Status, rep.ServerMsg)
+			}
+			if DecodeErrors().BadSeqNo == before {
+				t.Error("sequence number error not counted")
+			}
+		}
+		rs.Close()
+		s.close()
+	}
+}
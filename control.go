@@ -0,0 +1,230 @@
+package tacplus
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// DebugLog is a log function that can be switched on and off while a server is
+// running. It is disabled until SetEnabled is called.
+type DebugLog struct {
+	// Optional function to log messages. If not defined log.Print will be used.
+	Log func(v ...interface{})
+
+	enabled int32 // accessed atomically
+}
+
+// SetEnabled switches debug logging on or off.
+func (d *DebugLog) SetEnabled(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&d.enabled, v)
+}
+
+// Enabled reports whether debug logging is on.
+func (d *DebugLog) Enabled() bool {
+	return atomic.LoadInt32(&d.enabled) == 1
+}
+
+// Print logs v if debug logging is on.
+func (d *DebugLog) Print(v ...interface{}) {
+	if !d.Enabled() {
+		return
+	}
+	if d.Log == nil {
+		log.Print(v...)
+	} else {
+		d.Log(v...)
+	}
+}
+
+// Trace logs a summary of the packet p if debug logging is on. It can be used as
+// a ConnConfig PacketTrace function.
+func (d *DebugLog) Trace(p TracedPacket) {
+	if !d.Enabled() {
+		return
+	}
+	d.Print(fmt.Sprintf("%s %s session %#08x type %s seq %d flags %#02x length %d",
+		p.Dir, p.RemoteAddr, p.Header.SessionID, sessTypeName(p.Header.Type),
+		p.Header.SeqNo, p.Header.Flags, p.Header.BodyLen))
+}
+
+// SetEvery changes the Every sampling rate of a running TranscriptSampler.
+func (s *TranscriptSampler) SetEvery(n int) {
+	s.mu.Lock()
+	s.Every = n
+	s.mu.Unlock()
+}
+
+// Control changes the behavior of a running server in response to text commands,
+// such as those from an administrator connected to a unix socket with Serve.
+// Commands, one per line, are:
+//
+//	debug on|off     switch Debug logging on or off
+//	capture on|off   enable or disable Capture
+//	sample N|off     sample one in N sessions with Sampler, or stop sampling
+//	drain on|off     start or stop draining Server
+//	status           show the current settings
+//
+// Commands for components that are not set fail.
+type Control struct {
+	Server  *Server            // optional server to drain
+	Debug   *DebugLog          // optional debug log
+	Capture *PacketCapture     // optional packet capture
+	Sampler *TranscriptSampler // optional transcript sampler
+
+	// Optional function to log changes made by commands. If not defined log.Print will be used.
+	Log func(v ...interface{})
+}
+
+func (c *Control) log(v ...interface{}) {
+	if c.Log == nil {
+		log.Print(v...)
+	} else {
+		c.Log(v...)
+	}
+}
+
+// parseSwitch parses an on or off argument.
+func parseSwitch(args []string) (bool, error) {
+	if len(args) == 1 {
+		switch args[0] {
+		case "on":
+			return true, nil
+		case "off":
+			return false, nil
+		}
+	}
+	return false, fmt.Errorf("expected on or off")
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+// Exec runs the command cmd, returning a description of the result.
+func (c *Control) Exec(cmd string) (string, error) {
+	f := strings.Fields(cmd)
+	if len(f) == 0 {
+		return "", fmt.Errorf("empty command")
+	}
+	name, args := f[0], f[1:]
+	switch name {
+	case "debug":
+		on, err := parseSwitch(args)
+		if err != nil || c.Debug == nil {
+			return c.execErr(name, err, c.Debug == nil)
+		}
+		c.Debug.SetEnabled(on)
+		return "debug " + onOff(on), nil
+	case "capture":
+		on, err := parseSwitch(args)
+		if err != nil || c.Capture == nil {
+			return c.execErr(name, err, c.Capture == nil)
+		}
+		c.Capture.SetEnabled(on)
+		return "capture " + onOff(on), nil
+	case "sample":
+		if c.Sampler == nil {
+			return c.execErr(name, nil, true)
+		}
+		n := 0
+		if len(args) != 1 {
+			return c.execErr(name, fmt.Errorf("expected a number or off"), false)
+		}
+		if args[0] != "off" {
+			var err error
+			if n, err = strconv.Atoi(args[0]); err != nil || n <= 0 {
+				return c.execErr(name, fmt.Errorf("expected a number or off"), false)
+			}
+		}
+		c.Sampler.SetEvery(n)
+		if n == 0 {
+			return "sample off", nil
+		}
+		return "sample 1 in " + strconv.Itoa(n), nil
+	case "drain":
+		on, err := parseSwitch(args)
+		if err != nil || c.Server == nil {
+			return c.execErr(name, err, c.Server == nil)
+		}
+		c.Server.SetDraining(on)
+		return "drain " + onOff(on), nil
+	case "status":
+		return c.status(), nil
+	}
+	return "", fmt.Errorf("unknown command %q", name)
+}
+
+// execErr returns the error for a failed command name.
+func (c *Control) execErr(name string, err error, missing bool) (string, error) {
+	if missing {
+		return "", fmt.Errorf("%s: not configured", name)
+	}
+	return "", fmt.Errorf("%s: %w", name, err)
+}
+
+// status describes the current settings.
+func (c *Control) status() string {
+	var s []string
+	if c.Debug != nil {
+		s = append(s, "debug "+onOff(c.Debug.Enabled()))
+	}
+	if c.Capture != nil {
+		s = append(s, "capture "+onOff(c.Capture.isEnabled()))
+	}
+	if c.Sampler != nil {
+		c.Sampler.mu.Lock()
+		n := c.Sampler.Every
+		c.Sampler.mu.Unlock()
+		s = append(s, "sample "+strconv.Itoa(n))
+	}
+	if c.Server != nil {
+		s = append(s, "drain "+onOff(c.Server.Draining()), "connections "+strconv.Itoa(len(c.Server.Connections())))
+	}
+	return strings.Join(s, ", ")
+}
+
+// Serve accepts connections on l, such as a unix socket listener, running
+// commands read from them. Each command is answered with a line starting with
+// "ok" or "error". Serve returns when l fails to accept a connection.
+func (c *Control) Serve(l net.Listener) error {
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go c.serveConn(nc)
+	}
+}
+
+func (c *Control) serveConn(nc net.Conn) {
+	defer nc.Close()
+	sc := bufio.NewScanner(nc)
+	for sc.Scan() {
+		cmd := strings.TrimSpace(sc.Text())
+		if cmd == "" {
+			continue
+		}
+		reply, err := c.Exec(cmd)
+		if err != nil {
+			reply = "error: " + err.Error()
+		} else {
+			c.log("control: ", reply)
+			reply = "ok " + reply
+		}
+		if _, err = fmt.Fprintln(nc, reply); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package tacplus
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestControl(t *testing.T) {
+	var logged []string
+	debug := &DebugLog{Log: func(v ...interface{}) { logged = append(logged, fmt.Sprint(v...)) }}
+	sampler := &TranscriptSampler{Recorder: new(TranscriptRecorder)}
+	srv := new(Server)
+	ctl := &Control{Server: srv, Debug: debug, Capture: new(PacketCapture), Sampler: sampler, Log: func(...interface{}) {}}
+
+	cc, sc := net.Pipe()
+	defer cc.Close()
+	go ctl.serveConn(sc)
+	r := bufio.NewReader(cc)
+	for _, test := range []struct {
+		cmd, want string
+	}{
+		{"debug on", "ok debug on"},
+		{"capture on", "ok capture on"},
+		{"sample 10", "ok sample 1 in 10"},
+		{"drain on", "ok drain on"},
+		{"status", "ok debug on, capture on, sample 10, drain on, connections 0"},
+		{"sample off", "ok sample off"},
+		{"drain off", "ok drain off"},
+		{"debug maybe", "error: debug: expected on or off"},
+		{"sample -1", "error: sample: expected a number or off"},
+		{"reboot", `error: unknown command "reboot"`},
+	} {
+		if _, err := fmt.Fprintln(cc, test.cmd); err != nil {
+			t.Fatal(err)
+		}
+		got, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got = strings.TrimSpace(got); got != test.want {
+			t.Errorf("%q: got %q, want %q", test.cmd, got, test.want)
+		}
+	}
+	if !debug.Enabled() || !ctl.Capture.isEnabled() || sampler.Every != 0 || srv.Draining() {
+		t.Error("unexpected settings after commands")
+	}
+
+	debug.Trace(TracedPacket{Dir: DirIn, RemoteAddr: "192.0.2.1:49", Header: Header{Type: TypeAuthor, SeqNo: 1}})
+	debug.SetEnabled(false)
+	debug.Print("not logged")
+	if len(logged) != 1 || !strings.HasPrefix(logged[0], "in 192.0.2.1:49 session") {
+		t.Errorf("got debug log %q", logged)
+	}
+
+	if _, err := (&Control{}).Exec("drain on"); err == nil || err.Error() != "drain: not configured" {
+		t.Errorf("got error %v for missing server", err)
+	}
+}
+
+func TestServerDraining(t *testing.T) {
+	skipWithoutMD5(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := testHandler
+	h.ConnConfig.Log = func(...interface{}) {}
+	srv := &Server{ServeConnContext: h.ServeContext}
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Close()
+
+	c := &Client{Addr: l.Addr().String(), ConnConfig: testHandler.ConnConfig}
+	ctx := context.Background()
+	if _, err = c.SendAuthorRequest(ctx, testAuthorReq); err != nil {
+		t.Fatal(err)
+	}
+	srv.SetDraining(true)
+	c.Close()
+	if _, err = c.SendAuthorRequest(ctx, testAuthorReq); err == nil {
+		t.Error("request to draining server succeeded")
+	}
+	for i := 0; i < 10 && srv.CloseCounts()[CloseShed] == 0; i++ {
+		time.Sleep(timeScale / 4)
+	}
+	if n := srv.CloseCounts()[CloseShed]; n == 0 {
+		t.Error("connections refused while draining not counted")
+	}
+	srv.SetDraining(false)
+	if _, err = c.SendAuthorRequest(ctx, testAuthorReq); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,46 @@
+//go:build !tacplus_nomd5
+
+package tacplus
+
+import "crypto/md5"
+
+// md5Available is set when packet body obfuscation, which uses MD5, is built in.
+const md5Available = true
+
+// crypt encrypts or decrypts the body of a TACACS+ packet.
+//
+// Each block of the pad is the MD5 hash of the seed and the previous block,
+// so the pad for a single packet can only be generated sequentially. Packets
+// for different sessions are already processed concurrently, as crypt is run
+// on each session's own goroutine.
+func crypt(p, key []byte) {
+	body := p[hdrLen:]
+	if len(body) == 0 {
+		return
+	}
+
+	// seed is session id, shared secret, version and sequence number,
+	// with room to append the previous pad block.
+	buf := make([]byte, 0, 4+len(key)+2+md5.Size)
+	buf = append(buf, p[hdrID:hdrID+4]...)
+	buf = append(buf, key...)
+	buf = append(buf, p[hdrVer], p[hdrSeqNo])
+	seed := len(buf)
+
+	sum := md5.Sum(buf)
+	for {
+		n := len(sum)
+		if len(body) < n {
+			n = len(body)
+		}
+		for i := 0; i < n; i++ {
+			body[i] ^= sum[i]
+		}
+		body = body[n:]
+		if len(body) == 0 {
+			return
+		}
+		buf = append(buf[:seed], sum[:]...)
+		sum = md5.Sum(buf)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build tacplus_nomd5
+
+package tacplus
+
+// md5Available is set when packet body obfuscation, which uses MD5, is built in.
+// Building with the tacplus_nomd5 tag stops the package using MD5, for FIPS
+// constrained environments, so only Secretless connections work, relying on TLS
+// for privacy.
+const md5Available = false
+
+// crypt is never called without MD5, as connections that aren't Secretless
+// are closed before any packet is read or written.
+func crypt(p, key []byte) {
+	panic("tacplus: packet obfuscation not available in tacplus_nomd5 build")
+}
@@ -0,0 +1,45 @@
+//go:build tacplus_nomd5
+
+package tacplus
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestNoMD5(t *testing.T) {
+	if _, err := NewConnConfig(ConnConfig{Secret: testSecret}, nil); err != errNoMD5 {
+		t.Errorf("got config error %v, want %v", err, errNoMD5)
+	}
+	if _, err := NewConnConfig(ConnConfig{Secretless: true}, nil); err != nil {
+		t.Errorf("secretless config: %v", err)
+	}
+
+	h := testHandler
+	h.ConnConfig.Secretless = true
+	l, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.close()
+	ctx := context.Background()
+	if _, err = c.SendAcctRequest(ctx, testAcctReq); !errors.Is(err, errNoMD5) {
+		t.Errorf("got error %v, want %v", err, errNoMD5)
+	}
+	c.ConnConfig.Secretless = true
+	if _, err = c.SendAcctRequest(ctx, testAcctReq); err != nil {
+		t.Fatal(err)
+	}
+
+	// a server needing obfuscation closes connections without reading packets
+	l2, c2, err := newTestInstance(&testHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l2.close()
+	c2.ConnConfig.Secretless = true
+	if _, err = c2.SendAcctRequest(ctx, testAcctReq); err == nil {
+		t.Error("request served by a server without MD5")
+	}
+}
@@ -0,0 +1,74 @@
+package tacplus
+
+import (
+	"context"
+	"encoding"
+)
+
+// Packet is a TACACS+ packet body that can be encoded and decoded.
+// All the packet types in this package implement Packet.
+type Packet interface {
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+}
+
+// CustomHandler serves sessions of a custom packet type, consisting of a single
+// request and reply. Register it in ServerConnHandler.Custom by packet type.
+type CustomHandler struct {
+	// NewRequest returns an empty request packet to decode the request into.
+	NewRequest func() Packet
+
+	// Handle processes the request, returning an optional reply. A nil reply
+	// closes the session with no reply packet being sent. If an error is
+	// returned it is logged and the session is closed.
+	Handle func(ctx context.Context, req Packet, s *RawSession) (Packet, error)
+}
+
+// serve is a RawHandler for the CustomHandler.
+func (h CustomHandler) serve(ctx context.Context, p *RawPacket, s *RawSession) {
+	req := h.NewRequest()
+	if err := req.UnmarshalBinary(p.Body); err != nil {
+		s.Log(s.c.decodeError(s.hdr, err))
+		return
+	}
+	rep, err := h.Handle(ctx, req, s)
+	if err == nil && rep != nil {
+		var b []byte
+		if b, err = rep.MarshalBinary(); err == nil {
+			err = s.WritePacket(ctx, b)
+		}
+	}
+	if err != nil {
+		s.Log(err)
+	}
+}
+
+// rawHandler returns the RawHandler for sessions with packet type t, or nil.
+func (h *ServerConnHandler) rawHandler(t uint8) RawHandler {
+	if ch, ok := h.Custom[t]; ok {
+		return ch.serve
+	}
+	return h.RawHandler
+}
+
+// SendCustom sends the request req of custom packet type t with version ver to
+// the server, decoding the reply into rep.
+func (c *Client) SendCustom(ctx context.Context, ver, t uint8, req, rep Packet) error {
+	b, err := req.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	s, err := c.NewRawSession(ctx, ver, t)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if err = s.WritePacket(ctx, b); err != nil {
+		return err
+	}
+	p, err := s.ReadPacket(ctx)
+	if err != nil {
+		return err
+	}
+	return rep.UnmarshalBinary(p.Body)
+}
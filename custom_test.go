@@ -0,0 +1,59 @@
+//go:build !tacplus_nomd5
+
+package tacplus
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testCustomType = 0x80
+
+// testMessage is a custom packet holding a string.
+type testMessage struct {
+	s string
+}
+
+func (m *testMessage) MarshalBinary() ([]byte, error) { return []byte(m.s), nil }
+
+func (m *testMessage) UnmarshalBinary(b []byte) error {
+	if len(b) == 0 {
+		return errors.New("empty message")
+	}
+	m.s = string(b)
+	return nil
+}
+
+func TestCustomHandler(t *testing.T) {
+	h := testHandler
+	h.Custom = map[uint8]CustomHandler{
+		testCustomType: {
+			NewRequest: func() Packet { return new(testMessage) },
+			Handle: func(ctx context.Context, req Packet, s *RawSession) (Packet, error) {
+				return &testMessage{strings.ToUpper(req.(*testMessage).s)}, nil
+			},
+		},
+	}
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	ctx := context.Background()
+	rep := new(testMessage)
+	if err = c.SendCustom(ctx, verDefault, testCustomType, &testMessage{"hello"}, rep); err != nil {
+		t.Fatal(err)
+	}
+	if rep.s != "HELLO" {
+		t.Errorf("got reply %q, want %q", rep.s, "HELLO")
+	}
+
+	// unregistered type gets an error reply
+	err = c.SendCustom(ctx, verDefault, testCustomType+1, &testMessage{"hello"}, rep)
+	if err == nil {
+		t.Error("expected error for unregistered type")
+	}
+}
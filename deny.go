@@ -0,0 +1,71 @@
+package tacplus
+
+import (
+	"net"
+	"sync"
+)
+
+// Denial is a Fail or Error reply sent by a server with a DenyReason.
+type Denial struct {
+	RemoteAddr net.Addr // peer address
+	SessionID  uint32   // session id from the packet header
+	Type       uint8    // session type, TypeAuthen, TypeAuthor or TypeAcct
+	User       string   // user of the request
+	Status     uint8    // reply status
+	ServerMsg  string   // message sent to the client
+	Reason     string   // DenyReason of the reply, not sent to the client
+}
+
+// DenyCounts counts the Fail and Error replies sent by servers by their
+// DenyReason, for servers whose ConnConfig has it set. Each distinct reason is
+// kept, so reasons should come from a small fixed set, such as "locked out" or
+// "outside login window", with details of the request left to OnDeny. It is
+// safe for concurrent use.
+type DenyCounts struct {
+	mu sync.Mutex
+	m  map[string]int
+}
+
+// Reasons returns the number of replies counted, by their DenyReason. Replies
+// without a DenyReason aren't counted.
+func (dc *DenyCounts) Reasons() map[string]int {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	m := make(map[string]int, len(dc.m))
+	for r, n := range dc.m {
+		m[r] = n
+	}
+	return m
+}
+
+func (dc *DenyCounts) add(reason string) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if dc.m == nil {
+		dc.m = make(map[string]int)
+	}
+	dc.m[reason]++
+}
+
+// denied counts and reports a Fail or Error reply to s with status, if it has
+// a DenyReason.
+func (s *ServerSession) denied(user string, status uint8, msg, reason string) {
+	if reason == "" {
+		return
+	}
+	if s.c.DenyCounts != nil {
+		s.c.DenyCounts.add(reason)
+	}
+	if s.c.OnDeny == nil {
+		return
+	}
+	s.c.OnDeny(Denial{
+		RemoteAddr: s.RemoteAddr(),
+		SessionID:  s.id,
+		Type:       s.p[hdrType],
+		User:       user,
+		Status:     status,
+		ServerMsg:  msg,
+		Reason:     reason,
+	})
+}
@@ -0,0 +1,75 @@
+//go:build !tacplus_nomd5
+
+package tacplus
+
+import (
+	"context"
+	"testing"
+)
+
+// denyHandler denies authorization and accounting requests with an internal reason.
+type denyHandler struct {
+	RequestHandler
+}
+
+func (h denyHandler) HandleAuthorRequest(ctx context.Context, a *AuthorRequest, s *ServerSession) *AuthorResponse {
+	return &AuthorResponse{Status: AuthorStatusFail, ServerMsg: "denied", DenyReason: "test: not in group"}
+}
+
+func (h denyHandler) HandleAcctRequest(ctx context.Context, a *AcctRequest, s *ServerSession) *AcctReply {
+	e := &ErrorReply{Code: "backend-down", Msg: "try later", DenyReason: "test: spool full"}
+	return e.AcctReply()
+}
+
+func TestDenyReason(t *testing.T) {
+	denials := make(chan Denial, 2)
+	h := testHandler
+	h.Handler = denyHandler{testHandler.Handler}
+	h.ConnConfig.OnDeny = func(d Denial) { denials <- d }
+	h.ConnConfig.DenyCounts = new(DenyCounts)
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	defer c.Close()
+
+	ctx := context.Background()
+	resp, err := c.SendAuthorRequest(ctx, testAuthorReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != AuthorStatusFail || resp.ServerMsg != "denied" || resp.Data != "" || resp.DenyReason != "" {
+		t.Errorf("client got response %+v", resp)
+	}
+	rep, err := c.SendAcctRequest(ctx, testAcctReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Status != AcctStatusError || rep.ServerMsg != "try later" || rep.DenyReason != "" {
+		t.Errorf("client got reply %+v", rep)
+	}
+
+	for _, want := range []Denial{
+		{Type: TypeAuthor, User: testAuthorReq.User, Status: AuthorStatusFail, ServerMsg: "denied", Reason: "test: not in group"},
+		{Type: TypeAcct, User: testAcctReq.User, Status: AcctStatusError, ServerMsg: "try later", Reason: "test: spool full"},
+	} {
+		d := <-denials
+		d.RemoteAddr, d.SessionID = nil, 0
+		if d != want {
+			t.Errorf("got denial %+v, want %+v", d, want)
+		}
+	}
+	counts := h.ConnConfig.DenyCounts.Reasons()
+	if len(counts) != 2 {
+		t.Errorf("got counts %v", counts)
+	}
+	for _, r := range []string{"test: not in group", "test: spool full"} {
+		if n := counts[r]; n != 1 {
+			t.Errorf("reason %q counted %d times, want 1", r, n)
+		}
+	}
+	if err = s.err(); err != nil {
+		t.Error(err)
+	}
+}
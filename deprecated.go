@@ -0,0 +1,26 @@
+package tacplus
+
+import "fmt"
+
+// DeprecatedError is the error sent to clients for authentication requests
+// using features deprecated by RFC 8907, when the ConnConfig RejectDeprecated
+// is set.
+type DeprecatedError struct {
+	Feature string // deprecated feature, such as "ARAP authentication"
+}
+
+func (e *DeprecatedError) Error() string {
+	return fmt.Sprintf("%s is deprecated and not supported, reconfigure the device to use ASCII, PAP or CHAP login", e.Feature)
+}
+
+// deprecated returns a *DeprecatedError if the authentication start a uses a
+// feature deprecated by RFC 8907, otherwise nil.
+func (a *AuthenStart) deprecated() error {
+	if a.Action == AuthenActionSendPass {
+		return &DeprecatedError{Feature: "SENDPASS authentication action"}
+	}
+	if a.AuthenType == AuthenTypeARAP {
+		return &DeprecatedError{Feature: "ARAP authentication"}
+	}
+	return nil
+}
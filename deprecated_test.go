@@ -0,0 +1,43 @@
+//go:build !tacplus_nomd5
+
+package tacplus
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRejectDeprecated(t *testing.T) {
+	h := testHandler
+	h.ConnConfig.RejectDeprecated = true
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	for _, test := range []struct {
+		as      AuthenStart
+		feature string
+	}{
+		{AuthenStart{Action: AuthenActionLogin, AuthenType: AuthenTypeARAP, User: "user"}, "ARAP"},
+		{AuthenStart{Action: AuthenActionSendPass, AuthenType: AuthenTypeASCII, User: "user"}, "SENDPASS"},
+	} {
+		rep, _, err := c.SendAuthenStart(context.Background(), &test.as)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rep.Status != AuthenStatusError || !strings.Contains(rep.ServerMsg, test.feature) {
+			t.Errorf("got status %d %q, want error naming %s", rep.Status, rep.ServerMsg, test.feature)
+		}
+	}
+
+	rep, err := c.SendAuthenStartAuto(context.Background(), testAuthStart, "user", "password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Status != AuthenStatusPass {
+		t.Errorf("ASCII login got status %d %q", rep.Status, rep.ServerMsg)
+	}
+}
@@ -0,0 +1,313 @@
+package tacplus
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProxyURL returns a Client Proxy function that always returns u.
+func ProxyURL(u *url.URL) func(addr string) (*url.URL, error) {
+	return func(string) (*url.URL, error) { return u, nil }
+}
+
+// ProxyFromEnvironment is a Client Proxy function returning the proxy set by the
+// ALL_PROXY (or all_proxy) environment variable, unless addr matches the NO_PROXY
+// (or no_proxy) variable. NO_PROXY is a comma separated list of host names, domain
+// suffixes with a leading dot, IP addresses and CIDR ranges, or "*" to match all.
+// A nil URL is returned if no proxy should be used.
+func ProxyFromEnvironment(addr string) (*url.URL, error) {
+	proxy := getenv("ALL_PROXY", "all_proxy")
+	if proxy == "" || noProxy(getenv("NO_PROXY", "no_proxy"), addr) {
+		return nil, nil
+	}
+	u, err := url.Parse(proxy)
+	if err != nil || u.Host == "" {
+		// allow a bare host:port, as other tools do
+		if u, err = url.Parse("socks5://" + proxy); err != nil {
+			return nil, fmt.Errorf("invalid proxy address %q: %w", proxy, err)
+		}
+	}
+	return u, nil
+}
+
+func getenv(names ...string) string {
+	for _, n := range names {
+		if v := os.Getenv(n); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// noProxy returns whether addr matches the NO_PROXY list.
+func noProxy(list, addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	for _, e := range strings.Split(list, ",") {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if e == "" {
+			continue
+		}
+		if e == "*" {
+			return true
+		}
+		if _, n, err := net.ParseCIDR(e); err == nil {
+			if ip != nil && n.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if h, _, err := net.SplitHostPort(e); err == nil {
+			e = h
+		}
+		if e == host || (strings.HasPrefix(e, ".") && strings.HasSuffix(host, e)) ||
+			(!strings.HasPrefix(e, ".") && strings.HasSuffix(host, "."+e)) {
+			return true
+		}
+	}
+	return false
+}
+
+// dialProxy connects to addr through the proxy at u, using dial to reach the proxy.
+// Supported proxy schemes are socks5, socks5h and http (using CONNECT). Host
+// names are resolved locally for socks5, and by the proxy for socks5h and http.
+func dialProxy(ctx context.Context, dial func(ctx context.Context, network, addr string) (net.Conn, error), u *url.URL, addr string) (net.Conn, error) {
+	var port string
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		port = "1080"
+	case "http":
+		port = "80"
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	if u.Scheme == "socks5" {
+		var err error
+		if addr, err = resolveAddr(ctx, addr); err != nil {
+			return nil, err
+		}
+	}
+	proxyAddr := u.Host
+	if u.Port() == "" {
+		proxyAddr = net.JoinHostPort(u.Hostname(), port)
+	}
+	nc, err := dial(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// bound the proxy handshake by the context
+	if d, ok := ctx.Deadline(); ok {
+		_ = nc.SetDeadline(d)
+	}
+	pc := nc
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		select {
+		case <-ctx.Done():
+			_ = pc.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+
+	if u.Scheme == "http" {
+		nc, err = httpConnect(nc, u.User, addr)
+	} else {
+		err = socks5Connect(nc, u.User, addr)
+	}
+	// wait for the watcher, so it can't set a deadline after it is cleared
+	close(stop)
+	<-done
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err == nil {
+		err = pc.SetDeadline(time.Time{})
+	}
+	if err != nil {
+		_ = pc.Close()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("proxy %s: %w", proxyAddr, err)
+	}
+	return nc, nil
+}
+
+// resolveAddr returns addr with its host name resolved to an IP address.
+func resolveAddr(ctx context.Context, addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return addr, err
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(ips[0].IP.String(), port), nil
+}
+
+// socks5Connect asks the SOCKS5 server on nc to connect to addr (RFC 1928),
+// authenticating with a username and password if user is set (RFC 1929).
+func socks5Connect(nc net.Conn, user *url.Userinfo, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", portStr)
+	}
+
+	greeting := []byte{5, 1, 0}
+	if user != nil {
+		greeting = []byte{5, 2, 0, 2}
+	}
+	if _, err = nc.Write(greeting); err != nil {
+		return err
+	}
+	b := make([]byte, 2)
+	if _, err = readFull(nc, b); err != nil {
+		return err
+	}
+	if b[0] != 5 {
+		return errors.New("not a SOCKS5 proxy")
+	}
+	switch b[1] {
+	case 0:
+	case 2:
+		if user == nil {
+			return errors.New("SOCKS5 proxy requires authentication")
+		}
+		pass, _ := user.Password()
+		name := user.Username()
+		if len(name) > 255 || len(pass) > 255 {
+			return errors.New("SOCKS5 username or password too long")
+		}
+		req := append([]byte{1, byte(len(name))}, name...)
+		req = append(append(req, byte(len(pass))), pass...)
+		if _, err = nc.Write(req); err != nil {
+			return err
+		}
+		if _, err = readFull(nc, b); err != nil {
+			return err
+		}
+		if b[1] != 0 {
+			return errors.New("SOCKS5 authentication failed")
+		}
+	default:
+		return errors.New("no acceptable SOCKS5 authentication method")
+	}
+
+	req := []byte{5, 1, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return errors.New("SOCKS5 host name too long")
+		}
+		req = append(append(req, 3, byte(len(host))), host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(append(req, 1), ip4...)
+	} else {
+		req = append(append(req, 4), ip.To16()...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err = nc.Write(req); err != nil {
+		return err
+	}
+
+	// reply is version, status, reserved, then the bound address and port
+	rep := make([]byte, 4)
+	if _, err = readFull(nc, rep); err != nil {
+		return err
+	}
+	if rep[1] != 0 {
+		return fmt.Errorf("SOCKS5 connect failed with status %d", rep[1])
+	}
+	var n int
+	switch rep[3] {
+	case 1:
+		n = net.IPv4len
+	case 4:
+		n = net.IPv6len
+	case 3:
+		if _, err = readFull(nc, rep[:1]); err != nil {
+			return err
+		}
+		n = int(rep[0])
+	default:
+		return errors.New("invalid SOCKS5 reply address type")
+	}
+	_, err = readFull(nc, make([]byte, n+2))
+	return err
+}
+
+// httpConnect asks the HTTP proxy on nc to open a tunnel to addr with the CONNECT
+// method, authenticating with basic authorization if user is set.
+func httpConnect(nc net.Conn, user *url.Userinfo, addr string) (net.Conn, error) {
+	req := "CONNECT " + addr + " HTTP/1.1\r\nHost: " + addr + "\r\n"
+	if user != nil {
+		pass, _ := user.Password()
+		req += "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+pass)) + "\r\n"
+	}
+	if _, err := nc.Write([]byte(req + "\r\n")); err != nil {
+		return nc, err
+	}
+	br := bufio.NewReader(nc)
+	status, err := br.ReadString('\n')
+	if err != nil {
+		return nc, err
+	}
+	f := strings.Fields(status)
+	if len(f) < 2 || !strings.HasPrefix(f[0], "HTTP/") {
+		return nc, fmt.Errorf("invalid proxy response %q", strings.TrimSpace(status))
+	}
+	if f[1] != "200" {
+		return nc, fmt.Errorf("proxy CONNECT failed: %s", strings.TrimSpace(strings.Join(f[1:], " ")))
+	}
+	// skip headers
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nc, err
+		}
+		if line == "\r\n" || line == "\n" {
+			break
+		}
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{nc, br}, nil
+	}
+	return nc, nil
+}
+
+// bufferedConn is a net.Conn with data already read into a bufio.Reader.
+type bufferedConn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) { return c.br.Read(b) }
+
+func readFull(nc net.Conn, b []byte) (int, error) {
+	n, err := io.ReadFull(nc, b)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		err = errors.New("proxy closed connection")
+	}
+	return n, err
+}
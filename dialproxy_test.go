@@ -0,0 +1,233 @@
+package tacplus
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testProxy runs a proxy on a new listener, calling handshake on each accepted
+// connection to read the target address before relaying to it.
+func testProxy(t *testing.T, handshake func(nc net.Conn, br *bufio.Reader) (string, error)) net.Listener {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer nc.Close()
+				br := bufio.NewReader(nc)
+				addr, err := handshake(nc, br)
+				if err != nil {
+					return
+				}
+				tc, err := net.Dial("tcp", addr)
+				if err != nil {
+					return
+				}
+				defer tc.Close()
+				go func() { _, _ = io.Copy(tc, br) }()
+				_, _ = io.Copy(nc, tc)
+			}()
+		}
+	}()
+	return l
+}
+
+func socks5Handshake(nc net.Conn, br *bufio.Reader) (string, error) {
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(br, b); err != nil {
+		return "", err
+	}
+	methods := make([]byte, b[1])
+	if _, err := io.ReadFull(br, methods); err != nil {
+		return "", err
+	}
+	if methods[len(methods)-1] == 2 {
+		// check username and password
+		if _, err := nc.Write([]byte{5, 2}); err != nil {
+			return "", err
+		}
+		ver, _ := br.ReadByte()
+		n, _ := br.ReadByte()
+		name := make([]byte, n)
+		_, _ = io.ReadFull(br, name)
+		n, _ = br.ReadByte()
+		pass := make([]byte, n)
+		_, _ = io.ReadFull(br, pass)
+		if ver != 1 || string(name) != "nas" || string(pass) != "secret" {
+			_, _ = nc.Write([]byte{1, 1})
+			return "", io.EOF
+		}
+		if _, err := nc.Write([]byte{1, 0}); err != nil {
+			return "", err
+		}
+	} else if _, err := nc.Write([]byte{5, 0}); err != nil {
+		return "", err
+	}
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(br, req); err != nil {
+		return "", err
+	}
+	var host string
+	switch req[3] {
+	case 1:
+		ip := make([]byte, 4)
+		_, _ = io.ReadFull(br, ip)
+		host = net.IP(ip).String()
+	case 3:
+		n, _ := br.ReadByte()
+		name := make([]byte, n)
+		_, _ = io.ReadFull(br, name)
+		host = string(name)
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(br, port); err != nil {
+		return "", err
+	}
+	_, err := nc.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+	return net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1]))), err
+}
+
+func httpHandshake(nc net.Conn, br *bufio.Reader) (string, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	f := strings.Fields(line)
+	for {
+		h, err := br.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		if h == "\r\n" {
+			break
+		}
+	}
+	_, err = nc.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	return f[1], err
+}
+
+func TestClientProxy(t *testing.T) {
+	skipWithoutMD5(t)
+	s, c, err := newTestInstance(&testHandler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	socks := testProxy(t, socks5Handshake)
+	defer socks.Close()
+	httpProxy := testProxy(t, httpHandshake)
+	defer httpProxy.Close()
+
+	for _, u := range []string{
+		"socks5://" + socks.Addr().String(),
+		"socks5h://nas:secret@" + socks.Addr().String(),
+		"http://" + httpProxy.Addr().String(),
+	} {
+		pu, _ := url.Parse(u)
+		pc := &Client{Addr: c.Addr, ConnConfig: c.ConnConfig, Proxy: ProxyURL(pu)}
+		resp, err := pc.SendAuthorRequest(context.Background(), testAuthorReq)
+		if err != nil {
+			t.Errorf("%s: %v", u, err)
+			continue
+		}
+		if resp.Status != AuthorStatusPassAdd {
+			t.Errorf("%s: got status %d", u, resp.Status)
+		}
+	}
+
+	// bad credentials fail the dial
+	pu, _ := url.Parse("socks5://nas:wrong@" + socks.Addr().String())
+	pc := &Client{Addr: c.Addr, ConnConfig: c.ConnConfig, Proxy: ProxyURL(pu)}
+	if _, err = pc.SendAuthorRequest(context.Background(), testAuthorReq); err == nil {
+		t.Error("request with bad proxy credentials succeeded")
+	}
+}
+
+func TestDialProxy(t *testing.T) {
+	// the target writes after the handshake deadline has passed
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		for {
+			nc, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer nc.Close()
+				time.Sleep(4 * timeScale)
+				_, _ = nc.Write([]byte("ok"))
+			}()
+		}
+	}()
+
+	hosts := make(chan string, 1)
+	socks := testProxy(t, func(nc net.Conn, br *bufio.Reader) (string, error) {
+		addr, err := socks5Handshake(nc, br)
+		host, _, _ := net.SplitHostPort(addr)
+		hosts <- host
+		return target.Addr().String(), err
+	})
+	defer socks.Close()
+
+	var d net.Dialer
+	for scheme, resolved := range map[string]bool{"socks5": true, "socks5h": false} {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*timeScale)
+		nc, err := dialProxy(ctx, d.DialContext, &url.URL{Scheme: scheme, Host: socks.Addr().String()}, "localhost:49")
+		cancel()
+		if err != nil {
+			t.Errorf("%s: %v", scheme, err)
+			continue
+		}
+		if host := <-hosts; (net.ParseIP(host) != nil) != resolved {
+			t.Errorf("%s: proxy got host %q", scheme, host)
+		}
+		b := make([]byte, 2)
+		if _, err = io.ReadFull(nc, b); err != nil {
+			t.Errorf("%s: read after handshake: %v", scheme, err)
+		}
+		nc.Close()
+	}
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	t.Setenv("ALL_PROXY", "socks5://proxy.example:1080")
+	t.Setenv("NO_PROXY", "aaa.example, .internal.example,10.0.0.0/8")
+	for addr, want := range map[string]bool{
+		"tacacs.example:49":       true,
+		"aaa.example:49":          false,
+		"x.aaa.example:49":        false,
+		"host.internal.example:4": false,
+		"10.1.2.3:49":             false,
+		"192.0.2.1:49":            true,
+	} {
+		u, err := ProxyFromEnvironment(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := u != nil; got != want {
+			t.Errorf("%s: got proxy %v, want %v", addr, got, want)
+		}
+	}
+	t.Setenv("ALL_PROXY", "proxy.example:1080")
+	if u, _ := ProxyFromEnvironment("tacacs.example:49"); u == nil || u.Scheme != "socks5" || u.Host != "proxy.example:1080" {
+		t.Errorf("bare proxy address parsed as %v", u)
+	}
+}
@@ -0,0 +1,99 @@
+package tacplus
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// DryRunClient returns a Client whose requests are served by h in process,
+// without any network connection, over an in-memory net.Pipe. It can be used
+// to test a handler chain with synthetic requests, for example when editing
+// authorization policy.
+//
+// The Client uses the ConnConfig of h. The handler sees a remote address of
+// "pipe", and contexts carrying the values of the context of the request that
+// opened the connection.
+func DryRunClient(h *ServerConnHandler) *Client {
+	return &Client{
+		Addr:       "pipe",
+		ConnConfig: h.ConnConfig,
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			nc, sc := net.Pipe()
+			// the connection may outlive the request that opened it
+			go h.ServeContext(valuesContext{ctx}, sc)
+			return nc, nil
+		},
+	}
+}
+
+type ruleTraceKey struct{}
+
+// WithRuleTrace returns a copy of ctx in which policies deciding a request
+// report the rule that decided it to f, such as the matching rule of a
+// CommandMatcher or GroupPolicy, or the authenticator of a LoginHandler that
+// decided a login. Requests served by a DryRunClient are traced
+// with the context of the request that opened the connection.
+func WithRuleTrace(ctx context.Context, f func(rule string)) context.Context {
+	return context.WithValue(ctx, ruleTraceKey{}, f)
+}
+
+// traceRule reports rule to the rule trace of ctx, if any.
+func traceRule(ctx context.Context, rule string) {
+	if f, _ := ctx.Value(ruleTraceKey{}).(func(string)); f != nil {
+		f(rule)
+	}
+}
+
+// ruleCollector collects the rules reported to its trace.
+type ruleCollector struct {
+	mu    sync.Mutex
+	rules []string
+}
+
+func (r *ruleCollector) trace(ctx context.Context) context.Context {
+	return WithRuleTrace(ctx, func(rule string) {
+		r.mu.Lock()
+		r.rules = append(r.rules, rule)
+		r.mu.Unlock()
+	})
+}
+
+func (r *ruleCollector) collected() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rules
+}
+
+// DryRunAuthor sends the authorization request req to h with a DryRunClient,
+// returning its response and the rules reported deciding it.
+func DryRunAuthor(ctx context.Context, h *ServerConnHandler, req *AuthorRequest) (*AuthorResponse, []string, error) {
+	var r ruleCollector
+	c := DryRunClient(h)
+	defer c.Close()
+	resp, err := c.SendAuthorRequest(r.trace(ctx), req)
+	return resp, r.collected(), err
+}
+
+// DryRunAuthen sends the authentication start to h with a DryRunClient,
+// answering each prompt for a user name, password or other data with the next
+// of answers, and returns the final reply and the rules reported deciding it,
+// such as the authenticator of a LoginHandler that accepted the password. If
+// the server prompts after answers run out, the session is aborted and the
+// reply prompting is returned.
+func DryRunAuthen(ctx context.Context, h *ServerConnHandler, start *AuthenStart, answers ...string) (*AuthenReply, []string, error) {
+	var r ruleCollector
+	ctx = r.trace(ctx)
+	c := DryRunClient(h)
+	defer c.Close()
+	rep, s, err := c.SendAuthenStart(ctx, start)
+	for err == nil && !rep.last() {
+		if len(answers) == 0 {
+			err = s.Abort(ctx, "")
+			break
+		}
+		rep, err = s.Continue(ctx, answers[0])
+		answers = answers[1:]
+	}
+	return rep, r.collected(), err
+}
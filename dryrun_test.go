@@ -0,0 +1,157 @@
+//go:build !tacplus_nomd5
+
+package tacplus
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDryRunClient(t *testing.T) {
+	h := testHandler
+	var mu sync.Mutex
+	var logged []interface{}
+	h.ConnConfig.Log = func(v ...interface{}) {
+		mu.Lock()
+		logged = append(logged, v...)
+		mu.Unlock()
+	}
+	c := DryRunClient(&h)
+	ctx := context.Background()
+
+	resp, err := c.SendAuthorRequest(ctx, testAuthorReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != AuthorStatusPassAdd || len(resp.Arg) != 1 || resp.Arg[0] != "priv-lvl=5" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+
+	rep, s, err := c.SendAuthenStart(ctx, testAuthStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rep.Status == AuthenStatusGetUser || rep.Status == AuthenStatusGetPass {
+		msg := "user"
+		if rep.Status == AuthenStatusGetPass {
+			msg = "password123"
+		}
+		if rep, err = s.Continue(ctx, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rep.Status != AuthenStatusPass {
+		t.Errorf("got authentication status %d, want %d", rep.Status, AuthenStatusPass)
+	}
+	c.Close()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(logged) > 0 {
+		t.Error(logged...)
+	}
+}
+
+func TestDryRunClientMux(t *testing.T) {
+	h := testHandler
+	h.ConnConfig.Mux = true
+	c := DryRunClient(&h)
+	defer c.Close()
+	var mu sync.Mutex
+	connects := 0
+	c.OnConnect = func(ConnEvent) {
+		mu.Lock()
+		connects++
+		mu.Unlock()
+	}
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		_, err := c.SendAuthorRequest(ctx, testAuthorReq)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if connects != 1 {
+		t.Errorf("got %d connections, want 1", connects)
+	}
+}
+
+func TestDryRunAuthor(t *testing.T) {
+	h := testHandler
+	h.Handler = &PolicyHandler{Passthrough: Passthrough{testHandler.Handler}, Policy: testGroupPolicy(t)}
+	ctx := context.Background()
+	for _, tt := range []struct {
+		user   string
+		args   []string
+		status uint8
+		rule   string
+	}{
+		{"alice", []string{"service=shell", "cmd=configure", "cmd-arg=terminal"}, AuthorStatusPassAdd, "group netops: command rule 1: permit ^configure "},
+		{"bob", []string{"service=shell", "cmd=show", "cmd-arg=version"}, AuthorStatusPassAdd, "group staff: command rule 1: permit ^show "},
+		{"bob", []string{"service=shell", "cmd=reload"}, AuthorStatusFail, "group staff: command rule 2: deny ."},
+		{"alice", []string{"service=shell", "cmd="}, AuthorStatusPassAdd, "groups netops,staff: service shell"},
+		{"alice", []string{"service=ppp", "protocol=ip"}, AuthorStatusFail, "groups netops,staff: service ppp not permitted"},
+	} {
+		req := *testAuthorReq
+		req.User, req.Arg = tt.user, tt.args
+		resp, rules, err := DryRunAuthor(ctx, &h, &req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != tt.status || len(rules) != 1 || rules[0] != tt.rule {
+			t.Errorf("%s %v: got %d %q, want %d %q", tt.user, tt.args, resp.Status, rules, tt.status, tt.rule)
+		}
+	}
+
+	// requests decided by no policy report no rules
+	req := *testAuthorReq
+	req.User = "carol"
+	if resp, rules, err := DryRunAuthor(ctx, &h, &req); err != nil || len(rules) != 0 {
+		t.Errorf("user in no group: got %+v %q %v", resp, rules, err)
+	}
+}
+
+func TestDryRunAuthen(t *testing.T) {
+	users := new(UserStore)
+	now := time.Now()
+	users.Set("alice", "secret", now)
+	users.Set("bob", "secret", now)
+	users.Expire("bob")
+	h := testHandler
+	h.Handler = &LoginHandler{
+		Primary:     AuthenticatorFunc(func(context.Context, string, string) (bool, error) { return false, errors.New("down") }),
+		Fallback:    users,
+		Passthrough: Passthrough{testHandler.Handler},
+		OnFallback:  func(string, error) {},
+	}
+	ctx := context.Background()
+	for _, tt := range []struct {
+		answers []string
+		status  uint8
+		rule    string
+	}{
+		{[]string{"alice", "secret"}, AuthenStatusPass, "login fallback: password accepted"},
+		{[]string{"alice", "wrong"}, AuthenStatusFail, "login fallback: password rejected"},
+		{[]string{"bob", "secret"}, AuthenStatusGetData, "login fallback: password expired"}, // prompts for a new password
+	} {
+		rep, rules, err := DryRunAuthen(ctx, &h, testAuthStart, tt.answers...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rep.Status != tt.status || len(rules) != 1 || rules[0] != tt.rule {
+			t.Errorf("%q: got %d %q, want %d %q", tt.answers, rep.Status, rules, tt.status, tt.rule)
+		}
+	}
+
+	// the user of the start packet isn't prompted for
+	start := *testAuthStart
+	start.User = "alice"
+	if rep, _, err := DryRunAuthen(ctx, &h, &start, "secret"); err != nil || rep.Status != AuthenStatusPass {
+		t.Errorf("user in start packet: got %+v %v", rep, err)
+	}
+}
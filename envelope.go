@@ -0,0 +1,178 @@
+package tacplus
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// envelopeVersion is the format of encrypted data written by Encrypt, which is
+// also authenticated with the data.
+const envelopeVersion = "tacplus-envelope-v1"
+
+// dataKeyLen is the length of the AES-256 key encrypting each envelope.
+const dataKeyLen = 32
+
+// ErrNotEncrypted is the error decrypting data that isn't an envelope written
+// by Encrypt.
+var ErrNotEncrypted = errors.New("data not encrypted")
+
+// A KeyWrapper encrypts the keys of data encrypted at rest, such as a key
+// management service or a locally held AESKey. Each file is encrypted with a
+// new data key, which is stored with it wrapped by the KeyWrapper.
+type KeyWrapper interface {
+	// WrapKey returns key encrypted.
+	WrapKey(ctx context.Context, key []byte) ([]byte, error)
+
+	// UnwrapKey returns the key encrypted by WrapKey.
+	UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// AESKey is a KeyWrapper wrapping keys with AES-GCM. It must be 16, 24 or 32
+// bytes long.
+type AESKey []byte
+
+// KeyFromEnv returns the AESKey held base64 encoded in the environment variable
+// name, such as one generated with "openssl rand -base64 32".
+func KeyFromEnv(name string) (AESKey, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil, fmt.Errorf("environment variable %s not set", name)
+	}
+	k, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return nil, fmt.Errorf("environment variable %s: %w", name, err)
+	}
+	if _, err = aes.NewCipher(k); err != nil {
+		return nil, fmt.Errorf("environment variable %s: %w", name, err)
+	}
+	return AESKey(k), nil
+}
+
+// sealGCM returns plaintext encrypted with AES-GCM and key, prefixed with the
+// random nonce.
+func sealGCM(key, plaintext, data []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, data), nil
+}
+
+// openGCM returns the plaintext of ciphertext encrypted by sealGCM.
+func openGCM(key, ciphertext, data []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	n := aead.NonceSize()
+	return aead.Open(nil, ciphertext[:n], ciphertext[n:], data)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// WrapKey returns key encrypted with k.
+func (k AESKey) WrapKey(ctx context.Context, key []byte) ([]byte, error) {
+	return sealGCM(k, key, []byte(envelopeVersion))
+}
+
+// UnwrapKey returns the key encrypted with k by WrapKey.
+func (k AESKey) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	return openGCM(k, wrapped, []byte(envelopeVersion))
+}
+
+// envelope is the JSON encoding of encrypted data.
+type envelope struct {
+	Version string `json:"version"`
+	Key     []byte `json:"key"`  // data key wrapped by the KeyWrapper
+	Data    []byte `json:"data"` // nonce and ciphertext
+}
+
+// Encrypt returns plaintext encrypted with AES-256-GCM using a new random data
+// key, as a JSON envelope holding the data key wrapped by kw. The envelope is
+// decrypted with Decrypt.
+func Encrypt(ctx context.Context, kw KeyWrapper, plaintext []byte) ([]byte, error) {
+	key := make([]byte, dataKeyLen)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	wrapped, err := kw.WrapKey(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("wrapping data key: %w", err)
+	}
+	data, err := sealGCM(key, plaintext, []byte(envelopeVersion))
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(envelope{envelopeVersion, wrapped, data})
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// Decrypt returns the plaintext of an envelope written by Encrypt, unwrapping
+// its data key with kw. It returns ErrNotEncrypted if b isn't an envelope.
+func Decrypt(ctx context.Context, kw KeyWrapper, b []byte) ([]byte, error) {
+	var e envelope
+	if json.Unmarshal(b, &e) != nil || e.Version != envelopeVersion {
+		return nil, ErrNotEncrypted
+	}
+	key, err := kw.UnwrapKey(ctx, e.Key)
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping data key: %w", err)
+	}
+	plaintext, err := openGCM(key, e.Data, []byte(envelopeVersion))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting data: %w", err)
+	}
+	return plaintext, nil
+}
+
+// SaveEncrypted is like Save, with the users encrypted by Encrypt using kw.
+func (u *UserStore) SaveEncrypted(ctx context.Context, w io.Writer, kw KeyWrapper) error {
+	var b bytes.Buffer
+	if err := u.Save(&b); err != nil {
+		return err
+	}
+	data, err := Encrypt(ctx, kw, b.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// LoadEncrypted is like Load, with the users read from r decrypted by Decrypt
+// using kw. A user store that isn't encrypted is refused with ErrNotEncrypted.
+func (u *UserStore) LoadEncrypted(ctx context.Context, r io.Reader, kw KeyWrapper) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if b, err = Decrypt(ctx, kw, b); err != nil {
+		return err
+	}
+	return u.Load(bytes.NewReader(b))
+}
@@ -0,0 +1,85 @@
+package tacplus
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncrypt(t *testing.T) {
+	ctx := context.Background()
+	key := AESKey(bytes.Repeat([]byte{1}, 32))
+	plaintext := []byte("secret data")
+	b, err := Encrypt(ctx, key, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, plaintext) {
+		t.Error("plaintext in encrypted data")
+	}
+	got, err := Decrypt(ctx, key, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Errorf("got %q, want %q", got, plaintext)
+	}
+
+	if _, err = Decrypt(ctx, AESKey(bytes.Repeat([]byte{2}, 32)), b); err == nil {
+		t.Error("decrypted with the wrong key")
+	}
+	tampered := bytes.Replace(b, []byte(`"data":"`), []byte(`"data":"A`), 1)
+	if _, err = Decrypt(ctx, key, tampered); err == nil {
+		t.Error("decrypted tampered data")
+	}
+	if _, err = Decrypt(ctx, key, plaintext); err != ErrNotEncrypted {
+		t.Errorf("got error %v decrypting plaintext, want %v", err, ErrNotEncrypted)
+	}
+}
+
+func TestKeyFromEnv(t *testing.T) {
+	const name = "TACPLUS_TEST_KEY"
+	key := bytes.Repeat([]byte{1}, 32)
+	t.Setenv(name, base64.StdEncoding.EncodeToString(key))
+	if k, err := KeyFromEnv(name); err != nil || !bytes.Equal(k, key) {
+		t.Errorf("got key %x, %v", k, err)
+	}
+	for _, v := range []string{"", "not base64!", base64.StdEncoding.EncodeToString(key[:10])} {
+		t.Setenv(name, v)
+		if _, err := KeyFromEnv(name); err == nil {
+			t.Errorf("%q: no error", v)
+		}
+	}
+}
+
+func TestUserStoreEncrypted(t *testing.T) {
+	ctx := context.Background()
+	key := AESKey(bytes.Repeat([]byte{1}, 16))
+	u := new(UserStore)
+	u.Set("alice", "secret", time.Now())
+	var b bytes.Buffer
+	if err := u.SaveEncrypted(ctx, &b, key); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(b.String(), "alice") {
+		t.Error("user name in encrypted store")
+	}
+	got := new(UserStore)
+	if err := got.LoadEncrypted(ctx, &b, key); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := got.Authenticate(ctx, "alice", "secret"); !ok {
+		t.Error("password rejected after load")
+	}
+
+	b.Reset()
+	if err := u.Save(&b); err != nil {
+		t.Fatal(err)
+	}
+	if err := got.LoadEncrypted(ctx, &b, key); err != ErrNotEncrypted {
+		t.Errorf("got error %v loading plain store, want %v", err, ErrNotEncrypted)
+	}
+}
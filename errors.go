@@ -0,0 +1,240 @@
+package tacplus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// ErrConnClosed is the error returned when a session's network connection has closed.
+// All connection close errors match ErrConnClosed with errors.Is, and can be inspected
+// for the reason with errors.As and a *ConnClosedError.
+var ErrConnClosed = errors.New("connection closed")
+
+// A CloseReason describes why a connection was closed.
+type CloseReason int
+
+// CloseReason values
+const (
+	CloseLocal    CloseReason = iota // closed locally
+	ClosePeer                        // closed by the peer
+	CloseTimeout                     // read or write timeout expired
+	CloseError                       // network error
+	CloseIdle                        // idle timeout expired with no sessions in progress
+	CloseDone                        // sessions finished on a connection that isn't multiplexed
+	CloseProtocol                    // peer sent an unsupported version or oversized packet
+	CloseShutdown                    // server closed, or the connection context was canceled
+	CloseShed                        // closed by a server shedding load, such as while draining
+)
+
+func (r CloseReason) String() string {
+	switch r {
+	case CloseLocal:
+		return "connection closed"
+	case ClosePeer:
+		return "connection closed by peer"
+	case CloseTimeout:
+		return "connection timed out"
+	case CloseIdle:
+		return "connection idle"
+	case CloseDone:
+		return "connection sessions done"
+	case CloseProtocol:
+		return "connection protocol error"
+	case CloseShutdown:
+		return "connection shut down"
+	case CloseShed:
+		return "connection shed"
+	default:
+		return "connection error"
+	}
+}
+
+// Code returns a short, stable name for r suitable for logs and metrics labels.
+func (r CloseReason) Code() string {
+	switch r {
+	case CloseLocal:
+		return "local"
+	case ClosePeer:
+		return "peer"
+	case CloseTimeout:
+		return "timeout"
+	case CloseIdle:
+		return "idle"
+	case CloseDone:
+		return "done"
+	case CloseProtocol:
+		return "protocol"
+	case CloseShutdown:
+		return "shutdown"
+	case CloseShed:
+		return "shed"
+	default:
+		return "error"
+	}
+}
+
+// local reports whether r is a close made locally with no fault on either side.
+func (r CloseReason) local() bool {
+	switch r {
+	case CloseLocal, CloseIdle, CloseDone, CloseShutdown:
+		return true
+	}
+	return false
+}
+
+// ConnClosedError is the error returned to sessions when their connection closes.
+type ConnClosedError struct {
+	Reason CloseReason // reason connection was closed
+	Err    error       // underlying error, if any
+}
+
+// connClosedError returns a *ConnClosedError for a connection closed because of err.
+func connClosedError(err error) *ConnClosedError {
+	e := &ConnClosedError{Reason: CloseError, Err: err}
+	var ne net.Error
+	if err == io.EOF || err == errUnexpectedEOF {
+		e.Reason = ClosePeer
+	} else if errors.Is(err, errPacketTooLarge) || errors.Is(err, errBadVersion) {
+		e.Reason = CloseProtocol
+	} else if errors.As(err, &ne) && ne.Timeout() {
+		e.Reason = CloseTimeout
+	}
+	return e
+}
+
+// closeReason returns the reason for a connection closed with err.
+func closeReason(err error) CloseReason {
+	var ce *ConnClosedError
+	if errors.As(err, &ce) {
+		return ce.Reason
+	}
+	return CloseError
+}
+
+func (e *ConnClosedError) Error() string {
+	if e.Err == nil {
+		return e.Reason.String()
+	}
+	return e.Reason.String() + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *ConnClosedError) Unwrap() error { return e.Err }
+
+// Is reports whether target is ErrConnClosed.
+func (e *ConnClosedError) Is(target error) bool { return target == ErrConnClosed }
+
+// replyCode is the JSON encoding of an error code in a reply Data field.
+type replyCode struct {
+	Code string `json:"code"`
+}
+
+func encodeCode(code string) []byte {
+	if code == "" {
+		return nil
+	}
+	// encoding a struct with a single string field can't fail
+	b, _ := json.Marshal(replyCode{code})
+	return b
+}
+
+func decodeCode(data []byte) string {
+	var rc replyCode
+	if json.Unmarshal(data, &rc) != nil {
+		return ""
+	}
+	return rc.Code
+}
+
+// ErrorReply is a failed request result carrying both a message for the user
+// and a machine-readable code, allowing automated clients to tell different
+// failures apart. The message is sent in the reply ServerMsg field and the code
+// in the Data field encoded as a JSON object, eg {"code":"backend-down"}.
+//
+// A request handler uses one of the reply methods to create the reply for its
+// request type. Clients can recover the code with the reply's Err method.
+type ErrorReply struct {
+	Code string // machine-readable failure code
+	Msg  string // human readable message
+	Fail bool   // reply with a Fail status instead of an Error status
+
+	// Optional internal reason set as the reply's DenyReason, for operators
+	// only. Unlike Msg and Code it is never sent to the client.
+	DenyReason string
+}
+
+func (e *ErrorReply) Error() string {
+	if e.Code == "" {
+		return e.Msg
+	}
+	return fmt.Sprintf("%s (%s)", e.Msg, e.Code)
+}
+
+// AuthenReply returns an AuthenReply for the error.
+func (e *ErrorReply) AuthenReply() *AuthenReply {
+	r := &AuthenReply{Status: AuthenStatusError, ServerMsg: e.Msg, Data: encodeCode(e.Code), DenyReason: e.DenyReason}
+	if e.Fail {
+		r.Status = AuthenStatusFail
+	}
+	return r
+}
+
+// AuthorResponse returns an AuthorResponse for the error.
+func (e *ErrorReply) AuthorResponse() *AuthorResponse {
+	r := &AuthorResponse{Status: AuthorStatusError, ServerMsg: e.Msg, Data: string(encodeCode(e.Code)), DenyReason: e.DenyReason}
+	if e.Fail {
+		r.Status = AuthorStatusFail
+	}
+	return r
+}
+
+// AcctReply returns an AcctReply for the error.
+// Accounting has no Fail status so an Error status is always used.
+func (e *ErrorReply) AcctReply() *AcctReply {
+	return &AcctReply{Status: AcctStatusError, ServerMsg: e.Msg, Data: string(encodeCode(e.Code)), DenyReason: e.DenyReason}
+}
+
+// ReplyError is the error returned by a reply's Err method for a failed request.
+type ReplyError struct {
+	Status    uint8  // reply status
+	ServerMsg string // reply ServerMsg field
+	Code      string // error code from the reply Data field, or empty if not present
+}
+
+func (e *ReplyError) Error() string {
+	msg := e.ServerMsg
+	if msg == "" {
+		msg = fmt.Sprintf("request failed with status %d", e.Status)
+	}
+	if e.Code == "" {
+		return msg
+	}
+	return fmt.Sprintf("%s (%s)", msg, e.Code)
+}
+
+// Err returns a *ReplyError if the reply has a Fail or Error status, otherwise nil.
+func (a *AuthenReply) Err() error {
+	if a.Status != AuthenStatusFail && a.Status != AuthenStatusError {
+		return nil
+	}
+	return &ReplyError{Status: a.Status, ServerMsg: a.ServerMsg, Code: decodeCode(a.Data)}
+}
+
+// Err returns a *ReplyError if the response has a Fail or Error status, otherwise nil.
+func (a *AuthorResponse) Err() error {
+	if a.Status != AuthorStatusFail && a.Status != AuthorStatusError {
+		return nil
+	}
+	return &ReplyError{Status: a.Status, ServerMsg: a.ServerMsg, Code: decodeCode([]byte(a.Data))}
+}
+
+// Err returns a *ReplyError if the reply has an Error status, otherwise nil.
+func (a *AcctReply) Err() error {
+	if a.Status != AcctStatusError {
+		return nil
+	}
+	return &ReplyError{Status: a.Status, ServerMsg: a.ServerMsg, Code: decodeCode([]byte(a.Data))}
+}
@@ -0,0 +1,77 @@
+package tacplus
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestErrorReply(t *testing.T) {
+	e := &ErrorReply{Code: "backend-down", Msg: "try again later"}
+
+	var authen AuthenReply
+	b, _ := e.AuthenReply().marshal(nil)
+	if err := authen.unmarshal(b); err != nil {
+		t.Fatal(err)
+	}
+	var author AuthorResponse
+	b, _ = e.AuthorResponse().marshal(nil)
+	if err := author.unmarshal(b); err != nil {
+		t.Fatal(err)
+	}
+	var acct AcctReply
+	b, _ = e.AcctReply().marshal(nil)
+	if err := acct.unmarshal(b); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, err := range []error{authen.Err(), author.Err(), acct.Err()} {
+		var re *ReplyError
+		if !errors.As(err, &re) {
+			t.Fatalf("want *ReplyError: got %v", err)
+		}
+		if re.Code != e.Code || re.ServerMsg != e.Msg {
+			t.Errorf("want code %q msg %q: got code %q msg %q", e.Code, e.Msg, re.Code, re.ServerMsg)
+		}
+	}
+
+	e.Fail = true
+	if r := e.AuthenReply(); r.Status != AuthenStatusFail {
+		t.Errorf("want status %d: got %d", AuthenStatusFail, r.Status)
+	}
+	if r := (&AuthenReply{Status: AuthenStatusPass}); r.Err() != nil {
+		t.Error("unexpected error for pass reply:", r.Err())
+	}
+	if r := (&AuthorResponse{Status: AuthorStatusFail, Data: "not json"}); r.Err().(*ReplyError).Code != "" {
+		t.Error("unexpected code for non JSON Data")
+	}
+}
+
+func TestConnClosedError(t *testing.T) {
+	tests := []struct {
+		err    error
+		reason CloseReason
+	}{
+		{io.EOF, ClosePeer},
+		{errUnexpectedEOF, ClosePeer},
+		{os.ErrDeadlineExceeded, CloseTimeout},
+		{errBadPacket, CloseError},
+		{errPacketTooLarge, CloseProtocol},
+		{fmt.Errorf("%w %d", errBadVersion, 2), CloseProtocol},
+	}
+	for _, test := range tests {
+		err := error(connClosedError(test.err))
+		var ce *ConnClosedError
+		if !errors.Is(err, ErrConnClosed) || !errors.As(err, &ce) {
+			t.Fatalf("%v does not match ErrConnClosed", err)
+		}
+		if ce.Reason != test.reason {
+			t.Errorf("%v: want reason %v: got %v", test.err, test.reason, ce.Reason)
+		}
+		if !errors.Is(err, test.err) {
+			t.Errorf("%v does not wrap %v", err, test.err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package tacplus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync/atomic"
+)
+
+// A SecurityEventKind identifies the type of protocol anomaly in a SecurityEvent.
+type SecurityEventKind int
+
+// SecurityEventKind values
+const (
+	EventBadVersion      SecurityEventKind = iota // unsupported major version
+	EventBadSeqNo                                 // unexpected sequence number
+	EventBadParity                                // sequence number with wrong parity for the direction
+	EventBadSecret                                // packet body did not decode, likely a wrong secret
+	EventPacketTooLarge                           // packet body length larger than the maximum
+	EventClientAbort                              // client aborted an authentication session
+	EventMalformedPacket                          // packet body malformed regardless of the secret
+)
+
+func (k SecurityEventKind) String() string {
+	switch k {
+	case EventBadVersion:
+		return "bad version"
+	case EventBadSeqNo:
+		return "bad sequence number"
+	case EventBadParity:
+		return "bad sequence number parity"
+	case EventBadSecret:
+		return "bad secret"
+	case EventPacketTooLarge:
+		return "packet too large"
+	case EventClientAbort:
+		return "client abort"
+	case EventMalformedPacket:
+		return "malformed packet"
+	default:
+		return "unknown"
+	}
+}
+
+// A SecurityEvent describes a protocol anomaly seen on a connection, which may
+// be the result of probing or scanning.
+type SecurityEvent struct {
+	Kind       SecurityEventKind
+	RemoteAddr net.Addr // peer address
+	SessionID  uint32   // session id from the packet header
+	Err        error    // error returned for the packet
+}
+
+// A SecurityEventFunc is called with each SecurityEvent seen on a connection.
+// It is called synchronously so should not block.
+type SecurityEventFunc func(SecurityEvent)
+
+// securityEvent reports a SecurityEvent for the raw packet p.
+func (c *conn) securityEvent(kind SecurityEventKind, p []byte, err error) {
+	if c.OnSecurityEvent == nil {
+		return
+	}
+	c.OnSecurityEvent(SecurityEvent{
+		Kind:       kind,
+		RemoteAddr: c.nc.RemoteAddr(),
+		SessionID:  binary.BigEndian.Uint32(p[hdrID:]),
+		Err:        err,
+	})
+}
+
+// decodeErrs counts packet decode failures across the process, accessed atomically.
+var decodeErrs struct {
+	badSecret int64
+	malformed int64
+	badSeqNo  int64
+}
+
+// DecodeErrorCounts is the number of packets that were rejected as bad.
+type DecodeErrorCounts struct {
+	BadSecret int // packets failing with ErrBadSecret
+	Malformed int // packets failing with ErrMalformedPacket
+	BadSeqNo  int // packets failing with a *SeqError
+}
+
+// DecodeErrors returns the number of packets received by all clients and
+// servers in the process that were rejected as bad, by cause.
+func DecodeErrors() DecodeErrorCounts {
+	return DecodeErrorCounts{
+		BadSecret: int(atomic.LoadInt64(&decodeErrs.badSecret)),
+		Malformed: int(atomic.LoadInt64(&decodeErrs.malformed)),
+		BadSeqNo:  int(atomic.LoadInt64(&decodeErrs.badSeqNo)),
+	}
+}
+
+// SeqError is the error for a packet with an unexpected sequence number. The
+// session is sent an error reply, if it is a server session, and closed.
+type SeqError struct {
+	SeqNo uint8 // sequence number of the packet
+	Want  uint8 // expected sequence number
+}
+
+func (e *SeqError) Error() string {
+	if e.SeqNo&1 == e.Want&1 {
+		return fmt.Sprintf("invalid sequence number %d, expected %d", e.SeqNo, e.Want)
+	}
+	return fmt.Sprintf("invalid sequence number %d, wrong parity for the direction", e.SeqNo)
+}
+
+// seqError counts and reports the sequence number error err for the raw packet p.
+func (c *conn) seqError(kind SecurityEventKind, p []byte, err error) {
+	atomic.AddInt64(&decodeErrs.badSeqNo, 1)
+	c.securityEvent(kind, p, err)
+}
+
+// decodeError returns the error for err from decoding the body of the raw packet
+// p, counting and reporting it if the packet is bad. Field lengths that don't
+// match the body are blamed on the secret if the packet was obfuscated.
+func (c *conn) decodeError(p []byte, err error) error {
+	switch err {
+	case errBadPacket, ErrTruncated:
+		if c.Secretless {
+			err = ErrMalformedPacket
+		} else {
+			err = ErrBadSecret
+		}
+	case ErrMalformedPacket:
+	default:
+		return err
+	}
+	if err == ErrBadSecret {
+		atomic.AddInt64(&decodeErrs.badSecret, 1)
+		c.securityEvent(EventBadSecret, p, err)
+	} else {
+		atomic.AddInt64(&decodeErrs.malformed, 1)
+		c.securityEvent(EventMalformedPacket, p, err)
+	}
+	return err
+}
+
+// truncated returns nil if err is ErrTruncated and the peer has
+// QuirkTruncatedArgs, logging the truncation of the raw packet p, and otherwise
+// returns err.
+func (c *conn) truncated(p []byte, err error) error {
+	if err != ErrTruncated || !c.quirks.Has(QuirkTruncatedArgs) {
+		return err
+	}
+	c.log(c.nc.RemoteAddr(), " session ", binary.BigEndian.Uint32(p[hdrID:]), ": ", err, ", keeping the arguments read")
+	return nil
+}
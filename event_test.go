@@ -0,0 +1,98 @@
+package tacplus
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSecurityEvents(t *testing.T) {
+	skipWithoutMD5(t)
+	events := make(chan SecurityEvent, 10)
+	h := testHandler
+	h.ConnConfig.OnSecurityEvent = func(e SecurityEvent) { events <- e }
+	l, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.close()
+	defer c.Close()
+
+	next := func() SecurityEvent {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(5 * timeScale):
+			t.Fatal("security event not received")
+		}
+		return SecurityEvent{}
+	}
+
+	c.ConnConfig.Secret = []byte("bad secret")
+	_, _ = c.SendAcctRequest(context.Background(), testAcctReq)
+	e := next()
+	if e.Kind != EventBadSecret || e.Err != ErrBadSecret || e.RemoteAddr == nil {
+		t.Errorf("unexpected event: %+v", e)
+	}
+
+	nc, err := net.Dial("tcp", c.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	p := make([]byte, hdrLen)
+	p[hdrVer] = 0x10
+	p[hdrID+3] = 7
+	if _, err = nc.Write(p); err != nil {
+		t.Fatal(err)
+	}
+	e = next()
+	if e.Kind != EventBadVersion || e.SessionID != 7 {
+		t.Errorf("unexpected event: %+v", e)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	nc, _ := net.Pipe()
+	defer nc.Close()
+	c := newConn(nc, nil, ConnConfig{})
+	var events []SecurityEventKind
+	c.OnSecurityEvent = func(e SecurityEvent) { events = append(events, e.Kind) }
+	p := make([]byte, hdrLen)
+
+	before := DecodeErrors()
+	// lengths don't add up: a wrong secret, unless the packet isn't obfuscated
+	body := []byte{0, 0, 0, 0, 0, 0, 0, 0, 9}
+	err := new(AcctRequest).unmarshal(body)
+	if got := c.decodeError(p, err); got != ErrBadSecret {
+		t.Errorf("got %v, want %v", got, ErrBadSecret)
+	}
+	c.Secretless = true
+	if got := c.decodeError(p, err); got != ErrMalformedPacket {
+		t.Errorf("secretless got %v, want %v", got, ErrMalformedPacket)
+	}
+	// too short for the fixed fields
+	c.Secretless = false
+	err = new(AcctRequest).unmarshal(body[:4])
+	if got := c.decodeError(p, err); got != ErrMalformedPacket {
+		t.Errorf("short body got %v, want %v", got, ErrMalformedPacket)
+	}
+	if got := c.decodeError(p, errSessionClosed); got != errSessionClosed {
+		t.Errorf("got %v, want %v", got, errSessionClosed)
+	}
+
+	after := DecodeErrors()
+	if after.BadSecret-before.BadSecret != 1 || after.Malformed-before.Malformed != 2 {
+		t.Errorf("got counts %+v, before %+v", after, before)
+	}
+	want := []SecurityEventKind{EventBadSecret, EventMalformedPacket, EventMalformedPacket}
+	if len(events) != len(want) {
+		t.Fatalf("got events %v, want %v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("got events %v, want %v", events, want)
+		}
+	}
+}
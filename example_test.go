@@ -0,0 +1,94 @@
+//go:build !tacplus_nomd5
+
+package tacplus_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/nwaples/tacplus"
+)
+
+// scriptPolicy is a stand-in for an embedded script interpreter. Each line of
+// the script is "permit" or "deny" followed by name=value conditions, matched
+// against the user and the request arguments. The first matching line decides
+// the request.
+func scriptPolicy(script string) tacplus.Policy {
+	return tacplus.PolicyFunc(func(ctx context.Context, req *tacplus.AuthorRequest, s *tacplus.ServerSession) (*tacplus.AuthorResponse, error) {
+		vars := map[string]string{"user": req.User}
+		for _, arg := range req.Arg {
+			if i := strings.IndexAny(arg, "=*"); i > 0 {
+				vars[arg[:i]] = arg[i+1:]
+			}
+		}
+		sc := bufio.NewScanner(strings.NewReader(script))
+		for sc.Scan() {
+			f := strings.Fields(sc.Text())
+			if len(f) == 0 || !matchAll(vars, f[1:]) {
+				continue
+			}
+			switch f[0] {
+			case "permit":
+				return &tacplus.AuthorResponse{Status: tacplus.AuthorStatusPassAdd}, nil
+			case "deny":
+				return &tacplus.AuthorResponse{Status: tacplus.AuthorStatusFail}, nil
+			}
+			return nil, fmt.Errorf("unknown action %q", f[0])
+		}
+		return nil, nil // no decision, pass to the next handler
+	})
+}
+
+// matchAll returns whether vars meets every name=value condition of conds.
+func matchAll(vars map[string]string, conds []string) bool {
+	for _, c := range conds {
+		name, value, _ := strings.Cut(c, "=")
+		if vars[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func ExamplePolicyHandler() {
+	const script = `
+deny  cmd=reload
+permit user=alice service=shell
+permit service=shell cmd=show
+deny  service=shell
+`
+	h := &tacplus.ServerConnHandler{
+		Handler:    &tacplus.PolicyHandler{Policy: scriptPolicy(script)},
+		ConnConfig: tacplus.ConnConfig{Secret: []byte("secret")},
+	}
+	for _, req := range []struct {
+		user string
+		args []string
+	}{
+		{"alice", []string{"service=shell", "cmd=configure"}},
+		{"alice", []string{"service=shell", "cmd=reload"}},
+		{"bob", []string{"service=shell", "cmd=show", "cmd-arg=version"}},
+		{"bob", []string{"service=shell", "cmd=configure"}},
+	} {
+		resp, _, err := tacplus.DryRunAuthor(context.Background(), h, &tacplus.AuthorRequest{
+			AuthenMethod:  tacplus.AuthenMethodTACACSPlus,
+			PrivLvl:       1,
+			AuthenType:    tacplus.AuthenTypeASCII,
+			AuthenService: tacplus.AuthenServiceLogin,
+			User:          req.user,
+			Arg:           req.args,
+		})
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println(req.user, req.args[1], resp.Status == tacplus.AuthorStatusPassAdd)
+	}
+	// Output:
+	// alice cmd=configure true
+	// alice cmd=reload false
+	// bob cmd=show true
+	// bob cmd=configure false
+}
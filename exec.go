@@ -0,0 +1,108 @@
+package tacplus
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// ExecPolicy is a Policy that runs an external program to make authorization
+// decisions, similar to the before and after authorization scripts of tac_plus.
+//
+// The request is written to the program's standard input as a JSON object:
+//
+//	{"user":"fred","port":"tty1","rem_addr":"10.0.0.1","nas_addr":"10.1.1.1",
+//	 "priv_lvl":1,"authen_method":6,"authen_type":1,"authen_service":1,
+//	 "args":["service=shell","cmd="]}
+//
+// The program writes the response to standard output as a JSON object:
+//
+//	{"status":"pass_add","args":["priv-lvl=15"],"server_msg":"","data":""}
+//
+// where status is one of pass_add, pass_repl, fail or error. Empty output
+// means the program makes no decision. A non-zero exit status or invalid
+// output results in an Error response. The program is killed if the Timeout
+// expires. Child processes it leaves holding standard output open are given up
+// on a second later, with an Error response.
+type ExecPolicy struct {
+	Path    string        // path of the program to run
+	Args    []string      // arguments to the program
+	Timeout time.Duration // maximum time the program may run, ignored if zero
+}
+
+// execRequest is the JSON encoding of an authorization request for ExecPolicy.
+type execRequest struct {
+	User          string   `json:"user"`
+	Port          string   `json:"port"`
+	RemAddr       string   `json:"rem_addr"`
+	NASAddr       string   `json:"nas_addr"`
+	PrivLvl       uint8    `json:"priv_lvl"`
+	AuthenMethod  uint8    `json:"authen_method"`
+	AuthenType    uint8    `json:"authen_type"`
+	AuthenService uint8    `json:"authen_service"`
+	Args          []string `json:"args"`
+}
+
+// execResponse is the JSON encoding of an authorization response for ExecPolicy.
+type execResponse struct {
+	Status    string   `json:"status"`
+	Args      []string `json:"args"`
+	ServerMsg string   `json:"server_msg"`
+	Data      string   `json:"data"`
+}
+
+// execWaitDelay is the time to wait for the output of an ExecPolicy program to
+// close after it is killed or exits.
+const execWaitDelay = time.Second
+
+var execStatus = map[string]uint8{
+	"pass_add":  AuthorStatusPassAdd,
+	"pass_repl": AuthorStatusPassRepl,
+	"fail":      AuthorStatusFail,
+	"error":     AuthorStatusError,
+}
+
+// Authorize runs the program to make a decision on req.
+func (p *ExecPolicy) Authorize(ctx context.Context, req *AuthorRequest, s *ServerSession) (*AuthorResponse, error) {
+	in, err := json.Marshal(execRequest{
+		User:          req.User,
+		Port:          req.Port,
+		RemAddr:       req.RemAddr,
+		NASAddr:       remoteHost(s),
+		PrivLvl:       req.PrivLvl,
+		AuthenMethod:  req.AuthenMethod,
+		AuthenType:    req.AuthenType,
+		AuthenService: req.AuthenService,
+		Args:          req.Arg,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, p.Path, p.Args...)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.WaitDelay = execWaitDelay
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("authorization program %s: %w", p.Path, err)
+	}
+	if len(bytes.TrimSpace(out)) == 0 {
+		return nil, nil
+	}
+	var r execResponse
+	if err = json.Unmarshal(out, &r); err != nil {
+		return nil, fmt.Errorf("authorization program %s: %w", p.Path, err)
+	}
+	status, ok := execStatus[r.Status]
+	if !ok {
+		return nil, fmt.Errorf("authorization program %s: unknown status %q", p.Path, r.Status)
+	}
+	return &AuthorResponse{Status: status, Arg: r.Args, ServerMsg: r.ServerMsg, Data: r.Data}, nil
+}
@@ -0,0 +1,68 @@
+//go:build !tacplus_nomd5
+
+package tacplus
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestExecPolicy(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not found")
+	}
+	// script grants admin, fails fred, makes no decision for others and hangs for
+	// slow, and for orphan with a child holding standard output
+	script := `in=$(cat)
+case "$in" in
+*'"user":"admin"'*) echo '{"status":"pass_repl","args":["priv-lvl=15"]}' ;;
+*'"user":"fred"'*) echo '{"status":"fail","server_msg":"denied"}' ;;
+*'"user":"slow"'*) exec sleep 5 ;;
+*'"user":"orphan"'*) sleep 5 & wait ;;
+*'"user":"bad"'*) echo 'not json' ;;
+esac`
+	h := testHandler
+	h.Handler = &PolicyHandler{
+		Passthrough: Passthrough{testHandler.Handler},
+		Policy:      &ExecPolicy{Path: sh, Args: []string{"-c", script}, Timeout: 5 * timeScale},
+	}
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	for _, test := range []struct {
+		user   string
+		status uint8
+		msg    string
+	}{
+		{"admin", AuthorStatusPassRepl, ""},
+		{"fred", AuthorStatusFail, "denied"},
+		{"user", AuthorStatusPassAdd, ""},
+		{"slow", AuthorStatusError, errInternal},
+		{"orphan", AuthorStatusError, errInternal},
+		{"bad", AuthorStatusError, errInternal},
+	} {
+		req := *testAuthorReq
+		req.User = test.user
+		start := time.Now()
+		resp, err := c.SendAuthorRequest(context.Background(), &req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != test.status || resp.ServerMsg != test.msg {
+			t.Errorf("%s: got status %d %q, want %d %q", test.user, resp.Status, resp.ServerMsg, test.status, test.msg)
+		}
+		limit := time.Second
+		if test.user == "orphan" {
+			limit += execWaitDelay
+		}
+		if time.Since(start) > limit {
+			t.Errorf("%s: program not stopped after timeout", test.user)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package tacplus
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrInjectedReset is the error returned by a connection reset by a FaultInjector.
+var ErrInjectedReset = errors.New("tacplus: injected connection reset")
+
+// FaultInjector wraps network connections with injected faults, for testing
+// how clients and servers behave on an unreliable network. Connections are
+// wrapped with Conn, or with Dial for a Client and Listener for a Server.
+//
+// Rates are the probability, between 0 and 1, of the fault affecting each read
+// or write. Faults are ignored if zero.
+type FaultInjector struct {
+	Latency time.Duration // delay before each read and write
+	Jitter  time.Duration // maximum random delay added to Latency
+
+	// Split each write into random short writes, as a peer sees when a packet
+	// is sent in several segments.
+	PartialWrites bool
+
+	ResetRate float64 // rate of reads and writes that reset the connection
+	FlipRate  float64 // rate of reads with a random bit flipped in the data read
+
+	// Rate of reads that stall for Stall, or until the read deadline, before
+	// reading, as when a network path stops passing traffic.
+	StallRate float64
+	Stall     time.Duration
+
+	// Seed for the random source choosing faults, so failing runs can be
+	// repeated. A random seed is used if zero.
+	Seed int64
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// Conn returns nc wrapped so its reads and writes have faults injected.
+func (fi *FaultInjector) Conn(nc net.Conn) net.Conn {
+	return &faultConn{Conn: nc, fi: fi}
+}
+
+// Dial returns a function for Client.DialContext dialing with dial, or a
+// net.Dialer if nil, and injecting faults into the connections.
+func (fi *FaultInjector) Dial(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if dial == nil {
+		dial = zeroDialer.DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		nc, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return fi.Conn(nc), nil
+	}
+}
+
+// Listener returns l wrapped so the connections it accepts have faults injected.
+func (fi *FaultInjector) Listener(l net.Listener) net.Listener {
+	return &faultListener{Listener: l, fi: fi}
+}
+
+// chance reports whether a fault with the given rate happens.
+func (fi *FaultInjector) chance(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	return fi.rand().Float64() < rate
+}
+
+// intn returns a random number in [0, n).
+func (fi *FaultInjector) intn(n int) int {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	return fi.rand().Intn(n)
+}
+
+// rand returns the random source, creating it if needed. fi.mu must be held.
+func (fi *FaultInjector) rand() *rand.Rand {
+	if fi.rnd == nil {
+		seed := fi.Seed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+		fi.rnd = rand.New(rand.NewSource(seed))
+	}
+	return fi.rnd
+}
+
+// delay returns the latency for a read or write.
+func (fi *FaultInjector) delay() time.Duration {
+	d := fi.Latency
+	if fi.Jitter > 0 {
+		d += time.Duration(fi.intn(int(fi.Jitter)))
+	}
+	return d
+}
+
+type faultListener struct {
+	net.Listener
+	fi *FaultInjector
+}
+
+func (l *faultListener) Accept() (net.Conn, error) {
+	nc, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.fi.Conn(nc), nil
+}
+
+// faultConn is a net.Conn with faults injected by a FaultInjector.
+type faultConn struct {
+	net.Conn
+	fi *FaultInjector
+
+	mu     sync.Mutex
+	rdl    time.Time     // read deadline
+	wake   chan struct{} // closed to wake waiting reads, if not nil
+	reset  bool          // connection has been reset
+	closed bool          // connection has been closed
+}
+
+// wakeLocked wakes reads waiting in wait. c.mu must be held.
+func (c *faultConn) wakeLocked() {
+	if c.wake != nil {
+		close(c.wake)
+		c.wake = nil
+	}
+}
+
+// wait waits for d before a read, returning an error if the read deadline
+// passes or the connection is closed first. Changing the read deadline wakes
+// the wait to check the new deadline.
+func (c *faultConn) wait(d time.Duration) error {
+	end := time.Now().Add(d)
+	for {
+		c.mu.Lock()
+		switch {
+		case c.reset:
+			c.mu.Unlock()
+			return ErrInjectedReset
+		case c.closed:
+			c.mu.Unlock()
+			return net.ErrClosed
+		}
+		deadline := c.rdl
+		if c.wake == nil {
+			c.wake = make(chan struct{})
+		}
+		wake := c.wake
+		c.mu.Unlock()
+
+		now := time.Now()
+		if !deadline.IsZero() && !now.Before(deadline) {
+			return os.ErrDeadlineExceeded
+		}
+		if !now.Before(end) {
+			return nil
+		}
+		left := end.Sub(now)
+		if !deadline.IsZero() && deadline.Sub(now) < left {
+			left = deadline.Sub(now)
+		}
+		t := time.NewTimer(left)
+		select {
+		case <-t.C:
+		case <-wake:
+			t.Stop()
+		}
+	}
+}
+
+// resetConn closes the connection, discarding unsent data on TCP connections
+// so the peer sees a reset.
+func (c *faultConn) resetConn() error {
+	c.mu.Lock()
+	c.reset = true
+	c.wakeLocked()
+	c.mu.Unlock()
+	if tc, ok := c.Conn.(*net.TCPConn); ok {
+		_ = tc.SetLinger(0)
+	}
+	_ = c.Conn.Close()
+	return ErrInjectedReset
+}
+
+func (c *faultConn) isReset() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reset
+}
+
+func (c *faultConn) Read(p []byte) (int, error) {
+	if c.isReset() {
+		return 0, ErrInjectedReset
+	}
+	fi := c.fi
+	if err := c.wait(fi.delay()); err != nil {
+		return 0, err
+	}
+	if fi.chance(fi.StallRate) {
+		if err := c.wait(fi.Stall); err != nil {
+			return 0, err
+		}
+	}
+	if fi.chance(fi.ResetRate) {
+		return 0, c.resetConn()
+	}
+	n, err := c.Conn.Read(p)
+	if n > 0 && fi.chance(fi.FlipRate) {
+		i := fi.intn(n * 8)
+		p[i/8] ^= 1 << (i % 8)
+	}
+	return n, err
+}
+
+func (c *faultConn) Write(p []byte) (int, error) {
+	if c.isReset() {
+		return 0, ErrInjectedReset
+	}
+	fi := c.fi
+	time.Sleep(fi.delay())
+	if fi.chance(fi.ResetRate) {
+		return 0, c.resetConn()
+	}
+	if !fi.PartialWrites {
+		return c.Conn.Write(p)
+	}
+	written := 0
+	for written < len(p) {
+		m := 1 + fi.intn(len(p)-written)
+		n, err := c.Conn.Write(p[written : written+m])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+func (c *faultConn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.wakeLocked()
+	c.mu.Unlock()
+	return c.Conn.Close()
+}
+
+func (c *faultConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.rdl = t
+	c.wakeLocked()
+	c.mu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *faultConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.rdl = t
+	c.wakeLocked()
+	c.mu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
@@ -0,0 +1,91 @@
+package tacplus
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFaultInjectorClient(t *testing.T) {
+	skipWithoutMD5(t)
+	s, c, err := newTestInstance(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	defer c.Close()
+
+	// requests survive latency and writes split into short segments
+	fi := &FaultInjector{Latency: timeScale / 4, Jitter: timeScale / 4, PartialWrites: true, Seed: 1}
+	c.DialContext = fi.Dial(nil)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err = c.SendAcctRequest(context.Background(), testAcctReq); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 3*timeScale/4 {
+		t.Errorf("requests took %v, want at least %v", d, 3*timeScale/4)
+	}
+	c.Close()
+
+	// flipped bits in replies fail requests, or lose them if the header is hit
+	c.DialContext = (&FaultInjector{FlipRate: 1, Seed: 1}).Dial(nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*timeScale)
+	defer cancel()
+	if _, err = c.SendAcctRequest(ctx, testAcctReq); err == nil {
+		t.Error("request with corrupted reply succeeded")
+	}
+	c.Close()
+
+	// reset connections fail requests with a connection error
+	c.DialContext = (&FaultInjector{ResetRate: 1}).Dial(nil)
+	c.NoRetry = true
+	_, err = c.SendAcctRequest(context.Background(), testAcctReq)
+	if !errors.Is(err, ErrInjectedReset) && !errors.Is(err, ErrConnClosed) && !errors.Is(err, net.ErrClosed) {
+		t.Errorf("got error %v, want connection closed", err)
+	}
+}
+
+func TestFaultInjectorStall(t *testing.T) {
+	cc, sc := net.Pipe()
+	defer sc.Close()
+	fi := &FaultInjector{StallRate: 1, Stall: time.Minute}
+	nc := fi.Conn(cc)
+	defer nc.Close()
+
+	// a stalled read returns when its deadline passes
+	_ = nc.SetReadDeadline(time.Now().Add(timeScale))
+	start := time.Now()
+	_, err := nc.Read(make([]byte, 1))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("got error %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+	if d := time.Since(start); d > 5*timeScale {
+		t.Errorf("stalled read took %v", d)
+	}
+}
+
+func TestFaultInjectorStallInterrupt(t *testing.T) {
+	cc, sc := net.Pipe()
+	defer sc.Close()
+	fi := &FaultInjector{StallRate: 1, Stall: time.Minute}
+
+	for name, interrupt := range map[string]func(nc net.Conn) error{
+		"close":    func(nc net.Conn) error { return nc.Close() },
+		"deadline": func(nc net.Conn) error { return nc.SetReadDeadline(time.Now()) },
+	} {
+		nc := fi.Conn(cc)
+		time.AfterFunc(timeScale, func() { _ = interrupt(nc) })
+		start := time.Now()
+		if _, err := nc.Read(make([]byte, 1)); err == nil {
+			t.Errorf("%s: stalled read succeeded", name)
+		}
+		if d := time.Since(start); d > 5*timeScale {
+			t.Errorf("%s: stalled read took %v", name, d)
+		}
+	}
+}
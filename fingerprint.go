@@ -0,0 +1,81 @@
+package tacplus
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+)
+
+// fingerprintArgs are the quirks normalizing arguments before fingerprinting.
+const fingerprintArgs = QuirkArgSpaces | QuirkServiceCase
+
+// volatileAcctArgs are accounting arguments left out of fingerprints, as
+// devices can change them when resending a record.
+var volatileAcctArgs = map[string]bool{
+	"elapsed_time": true,
+}
+
+// fingerprint hashes the fields of a request of session type t.
+type fingerprint struct {
+	h hash.Hash
+}
+
+func newFingerprint(t uint8) fingerprint {
+	f := fingerprint{sha256.New()}
+	f.h.Write([]byte{t})
+	return f
+}
+
+func (f fingerprint) bytes(b ...uint8) {
+	f.h.Write(b)
+}
+
+// string writes s prefixed with its length, so adjacent fields can't run together.
+func (f fingerprint) string(s string) {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(s)))
+	f.h.Write(n[:])
+	f.h.Write([]byte(s))
+}
+
+// args writes the arguments of the request, normalized and skipping those in skip.
+func (f fingerprint) args(args []string, skip map[string]bool) {
+	for _, a := range fingerprintArgs.NormalizeArgs(args) {
+		if !skip[argName(a)] {
+			f.string(a)
+		}
+	}
+}
+
+func (f fingerprint) sum() string {
+	return hex.EncodeToString(f.h.Sum(nil))
+}
+
+// Fingerprint returns a stable hash of a, as a hex string, for use as an
+// idempotency key. Requests that differ only in the spacing of cmd and cmd-arg
+// values or the case of the service value have the same fingerprint.
+// Arguments are otherwise compared in order, as their order is significant.
+func (a *AuthorRequest) Fingerprint() string {
+	f := newFingerprint(sessTypeAuthor)
+	f.bytes(a.AuthenMethod, a.PrivLvl, a.AuthenType, a.AuthenService)
+	f.string(a.User)
+	f.string(a.Port)
+	f.string(a.RemAddr)
+	f.args(a.Arg, nil)
+	return f.sum()
+}
+
+// Fingerprint returns a stable hash of a, as a hex string, for use as an
+// idempotency key. Arguments are normalized as for AuthorRequest.Fingerprint,
+// and the elapsed_time argument, which devices can update when resending a
+// record, is left out.
+func (a *AcctRequest) Fingerprint() string {
+	f := newFingerprint(sessTypeAcct)
+	f.bytes(a.Flags, a.AuthenMethod, a.PrivLvl, a.AuthenType, a.AuthenService)
+	f.string(a.User)
+	f.string(a.Port)
+	f.string(a.RemAddr)
+	f.args(a.Arg, volatileAcctArgs)
+	return f.sum()
+}
@@ -0,0 +1,69 @@
+package tacplus
+
+import "testing"
+
+func TestAuthorRequestFingerprint(t *testing.T) {
+	req := func(args ...string) *AuthorRequest {
+		r := *testAuthorReq
+		r.Arg = args
+		return &r
+	}
+	fp := req("service=shell", "cmd=show", "cmd-arg=running-config").Fingerprint()
+	if len(fp) != 64 {
+		t.Fatalf("got fingerprint %q, want 64 hex digits", fp)
+	}
+
+	same := []*AuthorRequest{
+		req("service=shell", "cmd=show", "cmd-arg=running-config"),
+		req("service=Shell", "cmd=show ", "cmd-arg= running-config"),
+	}
+	for _, r := range same {
+		if got := r.Fingerprint(); got != fp {
+			t.Errorf("args %q: got fingerprint %s, want %s", r.Arg, got, fp)
+		}
+	}
+
+	user := req("service=shell", "cmd=show", "cmd-arg=running-config")
+	user.User = "other"
+	diff := []*AuthorRequest{
+		req("service=shell", "cmd=show", "cmd-arg=startup-config"),
+		req("cmd=show", "service=shell", "cmd-arg=running-config"),
+		req("service=shell", "cmd=show", "cmd-arg*running-config"),
+		req("service=shell", "cmd=show", "cmd-arg=running-config", ""),
+		user,
+	}
+	for _, r := range diff {
+		if r.Fingerprint() == fp {
+			t.Errorf("%+v has the same fingerprint", r)
+		}
+	}
+}
+
+func TestAcctRequestFingerprint(t *testing.T) {
+	a := *testAcctReq
+	a.Arg = []string{"task_id=1", "elapsed_time=5", "service=shell"}
+	b := a
+	b.Arg = []string{"task_id=1", "elapsed_time=6", "service=shell"}
+	if a.Fingerprint() != b.Fingerprint() {
+		t.Error("elapsed_time changed fingerprint")
+	}
+	b.Flags = AcctFlagStop
+	if a.Fingerprint() == b.Fingerprint() {
+		t.Error("flags didn't change fingerprint")
+	}
+
+	// the same fields in an authorization request hash differently
+	r := AuthorRequest{
+		AuthenMethod:  a.AuthenMethod,
+		PrivLvl:       a.PrivLvl,
+		AuthenType:    a.AuthenType,
+		AuthenService: a.AuthenService,
+		User:          a.User,
+		Port:          a.Port,
+		RemAddr:       a.RemAddr,
+		Arg:           a.Arg,
+	}
+	if r.Fingerprint() == a.Fingerprint() {
+		t.Error("authorization and accounting requests have the same fingerprint")
+	}
+}
@@ -0,0 +1,188 @@
+package tacplus
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// A GroupLookup returns the user groups a user belongs to, such as a UserStore.
+type GroupLookup interface {
+	UserGroups(ctx context.Context, user string) ([]string, error)
+}
+
+// SetGroups sets the user groups of user, returning false if the user doesn't
+// exist.
+func (u *UserStore) SetGroups(user string, groups ...string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	su := u.users[user]
+	if su == nil {
+		return false
+	}
+	su.groups = append([]string(nil), groups...)
+	return true
+}
+
+// UserGroups returns the user groups of user, set with SetGroups.
+func (u *UserStore) UserGroups(ctx context.Context, user string) ([]string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if su := u.users[user]; su != nil {
+		return append([]string(nil), su.groups...), nil
+	}
+	return nil, nil
+}
+
+// StaticGroups is a GroupLookup mapping user names to their user groups. It
+// never returns an error.
+type StaticGroups map[string][]string
+
+// UserGroups returns the user groups of user.
+func (g StaticGroups) UserGroups(ctx context.Context, user string) ([]string, error) {
+	return g[user], nil
+}
+
+// UserGroup is the authorization policy of a group of users in a GroupPolicy.
+type UserGroup struct {
+	// Group whose services and command rules are inherited, if not empty.
+	Parent string
+
+	// Arguments of the authorization response for each service, such as
+	// {"shell": {"priv-lvl=15"}}. They replace arguments of the same name
+	// inherited from the parent group.
+	Services map[string][]string
+
+	// Rules for shell commands, tried before those of the parent group.
+	Commands []CommandRule
+}
+
+type userGroup struct {
+	UserGroup
+	name     string
+	commands *CommandMatcher
+}
+
+// GroupPolicy is a Policy authorizing users by the user groups they belong to,
+// so common policies can be configured without a custom handler.
+//
+// The groups of a user are taken from Members, each followed by the groups it
+// inherits from, giving the group chain of the user. Groups not added to the
+// policy are left out. Command requests are decided by the first command rule
+// in the chain matching the command line, and denied if none match. Other
+// requests pass if a group in the chain has arguments for the requested
+// service, with those of earlier groups replacing inherited ones. Users in no
+// group get no decision, so their requests are passed on by a PolicyHandler.
+//
+// Failures have a DenyReason naming the group chain. The group and rule
+// deciding each request are reported to a rule trace. A CommandLog with Groups
+// set records the group chain of each command.
+//
+// A GroupPolicy is safe for concurrent use, so groups can be changed while a
+// server is running.
+type GroupPolicy struct {
+	Members GroupLookup // user group membership, such as a UserStore
+
+	mu     sync.RWMutex
+	groups map[string]*userGroup
+}
+
+// Add adds the group name, replacing any group already added with the name. It
+// returns an error if a command rule pattern isn't a valid regular expression.
+func (p *GroupPolicy) Add(name string, g UserGroup) error {
+	m, err := NewCommandMatcher(g.Commands...)
+	if err != nil {
+		return fmt.Errorf("group %s: %w", name, err)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.groups == nil {
+		p.groups = make(map[string]*userGroup)
+	}
+	p.groups[name] = &userGroup{g, name, m}
+	return nil
+}
+
+// Chain returns the group chain of user: each of the user's groups followed by
+// the groups it inherits from. Each group appears once.
+func (p *GroupPolicy) Chain(ctx context.Context, user string) ([]string, error) {
+	if p.Members == nil {
+		return nil, nil
+	}
+	groups, err := p.Members.UserGroups(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var chain []string
+	seen := make(map[string]bool)
+	for _, name := range groups {
+		for !seen[name] && p.groups[name] != nil {
+			seen[name] = true
+			chain = append(chain, name)
+			name = p.groups[name].Parent
+		}
+	}
+	return chain, nil
+}
+
+// lookup returns the groups of chain.
+func (p *GroupPolicy) lookup(chain []string) []*userGroup {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	groups := make([]*userGroup, 0, len(chain))
+	for _, name := range chain {
+		if g := p.groups[name]; g != nil {
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
+// Authorize implements Policy, authorizing the request by the group chain of
+// its user.
+func (p *GroupPolicy) Authorize(ctx context.Context, req *AuthorRequest, s *ServerSession) (*AuthorResponse, error) {
+	chain, err := p.Chain(ctx, req.User)
+	if err != nil || len(chain) == 0 {
+		return nil, err
+	}
+	groups := p.lookup(chain)
+	fail := func(reason string) *AuthorResponse {
+		return &AuthorResponse{
+			Status:     AuthorStatusFail,
+			DenyReason: "groups " + strings.Join(chain, ",") + ": " + reason,
+		}
+	}
+
+	if cmd, ok := CommandLine(req.Arg); ok {
+		for _, g := range groups {
+			if r := g.commands.match(cmd); r >= 0 {
+				traceRule(ctx, "group "+g.name+": "+g.commands.ruleName(r))
+				if !g.commands.rules[r].permit {
+					return fail("command denied"), nil
+				}
+				return &AuthorResponse{Status: AuthorStatusPassAdd}, nil
+			}
+		}
+		traceRule(ctx, "groups "+strings.Join(chain, ",")+": no command rule matched")
+		return fail("no command rule matched"), nil
+	}
+
+	service, _ := argValue(req.Arg, "service")
+	var args []string
+	var from []string
+	for i := len(groups) - 1; i >= 0; i-- {
+		if a, ok := groups[i].Services[service]; ok {
+			args = mergeArgs(args, a)
+			from = append([]string{groups[i].name}, from...)
+		}
+	}
+	if len(from) == 0 {
+		traceRule(ctx, "groups "+strings.Join(chain, ",")+": service "+service+" not permitted")
+		return fail("service " + service + " not permitted"), nil
+	}
+	traceRule(ctx, "groups "+strings.Join(from, ",")+": service "+service)
+	return &AuthorResponse{Status: AuthorStatusPassAdd, Arg: args}, nil
+}
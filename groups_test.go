@@ -0,0 +1,165 @@
+package tacplus
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testGroupPolicy(t *testing.T) *GroupPolicy {
+	p := &GroupPolicy{Members: StaticGroups{
+		"alice": {"netops"},
+		"bob":   {"helpdesk"},
+		"dave":  {"loop1", "staff"},
+	}}
+	for name, g := range map[string]UserGroup{
+		"staff": {
+			Services: map[string][]string{"shell": {"priv-lvl=1", "timeout=30"}},
+			Commands: []CommandRule{{true, "^show "}, {false, "."}},
+		},
+		"netops": {
+			Parent:   "staff",
+			Services: map[string][]string{"shell": {"priv-lvl=15"}},
+			Commands: []CommandRule{{true, "^configure "}},
+		},
+		"helpdesk": {
+			Parent:   "staff",
+			Commands: []CommandRule{{false, "^show running-config"}},
+		},
+		"loop1": {Parent: "loop2"},
+		"loop2": {Parent: "loop1"},
+	} {
+		if err := p.Add(name, g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return p
+}
+
+func TestGroupPolicyChain(t *testing.T) {
+	p := testGroupPolicy(t)
+	ctx := context.Background()
+	for user, want := range map[string][]string{
+		"alice": {"netops", "staff"},
+		"bob":   {"helpdesk", "staff"},
+		"carol": nil,
+		"dave":  {"loop1", "loop2", "staff"},
+	} {
+		if chain, err := p.Chain(ctx, user); err != nil || !reflect.DeepEqual(chain, want) {
+			t.Errorf("%s: got chain %v %v, want %v", user, chain, err, want)
+		}
+	}
+	if err := p.Add("bad", UserGroup{Commands: []CommandRule{{true, "("}}}); err == nil {
+		t.Error("invalid command pattern accepted")
+	}
+}
+
+func TestUserStoreGroups(t *testing.T) {
+	users := new(UserStore)
+	users.Set("alice", "pass", time.Now())
+	if !users.SetGroups("alice", "netops", "staff") || users.SetGroups("bob", "staff") {
+		t.Fatal("groups set for wrong users")
+	}
+	p := testGroupPolicy(t)
+	p.Members = users
+	ctx := context.Background()
+	for user, want := range map[string][]string{
+		"alice": {"netops", "staff"},
+		"bob":   nil,
+	} {
+		if chain, err := p.Chain(ctx, user); err != nil || !reflect.DeepEqual(chain, want) {
+			t.Errorf("%s: got chain %v %v, want %v", user, chain, err, want)
+		}
+	}
+}
+
+func TestGroupPolicyAuthorize(t *testing.T) {
+	p := testGroupPolicy(t)
+	ctx := context.Background()
+	// shell returns the arguments of a shell request running the command words
+	shell := func(words ...string) []string {
+		args := []string{"service=shell", "cmd="}
+		for i, w := range words {
+			if i == 0 {
+				args[1] += w
+			} else {
+				args = append(args, "cmd-arg="+w)
+			}
+		}
+		return args
+	}
+	tests := []struct {
+		user   string
+		args   []string
+		status uint8
+		resp   []string
+	}{
+		{"alice", shell(), AuthorStatusPassAdd, []string{"priv-lvl=15", "timeout=30"}},
+		{"bob", shell(), AuthorStatusPassAdd, []string{"priv-lvl=1", "timeout=30"}},
+		{"alice", shell("configure", "terminal"), AuthorStatusPassAdd, nil},
+		{"alice", shell("show", "running-config"), AuthorStatusPassAdd, nil},
+		{"bob", shell("show", "running-config"), AuthorStatusFail, nil},
+		{"bob", shell("show", "version"), AuthorStatusPassAdd, nil},
+		{"bob", shell("configure", "terminal"), AuthorStatusFail, nil},
+		{"alice", []string{"service=ppp", "protocol=ip"}, AuthorStatusFail, nil},
+	}
+	for _, tt := range tests {
+		r, err := p.Authorize(ctx, &AuthorRequest{User: tt.user, Arg: tt.args}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Status != tt.status || (tt.status != AuthorStatusFail && !reflect.DeepEqual(r.Arg, tt.resp)) {
+			t.Errorf("%s %v: got %d %v, want %d %v", tt.user, tt.args, r.Status, r.Arg, tt.status, tt.resp)
+		}
+		if tt.status == AuthorStatusFail && !strings.HasPrefix(r.DenyReason, "groups ") {
+			t.Errorf("%s %v: got deny reason %q", tt.user, tt.args, r.DenyReason)
+		}
+	}
+	if r, err := p.Authorize(ctx, &AuthorRequest{User: "carol", Arg: shell()}, nil); r != nil || err != nil {
+		t.Errorf("user in no group: got %+v %v, want no decision", r, err)
+	}
+}
+
+func TestGroupsRecorded(t *testing.T) {
+	skipWithoutMD5(t)
+	p := testGroupPolicy(t)
+	var buf bytes.Buffer
+	h := testHandler
+	h.Handler = &CommandLog{
+		Passthrough: Passthrough{&PolicyHandler{Passthrough: Passthrough{testHandler.Handler}, Policy: p}},
+		W:           &buf,
+		Groups:      p,
+	}
+	denials := make(chan Denial, 1)
+	h.ConnConfig.OnDeny = func(d Denial) { denials <- d }
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	defer c.Close()
+
+	ctx := context.Background()
+	req := &AuthorRequest{User: "bob", Arg: []string{"service=shell", "cmd=show", "cmd-arg=running-config"}}
+	resp, err := c.SendAuthorRequest(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != AuthorStatusFail {
+		t.Errorf("got status %d, want %d", resp.Status, AuthorStatusFail)
+	}
+	if d := <-denials; d.Reason != "groups helpdesk,staff: command denied" {
+		t.Errorf("got deny reason %q", d.Reason)
+	}
+
+	acct := &AcctRequest{Flags: AcctFlagStop, User: "alice", Arg: []string{"cmd=show version"}}
+	if _, err = c.SendAcctRequest(ctx, acct); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"groups":["netops","staff"]`) {
+		t.Errorf("group chain not recorded: %s", buf.String())
+	}
+}
@@ -0,0 +1,227 @@
+package tacplus
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupportedHash is the error for a password hash using a scheme that
+// isn't supported, such as bcrypt or MD5 crypt.
+var ErrUnsupportedHash = errors.New("unsupported password hash scheme")
+
+// SHA-crypt parameters
+const (
+	shaCryptRounds    = 5000 // default rounds
+	shaCryptMinRounds = 1000
+	shaCryptMaxRounds = 999999999
+	shaCryptSaltLen   = 16
+)
+
+// maxPasswordLen is the length of the longest password checked against a hash.
+// SHA-crypt takes time quadratic in the password length, so longer passwords
+// sent by clients are rejected without hashing them.
+const maxPasswordLen = 256
+
+const cryptAlphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// Byte order of SHA-crypt digests in their encoding, in groups of three.
+var (
+	sha256CryptOrder = []int{
+		0, 10, 20, 21, 1, 11, 12, 22, 2, 3, 13, 23, 24, 4, 14,
+		15, 25, 5, 6, 16, 26, 27, 7, 17, 18, 28, 8, 9, 19, 29,
+	}
+	sha512CryptOrder = []int{
+		0, 21, 42, 22, 43, 1, 44, 2, 23, 3, 24, 45, 25, 46, 4,
+		47, 5, 26, 6, 27, 48, 28, 49, 7, 50, 8, 29, 9, 30, 51,
+		31, 52, 10, 53, 11, 32, 12, 33, 54, 34, 55, 13, 56, 14, 35,
+		15, 36, 57, 37, 58, 16, 59, 17, 38, 18, 39, 60, 40, 61, 19,
+		62, 20, 41,
+	}
+)
+
+// cryptEncode appends the crypt base64 encoding of the 24 bit value of b2, b1
+// and b0 to dst, in n characters.
+func cryptEncode(dst []byte, b2, b1, b0 byte, n int) []byte {
+	w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+	for ; n > 0; n-- {
+		dst = append(dst, cryptAlphabet[w&0x3f])
+		w >>= 6
+	}
+	return dst
+}
+
+// repeat returns b repeated to n bytes.
+func repeat(b []byte, n int) []byte {
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		out = append(out, b[:min(len(b), n-len(out))]...)
+	}
+	return out
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// shaCrypt returns the SHA-crypt hash of pass with salt and rounds, as used in
+// shadow password files, with SHA-256 ($5$) if id is "5" or SHA-512 ($6$) if
+// id is "6". The explicit rounds are included in the hash if set.
+func shaCrypt(id string, pass, salt []byte, rounds int, explicit bool) string {
+	newHash, order := sha512.New, sha512CryptOrder
+	if id == "5" {
+		newHash, order = sha256.New, sha256CryptOrder
+	}
+	if len(salt) > shaCryptSaltLen {
+		salt = salt[:shaCryptSaltLen]
+	}
+	sum := func(parts ...[]byte) []byte {
+		h := newHash()
+		for _, p := range parts {
+			h.Write(p)
+		}
+		return h.Sum(nil)
+	}
+
+	b := sum(pass, salt, pass)
+	var h hash.Hash = newHash()
+	h.Write(pass)
+	h.Write(salt)
+	h.Write(repeat(b, len(pass)))
+	for n := len(pass); n > 0; n >>= 1 {
+		if n&1 != 0 {
+			h.Write(b)
+		} else {
+			h.Write(pass)
+		}
+	}
+	a := h.Sum(nil)
+
+	h = newHash()
+	for i := 0; i < len(pass); i++ {
+		h.Write(pass)
+	}
+	p := repeat(h.Sum(nil), len(pass))
+	h = newHash()
+	for i := 0; i < 16+int(a[0]); i++ {
+		h.Write(salt)
+	}
+	s := repeat(h.Sum(nil), len(salt))
+
+	c := a
+	for i := 0; i < rounds; i++ {
+		h = newHash()
+		if i&1 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(c)
+		}
+		if i%3 != 0 {
+			h.Write(s)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i&1 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(p)
+		}
+		c = h.Sum(nil)
+	}
+
+	out := []byte("$" + id + "$")
+	if explicit {
+		out = append(out, "rounds="+strconv.Itoa(rounds)+"$"...)
+	}
+	out = append(out, salt...)
+	out = append(out, '$')
+	for i := 0; i < len(order); i += 3 {
+		out = cryptEncode(out, c[order[i]], c[order[i+1]], c[order[i+2]], 4)
+	}
+	if id == "5" {
+		out = cryptEncode(out, 0, c[31], c[30], 3)
+	} else {
+		out = cryptEncode(out, 0, 0, c[63], 2)
+	}
+	return string(out)
+}
+
+// parseShaCrypt splits a SHA-crypt hash into its id, salt and rounds.
+func parseShaCrypt(hash string) (id string, salt []byte, rounds int, explicit bool, err error) {
+	f := strings.Split(hash, "$")
+	if len(f) < 4 || f[0] != "" || (f[1] != "5" && f[1] != "6") {
+		return "", nil, 0, false, ErrUnsupportedHash
+	}
+	id, rounds = f[1], shaCryptRounds
+	if r := strings.TrimPrefix(f[2], "rounds="); r != f[2] {
+		if rounds, err = strconv.Atoi(r); err != nil || len(f) != 5 {
+			return "", nil, 0, false, ErrUnsupportedHash
+		}
+		if rounds < shaCryptMinRounds {
+			rounds = shaCryptMinRounds
+		} else if rounds > shaCryptMaxRounds {
+			rounds = shaCryptMaxRounds
+		}
+		return id, []byte(f[3]), rounds, true, nil
+	}
+	if len(f) != 4 {
+		return "", nil, 0, false, ErrUnsupportedHash
+	}
+	return id, []byte(f[2]), rounds, false, nil
+}
+
+// checkHash returns ErrUnsupportedHash if the password hash uses an unsupported
+// scheme. Supported schemes are SHA-crypt ($5$ and $6$), as used in shadow
+// password files, and the {SHA} scheme of htpasswd files.
+func checkHash(hash string) error {
+	if strings.HasPrefix(hash, "{SHA}") {
+		if b, err := base64.StdEncoding.DecodeString(hash[5:]); err != nil || len(b) != sha1.Size {
+			return ErrUnsupportedHash
+		}
+		return nil
+	}
+	_, _, _, _, err := parseShaCrypt(hash)
+	return err
+}
+
+// hashPassword returns the SHA-512 crypt hash of pass with a random salt.
+func hashPassword(pass string) string {
+	b := make([]byte, shaCryptSaltLen)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	for i := range b {
+		b[i] = cryptAlphabet[b[i]&0x3f]
+	}
+	return shaCrypt("6", []byte(pass), b, shaCryptRounds, false)
+}
+
+// matchHash reports whether pass matches the password hash.
+func matchHash(hash, pass string) bool {
+	if len(pass) > maxPasswordLen {
+		return false
+	}
+	var got string
+	if strings.HasPrefix(hash, "{SHA}") {
+		sum := sha1.Sum([]byte(pass))
+		got = "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+	} else {
+		id, salt, rounds, explicit, err := parseShaCrypt(hash)
+		if err != nil {
+			return false
+		}
+		got = shaCrypt(id, []byte(pass), salt, rounds, explicit)
+	}
+	return subtle.ConstantTimeCompare([]byte(got), []byte(hash)) == 1
+}
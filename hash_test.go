@@ -0,0 +1,83 @@
+package tacplus
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShaCrypt(t *testing.T) {
+	tests := []struct {
+		pass, hash string
+	}{
+		{"Hello world!", "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"},
+		{"Hello world!", "$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5"},
+		{"Hello world!", "$6$rounds=1000$abc$Z1qGqKjJ955Q3hxAKRENp11lM160CDktIAQXrnkWjszWG6/BYyr9DR5eFLvBn4Tv/XyP46lXwBA6X4flRX5/B0"},
+		{"Hello world!", "$5$rounds=1000$saltstringsaltst$cSv7nWzgUjd5.cVg4yn64CGOTkcQl1wXTNvW1OSLbZ0"},
+		{"secret", "$6$abcdefgh$ltjgWl6579NluT/Vi1nwEvcil.G5Nbc4NiXZaNGStk8PSwGfQv72N2CKPPrVACtLtip/cZ/1GM/O6IND4WQhG."},
+		{"secret", "$5$12345678$vfXTriTcIE3fmtdKcmhtmhxt0kqEh3a0Degk.vD/PuB"},
+		{"secret", "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="},
+	}
+	for _, tt := range tests {
+		if err := checkHash(tt.hash); err != nil {
+			t.Errorf("%s: %v", tt.hash, err)
+		}
+		if !matchHash(tt.hash, tt.pass) {
+			t.Errorf("%s: password %q doesn't match", tt.hash, tt.pass)
+		}
+		if matchHash(tt.hash, tt.pass+"x") {
+			t.Errorf("%s: wrong password matches", tt.hash)
+		}
+	}
+}
+
+func TestUnsupportedHash(t *testing.T) {
+	for _, hash := range []string{
+		"$apr1$abcdefgh$h9FWgUz3n9YxylKLlR5SQ/",
+		"$2y$05$c4WoMPo3SXsafkva.HHa6uXQZWr7oboPiC2bT/r7q1BB8I2s0BRqC",
+		"abJnggxhB/yWI",
+		"$6$rounds=x$abc$def",
+		"{SHA}short",
+		"",
+	} {
+		if err := checkHash(hash); err != ErrUnsupportedHash {
+			t.Errorf("%q: got %v, want %v", hash, err, ErrUnsupportedHash)
+		}
+		if matchHash(hash, "secret") {
+			t.Errorf("%q: matched", hash)
+		}
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	h1, h2 := hashPassword("secret"), hashPassword("secret")
+	if !strings.HasPrefix(h1, "$6$") || h1 == h2 {
+		t.Errorf("got hashes %q and %q, want different SHA-512 crypt hashes", h1, h2)
+	}
+	if !matchHash(h1, "secret") || matchHash(h1, "other") {
+		t.Errorf("%s doesn't match its password", h1)
+	}
+}
+
+func TestLongPassword(t *testing.T) {
+	long := strings.Repeat("x", maxPasswordLen+1)
+	start := time.Now()
+	if matchHash(hashPassword(long[1:]), long) {
+		t.Error("long password matched")
+	}
+	u := new(UserStore)
+	u.Set("alice", long[1:], time.Now())
+	if ok, _ := u.Authenticate(context.Background(), "alice", long[1:]); !ok {
+		t.Errorf("%d byte password rejected", maxPasswordLen)
+	}
+	if ok, _ := u.Authenticate(context.Background(), "alice", strings.Repeat("x", 16384)); ok {
+		t.Error("16 KB password accepted")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("hashing took %v", d)
+	}
+	if err := u.ChangePassword(context.Background(), "alice", long); err != ErrPasswordLong {
+		t.Errorf("got error %v changing to long password, want %v", err, ErrPasswordLong)
+	}
+}
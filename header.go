@@ -0,0 +1,89 @@
+package tacplus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// HeaderLen is the length of a TACACS+ packet header.
+const HeaderLen = hdrLen
+
+// MaxBodyLen is the maximum length of a TACACS+ packet body accepted.
+const MaxBodyLen = maxBodyLen
+
+// Header Type field values
+const (
+	TypeAuthen = sessTypeAuthen // authentication
+	TypeAuthor = sessTypeAuthor // authorization
+	TypeAcct   = sessTypeAcct   // accounting
+)
+
+// sessTypeName returns a short name for session type t.
+func sessTypeName(t uint8) string {
+	switch t {
+	case sessTypeAuthen:
+		return "authen"
+	case sessTypeAuthor:
+		return "author"
+	case sessTypeAcct:
+		return "acct"
+	default:
+		return strconv.Itoa(int(t))
+	}
+}
+
+// Header Flags field values
+const (
+	FlagUnencrypted   = 0x01                 // packet body is not obfuscated
+	FlagSingleConnect = hdrFlagSingleConnect // multiplex sessions over a single connection
+)
+
+var errShortHeader = errors.New("packet header too short")
+
+// Header is a TACACS+ packet header.
+type Header struct {
+	Version   uint8  `json:"version"`    // major and minor version
+	Type      uint8  `json:"type"`       // packet type
+	SeqNo     uint8  `json:"seq_no"`     // sequence number
+	Flags     uint8  `json:"flags"`      // header flags
+	SessionID uint32 `json:"session_id"` // session id
+	BodyLen   uint32 `json:"body_len"`   // length of packet body
+}
+
+// ParseHeader decodes the packet header at the start of b.
+func ParseHeader(b []byte) (Header, error) {
+	if len(b) < hdrLen {
+		return Header{}, errShortHeader
+	}
+	return Header{
+		Version:   b[hdrVer],
+		Type:      b[hdrType],
+		SeqNo:     b[hdrSeqNo],
+		Flags:     b[hdrFlags],
+		SessionID: binary.BigEndian.Uint32(b[hdrID:]),
+		BodyLen:   binary.BigEndian.Uint32(b[hdrBodyLen:]),
+	}, nil
+}
+
+// Validate checks the header has a supported major version and that the
+// body length is no larger than MaxBodyLen.
+func (h Header) Validate() error {
+	if h.Version>>4 != verMajor {
+		return fmt.Errorf("%w %d", errBadVersion, h.Version>>4)
+	}
+	if h.BodyLen > maxBodyLen {
+		return errPacketTooLarge
+	}
+	return nil
+}
+
+// AppendTo appends the encoded header to b.
+func (h Header) AppendTo(b []byte) []byte {
+	b = append(b, h.Version, h.Type, h.SeqNo, h.Flags, 0, 0, 0, 0, 0, 0, 0, 0)
+	p := b[len(b)-8:]
+	binary.BigEndian.PutUint32(p, h.SessionID)
+	binary.BigEndian.PutUint32(p[4:], h.BodyLen)
+	return b
+}
@@ -0,0 +1,50 @@
+package tacplus
+
+import (
+	"testing"
+)
+
+func TestHeader(t *testing.T) {
+	h := Header{
+		Version:   verDefaultMinorOne,
+		Type:      TypeAuthor,
+		SeqNo:     3,
+		Flags:     FlagSingleConnect,
+		SessionID: 0x01020304,
+		BodyLen:   100,
+	}
+	b := h.AppendTo([]byte{0xff})
+	if len(b) != 1+HeaderLen {
+		t.Fatalf("want length %d: got %d", 1+HeaderLen, len(b))
+	}
+	h2, err := ParseHeader(b[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h2 != h {
+		t.Errorf("%+v != %+v", h2, h)
+	}
+	if err = h.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	if _, err = ParseHeader(b[2:]); err != errShortHeader {
+		t.Errorf("want %v: got %v", errShortHeader, err)
+	}
+	h.BodyLen = MaxBodyLen + 1
+	if err = h.Validate(); err != errPacketTooLarge {
+		t.Errorf("want %v: got %v", errPacketTooLarge, err)
+	}
+	h.Version = 0x10
+	if err = h.Validate(); err == nil {
+		t.Error("bad version accepted")
+	}
+}
+
+func TestSessTypeName(t *testing.T) {
+	for typ, want := range map[uint8]string{TypeAuthen: "authen", TypeAuthor: "author", TypeAcct: "acct", 0x80: "128"} {
+		if got := sessTypeName(typ); got != want {
+			t.Errorf("sessTypeName(%d) = %q, want %q", typ, got, want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package tacplus
+
+import (
+	"math/bits"
+	"time"
+)
+
+const (
+	histSubBits    = 4                // sub-buckets per power of two, as a power of two
+	histSub        = 1 << histSubBits // number of sub-buckets per power of two
+	histMaxShift   = 28               // largest recorded value is about 71 minutes
+	histBucketsLen = (histMaxShift + 2) * histSub
+)
+
+// LatencyHistogram counts latencies with a fixed relative precision, in the
+// manner of an HDR histogram. Values are recorded in microseconds, with buckets
+// no wider than 1/16th of their value, up to about 71 minutes.
+type LatencyHistogram struct {
+	counts [histBucketsLen]uint64
+	n      uint64
+	sum    time.Duration
+	max    time.Duration
+}
+
+// histIndex returns the bucket index for v microseconds.
+func histIndex(v uint64) int {
+	if v < histSub {
+		return int(v)
+	}
+	shift := bits.Len64(v) - histSubBits - 1
+	if shift > histMaxShift {
+		return histBucketsLen - 1
+	}
+	return shift*histSub + int(v>>uint(shift))
+}
+
+// histValue returns the midpoint in microseconds of the values in bucket i.
+func histValue(i int) uint64 {
+	if i < 2*histSub {
+		return uint64(i)
+	}
+	shift := uint(i/histSub - 1)
+	m := uint64(i - int(shift)*histSub)
+	return m<<shift + (1<<shift)/2
+}
+
+func (h *LatencyHistogram) record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	h.counts[histIndex(uint64(d/time.Microsecond))]++
+	h.n++
+	h.sum += d
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// Count returns the number of latencies recorded.
+func (h *LatencyHistogram) Count() uint64 { return h.n }
+
+// Max returns the largest latency recorded.
+func (h *LatencyHistogram) Max() time.Duration { return h.max }
+
+// Mean returns the mean latency, or zero if none have been recorded.
+func (h *LatencyHistogram) Mean() time.Duration {
+	if h.n == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.n)
+}
+
+// Quantile returns the latency at quantile q, between 0 and 1. For example
+// Quantile(0.99) is the 99th percentile latency.
+func (h *LatencyHistogram) Quantile(q float64) time.Duration {
+	if h.n == 0 {
+		return 0
+	}
+	if q >= 1 {
+		return h.max
+	}
+	rank := uint64(q*float64(h.n)) + 1
+	var seen uint64
+	for i, c := range h.counts {
+		if seen += c; seen >= rank {
+			if d := time.Duration(histValue(i)) * time.Microsecond; d < h.max {
+				return d
+			}
+			return h.max
+		}
+	}
+	return h.max
+}
+
+// ClientStats holds round trip latency histograms for a Client's requests by type.
+// Only requests receiving a reply are counted.
+type ClientStats struct {
+	Authen LatencyHistogram
+	Author LatencyHistogram
+	Acct   LatencyHistogram
+}
+
+// Stats returns a copy of the Client's latency histograms.
+func (c *Client) Stats() *ClientStats {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+	st := c.stats
+	return &st
+}
+
+// recordLatency records the round trip latency d of a request of session type t.
+func (c *Client) recordLatency(t uint8, d time.Duration) {
+	c.statsMu.Lock()
+	switch t {
+	case sessTypeAuthen:
+		c.stats.Authen.record(d)
+	case sessTypeAuthor:
+		c.stats.Author.record(d)
+	case sessTypeAcct:
+		c.stats.Acct.record(d)
+	}
+	c.statsMu.Unlock()
+}
@@ -0,0 +1,74 @@
+package tacplus
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHistIndex(t *testing.T) {
+	prev := -1
+	for v := uint64(0); v < 1<<20; v += 7 {
+		i := histIndex(v)
+		if i < prev {
+			t.Fatalf("index %d for %d less than previous %d", i, v, prev)
+		}
+		prev = i
+		// midpoint is within 1/16th of the value
+		if mid := histValue(i); float64(mid) < float64(v)*15/16 || float64(mid) > float64(v)*17/16+1 {
+			t.Fatalf("value %d in bucket %d with midpoint %d", v, i, mid)
+		}
+	}
+	if i := histIndex(1 << 62); i != histBucketsLen-1 {
+		t.Errorf("large value index %d, want %d", i, histBucketsLen-1)
+	}
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	var h LatencyHistogram
+	if h.Quantile(0.5) != 0 || h.Mean() != 0 {
+		t.Error("empty histogram has non-zero values")
+	}
+	for i := 1; i <= 100; i++ {
+		h.record(time.Duration(i) * time.Millisecond)
+	}
+	if h.Count() != 100 || h.Max() != 100*time.Millisecond {
+		t.Errorf("count %d max %v", h.Count(), h.Max())
+	}
+	if m := h.Mean(); m != 50500*time.Microsecond {
+		t.Errorf("mean %v", m)
+	}
+	for q, want := range map[float64]time.Duration{0.5: 51 * time.Millisecond, 0.99: 100 * time.Millisecond, 1: 100 * time.Millisecond} {
+		got := h.Quantile(q)
+		if got < want*15/16 || got > want*17/16 {
+			t.Errorf("quantile %v = %v, want about %v", q, got, want)
+		}
+	}
+}
+
+func TestClientStats(t *testing.T) {
+	skipWithoutMD5(t)
+	l, c, err := newTestInstance(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.close()
+	defer c.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if _, err = c.SendAcctRequest(ctx, testAcctReq); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = c.SendAuthorRequest(ctx, testAuthorReq); err != nil {
+		t.Fatal(err)
+	}
+	st := c.Stats()
+	if st.Acct.Count() != 3 || st.Author.Count() != 1 || st.Authen.Count() != 0 {
+		t.Errorf("got counts %d %d %d", st.Authen.Count(), st.Author.Count(), st.Acct.Count())
+	}
+	if st.Acct.Quantile(0.5) <= 0 {
+		t.Error("zero median latency")
+	}
+}
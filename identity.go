@@ -0,0 +1,98 @@
+package tacplus
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+)
+
+var errObfuscated = errors.New("obfuscated packet on secretless connection")
+
+// PeerIdentity is the identity of a TLS client proven by a verified certificate.
+type PeerIdentity struct {
+	Subject     string            // certificate subject distinguished name
+	DNSNames    []string          // DNS subject alternative names
+	URIs        []string          // URI subject alternative names
+	IPAddresses []net.IP          // IP address subject alternative names
+	Certificate *x509.Certificate // verified client certificate
+	Device      *DeviceConfig     // configuration from ServerConnHandler.Identify, or nil
+}
+
+// DeviceConfig is configuration for a device, chosen from its PeerIdentity or
+// its address in an Inventory.
+type DeviceConfig struct {
+	Name   string // device name
+	Tenant string // policy tenant the device belongs to
+	Vendor string // device vendor, such as "cisco"
+	Site   string // location of the device
+	Group  string // device group, such as "core-routers"
+	Locale string // locale of messages sent to the device's users, such as "de"
+
+	// Don't obfuscate packet bodies on the connection, relying on TLS for privacy.
+	// The device must set the unencrypted header flag. Only honored for devices
+	// on TLS connections with a verified client certificate.
+	Secretless bool
+}
+
+// newPeerIdentity returns the identity from the verified client certificate
+// of a TLS connection, or nil if there is none.
+func newPeerIdentity(cs tls.ConnectionState) *PeerIdentity {
+	if len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	cert := cs.VerifiedChains[0][0]
+	id := &PeerIdentity{
+		Subject:     cert.Subject.String(),
+		DNSNames:    cert.DNSNames,
+		IPAddresses: cert.IPAddresses,
+		Certificate: cert,
+	}
+	for _, u := range cert.URIs {
+		id.URIs = append(id.URIs, u.String())
+	}
+	return id
+}
+
+type peerIdentityKey struct{}
+
+// PeerIdentityFromContext returns the TLS client identity for a server session
+// context, or nil if the client didn't present a verified certificate.
+func PeerIdentityFromContext(ctx context.Context) *PeerIdentity {
+	id, _ := ctx.Value(peerIdentityKey{}).(*PeerIdentity)
+	return id
+}
+
+// PeerIdentity returns the TLS client identity for the session, or nil if the
+// client didn't present a verified certificate.
+func (s *ServerSession) PeerIdentity() *PeerIdentity {
+	return PeerIdentityFromContext(s.c.ctx)
+}
+
+// identify completes the TLS handshake on tc and returns ctx carrying the client
+// identity, mapped to a DeviceConfig if Identify is set. The handshake must
+// complete within ReadTimeout if it is set.
+func (h *ServerConnHandler) identify(ctx context.Context, tc *tls.Conn) (context.Context, *PeerIdentity, error) {
+	hctx := ctx
+	if h.ConnConfig.ReadTimeout > 0 {
+		var cancel context.CancelFunc
+		hctx, cancel = context.WithTimeout(ctx, h.ConnConfig.ReadTimeout)
+		defer cancel()
+	}
+	if err := tc.HandshakeContext(hctx); err != nil {
+		return ctx, nil, err
+	}
+	id := newPeerIdentity(tc.ConnectionState())
+	if id == nil {
+		return ctx, nil, nil
+	}
+	if h.Identify != nil {
+		dev, err := h.Identify(id)
+		if err != nil {
+			return ctx, nil, err
+		}
+		id.Device = dev
+	}
+	return context.WithValue(ctx, peerIdentityKey{}, id), id, nil
+}
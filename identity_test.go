@@ -0,0 +1,113 @@
+package tacplus
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testCert returns a self-signed certificate for name usable by clients and servers.
+func testCert(t *testing.T, name string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name, Organization: []string{"tacplus"}},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}
+}
+
+// identityHandler records the peer identity of authorization requests.
+type identityHandler struct {
+	RequestHandler
+	ids chan *PeerIdentity
+}
+
+func (h *identityHandler) HandleAuthorRequest(ctx context.Context, a *AuthorRequest, s *ServerSession) *AuthorResponse {
+	h.ids <- PeerIdentityFromContext(ctx)
+	return h.RequestHandler.HandleAuthorRequest(ctx, a, s)
+}
+
+func TestPeerIdentity(t *testing.T) {
+	serverCert := testCert(t, "server.example")
+	nasCert := testCert(t, "nas1.example")
+	roots := x509.NewCertPool()
+	roots.AddCert(nasCert.Leaf)
+
+	ih := &identityHandler{RequestHandler: testHandler.Handler, ids: make(chan *PeerIdentity, 1)}
+	h := &ServerConnHandler{Handler: ih, ConnConfig: testHandler.ConnConfig}
+	h.ConnConfig.Log = func(...interface{}) {}
+	h.Identify = func(id *PeerIdentity) (*DeviceConfig, error) {
+		if len(id.DNSNames) == 0 || id.DNSNames[0] != "nas1.example" {
+			return nil, errors.New("unknown device")
+		}
+		return &DeviceConfig{Name: "nas1", Tenant: "blue", Secretless: true}, nil
+	}
+
+	l, err := tls.Listen("tcp", "localhost:0", &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    roots,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{ServeConnContext: h.ServeContext, Log: h.ConnConfig.Log}
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Close()
+
+	c := &Client{
+		Addr:       l.Addr().String(),
+		ConnConfig: ConnConfig{Secretless: true},
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			d := tls.Dialer{Config: &tls.Config{Certificates: []tls.Certificate{nasCert}, InsecureSkipVerify: true}}
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+	resp, err := c.SendAuthorRequest(context.Background(), testAuthorReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != AuthorStatusPassAdd {
+		t.Errorf("got status %d, want %d", resp.Status, AuthorStatusPassAdd)
+	}
+	id := <-ih.ids
+	if id == nil || id.Device == nil {
+		t.Fatalf("got identity %+v", id)
+	}
+	if id.Subject != "CN=nas1.example,O=tacplus" || id.Device.Tenant != "blue" {
+		t.Errorf("got subject %q tenant %q", id.Subject, id.Device.Tenant)
+	}
+
+	// an obfuscating client is rejected by a secretless connection
+	c.ConnConfig = testHandler.ConnConfig
+	if _, err = c.SendAuthorRequest(context.Background(), testAuthorReq); err == nil {
+		t.Error("obfuscated request to secretless connection succeeded")
+	}
+}
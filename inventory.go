@@ -0,0 +1,138 @@
+package tacplus
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Inventory maps client device addresses to their DeviceConfig, allowing
+// policies to be written in terms of device names and groups instead of
+// addresses. An Inventory is safe for concurrent use, so devices can be added
+// and removed while a server is running.
+type Inventory struct {
+	mu      sync.RWMutex
+	devices []inventoryEntry
+}
+
+type inventoryEntry struct {
+	net *net.IPNet
+	dev *DeviceConfig
+}
+
+// parseNetwork parses an IP address or CIDR range, returning single addresses
+// as a network with a full length mask.
+func parseNetwork(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, n, err := net.ParseCIDR(s)
+		return n, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %q", s)
+	}
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// Add adds the device dev at network, an IP address or CIDR range, replacing
+// any device already added for the same network.
+func (inv *Inventory) Add(network string, dev *DeviceConfig) error {
+	n, err := parseNetwork(network)
+	if err != nil {
+		return err
+	}
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	for i, e := range inv.devices {
+		if e.net.String() == n.String() {
+			inv.devices[i].dev = dev
+			return nil
+		}
+	}
+	inv.devices = append(inv.devices, inventoryEntry{net: n, dev: dev})
+	return nil
+}
+
+// Remove removes the device added at network, returning whether there was one.
+func (inv *Inventory) Remove(network string) bool {
+	n, err := parseNetwork(network)
+	if err != nil {
+		return false
+	}
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	for i, e := range inv.devices {
+		if e.net.String() == n.String() {
+			inv.devices = append(inv.devices[:i], inv.devices[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Lookup returns the device with the most specific network containing ip,
+// or nil if there is none.
+func (inv *Inventory) Lookup(ip net.IP) *DeviceConfig {
+	if ip == nil {
+		return nil
+	}
+	inv.mu.RLock()
+	defer inv.mu.RUnlock()
+	var dev *DeviceConfig
+	best := -1
+	for _, e := range inv.devices {
+		if ones, _ := e.net.Mask.Size(); ones > best && e.net.Contains(ip) {
+			dev, best = e.dev, ones
+		}
+	}
+	return dev
+}
+
+// addrIP returns the IP address of addr, or nil if it doesn't have one.
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case nil:
+		return nil
+	}
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return net.ParseIP(host)
+	}
+	return nil
+}
+
+type deviceKey struct{}
+
+// DeviceFromContext returns the configuration of the client device for a
+// server session context, from the TLS client identity or the ServerConnHandler
+// Inventory, or nil if the device isn't known.
+func DeviceFromContext(ctx context.Context) *DeviceConfig {
+	if id := PeerIdentityFromContext(ctx); id != nil && id.Device != nil {
+		return id.Device
+	}
+	dev, _ := ctx.Value(deviceKey{}).(*DeviceConfig)
+	return dev
+}
+
+// Device returns the configuration of the client device for the session,
+// or nil if the device isn't known.
+func (s *ServerSession) Device() *DeviceConfig {
+	return DeviceFromContext(s.c.ctx)
+}
+
+// DeviceGroups returns a Condition met for requests from devices in any of
+// the named device groups.
+func DeviceGroups(groups ...string) Condition {
+	set := make(map[string]bool, len(groups))
+	for _, g := range groups {
+		set[g] = true
+	}
+	return func(ri *RequestInfo) bool { return ri.Device != nil && set[ri.Device.Group] }
+}
@@ -0,0 +1,123 @@
+package tacplus
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestInventoryLookup(t *testing.T) {
+	var inv Inventory
+	lab := &DeviceConfig{Name: "lab", Group: "lab"}
+	core := &DeviceConfig{Name: "core1", Vendor: "cisco", Site: "dc1", Group: "core"}
+	for _, d := range []struct {
+		network string
+		dev     *DeviceConfig
+	}{
+		{"10.0.0.0/8", lab},
+		{"10.1.2.3", core},
+		{"2001:db8::/32", lab},
+	} {
+		if err := inv.Add(d.network, d.dev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := inv.Add("10.0.0.0/33", lab); err == nil {
+		t.Error("expected error for invalid network")
+	}
+	for _, test := range []struct {
+		ip   string
+		want *DeviceConfig
+	}{
+		{"10.1.2.3", core},
+		{"10.1.2.4", lab},
+		{"2001:db8::1", lab},
+		{"192.0.2.1", nil},
+		{"", nil},
+	} {
+		if got := inv.Lookup(net.ParseIP(test.ip)); got != test.want {
+			t.Errorf("%s: got %+v, want %+v", test.ip, got, test.want)
+		}
+	}
+	if !inv.Remove("10.1.2.3") || inv.Remove("10.1.2.3") {
+		t.Error("unexpected Remove result")
+	}
+	if got := inv.Lookup(net.ParseIP("10.1.2.3")); got != lab {
+		t.Errorf("after Remove got %+v", got)
+	}
+}
+
+func TestInventoryCondition(t *testing.T) {
+	skipWithoutMD5(t)
+	// only user may log into core devices
+	allow, err := ParseCondition("!group=core", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allow = Any(allow, Users("user"))
+
+	var inv Inventory
+	for _, test := range []struct {
+		group string
+		user  string
+		want  uint8
+	}{
+		{"core", "user", AuthorStatusPassAdd},
+		{"core", "fred", AuthorStatusFail},
+		{"edge", "fred", AuthorStatusPassAdd},
+	} {
+		if err := inv.Add("127.0.0.0/8", &DeviceConfig{Name: "nas", Group: test.group}); err != nil {
+			t.Fatal(err)
+		}
+		h := testHandler
+		h.Inventory = &inv
+		h.Handler = &ConditionHandler{Passthrough: Passthrough{testHandler.Handler}, Allow: allow}
+		s, c, err := newTestInstance(&h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := *testAuthorReq
+		req.User = test.user
+		resp, err := c.SendAuthorRequest(context.Background(), &req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != test.want {
+			t.Errorf("%s on %s: got status %d, want %d", test.user, test.group, resp.Status, test.want)
+		}
+		s.close()
+	}
+
+	ri := &RequestInfo{Time: time.Now()}
+	if DeviceGroups("core")(ri) {
+		t.Error("unknown device matched a device group")
+	}
+}
+
+func TestInventorySecretlessPlainTCP(t *testing.T) {
+	skipWithoutMD5(t)
+	var inv Inventory
+	if err := inv.Add("127.0.0.0/8", &DeviceConfig{Name: "nas", Secretless: true}); err != nil {
+		t.Fatal(err)
+	}
+	h := testHandler
+	h.Inventory = &inv
+	h.ConnConfig.Log = func(...interface{}) {}
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	defer c.Close()
+
+	ctx := context.Background()
+	if _, err = c.SendAuthorRequest(ctx, testAuthorReq); err != nil {
+		t.Errorf("obfuscated request failed: %v", err)
+	}
+	c.Close()
+	c.ConnConfig.Secretless = true
+	if _, err = c.SendAuthorRequest(ctx, testAuthorReq); err == nil {
+		t.Error("unobfuscated request over plain TCP succeeded")
+	}
+}
@@ -0,0 +1,200 @@
+package tacplus
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"time"
+)
+
+// An Authenticator checks user passwords for a LoginHandler.
+//
+// Authenticate returns whether pass is the password for user. An error means the
+// check couldn't be made, such as when a directory server is unreachable, and is
+// distinct from a denial.
+type Authenticator interface {
+	Authenticate(ctx context.Context, user, pass string) (bool, error)
+}
+
+// AuthenticatorFunc is an adapter allowing a function to be used as an Authenticator.
+type AuthenticatorFunc func(ctx context.Context, user, pass string) (bool, error)
+
+// Authenticate calls f(ctx, user, pass).
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, user, pass string) (bool, error) {
+	return f(ctx, user, pass)
+}
+
+// LocalUsers is an Authenticator mapping user names to passwords, suitable for
+// a small database of emergency accounts. It never returns an error.
+//
+// Deprecated: Use UserStore, which holds password hashes instead of passwords,
+// can be saved to a file, and supports password ageing and account
+// restrictions. The UserStore method converts a LocalUsers map to one.
+type LocalUsers map[string]string
+
+// Authenticate returns whether pass is the password of user.
+func (u LocalUsers) Authenticate(ctx context.Context, user, pass string) (bool, error) {
+	want, ok := u[user]
+	return ok && subtle.ConstantTimeCompare([]byte(want), []byte(pass)) == 1, nil
+}
+
+// UserStore returns a UserStore with the users of u, their passwords set now.
+func (u LocalUsers) UserStore() *UserStore {
+	s := new(UserStore)
+	now := time.Now()
+	for user, pass := range u {
+		s.Set(user, pass, now)
+	}
+	return s
+}
+
+var errNoPrimary = errors.New("LoginHandler has no Primary authenticator")
+
+// LoginHandler is a RequestHandler serving ASCII and PAP login authentication
+// with Primary, falling back to Fallback when Primary returns an error. A denial
+// from Primary is final, so local accounts are only usable while the primary
+// backend is unavailable.
+//
+// ASCII logins prompt for the user name, if the start packet has none, and the
+// password. Other authentication requests, and all authorization and accounting
+// requests, are passed to Handler.
+//
+// If the Authenticator checking a password is a PasswordChanger and the password
+// has expired, ASCII logins prompt for a new password twice before passing,
+// checking it with CheckPassword. PAP logins with an expired password fail, as
+// they can't prompt.
+//
+// If the Authenticator is an AccountChecker, users whose account it refuses,
+// such as a disabled account, fail to log in even with the right password, and
+// their authorization requests fail without being passed to Handler. The
+// replies have the account error as their DenyReason.
+type LoginHandler struct {
+	Primary     Authenticator // required, logins fail with an error if nil
+	Fallback    Authenticator // optional, errors from Primary are returned if nil
+	Passthrough               // optional handler for other requests
+
+	// Prompts sent to the client. "Username: " and "Password: " are used if empty.
+	UserPrompt string
+	PassPrompt string
+
+	// Optional function called when Primary fails and Fallback is used. If not set
+	// the error is logged with the session's Log function.
+	OnFallback func(user string, err error)
+
+	// Optional function checking a new password for user meets complexity rules,
+	// returning an error shown to the user if not. CheckPasswordComplexity is
+	// used if nil.
+	CheckPassword func(user, pass string) error
+
+	// Optional function called when a user changes an expired password, with the
+	// error if the change couldn't be stored. If not set the change is logged with
+	// the session's Log function.
+	OnPasswordChange func(user string, err error)
+}
+
+// login prompts for any credentials the client hasn't supplied, returning
+// ok false if the session was closed or aborted.
+func (h *LoginHandler) login(ctx context.Context, a *AuthenStart, s *ServerSession) (user, pass string, ok bool) {
+	user = a.User
+	if a.AuthenType == AuthenTypePAP {
+		return user, string(a.Data), true
+	}
+	for user == "" {
+		prompt := h.UserPrompt
+		if prompt == "" {
+			prompt = "Username: "
+		}
+		c, err := s.GetUser(ctx, prompt)
+		if err != nil {
+			return "", "", false
+		}
+		user = c.Message
+	}
+	prompt := h.PassPrompt
+	if prompt == "" {
+		prompt = "Password: "
+	}
+	c, err := s.GetPass(ctx, prompt)
+	if err != nil {
+		return "", "", false
+	}
+	return user, c.Message, true
+}
+
+// HandleAuthenStart authenticates ASCII and PAP logins, passing other
+// authentication requests to Handler.
+func (h *LoginHandler) HandleAuthenStart(ctx context.Context, a *AuthenStart, s *ServerSession) *AuthenReply {
+	if a.Action != AuthenActionLogin || (a.AuthenType != AuthenTypeASCII && a.AuthenType != AuthenTypePAP) {
+		return h.Passthrough.HandleAuthenStart(ctx, a, s)
+	}
+	if h.Primary == nil {
+		return errorReply(s, errNoPrimary).AuthenReply()
+	}
+	user, pass, ok := h.login(ctx, a, s)
+	if !ok {
+		return nil
+	}
+	auth, name := h.Primary, "primary"
+	valid, err := auth.Authenticate(ctx, user, pass)
+	if err != nil && h.Fallback != nil {
+		if h.OnFallback != nil {
+			h.OnFallback(user, err)
+		} else {
+			s.Log("login for ", user, " using fallback: ", err)
+		}
+		auth, name = h.Fallback, "fallback"
+		valid, err = auth.Authenticate(ctx, user, pass)
+	}
+	if err != nil {
+		return errorReply(s, err).AuthenReply()
+	}
+	if !valid {
+		traceRule(ctx, "login "+name+": password rejected")
+		return &AuthenReply{Status: AuthenStatusFail}
+	}
+	if err = accountDenied(ctx, auth, user, newRequestInfo(s, user, a.Port, a.RemAddr)); err != nil {
+		if isAccountError(err) {
+			traceRule(ctx, "login "+name+": "+err.Error())
+			return &AuthenReply{Status: AuthenStatusFail, DenyReason: err.Error()}
+		}
+		return errorReply(s, err).AuthenReply()
+	}
+	if pc, ok := auth.(PasswordChanger); ok {
+		expired, err := pc.PasswordExpired(ctx, user)
+		if err != nil {
+			return errorReply(s, err).AuthenReply()
+		}
+		if expired {
+			traceRule(ctx, "login "+name+": password expired")
+			if a.AuthenType == AuthenTypePAP {
+				return &AuthenReply{Status: AuthenStatusFail, ServerMsg: "Password expired."}
+			}
+			return h.changePassword(ctx, s, pc, user, pass)
+		}
+	}
+	traceRule(ctx, "login "+name+": password accepted")
+	return &AuthenReply{Status: AuthenStatusPass}
+}
+
+// HandleAuthorRequest calls the HandleAuthorRequest method of Handler, unless
+// the user's account is refused by Primary, or by Fallback when Primary
+// returns an error.
+func (h *LoginHandler) HandleAuthorRequest(ctx context.Context, a *AuthorRequest, s *ServerSession) *AuthorResponse {
+	ri := newRequestInfo(s, a.User, a.Port, a.RemAddr)
+	err := accountDenied(ctx, h.Primary, a.User, ri)
+	if err != nil && !isAccountError(err) && h.Fallback != nil {
+		if h.OnFallback != nil {
+			h.OnFallback(a.User, err)
+		} else {
+			s.Log("authorization for ", a.User, " using fallback: ", err)
+		}
+		err = accountDenied(ctx, h.Fallback, a.User, ri)
+	}
+	if err != nil {
+		if isAccountError(err) {
+			return &AuthorResponse{Status: AuthorStatusFail, DenyReason: err.Error()}
+		}
+		return errorReply(s, err).AuthorResponse()
+	}
+	return h.Passthrough.HandleAuthorRequest(ctx, a, s)
+}
@@ -0,0 +1,116 @@
+//go:build !tacplus_nomd5
+
+package tacplus
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestLoginHandler(t *testing.T) {
+	primary := func(ctx context.Context, user, pass string) (bool, error) {
+		if user == "down" {
+			return false, errors.New("ldap: connection refused")
+		}
+		return user == "user" && pass == "good", nil
+	}
+	fallbacks := make(chan string, 10)
+	lh := &LoginHandler{
+		Primary:     AuthenticatorFunc(primary),
+		Fallback:    LocalUsers{"down": "local", "user": "local"}.UserStore(),
+		Passthrough: Passthrough{testHandler.Handler},
+		OnFallback:  func(user string, err error) { fallbacks <- user },
+	}
+	h := testHandler
+	h.Handler = lh
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	ctx := context.Background()
+	for _, test := range []struct {
+		user, pass string
+		pap        bool
+		status     uint8
+		fallback   bool
+	}{
+		{"user", "good", false, AuthenStatusPass, false},
+		{"user", "local", false, AuthenStatusFail, false}, // denied by primary
+		{"down", "local", false, AuthenStatusPass, true},
+		{"down", "bad", false, AuthenStatusFail, true},
+		{"down", "local", true, AuthenStatusPass, true},
+		{"user", "good", true, AuthenStatusPass, false},
+	} {
+		start := *testAuthStart
+		if test.pap {
+			start.AuthenType = AuthenTypePAP
+			start.User = test.user
+			start.Data = []byte(test.pass)
+		}
+		rep, err := c.SendAuthenStartAuto(ctx, &start, test.user, test.pass)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rep.Status != test.status {
+			t.Errorf("%s/%s pap=%v: got status %d, want %d", test.user, test.pass, test.pap, rep.Status, test.status)
+		}
+		select {
+		case user := <-fallbacks:
+			if !test.fallback || user != test.user {
+				t.Errorf("%s/%s: unexpected fallback for %s", test.user, test.pass, user)
+			}
+		default:
+			if test.fallback {
+				t.Errorf("%s/%s: fallback not used", test.user, test.pass)
+			}
+		}
+	}
+
+	// without a fallback backend errors are sent to the client
+	h.Handler = &LoginHandler{Primary: AuthenticatorFunc(primary), Passthrough: Passthrough{testHandler.Handler}}
+	ns, nc, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ns.close()
+	rep, err := nc.SendAuthenStartAuto(ctx, testAuthStart, "down", "local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Status != AuthenStatusError || rep.ServerMsg != errInternal {
+		t.Errorf("got status %d %q, want %d %q", rep.Status, rep.ServerMsg, AuthenStatusError, errInternal)
+	}
+}
+
+func TestLoginHandlerUnset(t *testing.T) {
+	h := testHandler
+	h.Handler = &LoginHandler{}
+	h.ConnConfig.Log = func(...interface{}) {}
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	defer c.Close()
+
+	ctx := context.Background()
+	rep, err := c.SendAuthenStartAuto(ctx, testAuthStart, "user", "password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Status != AuthenStatusError {
+		t.Errorf("got status %d without Primary, want %d", rep.Status, AuthenStatusError)
+	}
+	// requests without a Handler close their session without a reply
+	ctx, cancel := context.WithTimeout(ctx, 5*timeScale)
+	defer cancel()
+	if _, err = c.SendAuthorRequest(ctx, testAuthorReq); err == nil {
+		t.Error("authorization without Handler got a reply")
+	}
+	if _, err = c.SendAcctRequest(ctx, testAcctReq); err == nil {
+		t.Error("accounting without Handler got a reply")
+	}
+}
@@ -0,0 +1,192 @@
+package tacplus
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// Message names of server replies, by session type and status.
+var (
+	authenMessages = map[uint8]string{
+		AuthenStatusPass:    "authen-pass",
+		AuthenStatusFail:    "authen-fail",
+		AuthenStatusGetData: "authen-getdata",
+		AuthenStatusGetUser: "authen-getuser",
+		AuthenStatusGetPass: "authen-getpass",
+		AuthenStatusRestart: "authen-restart",
+		AuthenStatusError:   "authen-error",
+		AuthenStatusFollow:  "authen-follow",
+	}
+	authorMessages = map[uint8]string{
+		AuthorStatusPassAdd:  "author-pass-add",
+		AuthorStatusPassRepl: "author-pass-repl",
+		AuthorStatusFail:     "author-fail",
+		AuthorStatusError:    "author-error",
+		AuthorStatusFollow:   "author-follow",
+	}
+	acctMessages = map[uint8]string{
+		AcctStatusSuccess: "acct-success",
+		AcctStatusError:   "acct-error",
+		AcctStatusFollow:  "acct-follow",
+	}
+)
+
+type messageKey struct {
+	name, group, locale string
+}
+
+// MessageData is the data a message template is rendered with. Fields of the
+// RequestInfo, such as {{.User}} or {{.Device.Name}}, describe the request, and
+// Msg is the message set by the request handler.
+type MessageData struct {
+	*RequestInfo
+	Msg string
+}
+
+// MessageTemplates replaces the ServerMsg of server replies with messages
+// rendered from text/template templates, so login banners, prompts and failure
+// messages can be changed by configuration instead of in request handlers.
+//
+// Templates are named by session type and reply status: authen-pass,
+// authen-fail, authen-getdata, authen-getuser, authen-getpass, authen-restart,
+// authen-error, authen-follow, author-pass-add, author-pass-repl, author-fail,
+// author-error, author-follow, acct-success, acct-error and acct-follow.
+// Prompts sent with GetData, GetUser and GetPass use the template of their
+// status. Replies with no template keep the handler's message. The ConnConfig
+// Banner is rendered with the authen-banner template.
+//
+// Each name can have templates for a device group and a locale, taken from the
+// DeviceConfig of the client device. The most specific template is used, with
+// a group matching before a locale, and an empty group or locale matching any.
+//
+// A MessageTemplates is safe for concurrent use, so templates can be changed
+// while a server is running.
+type MessageTemplates struct {
+	mu sync.RWMutex
+	t  map[messageKey]*template.Template
+}
+
+// Add parses text as the template for the message name, for devices in group
+// with locale, replacing any template already added for them. An empty group
+// or locale applies to all devices without a more specific template.
+func (m *MessageTemplates) Add(name, group, locale, text string) error {
+	t, err := template.New(name).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.t == nil {
+		m.t = make(map[messageKey]*template.Template)
+	}
+	m.t[messageKey{name, group, locale}] = t
+	return nil
+}
+
+// MessageConfig is the configuration of a message template, as read by
+// ParseMessageTemplates.
+type MessageConfig struct {
+	Name   string `json:"name"`
+	Group  string `json:"group,omitempty"`
+	Locale string `json:"locale,omitempty"`
+	Text   string `json:"text"`
+}
+
+// ParseMessageTemplates returns the MessageTemplates configured by a JSON array
+// of MessageConfig objects read from r, such as:
+//
+//	[
+//		{"name": "authen-getpass", "text": "Password for {{.User}}:"},
+//		{"name": "authen-fail", "locale": "de", "text": "Anmeldung fehlgeschlagen"}
+//	]
+func ParseMessageTemplates(r io.Reader) (*MessageTemplates, error) {
+	var cfg []MessageConfig
+	if err := json.NewDecoder(r).Decode(&cfg); err != nil {
+		return nil, err
+	}
+	m := new(MessageTemplates)
+	for i, c := range cfg {
+		if c.Name == "" {
+			return nil, fmt.Errorf("message %d: no name", i)
+		}
+		if err := m.Add(c.Name, c.Group, c.Locale, c.Text); err != nil {
+			return nil, fmt.Errorf("message %d: %w", i, err)
+		}
+	}
+	return m, nil
+}
+
+// lookup returns the most specific template for name, group and locale, or nil.
+func (m *MessageTemplates) lookup(name, group, locale string) *template.Template {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, k := range []messageKey{
+		{name, group, locale},
+		{name, group, ""},
+		{name, "", locale},
+		{name, "", ""},
+	} {
+		if t := m.t[k]; t != nil {
+			return t
+		}
+	}
+	return nil
+}
+
+// Render renders the template for the message name, chosen by the device of
+// data, returning false if there is no template.
+func (m *MessageTemplates) Render(name string, data MessageData) (string, bool, error) {
+	var group, locale string
+	if data.RequestInfo != nil && data.Device != nil {
+		group, locale = data.Device.Group, data.Device.Locale
+	}
+	t := m.lookup(name, group, locale)
+	if t == nil {
+		return "", false, nil
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", true, err
+	}
+	msg := b.String()
+	if len(msg) > maxUint16 {
+		msg = msg[:maxUint16]
+	}
+	return msg, true, nil
+}
+
+// setRequestInfo records the request fields of the session for message templates.
+func (s *ServerSession) setRequestInfo(user, port, remAddr string) {
+	if s.c.Messages != nil {
+		s.info = newRequestInfo(s, user, port, remAddr)
+	}
+}
+
+// message returns the ServerMsg for a reply with the message name, replacing
+// msg with the rendered template if there is one. Errors rendering the
+// template are logged and msg is kept.
+func (s *ServerSession) message(name, msg string) string {
+	m := s.c.Messages
+	if m == nil || s.info == nil || name == "" {
+		return msg
+	}
+	info := *s.info
+	if info.User == "" {
+		s.mu.Lock()
+		info.User = s.user
+		s.mu.Unlock()
+	}
+	out, ok, err := m.Render(name, MessageData{&info, msg})
+	if err != nil {
+		s.c.log(fmt.Sprintf("%s message template %s: %v", s.RemoteAddr(), name, err))
+		return msg
+	}
+	if !ok {
+		return msg
+	}
+	return out
+}
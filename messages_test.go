@@ -0,0 +1,106 @@
+package tacplus
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+const testMessages = `[
+	{"name": "authen-getpass", "text": "Password for {{.User}}:"},
+	{"name": "authen-getpass", "locale": "de", "text": "Passwort für {{.User}}:"},
+	{"name": "authen-fail", "group": "core", "text": "{{.Device.Name}}: {{.Msg}}"},
+	{"name": "author-fail", "locale": "de", "text": "{{.User}} darf das nicht"}
+]`
+
+func TestMessageTemplatesRender(t *testing.T) {
+	m, err := ParseMessageTemplates(strings.NewReader(testMessages))
+	if err != nil {
+		t.Fatal(err)
+	}
+	core := &DeviceConfig{Name: "r1", Group: "core", Locale: "de"}
+	tests := []struct {
+		name string
+		dev  *DeviceConfig
+		msg  string
+		ok   bool
+	}{
+		{"authen-getpass", nil, "Password for fred:", true},
+		{"authen-getpass", core, "Passwort für fred:", true},
+		{"authen-getpass", &DeviceConfig{Locale: "fr"}, "Password for fred:", true},
+		{"authen-fail", core, "r1: denied", true},
+		{"authen-fail", &DeviceConfig{Locale: "de"}, "", false},
+		{"author-fail", core, "fred darf das nicht", true},
+		{"acct-error", core, "", false},
+	}
+	for _, tt := range tests {
+		info := &RequestInfo{User: "fred", Device: tt.dev}
+		msg, ok, err := m.Render(tt.name, MessageData{info, "denied"})
+		if err != nil || msg != tt.msg || ok != tt.ok {
+			t.Errorf("%s for %+v: got %q, %v, %v, want %q, %v", tt.name, tt.dev, msg, ok, err, tt.msg, tt.ok)
+		}
+	}
+
+	for _, cfg := range []string{`{}`, `[{"text": "no name"}]`, `[{"name": "authen-fail", "text": "{{.User"}]`} {
+		if _, err = ParseMessageTemplates(strings.NewReader(cfg)); err == nil {
+			t.Errorf("%s: no error", cfg)
+		}
+	}
+}
+
+func TestServerMessages(t *testing.T) {
+	skipWithoutMD5(t)
+	m, err := ParseMessageTemplates(strings.NewReader(testMessages))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inv Inventory
+	if err = inv.Add("127.0.0.1", &DeviceConfig{Name: "r1", Group: "core", Locale: "de"}); err != nil {
+		t.Fatal(err)
+	}
+	h := testHandler
+	h.Inventory = &inv
+	h.ConnConfig.Messages = m
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	defer c.Close()
+
+	ctx := context.Background()
+	rep, cs, err := c.SendAuthenStart(ctx, testAuthStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Close()
+	// no template for the user prompt, so the handler's message is kept
+	if rep.ServerMsg != "Username:" {
+		t.Errorf("got user prompt %q", rep.ServerMsg)
+	}
+	if rep, err = cs.Continue(ctx, "fred"); err != nil {
+		t.Fatal(err)
+	}
+	if rep.ServerMsg != "Passwort für fred:" {
+		t.Errorf("got password prompt %q", rep.ServerMsg)
+	}
+	if rep, err = cs.Continue(ctx, "wrong"); err != nil {
+		t.Fatal(err)
+	}
+	if rep.Status != AuthenStatusFail || rep.ServerMsg != "r1: " {
+		t.Errorf("got status %d message %q", rep.Status, rep.ServerMsg)
+	}
+
+	req := *testAuthorReq
+	req.User = "nobody"
+	resp, err := c.SendAuthorRequest(ctx, &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != AuthorStatusFail || resp.ServerMsg != "nobody darf das nicht" {
+		t.Errorf("got status %d message %q", resp.Status, resp.ServerMsg)
+	}
+	if err = s.err(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,90 @@
+package tacplus
+
+// MuxMode is the session multiplexing behavior of a client and server connection.
+type MuxMode int
+
+// MuxMode values
+const (
+	// MuxNone uses a new connection for each session.
+	MuxNone MuxMode = iota
+
+	// MuxSingleConnect multiplexes sessions, negotiated with the single-connection
+	// header flag.
+	MuxSingleConnect
+
+	// MuxLegacy multiplexes sessions, assumed by both ends with LegacyMux.
+	MuxLegacy
+
+	// MuxClientOnly has the client multiplex sessions while the server doesn't
+	// expect it. The server accepts sessions started while others are in progress,
+	// but closes the connection whenever its sessions finish, so the client opens
+	// more connections than it needs.
+	MuxClientOnly
+)
+
+func (m MuxMode) String() string {
+	switch m {
+	case MuxNone:
+		return "not multiplexed"
+	case MuxSingleConnect:
+		return "multiplexed with single-connection flag"
+	case MuxLegacy:
+		return "multiplexed with LegacyMux"
+	case MuxClientOnly:
+		return "multiplexed by client only"
+	default:
+		return "unknown"
+	}
+}
+
+// MuxOutcome is the result of negotiating session multiplexing.
+type MuxOutcome struct {
+	Mode   MuxMode
+	Reason string // why Mode was chosen
+}
+
+// NegotiateMux returns the multiplexing mode of connections from a client with
+// ConnConfig client to a server with ConnConfig server, to check a configuration
+// pair is compatible without connecting. Only the Mux, LegacyMux and Quirks
+// fields are used.
+func NegotiateMux(client, server ConnConfig) MuxOutcome {
+	switch {
+	case !client.Mux && !client.LegacyMux:
+		return MuxOutcome{MuxNone, "client has neither Mux nor LegacyMux set"}
+	case client.LegacyMux && server.LegacyMux:
+		return MuxOutcome{MuxLegacy, "client and server have LegacyMux set"}
+	case client.LegacyMux:
+		return MuxOutcome{MuxClientOnly, "client has LegacyMux set, so it doesn't send the single-connection flag the server needs"}
+	case !server.Mux && !server.LegacyMux:
+		return MuxOutcome{MuxNone, "server has neither Mux nor LegacyMux set, so doesn't return the single-connection flag"}
+	case server.Quirks.Has(QuirkSingleConnect):
+		return MuxOutcome{MuxNone, "server ignores the single-connection flag with QuirkSingleConnect"}
+	case client.Quirks.Has(QuirkSingleConnect):
+		return MuxOutcome{MuxNone, "client ignores the single-connection flag with QuirkSingleConnect"}
+	}
+	return MuxOutcome{MuxSingleConnect, "client sends the single-connection flag and the server returns it"}
+}
+
+// muxReason returns why the connection does or doesn't multiplex sessions,
+// given whether the first packet from the peer set the single-connection flag.
+func (c *conn) muxReason(flag bool) string {
+	switch {
+	case c.LegacyMux:
+		return "multiplexed: LegacyMux set"
+	case !c.Mux:
+		return "not multiplexed: neither Mux nor LegacyMux set"
+	case !flag:
+		return "not multiplexed: peer didn't set the single-connection flag"
+	case c.quirks.Has(QuirkSingleConnect):
+		return "not multiplexed: single-connection flag ignored with QuirkSingleConnect"
+	}
+	return "multiplexed: peer set the single-connection flag"
+}
+
+// logMux logs the multiplexing mode of the connection if LogMux is set. The
+// first packet from the peer is p.
+func (c *conn) logMux(p []byte) {
+	if c.LogMux {
+		c.log(c.nc.RemoteAddr(), " ", c.muxReason(p[hdrFlags]&hdrFlagSingleConnect > 0))
+	}
+}
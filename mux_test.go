@@ -0,0 +1,44 @@
+package tacplus
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateMuxQuirks(t *testing.T) {
+	mux := ConnConfig{Mux: true}
+	for _, test := range []struct {
+		client, server ConnConfig
+		mode           MuxMode
+	}{
+		{mux, mux, MuxSingleConnect},
+		{mux, ConnConfig{Mux: true, Quirks: QuirkOldHP}, MuxNone},
+		{ConnConfig{Mux: true, Quirks: QuirkSingleConnect}, mux, MuxNone},
+		{ConnConfig{LegacyMux: true}, ConnConfig{LegacyMux: true, Quirks: QuirkSingleConnect}, MuxLegacy},
+	} {
+		if o := NegotiateMux(test.client, test.server); o.Mode != test.mode {
+			t.Errorf("%+v %+v: got %v (%s), want %v", test.client, test.server, o.Mode, o.Reason, test.mode)
+		}
+	}
+}
+
+func TestLogMux(t *testing.T) {
+	skipWithoutMD5(t)
+	h := testHandler
+	h.ConnConfig.LogMux = true
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	defer c.Close()
+
+	if _, err = c.SendAcctRequest(context.Background(), testAcctReq); err != nil {
+		t.Fatal(err)
+	}
+	err = s.err()
+	if err == nil || !strings.Contains(err.Error(), "multiplexed: peer set the single-connection flag") {
+		t.Errorf("got log %v, want multiplexing reason", err)
+	}
+}
@@ -0,0 +1,65 @@
+package tacplus
+
+import "time"
+
+// ConnOverrides replaces ConnConfig settings for the connections of a group of
+// devices, so devices with different needs can share a listener. Zero values
+// leave the setting unchanged.
+type ConnOverrides struct {
+	Secret       []byte        // replaces Secret
+	Secrets      []KeyedSecret // replaces Secrets
+	IdleTimeout  time.Duration // replaces IdleTimeout
+	ReadTimeout  time.Duration // replaces ReadTimeout
+	WriteTimeout time.Duration // replaces WriteTimeout
+
+	// Replace Mux and LegacyMux if not nil.
+	Mux       *bool
+	LegacyMux *bool
+
+	// Quirks added to those of the ConnConfig.
+	Quirks Quirks
+}
+
+// apply returns cfg with the overrides applied.
+func (o *ConnOverrides) apply(cfg ConnConfig) ConnConfig {
+	if o.Secret != nil {
+		cfg.Secret = o.Secret
+		if o.Secrets == nil {
+			// Secrets take precedence, so drop them for the group's Secret
+			cfg.Secrets = nil
+		}
+	}
+	if o.Secrets != nil {
+		cfg.Secrets = o.Secrets
+	}
+	if o.IdleTimeout > 0 {
+		cfg.IdleTimeout = o.IdleTimeout
+	}
+	if o.ReadTimeout > 0 {
+		cfg.ReadTimeout = o.ReadTimeout
+	}
+	if o.WriteTimeout > 0 {
+		cfg.WriteTimeout = o.WriteTimeout
+	}
+	if o.Mux != nil {
+		cfg.Mux = *o.Mux
+	}
+	if o.LegacyMux != nil {
+		cfg.LegacyMux = *o.LegacyMux
+	}
+	cfg.Quirks |= o.Quirks
+	return cfg
+}
+
+// connConfig returns the ConnConfig for a connection from dev, with the
+// overrides for its device group applied.
+func (h *ServerConnHandler) connConfig(dev *DeviceConfig) ConnConfig {
+	if dev == nil || h.GroupConfig == nil {
+		return h.ConnConfig
+	}
+	o := h.GroupConfig[dev.Group]
+	if o == nil {
+		return h.ConnConfig
+	}
+	return o.apply(h.ConnConfig)
+}
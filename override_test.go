@@ -0,0 +1,50 @@
+//go:build !tacplus_nomd5
+
+package tacplus
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGroupConfig(t *testing.T) {
+	var inv Inventory
+	if err := inv.Add("127.0.0.1", &DeviceConfig{Name: "nas", Group: "legacy"}); err != nil {
+		t.Fatal(err)
+	}
+	legacySecret := []byte("legacy secret")
+	noMux := false
+	h := testHandler
+	h.Inventory = &inv
+	h.GroupConfig = map[string]*ConnOverrides{
+		"legacy": {Secret: legacySecret, ReadTimeout: time.Second, Mux: &noMux, Quirks: QuirkNormalizeArgs},
+	}
+	cfg := h.connConfig(inv.Lookup(addrIP(nil)))
+	if string(cfg.Secret) != string(testSecret) {
+		t.Errorf("unknown device got secret %q", cfg.Secret)
+	}
+	cfg = h.connConfig(&DeviceConfig{Group: "legacy"})
+	if string(cfg.Secret) != string(legacySecret) || cfg.ReadTimeout != time.Second || cfg.Mux || cfg.Quirks != QuirkNormalizeArgs {
+		t.Errorf("got overridden config %+v", cfg)
+	}
+
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	c.ConnConfig.Secret = legacySecret
+	resp, err := c.SendAuthorRequest(context.Background(), testAuthorReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != AuthorStatusPassAdd {
+		t.Errorf("got status %d, want %d", resp.Status, AuthorStatusPassAdd)
+	}
+	c.ConnConfig.Secret = testSecret
+	c.Close()
+	if _, err = c.SendAuthorRequest(context.Background(), testAuthorReq); err == nil {
+		t.Error("request with the default secret succeeded")
+	}
+}
@@ -1,6 +1,9 @@
 package tacplus
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 const (
 	// Session Types
@@ -44,11 +47,12 @@ const (
 
 // AuthenType field values
 const (
-	AuthenTypeASCII  = 0x1
-	AuthenTypePAP    = 0x2
-	AuthenTypeCHAP   = 0x3
-	AuthenTypeARAP   = 0x4
-	AuthenTypeMSCHAP = 0x5
+	AuthenTypeASCII    = 0x1
+	AuthenTypePAP      = 0x2
+	AuthenTypeCHAP     = 0x3
+	AuthenTypeARAP     = 0x4
+	AuthenTypeMSCHAP   = 0x5
+	AuthenTypeMSCHAPV2 = 0x6
 )
 
 // AuthenStart Action field values
@@ -96,6 +100,29 @@ const (
 )
 
 var (
+	// ErrBadSecret is the error for a packet whose field lengths don't match its
+	// body length after de-obfuscation, which almost always means the peers have
+	// different secrets.
+	ErrBadSecret = errors.New("bad secret")
+
+	// ErrMalformedPacket is the error for a packet body too short for its fixed
+	// fields, or an unobfuscated packet whose field lengths don't match its body
+	// length. The body length is never obfuscated, so these aren't caused by a
+	// wrong secret.
+	ErrMalformedPacket = errors.New("malformed packet")
+
+	// ErrTruncated is the error for an authorization or accounting packet whose
+	// body ends part way through its arguments, as sent by devices declaring
+	// more arguments than they send. The arguments read are kept.
+	ErrTruncated = errors.New("truncated arguments")
+
+	// ErrUnknownFlags is the error for an authentication reply with flags not
+	// defined by the protocol, returned by a Client with ConnConfig.StrictFlags set.
+	ErrUnknownFlags = errors.New("unknown flags")
+
+	// errBadPacket is returned when decoding a packet body whose field lengths
+	// don't match its length, which decodeError resolves to ErrBadSecret or
+	// ErrMalformedPacket.
 	errBadPacket = errors.New("bad secret or packet")
 
 	maxUint8  = int(^uint8(0))
@@ -134,6 +161,37 @@ func (b *readBuf) string(n int) string {
 	return string(s)
 }
 
+// has returns whether b holds at least the total length of fields with lengths lens.
+func (b readBuf) has(lens ...int) bool {
+	n := 0
+	for _, l := range lens {
+		n += l
+	}
+	return len(b) >= n
+}
+
+// args reads arguments with the lengths in al. If b is too short it returns
+// the arguments that fit and false.
+func (b *readBuf) args(al []byte) ([]string, bool) {
+	args := make([]string, 0, len(al))
+	for _, n := range al {
+		if !b.has(int(n)) {
+			return args, false
+		}
+		args = append(args, b.string(int(n)))
+	}
+	return args, true
+}
+
+// argLen returns the total length of arguments with the lengths in al.
+func argLen(al []byte) int {
+	n := 0
+	for _, l := range al {
+		n += int(l)
+	}
+	return n
+}
+
 func appendUint16(b []byte, i, j int) []byte {
 	return append(b, byte(i>>8), byte(i), byte(j>>8), byte(j))
 }
@@ -155,12 +213,12 @@ func (a *AuthenStart) version() uint8 {
 	switch a.Action {
 	case AuthenActionLogin:
 		switch a.AuthenType {
-		case AuthenTypePAP, AuthenTypeCHAP, AuthenTypeARAP, AuthenTypeMSCHAP:
+		case AuthenTypePAP, AuthenTypeCHAP, AuthenTypeARAP, AuthenTypeMSCHAP, AuthenTypeMSCHAPV2:
 			return verDefaultMinorOne
 		}
 	case AuthenActionSendAuth:
 		switch a.AuthenType {
-		case AuthenTypePAP, AuthenTypeCHAP, AuthenTypeMSCHAP:
+		case AuthenTypePAP, AuthenTypeCHAP, AuthenTypeMSCHAP, AuthenTypeMSCHAPV2:
 			return verDefaultMinorOne
 		}
 	}
@@ -194,7 +252,7 @@ func (a AuthenStart) marshal(b []byte) ([]byte, error) {
 func (a *AuthenStart) unmarshal(buf []byte) error {
 	b := readBuf(buf)
 	if len(b) < 8 {
-		return errBadPacket
+		return ErrMalformedPacket
 	}
 	a.Action = b.byte()
 	a.PrivLvl = b.byte()
@@ -204,7 +262,7 @@ func (a *AuthenStart) unmarshal(buf []byte) error {
 	pl := int(b.byte())
 	rl := int(b.byte())
 	dl := int(b.byte())
-	if len(b) < ul+pl+rl+dl {
+	if !b.has(ul, pl, rl, dl) {
 		return errBadPacket
 	}
 	a.User = b.string(ul)
@@ -221,6 +279,14 @@ type AuthenReply struct {
 	NoEcho    bool
 	ServerMsg string
 	Data      []byte
+
+	// Flags is the raw flags byte of a received reply, including the NoEcho bit
+	// and any bits not defined yet. Bits set in Flags are sent along with NoEcho.
+	Flags uint8
+
+	// DenyReason is an internal reason for a Fail or Error reply, for operators.
+	// It is reported to OnDeny and counted by DenyCounts, but never sent.
+	DenyReason string
 }
 
 // last returns whether the AuthenReply packet is the last packet in the session.
@@ -230,9 +296,17 @@ func (a *AuthenReply) last() bool {
 
 func (a *AuthenReply) flags() uint8 {
 	if a.NoEcho {
-		return authenReplyFlagNoEcho
+		return a.Flags | authenReplyFlagNoEcho
 	}
-	return 0
+	return a.Flags
+}
+
+// checkFlags returns an error if the reply has flags other than NoEcho set.
+func (a *AuthenReply) checkFlags() error {
+	if f := a.Flags &^ authenReplyFlagNoEcho; f != 0 {
+		return fmt.Errorf("%w %#02x in authentication reply", ErrUnknownFlags, f)
+	}
+	return nil
 }
 
 func (a AuthenReply) marshal(b []byte) ([]byte, error) {
@@ -254,14 +328,15 @@ func (a AuthenReply) marshal(b []byte) ([]byte, error) {
 func (a *AuthenReply) unmarshal(buf []byte) error {
 	b := readBuf(buf)
 	if len(b) < 6 {
-		return errBadPacket
+		return ErrMalformedPacket
 	}
 	a.Status = b.byte()
-	a.NoEcho = b.byte()&authenReplyFlagNoEcho > 0
+	a.Flags = b.byte()
+	a.NoEcho = a.Flags&authenReplyFlagNoEcho > 0
 	sl := b.uint16()
 	dl := b.uint16()
 
-	if len(b) < sl+dl {
+	if !b.has(sl, dl) {
 		return errBadPacket
 	}
 	a.ServerMsg = b.string(sl)
@@ -301,12 +376,12 @@ func (a AuthenContinue) marshal(b []byte) ([]byte, error) {
 func (a *AuthenContinue) unmarshal(buf []byte) error {
 	b := readBuf(buf)
 	if len(b) < 5 {
-		return errBadPacket
+		return ErrMalformedPacket
 	}
 	ml := b.uint16()
 	dl := b.uint16()
 	a.Abort = b.byte()&authenContinueFlagAbort > 0
-	if len(b) < ml+dl {
+	if !b.has(ml, dl) {
 		return errBadPacket
 	}
 
@@ -371,7 +446,7 @@ func (a AuthorRequest) marshal(b []byte) ([]byte, error) {
 func (a *AuthorRequest) unmarshal(buf []byte) error {
 	b := readBuf(buf)
 	if len(b) < 8 {
-		return errBadPacket
+		return ErrMalformedPacket
 	}
 	a.AuthenMethod = b.byte()
 	a.PrivLvl = b.byte()
@@ -381,19 +456,16 @@ func (a *AuthorRequest) unmarshal(buf []byte) error {
 	pl := int(b.byte())
 	rl := int(b.byte())
 	ac := int(b.byte())
-	if len(b) < ul+pl+rl+ac {
+	if !b.has(ul, pl, rl, ac) {
 		return errBadPacket
 	}
 	al := b.slice(ac)
 	a.User = b.string(ul)
 	a.Port = b.string(pl)
 	a.RemAddr = b.string(rl)
-	a.Arg = make([]string, ac)
-	for i, n := range al {
-		if len(b) < int(n) {
-			return errBadPacket
-		}
-		a.Arg[i] = b.string(int(n))
+	var ok bool
+	if a.Arg, ok = b.args(al); !ok {
+		return ErrTruncated
 	}
 	return nil
 }
@@ -404,6 +476,10 @@ type AuthorResponse struct {
 	Arg       []string
 	ServerMsg string
 	Data      string
+
+	// DenyReason is an internal reason for a Fail or Error response, for
+	// operators. It is reported to OnDeny and counted by DenyCounts, but never sent.
+	DenyReason string
 }
 
 func (a AuthorResponse) marshal(b []byte) ([]byte, error) {
@@ -440,24 +516,21 @@ func (a AuthorResponse) marshal(b []byte) ([]byte, error) {
 func (a *AuthorResponse) unmarshal(buf []byte) error {
 	b := readBuf(buf)
 	if len(b) < 6 {
-		return errBadPacket
+		return ErrMalformedPacket
 	}
 	a.Status = b.byte()
 	ac := int(b.byte())
 	sl := b.uint16()
 	dl := b.uint16()
-	if len(b) < ac+sl+dl {
+	if !b.has(ac, sl, dl) {
 		return errBadPacket
 	}
 	al := b.slice(ac)
 	a.ServerMsg = b.string(sl)
 	a.Data = b.string(dl)
-	a.Arg = make([]string, ac)
-	for i, n := range al {
-		if len(b) < int(n) {
-			return errBadPacket
-		}
-		a.Arg[i] = b.string(int(n))
+	var ok bool
+	if a.Arg, ok = b.args(al); !ok {
+		return ErrTruncated
 	}
 	return nil
 }
@@ -513,7 +586,7 @@ func (a AcctRequest) marshal(b []byte) ([]byte, error) {
 func (a *AcctRequest) unmarshal(buf []byte) error {
 	b := readBuf(buf)
 	if len(b) < 9 {
-		return errBadPacket
+		return ErrMalformedPacket
 	}
 	a.Flags = b.byte()
 	a.AuthenMethod = b.byte()
@@ -524,19 +597,16 @@ func (a *AcctRequest) unmarshal(buf []byte) error {
 	pl := int(b.byte())
 	rl := int(b.byte())
 	ac := int(b.byte())
-	if len(b) < ul+pl+rl+ac {
+	if !b.has(ul, pl, rl, ac) {
 		return errBadPacket
 	}
 	al := b.slice(ac)
 	a.User = b.string(ul)
 	a.Port = b.string(pl)
 	a.RemAddr = b.string(rl)
-	a.Arg = make([]string, ac)
-	for i, n := range al {
-		if len(b) < int(n) {
-			return errBadPacket
-		}
-		a.Arg[i] = b.string(int(n))
+	var ok bool
+	if a.Arg, ok = b.args(al); !ok {
+		return ErrTruncated
 	}
 	return nil
 }
@@ -546,6 +616,10 @@ type AcctReply struct {
 	Status    uint8
 	ServerMsg string
 	Data      string
+
+	// DenyReason is an internal reason for an Error reply, for operators.
+	// It is reported to OnDeny and counted by DenyCounts, but never sent.
+	DenyReason string
 }
 
 func (a AcctReply) marshal(b []byte) ([]byte, error) {
@@ -567,15 +641,117 @@ func (a AcctReply) marshal(b []byte) ([]byte, error) {
 func (a *AcctReply) unmarshal(buf []byte) error {
 	b := readBuf(buf)
 	if len(b) < 5 {
-		return errBadPacket
+		return ErrMalformedPacket
 	}
 	sl := b.uint16()
 	dl := b.uint16()
 	a.Status = b.byte()
-	if len(b) < sl+dl {
+	if !b.has(sl, dl) {
 		return errBadPacket
 	}
 	a.ServerMsg = b.string(sl)
 	a.Data = b.string(dl)
 	return nil
 }
+
+// MarshalBinary and UnmarshalBinary implement encoding.BinaryMarshaler and
+// encoding.BinaryUnmarshaler for the packet bodies, for use by tools that
+// process TACACS+ packets outside of a connection.
+
+// MarshalBinary returns the encoded AuthenStart packet body.
+func (a AuthenStart) MarshalBinary() ([]byte, error) { return a.marshal(nil) }
+
+// UnmarshalBinary decodes a AuthenStart packet body.
+func (a *AuthenStart) UnmarshalBinary(b []byte) error { return a.unmarshal(b) }
+
+// MarshalBinary returns the encoded AuthenReply packet body.
+func (a AuthenReply) MarshalBinary() ([]byte, error) { return a.marshal(nil) }
+
+// UnmarshalBinary decodes a AuthenReply packet body.
+func (a *AuthenReply) UnmarshalBinary(b []byte) error { return a.unmarshal(b) }
+
+// MarshalBinary returns the encoded AuthenContinue packet body.
+func (a AuthenContinue) MarshalBinary() ([]byte, error) { return a.marshal(nil) }
+
+// UnmarshalBinary decodes a AuthenContinue packet body.
+func (a *AuthenContinue) UnmarshalBinary(b []byte) error { return a.unmarshal(b) }
+
+// MarshalBinary returns the encoded AuthorRequest packet body.
+func (a AuthorRequest) MarshalBinary() ([]byte, error) { return a.marshal(nil) }
+
+// UnmarshalBinary decodes a AuthorRequest packet body.
+// If the body ends part way through the arguments, it keeps the arguments read
+// and returns ErrTruncated.
+func (a *AuthorRequest) UnmarshalBinary(b []byte) error { return a.unmarshal(b) }
+
+// MarshalBinary returns the encoded AuthorResponse packet body.
+func (a AuthorResponse) MarshalBinary() ([]byte, error) { return a.marshal(nil) }
+
+// UnmarshalBinary decodes a AuthorResponse packet body.
+// If the body ends part way through the arguments, it keeps the arguments read
+// and returns ErrTruncated.
+func (a *AuthorResponse) UnmarshalBinary(b []byte) error { return a.unmarshal(b) }
+
+// MarshalBinary returns the encoded AcctRequest packet body.
+func (a AcctRequest) MarshalBinary() ([]byte, error) { return a.marshal(nil) }
+
+// UnmarshalBinary decodes a AcctRequest packet body.
+// If the body ends part way through the arguments, it keeps the arguments read
+// and returns ErrTruncated.
+func (a *AcctRequest) UnmarshalBinary(b []byte) error { return a.unmarshal(b) }
+
+// MarshalBinary returns the encoded AcctReply packet body.
+func (a AcctReply) MarshalBinary() ([]byte, error) { return a.marshal(nil) }
+
+// UnmarshalBinary decodes a AcctReply packet body.
+func (a *AcctReply) UnmarshalBinary(b []byte) error { return a.unmarshal(b) }
+
+// truncatedRequestOK returns whether the session start request body b of
+// session type t is an authorization or accounting request ending part way
+// through its arguments, with valid fields before the truncation.
+func truncatedRequestOK(t uint8, b []byte) bool {
+	var req interface {
+		unmarshal([]byte) error
+		Validate() error
+	}
+	switch t {
+	case sessTypeAuthor:
+		req = new(AuthorRequest)
+	case sessTypeAcct:
+		req = new(AcctRequest)
+	default:
+		return false
+	}
+	return req.unmarshal(b) == ErrTruncated && req.Validate() == nil
+}
+
+// requestLenOK returns whether the field lengths of the session start request
+// body b of session type t add up to the body length.
+func requestLenOK(t uint8, b []byte) bool {
+	n := 0
+	switch t {
+	case sessTypeAuthen:
+		if len(b) < 8 {
+			return false
+		}
+		n = 8 + int(b[4]) + int(b[5]) + int(b[6]) + int(b[7])
+	case sessTypeAuthor, sessTypeAcct:
+		if t == sessTypeAcct {
+			if len(b) < 1 {
+				return false
+			}
+			b = b[1:] // skip flags
+		}
+		if len(b) < 8 {
+			return false
+		}
+		ac := int(b[7])
+		if len(b) < 8+ac {
+			return false
+		}
+		n = 8 + ac + int(b[4]) + int(b[5]) + int(b[6]) + argLen(b[8:8+ac])
+	default:
+		return false
+	}
+	return n == len(b)
+}
@@ -21,6 +21,7 @@ var marshalUnmarshalTests = []packet{
 		NoEcho:    true,
 		ServerMsg: "nothing here",
 		Data:      []byte{9, 8, 7, 6},
+		Flags:     authenReplyFlagNoEcho,
 	},
 	&AuthenContinue{Abort: false, Message: "message one"},
 	&AuthenContinue{Abort: true, Message: "message two"},
@@ -56,6 +57,8 @@ var marshalUnmarshalTests = []packet{
 		ServerMsg: "user log message",
 		Data:      "admin log message",
 	},
+	// flags not defined yet are kept
+	&AuthenReply{Status: AuthenStatusPass, Flags: 0x80},
 }
 
 func TestPacketMarshalUnmarshal(t *testing.T) {
@@ -75,3 +78,41 @@ func TestPacketMarshalUnmarshal(t *testing.T) {
 		}
 	}
 }
+
+// newPacket returns a new empty packet of the same type as p.
+func newPacket(p packet) packet {
+	tp := reflect.Indirect(reflect.ValueOf(p)).Type()
+	return reflect.New(tp).Interface().(packet)
+}
+
+func FuzzUnmarshal(f *testing.F) {
+	for _, p := range marshalUnmarshalTests {
+		b, err := p.marshal(nil)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b)
+	}
+	f.Add(append([]byte{0, 0, 0, 0, 0, 0, 0, 255}, make([]byte, 255)...)) // arg count with short body
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		for _, tp := range marshalUnmarshalTests {
+			p := newPacket(tp)
+			if p.unmarshal(b) != nil {
+				continue
+			}
+			// successfully decoded packets must encode and decode to the same value
+			b2, err := p.marshal(nil)
+			if err != nil {
+				t.Fatalf("%T: marshal of decoded packet failed: %v", p, err)
+			}
+			p2 := newPacket(tp)
+			if err = p2.unmarshal(b2); err != nil {
+				t.Fatalf("%T: unmarshal of encoded packet failed: %v", p, err)
+			}
+			if !reflect.DeepEqual(p, p2) {
+				t.Fatalf("%T: %v != %v", p, p2, p)
+			}
+		}
+	})
+}
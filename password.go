@@ -0,0 +1,233 @@
+package tacplus
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// maxChangeAttempts is the number of times a user is asked for a new password
+// that is rejected before the login fails.
+const maxChangeAttempts = 3
+
+// A PasswordChanger is an Authenticator whose passwords can expire. When a
+// LoginHandler authenticates a user with an expired password, it has them
+// choose a new one before the login passes.
+type PasswordChanger interface {
+	Authenticator
+
+	// PasswordExpired reports whether the password of user must be changed.
+	PasswordExpired(ctx context.Context, user string) (bool, error)
+
+	// ChangePassword sets the password of user to pass.
+	ChangePassword(ctx context.Context, user, pass string) error
+}
+
+type storedUser struct {
+	hash    string    // password hash
+	changed time.Time // time the password was set
+	expired bool      // change forced on next login
+	account Account   // restrictions on using the account
+	groups  []string  // user groups, for a GroupPolicy
+}
+
+// UserStore is a PasswordChanger, AccountChecker and GroupLookup holding user
+// password hashes in memory with password ageing, account restrictions and
+// user groups, suitable for a small database of local accounts. It never
+// returns an error. A UserStore is safe for concurrent use.
+//
+// Passwords are stored as SHA-512 crypt hashes. Hashes of other supported
+// schemes can be added with SetHash, so users can be imported from htpasswd
+// and shadow password files. A UserStore is saved to and loaded from a file
+// with Save and Load.
+type UserStore struct {
+	// Time after which passwords expire, counted from when they were set.
+	// Passwords don't expire with age if zero.
+	MaxAge time.Duration
+
+	mu    sync.Mutex
+	users map[string]*storedUser
+}
+
+// Set sets the password of user, as if it was changed at the time changed,
+// adding the user if they don't exist.
+func (u *UserStore) Set(user, pass string, changed time.Time) {
+	u.set(user, hashPassword(pass), changed)
+}
+
+// SetHash is like Set, with the password given as a hash. It returns
+// ErrUnsupportedHash unless the hash is a SHA-256 or SHA-512 crypt hash ($5$
+// or $6$), or an htpasswd {SHA} hash.
+func (u *UserStore) SetHash(user, hash string, changed time.Time) error {
+	if err := checkHash(hash); err != nil {
+		return err
+	}
+	u.set(user, hash, changed)
+	return nil
+}
+
+func (u *UserStore) set(user, hash string, changed time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.users == nil {
+		u.users = make(map[string]*storedUser)
+	}
+	su := u.users[user]
+	if su == nil {
+		su = new(storedUser)
+		u.users[user] = su
+	}
+	su.hash, su.changed, su.expired = hash, changed, false
+}
+
+// Expire forces user to change their password at their next login.
+func (u *UserStore) Expire(user string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if su := u.users[user]; su != nil {
+		su.expired = true
+	}
+}
+
+// Delete removes user.
+func (u *UserStore) Delete(user string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.users, user)
+}
+
+// dummyHash is checked against the password of unknown users.
+const dummyHash = "$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"
+
+// Authenticate returns whether pass is the password of user.
+func (u *UserStore) Authenticate(ctx context.Context, user, pass string) (bool, error) {
+	u.mu.Lock()
+	su := u.users[user]
+	var hash string
+	if su != nil {
+		hash = su.hash // changed in place by set
+	}
+	u.mu.Unlock()
+	if su == nil {
+		// take as long as checking a password, so users can't be found by timing
+		matchHash(dummyHash, pass)
+		return false, nil
+	}
+	return matchHash(hash, pass), nil
+}
+
+// PasswordExpired reports whether the password of user is older than MaxAge,
+// or has been expired with Expire.
+func (u *UserStore) PasswordExpired(ctx context.Context, user string) (bool, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	su := u.users[user]
+	if su == nil {
+		return false, nil
+	}
+	return su.expired || (u.MaxAge > 0 && time.Since(su.changed) >= u.MaxAge), nil
+}
+
+// ChangePassword sets the password of user to pass, resetting its age. It
+// returns ErrPasswordLong for passwords over 256 bytes.
+func (u *UserStore) ChangePassword(ctx context.Context, user, pass string) error {
+	if len(pass) > maxPasswordLen {
+		return ErrPasswordLong
+	}
+	u.Set(user, pass, time.Now())
+	return nil
+}
+
+// Password complexity errors returned by CheckPasswordComplexity.
+var (
+	ErrPasswordShort   = errors.New("password must be at least 8 characters")
+	ErrPasswordLong    = errors.New("password must be at most 256 bytes")
+	ErrPasswordSimple  = errors.New("password must have 3 of upper case, lower case, digits and symbols")
+	ErrPasswordHasUser = errors.New("password must not contain the user name")
+)
+
+// CheckPasswordComplexity checks a new password for user is at least 8
+// characters and at most 256 bytes long, has characters of 3 of the classes
+// upper case, lower case, digits and symbols, and doesn't contain the user
+// name. It is the default LoginHandler CheckPassword function.
+func CheckPasswordComplexity(user, pass string) error {
+	if len([]rune(pass)) < 8 {
+		return ErrPasswordShort
+	}
+	if len(pass) > maxPasswordLen {
+		return ErrPasswordLong
+	}
+	var upper, lower, digit, other int
+	for _, r := range pass {
+		switch {
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	if upper+lower+digit+other < 3 {
+		return ErrPasswordSimple
+	}
+	if user != "" && strings.Contains(strings.ToLower(pass), strings.ToLower(user)) {
+		return ErrPasswordHasUser
+	}
+	return nil
+}
+
+// changePassword has user choose a new password for pc, prompting for it
+// twice, and returns the final reply.
+func (h *LoginHandler) changePassword(ctx context.Context, s *ServerSession, pc PasswordChanger, user, old string) *AuthenReply {
+	check := h.CheckPassword
+	if check == nil {
+		check = CheckPasswordComplexity
+	}
+	msg := "Your password has expired."
+	for i := 0; i < maxChangeAttempts; i++ {
+		c, err := s.GetData(ctx, msg+"\nNew password: ", true)
+		if err != nil {
+			return nil
+		}
+		pass := c.Message
+		if c, err = s.GetData(ctx, "Confirm new password: ", true); err != nil {
+			return nil
+		}
+		switch {
+		case c.Message != pass:
+			err = errors.New("passwords don't match")
+		case pass == old:
+			err = errors.New("new password must differ from the old one")
+		default:
+			err = check(user, pass)
+		}
+		if err != nil {
+			msg = err.Error() + "."
+			continue
+		}
+		err = pc.ChangePassword(ctx, user, pass)
+		h.passwordChanged(s, user, err)
+		if err != nil {
+			return errorReply(s, err).AuthenReply()
+		}
+		return &AuthenReply{Status: AuthenStatusPass}
+	}
+	return &AuthenReply{Status: AuthenStatusFail, ServerMsg: "Password not changed."}
+}
+
+// passwordChanged reports a password change for user, which failed if err is set.
+func (h *LoginHandler) passwordChanged(s *ServerSession, user string, err error) {
+	if h.OnPasswordChange != nil {
+		h.OnPasswordChange(user, err)
+	} else if err != nil {
+		s.Log("password change for ", user, " failed: ", err)
+	} else {
+		s.Log("password changed for ", user)
+	}
+}
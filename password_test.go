@@ -0,0 +1,167 @@
+package tacplus
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckPasswordComplexity(t *testing.T) {
+	tests := []struct {
+		pass string
+		want error
+	}{
+		{"Sh0rt!", ErrPasswordShort},
+		{"alllowercase", ErrPasswordSimple},
+		{"lower12345", ErrPasswordSimple},
+		{"Lower12345", nil},
+		{"lower-12345", nil},
+		{"xAlice-123", ErrPasswordHasUser},
+		{"Lower1" + strings.Repeat("x", maxPasswordLen), ErrPasswordLong},
+	}
+	for _, tt := range tests {
+		if err := CheckPasswordComplexity("alice", tt.pass); err != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.pass, err, tt.want)
+		}
+	}
+}
+
+func TestUserStoreExpiry(t *testing.T) {
+	ctx := context.Background()
+	u := &UserStore{MaxAge: time.Hour}
+	u.Set("alice", "old", time.Now().Add(-2*time.Hour))
+	u.Set("bob", "pass", time.Now())
+	for user, want := range map[string]bool{"alice": true, "bob": false, "nobody": false} {
+		if got, _ := u.PasswordExpired(ctx, user); got != want {
+			t.Errorf("%s: got expired %v, want %v", user, got, want)
+		}
+	}
+	u.Expire("bob")
+	if expired, _ := u.PasswordExpired(ctx, "bob"); !expired {
+		t.Error("bob not expired after Expire")
+	}
+	_ = u.ChangePassword(ctx, "alice", "new")
+	if ok, _ := u.Authenticate(ctx, "alice", "new"); !ok {
+		t.Error("new password rejected")
+	}
+	if expired, _ := u.PasswordExpired(ctx, "alice"); expired {
+		t.Error("changed password expired")
+	}
+}
+
+func TestLoginPasswordChange(t *testing.T) {
+	skipWithoutMD5(t)
+	users := &UserStore{MaxAge: time.Hour}
+	users.Set("alice", "Old-pass1", time.Now().Add(-2*time.Hour))
+	changes := make(chan string, 1)
+	h := testHandler
+	h.Handler = &LoginHandler{
+		Primary:          users,
+		Passthrough:      Passthrough{testHandler.Handler},
+		OnPasswordChange: func(user string, err error) { changes <- user },
+	}
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	defer c.Close()
+
+	ctx := context.Background()
+	// PAP can't prompt for a new password
+	pap := *testAuthStart
+	pap.AuthenType = AuthenTypePAP
+	pap.User = "alice"
+	pap.Data = []byte("Old-pass1")
+	rep, err := c.SendAuthenStartAuto(ctx, &pap, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Status != AuthenStatusFail {
+		t.Errorf("PAP login got status %d, want %d", rep.Status, AuthenStatusFail)
+	}
+
+	_, cs, err := c.SendAuthenStart(ctx, testAuthStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Close()
+	steps := []struct {
+		answer string
+		status uint8
+		msg    string // expected in the reply message
+	}{
+		{"alice", AuthenStatusGetPass, "Password"},
+		{"Old-pass1", AuthenStatusGetData, "expired"},
+		{"short", AuthenStatusGetData, "Confirm"},
+		{"short", AuthenStatusGetData, "at least 8"},
+		{"New-pass1", AuthenStatusGetData, "Confirm"},
+		{"New-pass2", AuthenStatusGetData, "match"},
+		{"New-pass1", AuthenStatusGetData, "Confirm"},
+		{"New-pass1", AuthenStatusPass, ""},
+	}
+	for _, step := range steps {
+		rep, err := cs.Continue(ctx, step.answer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rep.Status != step.status || !strings.Contains(rep.ServerMsg, step.msg) {
+			t.Fatalf("answer %q: got status %d message %q, want %d containing %q",
+				step.answer, rep.Status, rep.ServerMsg, step.status, step.msg)
+		}
+		if rep.Status == AuthenStatusGetData && !rep.NoEcho {
+			t.Errorf("answer %q: new password prompt echoes", step.answer)
+		}
+	}
+	if user := <-changes; user != "alice" {
+		t.Errorf("got change for %q, want alice", user)
+	}
+	rep, err = c.SendAuthenStartAuto(ctx, testAuthStart, "alice", "New-pass1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Status != AuthenStatusPass {
+		t.Errorf("login with new password got status %d, want %d", rep.Status, AuthenStatusPass)
+	}
+	if err = s.err(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUserStoreUnknownUserTiming(t *testing.T) {
+	ctx := context.Background()
+	u := new(UserStore)
+	u.Set("alice", "secret", time.Now())
+	timeAuth := func(user string) time.Duration {
+		start := time.Now()
+		for i := 0; i < 5; i++ {
+			u.Authenticate(ctx, user, "wrong")
+		}
+		return time.Since(start)
+	}
+	known, unknown := timeAuth("alice"), timeAuth("nobody")
+	if unknown < known/2 {
+		t.Errorf("unknown user took %v, known user %v", unknown, known)
+	}
+}
+
+func TestUserStoreConcurrentChange(t *testing.T) {
+	ctx := context.Background()
+	u := new(UserStore)
+	u.Set("alice", "old", time.Now())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			_ = u.ChangePassword(ctx, "alice", "new")
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		u.Authenticate(ctx, "alice", "new")
+	}
+	<-done
+	if ok, _ := u.Authenticate(ctx, "alice", "new"); !ok {
+		t.Error("changed password rejected")
+	}
+}
@@ -0,0 +1,41 @@
+package tacplus
+
+import "context"
+
+// A Policy makes authorization decisions. It allows authorization policy to be
+// supplied by a plugin, such as an embedded script interpreter, so it can be
+// changed without recompiling the server.
+//
+// Authorize returns the response for the request, or a nil response and error
+// if the policy makes no decision. Errors are handled as for a RequestHandlerV2.
+type Policy interface {
+	Authorize(ctx context.Context, req *AuthorRequest, s *ServerSession) (*AuthorResponse, error)
+}
+
+// PolicyFunc is an adapter allowing a function to be used as a Policy.
+type PolicyFunc func(ctx context.Context, req *AuthorRequest, s *ServerSession) (*AuthorResponse, error)
+
+// Authorize calls f(ctx, req, s).
+func (f PolicyFunc) Authorize(ctx context.Context, req *AuthorRequest, s *ServerSession) (*AuthorResponse, error) {
+	return f(ctx, req, s)
+}
+
+// PolicyHandler is a RequestHandler that makes authorization decisions with Policy.
+// Requests Policy makes no decision on, and other request types, are passed to Handler.
+type PolicyHandler struct {
+	Passthrough        // handler for requests
+	Policy      Policy // authorization policy
+}
+
+// HandleAuthorRequest returns the decision of Policy, or the response of Handler
+// if there is no decision.
+func (h *PolicyHandler) HandleAuthorRequest(ctx context.Context, a *AuthorRequest, s *ServerSession) *AuthorResponse {
+	r, err := h.Policy.Authorize(ctx, a, s)
+	if err != nil {
+		return errorReply(s, err).AuthorResponse()
+	}
+	if r != nil {
+		return r
+	}
+	return h.Passthrough.HandleAuthorRequest(ctx, a, s)
+}
@@ -0,0 +1,52 @@
+//go:build !tacplus_nomd5
+
+package tacplus
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestPolicyHandler(t *testing.T) {
+	policy := func(ctx context.Context, req *AuthorRequest, s *ServerSession) (*AuthorResponse, error) {
+		switch req.User {
+		case "admin":
+			return &AuthorResponse{Status: AuthorStatusPassAdd, Arg: []string{"priv-lvl=15"}}, nil
+		case "blocked":
+			return nil, &ErrorReply{Msg: "blocked", Fail: true}
+		case "broken":
+			return nil, errors.New("policy failure")
+		}
+		return nil, nil
+	}
+	h := testHandler
+	h.Handler = &PolicyHandler{Passthrough: Passthrough{testHandler.Handler}, Policy: PolicyFunc(policy)}
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	for _, test := range []struct {
+		user   string
+		status uint8
+		msg    string
+	}{
+		{"admin", AuthorStatusPassAdd, ""},
+		{"blocked", AuthorStatusFail, "blocked"},
+		{"broken", AuthorStatusError, errInternal},
+		{"user", AuthorStatusPassAdd, ""}, // no decision, passed to handler
+		{"nobody", AuthorStatusFail, ""},
+	} {
+		req := *testAuthorReq
+		req.User = test.user
+		resp, err := c.SendAuthorRequest(context.Background(), &req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != test.status || resp.ServerMsg != test.msg {
+			t.Errorf("%s: got status %d %q, want %d %q", test.user, resp.Status, resp.ServerMsg, test.status, test.msg)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package tacplus
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+)
+
+// BalancePolicy selects how a ClientPool spreads requests across upstreams.
+type BalancePolicy int
+
+// BalancePolicy values
+const (
+	BalanceRoundRobin       BalancePolicy = iota // weighted round-robin
+	BalanceLeastOutstanding                      // fewest requests in progress relative to weight
+	BalanceSticky                                // consistent hash of the balance key
+)
+
+type balanceKey struct{}
+
+// WithBalanceKey returns a copy of ctx carrying key, used by a ClientPool with
+// the BalanceSticky policy to choose an upstream. Requests with the same key go
+// to the same upstream while it is healthy. Proxy sets the key to the address of
+// the NAS that sent the request.
+func WithBalanceKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, balanceKey{}, key)
+}
+
+func balanceKeyFrom(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(balanceKey{}).(string)
+	return key, ok
+}
+
+// stickyScore returns the weighted rendezvous hash score of an upstream with
+// address addr and weight w for key. The upstream with the highest score is chosen.
+func stickyScore(key, addr string, w int) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write([]byte(addr))
+	// map hash to (0, 1)
+	u := (float64(h.Sum64()>>11) + 0.5) / (1 << 53)
+	return -float64(w) / math.Log(u)
+}
+
+// Upstream is a server in a ClientPool.
+type Upstream struct {
+	Client *Client // client for the server
+	Weight int     // relative share of requests, treated as 1 if less than 1
+}
+
+func (u Upstream) weight() int {
+	if u.Weight < 1 {
+		return 1
+	}
+	return u.Weight
+}
+
+// UpstreamStatus describes the state of an Upstream in a ClientPool.
+type UpstreamStatus struct {
+	Addr        string // server address
+	Healthy     bool   // upstream has not recently failed
+	Outstanding int    // requests in progress
+}
+
+// upstreamState is the balancing and health state of an Upstream.
+type upstreamState struct {
+	current     int       // smooth weighted round-robin counter
+	outstanding int       // requests in progress
+	downUntil   time.Time // upstream is unhealthy until this time
+}
+
+// ClientPool is a Sender that balances requests across multiple upstream servers.
+//
+// If a request to an upstream fails with an error other than its context being
+// done, the upstream is marked unhealthy for FailTimeout and the request is retried
+// on the next upstream chosen. Unhealthy upstreams are only used if all are unhealthy.
+//
+// With the BalanceSticky policy, requests with a context from WithBalanceKey are sent
+// to an upstream chosen by a consistent hash of the key, failing over to the next
+// upstream for that key. Requests without a key are balanced with weighted round-robin.
+// Note a retried request may already have been processed by the failed upstream.
+type ClientPool struct {
+	Upstreams   []Upstream    // upstream servers, must not be changed after the first request
+	Balance     BalancePolicy // balancing policy
+	FailTimeout time.Duration // time a failed upstream is avoided for, default 10s
+
+	mu    sync.Mutex
+	state []upstreamState
+}
+
+func (p *ClientPool) failTimeout() time.Duration {
+	if p.FailTimeout > 0 {
+		return p.FailTimeout
+	}
+	return 10 * time.Second
+}
+
+// Status returns the status of each upstream, in the order of Upstreams.
+func (p *ClientPool) Status() []UpstreamStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.init()
+	now := time.Now()
+	st := make([]UpstreamStatus, len(p.Upstreams))
+	for i, u := range p.Upstreams {
+		st[i] = UpstreamStatus{
+			Addr:        u.Client.Addr,
+			Healthy:     !now.Before(p.state[i].downUntil),
+			Outstanding: p.state[i].outstanding,
+		}
+	}
+	return st
+}
+
+func (p *ClientPool) init() {
+	if p.state == nil {
+		p.state = make([]upstreamState, len(p.Upstreams))
+	}
+}
+
+// pick chooses an upstream not in tried for a request with balance key key,
+// incrementing its outstanding count. It returns -1 if all upstreams have been tried.
+func (p *ClientPool) pick(tried []bool, key string, sticky bool) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.init()
+	now := time.Now()
+	choose := p.choose
+	if sticky && p.Balance == BalanceSticky {
+		choose = func(tried []bool, ok func(int) bool) int { return p.chooseSticky(tried, ok, key) }
+	}
+	i := choose(tried, func(i int) bool { return !now.Before(p.state[i].downUntil) })
+	if i < 0 {
+		// no healthy upstreams left, so try unhealthy ones
+		i = choose(tried, func(int) bool { return true })
+	}
+	if i >= 0 {
+		p.state[i].outstanding++
+	}
+	return i
+}
+
+// choose selects an untried upstream for which ok returns true using the balancing policy.
+func (p *ClientPool) choose(tried []bool, ok func(int) bool) int {
+	best, total := -1, 0
+	for i, u := range p.Upstreams {
+		if tried[i] || !ok(i) {
+			continue
+		}
+		st, w := &p.state[i], u.weight()
+		switch p.Balance {
+		case BalanceLeastOutstanding:
+			// compare outstanding/weight without division
+			if best < 0 || st.outstanding*p.Upstreams[best].weight() < p.state[best].outstanding*w {
+				best = i
+			}
+		default:
+			// smooth weighted round-robin
+			st.current += w
+			total += w
+			if best < 0 || st.current > p.state[best].current {
+				best = i
+			}
+		}
+	}
+	if best >= 0 && p.Balance != BalanceLeastOutstanding {
+		p.state[best].current -= total
+	}
+	return best
+}
+
+// chooseSticky selects the untried upstream for which ok returns true with the
+// highest score for key.
+func (p *ClientPool) chooseSticky(tried []bool, ok func(int) bool, key string) int {
+	best, bestScore := -1, 0.0
+	for i, u := range p.Upstreams {
+		if tried[i] || !ok(i) {
+			continue
+		}
+		if score := stickyScore(key, u.Client.Addr, u.weight()); best < 0 || score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+// done records the result err of a request to upstream i.
+func (p *ClientPool) done(i int, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.state[i].outstanding--
+	if err != nil {
+		p.state[i].downUntil = time.Now().Add(p.failTimeout())
+	} else {
+		p.state[i].downUntil = time.Time{}
+	}
+}
+
+var errNoUpstreams = errors.New("no upstream servers")
+
+// do runs f with upstream clients until it succeeds or all upstreams have been tried.
+// If f returns a non-nil release channel, the upstream's request is considered
+// outstanding until the channel is closed.
+func (p *ClientPool) do(ctx context.Context, f func(*Client) (release <-chan struct{}, err error)) error {
+	err := errNoUpstreams
+	tried := make([]bool, len(p.Upstreams))
+	key, sticky := balanceKeyFrom(ctx)
+	for {
+		i := p.pick(tried, key, sticky)
+		if i < 0 {
+			return err
+		}
+		tried[i] = true
+		var release <-chan struct{}
+		release, err = f(p.Upstreams[i].Client)
+		if err != nil && ctx.Err() != nil {
+			// not the upstream's fault
+			p.mu.Lock()
+			p.state[i].outstanding--
+			p.mu.Unlock()
+			return err
+		}
+		if release != nil {
+			go func() {
+				<-release
+				p.done(i, nil)
+			}()
+		} else {
+			p.done(i, err)
+		}
+		if err == nil {
+			return nil
+		}
+	}
+}
+
+// SendAuthenStart sends an AuthenStart to an upstream server. An interactive
+// session counts as outstanding until it is closed.
+func (p *ClientPool) SendAuthenStart(ctx context.Context, as *AuthenStart) (*AuthenReply, *ClientSession, error) {
+	var rep *AuthenReply
+	var cs *ClientSession
+	err := p.do(ctx, func(c *Client) (<-chan struct{}, error) {
+		var err error
+		rep, cs, err = c.SendAuthenStart(ctx, as)
+		if cs != nil {
+			return cs.done, nil
+		}
+		return nil, err
+	})
+	return rep, cs, err
+}
+
+// SendAuthorRequest sends an AuthorRequest to an upstream server.
+func (p *ClientPool) SendAuthorRequest(ctx context.Context, req *AuthorRequest) (*AuthorResponse, error) {
+	var resp *AuthorResponse
+	err := p.do(ctx, func(c *Client) (<-chan struct{}, error) {
+		var err error
+		resp, err = c.SendAuthorRequest(ctx, req)
+		return nil, err
+	})
+	return resp, err
+}
+
+// SendAcctRequest sends an AcctRequest to an upstream server.
+func (p *ClientPool) SendAcctRequest(ctx context.Context, req *AcctRequest) (*AcctReply, error) {
+	var rep *AcctReply
+	err := p.do(ctx, func(c *Client) (<-chan struct{}, error) {
+		var err error
+		rep, err = c.SendAcctRequest(ctx, req)
+		return nil, err
+	})
+	return rep, err
+}
+
+// Close closes the cached connections of all upstream clients.
+func (p *ClientPool) Close() {
+	for _, u := range p.Upstreams {
+		u.Client.Close()
+	}
+}
@@ -0,0 +1,131 @@
+package tacplus
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestClientPoolBalance(t *testing.T) {
+	p := &ClientPool{Upstreams: []Upstream{
+		{Client: &Client{Addr: "a"}, Weight: 3},
+		{Client: &Client{Addr: "b"}},
+	}}
+	counts := make([]int, 2)
+	for i := 0; i < 8; i++ {
+		n := p.pick(make([]bool, 2), "", false)
+		counts[n]++
+		p.done(n, nil)
+	}
+	if counts[0] != 6 || counts[1] != 2 {
+		t.Errorf("weighted round-robin counts %v, want [6 2]", counts)
+	}
+
+	p = &ClientPool{
+		Upstreams: []Upstream{
+			{Client: &Client{Addr: "a"}, Weight: 2},
+			{Client: &Client{Addr: "b"}},
+		},
+		Balance: BalanceLeastOutstanding,
+	}
+	counts = make([]int, 2)
+	for i := 0; i < 6; i++ {
+		counts[p.pick(make([]bool, 2), "", false)]++
+	}
+	if counts[0] != 4 || counts[1] != 2 {
+		t.Errorf("least outstanding counts %v, want [4 2]", counts)
+	}
+	for i, st := range p.Status() {
+		if st.Outstanding != counts[i] || !st.Healthy {
+			t.Errorf("unexpected status %+v", st)
+		}
+	}
+}
+
+func TestClientPoolFailover(t *testing.T) {
+	skipWithoutMD5(t)
+	s, c, err := newTestInstance(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	// address with nothing listening
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := &Client{Addr: l.Addr().String(), ConnConfig: c.ConnConfig}
+	l.Close()
+
+	p := &ClientPool{Upstreams: []Upstream{{Client: down}, {Client: c}}}
+	defer p.Close()
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		resp, err := p.SendAuthorRequest(ctx, testAuthorReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != AuthorStatusPassAdd {
+			t.Errorf("got status %d, want %d", resp.Status, AuthorStatusPassAdd)
+		}
+	}
+	st := p.Status()
+	if st[0].Healthy || !st[1].Healthy {
+		t.Errorf("unexpected status %+v", st)
+	}
+
+	rep, cs, err := p.SendAuthenStart(ctx, testAuthStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs == nil || rep.last() {
+		t.Fatal("expected interactive session")
+	}
+	if n := p.Status()[1].Outstanding; n != 1 {
+		t.Errorf("got %d outstanding requests, want 1", n)
+	}
+	cs.Close()
+	if err = s.err(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClientPoolSticky(t *testing.T) {
+	p := &ClientPool{Balance: BalanceSticky}
+	for _, addr := range []string{"a", "b", "c", "d"} {
+		p.Upstreams = append(p.Upstreams, Upstream{Client: &Client{Addr: addr}})
+	}
+	picks := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		key := string(rune('A' + i))
+		n := p.pick(make([]bool, 4), key, true)
+		p.done(n, nil)
+		picks[key] = n
+		if n2 := p.pick(make([]bool, 4), key, true); n2 != n {
+			t.Errorf("key %s picked %d then %d", key, n, n2)
+		}
+		p.done(n, nil)
+	}
+	used := make(map[int]bool)
+	for _, n := range picks {
+		used[n] = true
+	}
+	if len(used) != 4 {
+		t.Errorf("only %d of 4 upstreams used", len(used))
+	}
+
+	// failing an upstream only moves its keys
+	p.state[0].downUntil = time.Now().Add(time.Minute)
+	for key, n := range picks {
+		n2 := p.pick(make([]bool, 4), key, true)
+		p.done(n2, nil)
+		if n != 0 && n2 != n {
+			t.Errorf("key %s moved from healthy upstream %d to %d", key, n, n2)
+		}
+		if n2 == 0 {
+			t.Errorf("key %s sent to unhealthy upstream", key)
+		}
+	}
+}
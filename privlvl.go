@@ -0,0 +1,165 @@
+package tacplus
+
+import (
+	"context"
+	"strconv"
+)
+
+// Privilege levels defined by RFC 8907. On Cisco devices level 0 permits only a
+// few commands such as logout, level 1 is user EXEC mode and level 15 is
+// privileged EXEC mode.
+const (
+	PrivLvlMin  = 0
+	PrivLvlUser = 1
+	PrivLvlRoot = 15
+)
+
+const privLvlArg = "priv-lvl"
+
+// parsePrivLvl returns the privilege level of a priv-lvl argument value.
+func parsePrivLvl(v string) (uint8, bool) {
+	n, err := strconv.ParseUint(v, 10, 8)
+	if err != nil || n > maxPrivLvl {
+		return 0, false
+	}
+	return uint8(n), true
+}
+
+// argPrivLvl returns the value of the last priv-lvl argument in args, and whether
+// there is one. The value may not be a valid privilege level.
+func argPrivLvl(args []string) (string, bool) {
+	var v string
+	found := false
+	for _, a := range args {
+		if argName(a) == privLvlArg && len(a) > len(privLvlArg) {
+			v, found = a[len(privLvlArg)+1:], true
+		}
+	}
+	return v, found
+}
+
+// GroupPrivLvl returns the highest privilege level in levels of any of groups,
+// and false if none of the groups has a level.
+func GroupPrivLvl(groups []string, levels map[string]uint8) (uint8, bool) {
+	var lvl uint8
+	found := false
+	for _, g := range groups {
+		if l, ok := levels[g]; ok && (!found || l > lvl) {
+			lvl, found = l, true
+		}
+	}
+	if lvl > maxPrivLvl {
+		lvl = maxPrivLvl
+	}
+	return lvl, found
+}
+
+// PrivLvl returns the privilege level granted by the response's priv-lvl argument.
+// It returns false if there is no argument or its value isn't a valid level.
+func (r *AuthorResponse) PrivLvl() (uint8, bool) {
+	v, ok := argPrivLvl(r.Arg)
+	if !ok {
+		return 0, false
+	}
+	return parsePrivLvl(v)
+}
+
+// SetPrivLvl replaces the response's priv-lvl arguments with a single one
+// setting lvl, or adds one. Levels above PrivLvlRoot are set to PrivLvlRoot.
+func (r *AuthorResponse) SetPrivLvl(lvl uint8) {
+	if lvl > maxPrivLvl {
+		lvl = maxPrivLvl
+	}
+	arg := privLvlArg + "=" + strconv.Itoa(int(lvl))
+	args := make([]string, 0, len(r.Arg)+1)
+	for _, a := range r.Arg {
+		if argName(a) != privLvlArg {
+			args = append(args, a)
+		} else if arg != "" {
+			// replace the first priv-lvl argument, dropping any others
+			args = append(args, arg)
+			arg = ""
+		}
+	}
+	if arg != "" {
+		args = append(args, arg)
+	}
+	r.Arg = args
+}
+
+// ClampPrivLvl lowers the priv-lvl argument of r to max if it grants more, or
+// isn't a valid level, returning whether r was changed. A response with more
+// than one priv-lvl argument is left with one, as devices may use any of them.
+func ClampPrivLvl(r *AuthorResponse, max uint8) bool {
+	var lvl uint8
+	n, ok := 0, true
+	for _, a := range r.Arg {
+		if argName(a) != privLvlArg {
+			continue
+		}
+		n++
+		if l, valid := parsePrivLvl(a[min(len(a), len(privLvlArg)+1):]); valid && l <= max {
+			lvl = l
+		} else {
+			ok = false
+		}
+	}
+	if n == 0 || (n == 1 && ok) {
+		return false
+	}
+	if !ok {
+		lvl = max
+	}
+	r.SetPrivLvl(lvl)
+	return true
+}
+
+// PrivLvlHandler is a RequestHandler that stops users being granted a privilege
+// level above their maximum, passing requests to Handler.
+//
+// Successful authorization responses have their priv-lvl argument clamped to the
+// maximum. A response adding to request arguments gets a priv-lvl argument if the
+// request asked for too high a level and the response didn't set one. Enable
+// authentication for a level above the maximum fails without calling Handler.
+type PrivLvlHandler struct {
+	Passthrough
+
+	// MaxPrivLvl returns the highest privilege level user may have, and false
+	// if user has no limit.
+	MaxPrivLvl func(ctx context.Context, user string) (uint8, bool)
+}
+
+// HandleAuthenStart fails enable requests above the user's maximum privilege
+// level, and otherwise calls the HandleAuthenStart method of Handler.
+func (h *PrivLvlHandler) HandleAuthenStart(ctx context.Context, a *AuthenStart, s *ServerSession) *AuthenReply {
+	if a.AuthenService == AuthenServiceEnable {
+		if max, ok := h.MaxPrivLvl(ctx, a.User); ok && a.PrivLvl > max {
+			return &AuthenReply{Status: AuthenStatusFail}
+		}
+	}
+	return h.Passthrough.HandleAuthenStart(ctx, a, s)
+}
+
+// HandleAuthorRequest calls the HandleAuthorRequest method of Handler, limiting
+// the privilege level of its response to the user's maximum.
+func (h *PrivLvlHandler) HandleAuthorRequest(ctx context.Context, a *AuthorRequest, s *ServerSession) *AuthorResponse {
+	r := h.Passthrough.HandleAuthorRequest(ctx, a, s)
+	if r == nil || (r.Status != AuthorStatusPassAdd && r.Status != AuthorStatusPassRepl) {
+		return r
+	}
+	max, ok := h.MaxPrivLvl(ctx, a.User)
+	if !ok {
+		return r
+	}
+	// don't modify a response the handler may reuse
+	resp := *r
+	r = &resp
+	if _, set := argPrivLvl(r.Arg); set {
+		ClampPrivLvl(r, max)
+	} else if v, asked := argPrivLvl(a.Arg); asked && r.Status == AuthorStatusPassAdd {
+		if lvl, valid := parsePrivLvl(v); !valid || lvl > max {
+			r.SetPrivLvl(max)
+		}
+	}
+	return r
+}
@@ -0,0 +1,117 @@
+package tacplus
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestGroupPrivLvl(t *testing.T) {
+	// Cisco conventions: 0 for logout only, 1 for user EXEC, 15 for privileged EXEC
+	levels := map[string]uint8{"readonly": PrivLvlMin, "helpdesk": PrivLvlUser, "netops": PrivLvlRoot}
+	for _, test := range []struct {
+		groups []string
+		lvl    uint8
+		ok     bool
+	}{
+		{nil, 0, false},
+		{[]string{"guests"}, 0, false},
+		{[]string{"readonly"}, PrivLvlMin, true},
+		{[]string{"readonly", "helpdesk"}, PrivLvlUser, true},
+		{[]string{"netops", "helpdesk", "guests"}, PrivLvlRoot, true},
+	} {
+		lvl, ok := GroupPrivLvl(test.groups, levels)
+		if lvl != test.lvl || ok != test.ok {
+			t.Errorf("%v: got %d %v, want %d %v", test.groups, lvl, ok, test.lvl, test.ok)
+		}
+	}
+}
+
+func TestClampPrivLvl(t *testing.T) {
+	for _, test := range []struct {
+		args    []string
+		max     uint8
+		want    []string
+		changed bool
+	}{
+		{[]string{"service=shell"}, PrivLvlUser, []string{"service=shell"}, false},
+		{[]string{"priv-lvl=1"}, PrivLvlUser, []string{"priv-lvl=1"}, false},
+		{[]string{"priv-lvl=15", "timeout=5"}, PrivLvlUser, []string{"priv-lvl=1", "timeout=5"}, true},
+		{[]string{"priv-lvl*15"}, PrivLvlMin, []string{"priv-lvl=0"}, true},
+		{[]string{"priv-lvl=admin"}, PrivLvlRoot, []string{"priv-lvl=15"}, true},
+		{[]string{"priv-lvl=1", "priv-lvl=15"}, PrivLvlUser, []string{"priv-lvl=1"}, true},
+		{[]string{"priv-lvl=15", "timeout=5", "priv-lvl*1"}, PrivLvlUser, []string{"priv-lvl=1", "timeout=5"}, true},
+		{[]string{"priv-lvl=0", "priv-lvl=1"}, PrivLvlUser, []string{"priv-lvl=1"}, true},
+	} {
+		r := &AuthorResponse{Status: AuthorStatusPassAdd, Arg: test.args}
+		changed := ClampPrivLvl(r, test.max)
+		if changed != test.changed || !reflect.DeepEqual(r.Arg, test.want) {
+			t.Errorf("%v max %d: got %v %v, want %v %v", test.args, test.max, r.Arg, changed, test.want, test.changed)
+		}
+	}
+
+	r := &AuthorResponse{}
+	if _, ok := r.PrivLvl(); ok {
+		t.Error("PrivLvl of response without argument is valid")
+	}
+	r.SetPrivLvl(20)
+	if lvl, ok := r.PrivLvl(); !ok || lvl != PrivLvlRoot {
+		t.Errorf("got priv-lvl %d %v, want %d", lvl, ok, PrivLvlRoot)
+	}
+	r.Arg = []string{"priv-lvl=1", "service=shell", "priv-lvl=15"}
+	r.SetPrivLvl(PrivLvlUser)
+	if want := []string{"priv-lvl=1", "service=shell"}; !reflect.DeepEqual(r.Arg, want) {
+		t.Errorf("got %v, want %v", r.Arg, want)
+	}
+}
+
+func TestPrivLvlHandler(t *testing.T) {
+	skipWithoutMD5(t)
+	// testHandler grants user priv-lvl=5 and fred priv-lvl=1
+	max := map[string]uint8{"user": PrivLvlUser, "fred": PrivLvlRoot}
+	h := testHandler
+	h.Handler = &PrivLvlHandler{
+		Passthrough: Passthrough{testHandler.Handler},
+		MaxPrivLvl: func(ctx context.Context, user string) (uint8, bool) {
+			lvl, ok := max[user]
+			return lvl, ok
+		},
+	}
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	ctx := context.Background()
+	for _, test := range []struct {
+		user string
+		lvl  uint8
+	}{
+		{"user", PrivLvlUser},
+		{"fred", PrivLvlUser},
+	} {
+		req := *testAuthorReq
+		req.User = test.user
+		resp, err := c.SendAuthorRequest(ctx, &req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lvl, ok := resp.PrivLvl(); !ok || lvl != test.lvl {
+			t.Errorf("%s: got priv-lvl %d %v, want %d", test.user, lvl, ok, test.lvl)
+		}
+	}
+
+	// enable above the maximum fails
+	start := *testAuthStart
+	start.AuthenService = AuthenServiceEnable
+	start.User = "user"
+	start.PrivLvl = PrivLvlRoot
+	rep, _, err := c.SendAuthenStart(ctx, &start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Status != AuthenStatusFail {
+		t.Errorf("got enable status %d, want %d", rep.Status, AuthenStatusFail)
+	}
+}
@@ -0,0 +1,228 @@
+package tacplus
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strings"
+	"time"
+)
+
+// A Sender sends TACACS+ requests to a server. It is implemented by *Client
+// and *ClientPool.
+type Sender interface {
+	SendAuthenStart(ctx context.Context, as *AuthenStart) (*AuthenReply, *ClientSession, error)
+	SendAuthorRequest(ctx context.Context, req *AuthorRequest) (*AuthorResponse, error)
+	SendAcctRequest(ctx context.Context, req *AcctRequest) (*AcctReply, error)
+}
+
+// errUpstream is the message sent to clients when a Proxy request fails.
+const errUpstream = "upstream server error"
+
+// Proxy is a RequestHandler that forwards requests to an upstream server chosen
+// by Routes, or Upstream if no route matches, relaying interactive authentication
+// sessions between the client and server.
+//
+// If Mirror is set, accounting requests (and authorization requests if
+// MirrorAuthor is set) are also sent to Mirror asynchronously, allowing two
+// servers to be run in parallel. Mirror replies are discarded.
+//
+// Requests are sent with the address of the NAS as their balance key (see
+// WithBalanceKey), so a ClientPool with the BalanceSticky policy sends all
+// requests from a NAS to the same upstream.
+type Proxy struct {
+	Upstream Sender  // server requests are forwarded to if no route matches
+	Routes   []Route // optional routes, the first matching route is used
+
+	Mirror        Sender        // optional server requests are mirrored to
+	MirrorAuthor  bool          // mirror authorization requests as well as accounting
+	MirrorTimeout time.Duration // maximum time for a mirrored request, ignored if zero
+}
+
+// Route sends the requests that match it to an Upstream.
+// Unset match fields match any request.
+//
+// Users are matched by realm, the suffix after the last '@' in the user name.
+// Interactive authentications that don't supply the user in the AuthenStart
+// can only be matched by service and port.
+type Route struct {
+	Realm   string  // user realm, matched case-insensitively
+	Service []uint8 // AuthenService values
+	Port    string  // port name pattern, in the syntax of path.Match
+
+	StripRealm bool   // remove "@realm" from the user name when forwarding
+	Upstream   Sender // server matching requests are forwarded to
+}
+
+// splitRealm splits user into the user name and realm.
+func splitRealm(user string) (string, string) {
+	if i := strings.LastIndexByte(user, '@'); i >= 0 {
+		return user[:i], user[i+1:]
+	}
+	return user, ""
+}
+
+// match reports whether the route matches a request with the given fields.
+func (r *Route) match(user string, service uint8, port string) bool {
+	if r.Realm != "" {
+		if _, realm := splitRealm(user); !strings.EqualFold(realm, r.Realm) {
+			return false
+		}
+	}
+	if len(r.Service) > 0 {
+		found := false
+		for _, s := range r.Service {
+			found = found || s == service
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.Port != "" {
+		if ok, _ := path.Match(r.Port, port); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+var errNoRoute = errors.New("no route for request")
+
+// route returns the upstream for a request with the given fields, and the
+// user name to forward.
+func (p *Proxy) route(user string, service uint8, port string) (Sender, string, error) {
+	for i := range p.Routes {
+		r := &p.Routes[i]
+		if r.match(user, service, port) {
+			if r.StripRealm {
+				user, _ = splitRealm(user)
+			}
+			return r.Upstream, user, nil
+		}
+	}
+	if p.Upstream == nil {
+		return nil, user, errNoRoute
+	}
+	return p.Upstream, user, nil
+}
+
+// upstreamError logs err and returns the ErrorReply sent to the client.
+func upstreamError(s *ServerSession, err error) *ErrorReply {
+	s.Log(err)
+	return &ErrorReply{Msg: errUpstream}
+}
+
+// mirror runs f in a new goroutine with a context carrying the values of ctx.
+func (p *Proxy) mirror(ctx context.Context, s *ServerSession, f func(context.Context) error) {
+	ctx = valuesContext{ctx}
+	go func() {
+		if p.MirrorTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, p.MirrorTimeout)
+			defer cancel()
+		}
+		if err := f(ctx); err != nil {
+			s.Log("mirror:", err)
+		}
+	}()
+}
+
+// HandleAuthenStart forwards the authentication to its upstream, relaying prompts
+// from the server to the client until the session finishes.
+func (p *Proxy) HandleAuthenStart(ctx context.Context, a *AuthenStart, s *ServerSession) *AuthenReply {
+	ctx = WithBalanceKey(ctx, remoteHost(s))
+	up, user, err := p.route(a.User, a.AuthenService, a.Port)
+	if err != nil {
+		return upstreamError(s, err).AuthenReply()
+	}
+	if user != a.User {
+		as := *a
+		as.User = user
+		a = &as
+	}
+	rep, cs, err := up.SendAuthenStart(ctx, a)
+	if err != nil {
+		return upstreamError(s, err).AuthenReply()
+	}
+	for cs != nil {
+		var c *AuthenContinue
+		switch rep.Status {
+		case AuthenStatusGetData:
+			c, err = s.GetData(ctx, rep.ServerMsg, rep.NoEcho)
+		case AuthenStatusGetUser:
+			c, err = s.GetUser(ctx, rep.ServerMsg)
+		default:
+			c, err = s.GetPass(ctx, rep.ServerMsg)
+		}
+		var ae *AbortError
+		if errors.As(err, &ae) {
+			if err = cs.Abort(ctx, ae.Reason); err != nil {
+				s.Log(err)
+			}
+			return nil
+		}
+		if err != nil {
+			_ = cs.Abort(ctx, "client session failed")
+			return nil
+		}
+		if rep, err = cs.Continue(ctx, c.Message); err != nil {
+			return upstreamError(s, err).AuthenReply()
+		}
+		if rep.last() {
+			cs = nil
+		}
+	}
+	return rep
+}
+
+// HandleAuthorRequest forwards the request to its upstream, mirroring it if MirrorAuthor is set.
+func (p *Proxy) HandleAuthorRequest(ctx context.Context, a *AuthorRequest, s *ServerSession) *AuthorResponse {
+	ctx = WithBalanceKey(ctx, remoteHost(s))
+	if p.Mirror != nil && p.MirrorAuthor {
+		p.mirror(ctx, s, func(ctx context.Context) error {
+			_, err := p.Mirror.SendAuthorRequest(ctx, a)
+			return err
+		})
+	}
+	up, user, err := p.route(a.User, a.AuthenService, a.Port)
+	if err != nil {
+		return upstreamError(s, err).AuthorResponse()
+	}
+	fwd := a
+	if user != a.User {
+		req := *a
+		req.User = user
+		fwd = &req
+	}
+	r, err := up.SendAuthorRequest(ctx, fwd)
+	if err != nil {
+		return upstreamError(s, err).AuthorResponse()
+	}
+	return r
+}
+
+// HandleAcctRequest forwards the request to its upstream, mirroring it if Mirror is set.
+func (p *Proxy) HandleAcctRequest(ctx context.Context, a *AcctRequest, s *ServerSession) *AcctReply {
+	ctx = WithBalanceKey(ctx, remoteHost(s))
+	if p.Mirror != nil {
+		p.mirror(ctx, s, func(ctx context.Context) error {
+			_, err := p.Mirror.SendAcctRequest(ctx, a)
+			return err
+		})
+	}
+	up, user, err := p.route(a.User, a.AuthenService, a.Port)
+	if err != nil {
+		return upstreamError(s, err).AcctReply()
+	}
+	fwd := a
+	if user != a.User {
+		req := *a
+		req.User = user
+		fwd = &req
+	}
+	r, err := up.SendAcctRequest(ctx, fwd)
+	if err != nil {
+		return upstreamError(s, err).AcctReply()
+	}
+	return r
+}
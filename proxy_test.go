@@ -0,0 +1,220 @@
+package tacplus
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// authenticate runs an interactive ASCII login for user with password using c.
+func authenticate(ctx context.Context, c *Client, user, password string) (*AuthenReply, error) {
+	rep, s, err := c.SendAuthenStart(ctx, testAuthStart)
+	if err != nil {
+		return nil, err
+	}
+	for s != nil && !rep.last() {
+		msg := user
+		if rep.Status == AuthenStatusGetPass {
+			msg = password
+		}
+		if rep, err = s.Continue(ctx, msg); err != nil {
+			return nil, err
+		}
+	}
+	return rep, nil
+}
+
+func TestProxy(t *testing.T) {
+	skipWithoutMD5(t)
+	us, uc, err := newTestInstance(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer us.close()
+
+	count := &countAcctHandler{RequestHandler: testHandler.Handler}
+	mh := testHandler
+	mh.Handler = count
+	ms, mc, err := newTestInstance(&mh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ms.close()
+
+	ph := testHandler
+	ph.Handler = &Proxy{Upstream: uc, Mirror: mc}
+	ps, c, err := newTestInstance(&ph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.close()
+
+	ctx := context.Background()
+	resp, err := c.SendAuthorRequest(ctx, testAuthorReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != AuthorStatusPassAdd || len(resp.Arg) != 1 || resp.Arg[0] != "priv-lvl=5" {
+		t.Errorf("unexpected authorization response %+v", resp)
+	}
+
+	for _, test := range []struct {
+		password string
+		status   uint8
+	}{
+		{"password123", AuthenStatusPass},
+		{"wrong", AuthenStatusFail},
+	} {
+		rep, err := authenticate(ctx, c, "user", test.password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rep.Status != test.status {
+			t.Errorf("got authentication status %d, want %d", rep.Status, test.status)
+		}
+	}
+
+	rep, err := c.SendAcctRequest(ctx, testAcctReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Status != AcctStatusSuccess {
+		t.Errorf("got accounting status %d, want %d", rep.Status, AcctStatusSuccess)
+	}
+	for i := 0; atomic.LoadInt32(&count.n) == 0; i++ {
+		if i == 10 {
+			t.Fatal("accounting request not mirrored")
+		}
+		time.Sleep(timeScale)
+	}
+
+	// upstream down
+	us.close()
+	uc.Close()
+	resp, err = c.SendAuthorRequest(ctx, testAuthorReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != AuthorStatusError || resp.ServerMsg != errUpstream {
+		t.Errorf("unexpected response with upstream down %+v", resp)
+	}
+
+	if err = ms.err(); err != nil {
+		t.Error(err)
+	}
+}
+
+// userHandler records the user of the last authorization request.
+type userHandler struct {
+	RequestHandler
+	user chan string
+}
+
+func (h userHandler) HandleAuthorRequest(ctx context.Context, a *AuthorRequest, s *ServerSession) *AuthorResponse {
+	h.user <- a.User
+	return &AuthorResponse{Status: AuthorStatusPassAdd}
+}
+
+func TestProxyRoutes(t *testing.T) {
+	skipWithoutMD5(t)
+	var users []chan string
+	var clients []*Client
+	for i := 0; i < 2; i++ {
+		uh := userHandler{testHandler.Handler, make(chan string, 1)}
+		h := testHandler
+		h.Handler = uh
+		s, c, err := newTestInstance(&h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.close()
+		users = append(users, uh.user)
+		clients = append(clients, c)
+	}
+
+	ph := testHandler
+	ph.Handler = &Proxy{Routes: []Route{
+		{Realm: "corp", StripRealm: true, Upstream: clients[0]},
+		{Port: "tty*", Upstream: clients[1]},
+	}}
+	ps, c, err := newTestInstance(&ph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.close()
+
+	for _, test := range []struct {
+		user, port string
+		upstream   int
+		sent       string
+	}{
+		{"fred@CORP", "vty0", 0, "fred"},
+		{"fred@lab", "tty1", 1, "fred@lab"},
+		{"fred@lab", "vty0", -1, ""},
+	} {
+		req := *testAuthorReq
+		req.User, req.Port = test.user, test.port
+		resp, err := c.SendAuthorRequest(context.Background(), &req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.upstream < 0 {
+			if resp.Status != AuthorStatusError {
+				t.Errorf("%s %s: got status %d for unrouted request", test.user, test.port, resp.Status)
+			}
+			continue
+		}
+		select {
+		case user := <-users[test.upstream]:
+			if user != test.sent {
+				t.Errorf("%s %s: upstream got user %q, want %q", test.user, test.port, user, test.sent)
+			}
+		default:
+			t.Errorf("%s %s: request not sent to upstream %d", test.user, test.port, test.upstream)
+		}
+	}
+}
+
+// deadlineSender is a Sender reporting the time left before the deadline of
+// accounting requests, or zero if there is none.
+type deadlineSender struct {
+	Sender
+	left chan time.Duration
+}
+
+func (d deadlineSender) SendAcctRequest(ctx context.Context, req *AcctRequest) (*AcctReply, error) {
+	var left time.Duration
+	if t, ok := ctx.Deadline(); ok {
+		left = time.Until(t)
+	}
+	d.left <- left
+	return &AcctReply{Status: AcctStatusSuccess}, nil
+}
+
+func TestProxyMirrorTimeout(t *testing.T) {
+	skipWithoutMD5(t)
+	us, uc, err := newTestInstance(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer us.close()
+
+	mirror := deadlineSender{uc, make(chan time.Duration, 1)}
+	ph := testHandler
+	ph.ConnConfig.HandlerTimeout = timeScale
+	ph.Handler = &Proxy{Upstream: uc, Mirror: mirror, MirrorTimeout: 10 * timeScale}
+	ps, c, err := newTestInstance(&ph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.close()
+	defer c.Close()
+
+	if _, err = c.SendAcctRequest(context.Background(), testAcctReq); err != nil {
+		t.Fatal(err)
+	}
+	if left := <-mirror.left; left <= 5*timeScale {
+		t.Errorf("mirror had %v before its deadline, want MirrorTimeout %v", left, 10*timeScale)
+	}
+}
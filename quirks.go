@@ -0,0 +1,114 @@
+package tacplus
+
+import (
+	"net"
+	"strings"
+)
+
+// Quirks is a set of flags enabling workarounds for peers that deviate from
+// the TACACS+ protocol. Workarounds are only applied when enabled.
+type Quirks uint32
+
+// Individual quirks
+const (
+	// QuirkMinorVersion accepts requests with an unexpected minor version
+	// without logging, replying with the received version. It overrides
+	// the ConnConfig VersionPolicy.
+	QuirkMinorVersion Quirks = 1 << iota
+
+	// QuirkSingleConnect ignores the single-connection header flag, for peers
+	// that set it but don't multiplex sessions. The connection is closed after
+	// each session and the flag is not set in replies.
+	QuirkSingleConnect
+
+	// QuirkEmptyAcctReply treats an accounting reply with an empty body
+	// as a successful reply.
+	QuirkEmptyAcctReply
+
+	// QuirkArgSpaces collapses runs of white space in the cmd and cmd-arg
+	// arguments of authorization requests, dropping cmd-arg arguments left
+	// empty, for devices that split commands typed with extra spaces.
+	QuirkArgSpaces
+
+	// QuirkMissingService adds a service=shell argument to authorization
+	// requests with a cmd argument but no service argument.
+	QuirkMissingService
+
+	// QuirkServiceCase lowercases the value of the service argument of
+	// authorization requests.
+	QuirkServiceCase
+
+	// QuirkTruncatedArgs accepts authorization and accounting packets whose
+	// body ends part way through their arguments, keeping the arguments read
+	// and logging the truncation, for devices declaring more arguments than
+	// they send. A wrong secret can look the same, so enable it only for the
+	// devices that need it.
+	QuirkTruncatedArgs
+)
+
+// Quirk profiles bundling the workarounds needed for particular devices.
+const (
+	// QuirkCiscoIOS is for older Cisco IOS images that send minor version one
+	// for ASCII logins.
+	QuirkCiscoIOS = QuirkMinorVersion
+
+	// QuirkOldHP is for older HP switches that set the single-connection flag
+	// without multiplexing, and servers answering them with empty accounting replies.
+	QuirkOldHP = QuirkSingleConnect | QuirkEmptyAcctReply
+
+	// QuirkNormalizeArgs canonicalizes authorization request arguments, so
+	// one set of authorization rules works for devices from several vendors.
+	QuirkNormalizeArgs = QuirkArgSpaces | QuirkMissingService | QuirkServiceCase
+)
+
+// Has reports whether all the quirks in q2 are set in q.
+func (q Quirks) Has(q2 Quirks) bool {
+	return q&q2 == q2
+}
+
+// peerQuirks returns the quirks enabled for a connection to addr.
+func (c *ConnConfig) peerQuirks(addr net.Addr) Quirks {
+	q := c.Quirks
+	if c.QuirksFor != nil && addr != nil {
+		q |= c.QuirksFor(addr)
+	}
+	return q
+}
+
+// NormalizeArgs returns authorization request arguments args canonicalized by
+// the argument quirks in q. Args is returned unchanged if there is nothing to do.
+func (q Quirks) NormalizeArgs(args []string) []string {
+	if q&QuirkNormalizeArgs == 0 {
+		return args
+	}
+	out := make([]string, 0, len(args)+1)
+	hasCmd, hasService := false, false
+	for _, a := range args {
+		name := argName(a)
+		if len(a) == len(name) {
+			out = append(out, a)
+			continue
+		}
+		sep, v := a[len(name)], a[len(name)+1:]
+		switch name {
+		case "cmd", "cmd-arg":
+			hasCmd = hasCmd || name == "cmd"
+			if q.Has(QuirkArgSpaces) {
+				v = strings.Join(strings.Fields(v), " ")
+				if v == "" && name == "cmd-arg" {
+					continue
+				}
+			}
+		case "service":
+			hasService = true
+			if q.Has(QuirkServiceCase) {
+				v = strings.ToLower(v)
+			}
+		}
+		out = append(out, name+string(sep)+v)
+	}
+	if hasCmd && !hasService && q.Has(QuirkMissingService) {
+		out = append([]string{"service=shell"}, out...)
+	}
+	return out
+}
@@ -0,0 +1,203 @@
+package tacplus
+
+import (
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestQuirkMinorVersion(t *testing.T) {
+	skipWithoutMD5(t)
+	h := testHandler
+	h.ConnConfig.QuirksFor = func(addr net.Addr) Quirks { return QuirkCiscoIOS }
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	as := &AuthenStart{Action: AuthenActionLogin, AuthenType: AuthenTypeASCII, User: "user"}
+	body, _ := as.MarshalBinary()
+	ctx := context.Background()
+	rs, err := c.NewRawSession(ctx, verDefaultMinorOne, sessTypeAuthen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Close()
+	if err = rs.WritePacket(ctx, body); err != nil {
+		t.Fatal(err)
+	}
+	p, err := rs.ReadPacket(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep := new(AuthenReply)
+	if err = rep.UnmarshalBinary(p.Body); err != nil {
+		t.Fatal(err)
+	}
+	if rep.Status == AuthenStatusError || p.Version != verDefaultMinorOne {
+		t.Errorf("unexpected reply version %#x status %d: %s", p.Version, rep.Status, rep.ServerMsg)
+	}
+	if err = s.err(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQuirkEmptyAcctReply(t *testing.T) {
+	skipWithoutMD5(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		// reply to a single request with an empty body
+		nc, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer nc.Close()
+		b := make([]byte, hdrLen)
+		if _, err = io.ReadFull(nc, b); err != nil {
+			return
+		}
+		h, _ := ParseHeader(b)
+		if _, err = io.CopyN(io.Discard, nc, int64(h.BodyLen)); err != nil {
+			return
+		}
+		h.SeqNo++
+		h.BodyLen = 0
+		_, _ = nc.Write(h.AppendTo(nil))
+	}()
+
+	c := &Client{
+		Addr:       l.Addr().String(),
+		ConnConfig: ConnConfig{Secret: testSecret, Quirks: QuirkOldHP},
+	}
+	rep, err := c.SendAcctRequest(context.Background(), testAcctReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Status != AcctStatusSuccess {
+		t.Errorf("got status %d, want %d", rep.Status, AcctStatusSuccess)
+	}
+}
+
+func TestNormalizeArgs(t *testing.T) {
+	for _, test := range []struct {
+		q    Quirks
+		args []string
+		want []string
+	}{
+		{0, []string{"service=Shell", "cmd=show  ip", "cmd-arg= "}, []string{"service=Shell", "cmd=show  ip", "cmd-arg= "}},
+		{QuirkArgSpaces, []string{"service=shell", "cmd=show", "cmd-arg=", "cmd-arg=ip  route ", "cmd-arg=<cr>"}, []string{"service=shell", "cmd=show", "cmd-arg=ip route", "cmd-arg=<cr>"}},
+		{QuirkMissingService, []string{"cmd=show", "cmd-arg=version"}, []string{"service=shell", "cmd=show", "cmd-arg=version"}},
+		{QuirkMissingService, []string{"protocol=ip"}, []string{"protocol=ip"}},
+		{QuirkServiceCase, []string{"service=SHELL", "priv-lvl*15"}, []string{"service=shell", "priv-lvl*15"}},
+		{QuirkNormalizeArgs, []string{"cmd=show   running-config", "cmd-arg= "}, []string{"service=shell", "cmd=show running-config"}},
+	} {
+		got := test.q.NormalizeArgs(test.args)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%#x %q: got %q, want %q", test.q, test.args, got, test.want)
+		}
+	}
+}
+
+func TestQuirkNormalizeArgs(t *testing.T) {
+	skipWithoutMD5(t)
+	m, err := NewCommandMatcher(CommandRule{Permit: true, Pattern: "^show version$"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var service string
+	policy := PolicyFunc(func(ctx context.Context, req *AuthorRequest, s *ServerSession) (*AuthorResponse, error) {
+		service, _ = argValue(req.Arg, "service")
+		return m.Authorize(ctx, req, s)
+	})
+	h := testHandler
+	h.Handler = &PolicyHandler{Passthrough: Passthrough{testHandler.Handler}, Policy: policy}
+	h.ConnConfig.Quirks = QuirkNormalizeArgs
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	req := *testAuthorReq
+	req.Arg = []string{"cmd=show", "cmd-arg=", "cmd-arg=version"}
+	resp, err := c.SendAuthorRequest(context.Background(), &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != AuthorStatusPassAdd || service != "shell" {
+		t.Errorf("got status %d service %q, want %d %q", resp.Status, service, AuthorStatusPassAdd, "shell")
+	}
+}
+
+// acctArgsHandler records the arguments of accounting requests.
+type acctArgsHandler struct {
+	RequestHandler
+	args chan []string
+}
+
+func (h acctArgsHandler) HandleAcctRequest(ctx context.Context, a *AcctRequest, s *ServerSession) *AcctReply {
+	h.args <- a.Arg
+	return h.RequestHandler.HandleAcctRequest(ctx, a, s)
+}
+
+func TestQuirkTruncatedArgs(t *testing.T) {
+	skipWithoutMD5(t)
+	body, _ := testAcctReq.MarshalBinary()
+	// cut the body short inside the last argument
+	body = body[:len(body)-2]
+	req := new(AcctRequest)
+	if err := req.UnmarshalBinary(body); err != ErrTruncated {
+		t.Fatalf("got error %v, want %v", err, ErrTruncated)
+	}
+	if want := testAcctReq.Arg[:2]; !reflect.DeepEqual(req.Arg, want) {
+		t.Errorf("got args %q, want %q", req.Arg, want)
+	}
+
+	for _, quirk := range []bool{false, true} {
+		h := testHandler
+		ah := acctArgsHandler{testHandler.Handler, make(chan []string, 1)}
+		h.Handler = ah
+		if quirk {
+			h.ConnConfig.Quirks = QuirkTruncatedArgs
+		}
+		s, c, err := newTestInstance(&h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := context.Background()
+		rs, err := c.NewRawSession(ctx, verDefault, sessTypeAcct)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = rs.WritePacket(ctx, body); err != nil {
+			t.Fatal(err)
+		}
+		p, err := rs.ReadPacket(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rep := new(AcctReply)
+		if err = rep.UnmarshalBinary(p.Body); err != nil {
+			t.Fatal(err)
+		}
+		if quirk {
+			if rep.Status != AcctStatusSuccess {
+				t.Errorf("got status %d, want %d", rep.Status, AcctStatusSuccess)
+			}
+			if args := <-ah.args; !reflect.DeepEqual(args, testAcctReq.Arg[:2]) {
+				t.Errorf("handler got args %q, want %q", args, testAcctReq.Arg[:2])
+			}
+		} else if rep.Status != AcctStatusError {
+			t.Errorf("got status %d, want %d", rep.Status, AcctStatusError)
+		}
+		rs.Close()
+		s.close()
+	}
+}
@@ -0,0 +1,145 @@
+package tacplus
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+var errSeqNoOverflow = errors.New("sequence number overflow, session must be restarted")
+
+// RawPacket is a TACACS+ packet with a decoded header and decrypted body.
+type RawPacket struct {
+	Header
+	Body []byte
+}
+
+// RawSession is a low-level TACACS+ session that exchanges packet bodies that
+// are not decoded by this package. It can be used to implement vendor extensions
+// or packet types not supported by Client and RequestHandler.
+//
+// Sequence numbers, session ids, encryption and the header length field are
+// handled by the session. Written packets use the version, type and flags of
+// the session's header.
+type RawSession struct {
+	*session
+	hdr   []byte                                    // header for written packets
+	last  uint8                                     // sequence number of last packet read or written
+	write func(ctx context.Context, p []byte) error // writes a packet for the session
+}
+
+// ReadPacket reads the next packet from the peer.
+func (s *RawSession) ReadPacket(ctx context.Context) (*RawPacket, error) {
+	p, err := s.readPacket(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.last = p[hdrSeqNo]
+	return rawPacket(p), nil
+}
+
+// WritePacket writes a packet with the given body to the peer.
+func (s *RawSession) WritePacket(ctx context.Context, body []byte) error {
+	if s.last == 0xff {
+		return errSeqNoOverflow
+	}
+	p := make([]byte, hdrLen, hdrLen+len(body))
+	copy(p, s.hdr)
+	p[hdrSeqNo] = s.last
+	p = append(p, body...)
+	if err := s.write(ctx, p); err != nil {
+		return err
+	}
+	s.last = p[hdrSeqNo]
+	return nil
+}
+
+// Header returns the header used for packets written by the session.
+// The SeqNo and BodyLen fields are set when each packet is written.
+func (s *RawSession) Header() Header {
+	h, _ := ParseHeader(s.hdr)
+	return h
+}
+
+// Log output using the connections ConnConfig Log function.
+func (s *RawSession) Log(v ...interface{}) {
+	s.c.log(v...)
+}
+
+// Close closes the session.
+func (s *RawSession) Close() {
+	s.close()
+}
+
+// RemoteAddr returns the remote network address for the session.
+func (s *RawSession) RemoteAddr() net.Addr {
+	return s.session.c.nc.RemoteAddr()
+}
+
+// LocalAddr returns the local network address for the session.
+func (s *RawSession) LocalAddr() net.Addr {
+	return s.session.c.nc.LocalAddr()
+}
+
+func rawPacket(p []byte) *RawPacket {
+	h, _ := ParseHeader(p)
+	return &RawPacket{Header: h, Body: p[hdrLen:]}
+}
+
+// A RawHandler serves a RawSession for a session whose first packet is p.
+// The session is closed when it returns.
+type RawHandler func(ctx context.Context, p *RawPacket, s *RawSession)
+
+// serveRaw serves a session using the RawHandler h.
+func (s *ServerSession) serveRaw(ctx context.Context, h RawHandler) {
+	rs := &RawSession{
+		session: s.session,
+		hdr:     append([]byte(nil), s.p[:hdrLen]...),
+		last:    s.p[hdrSeqNo],
+		write:   s.writePacket,
+	}
+	if s.c.HandlerTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.c.HandlerTimeout)
+		defer cancel()
+	}
+	h(ctx, rawPacket(s.p), rs)
+}
+
+// NewRawSession starts a new RawSession with the server, writing packets with
+// version ver and packet type t. It is not retried if the connection fails.
+// The session must be closed when finished with.
+func (c *Client) NewRawSession(ctx context.Context, ver, t uint8) (*RawSession, error) {
+	release, err := c.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	addr := c.Addr
+	if err = c.breakerAllow(addr); err != nil {
+		release()
+		return nil, err
+	}
+	s, _, err := c.newSession(ctx, true)
+	c.breakerReport(addr, err)
+	if err != nil {
+		release()
+		if de, ok := err.(*dialError); ok {
+			err = de.err
+		}
+		return nil, err
+	}
+	go func() {
+		<-s.done
+		release()
+	}()
+
+	h := make([]byte, hdrLen)
+	h[hdrVer] = ver
+	h[hdrType] = t
+	if s.c.Mux && !s.c.LegacyMux {
+		h[hdrFlags] = hdrFlagSingleConnect
+	}
+	binary.BigEndian.PutUint32(h[hdrID:], s.id)
+	return &RawSession{session: s, hdr: h, write: s.writePacket}, nil
+}
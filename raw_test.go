@@ -0,0 +1,78 @@
+//go:build !tacplus_nomd5
+
+package tacplus
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+const testRawType = 0x7f
+
+func TestRawSession(t *testing.T) {
+	h := testHandler
+	h.RawHandler = func(ctx context.Context, p *RawPacket, s *RawSession) {
+		// echo bodies back in upper case until an empty body is read
+		for {
+			if p.Type != testRawType {
+				s.Log("bad type", p.Type)
+				return
+			}
+			if len(p.Body) == 0 {
+				return
+			}
+			if err := s.WritePacket(ctx, bytes.ToUpper(p.Body)); err != nil {
+				s.Log(err)
+				return
+			}
+			var err error
+			if p, err = s.ReadPacket(ctx); err != nil {
+				s.Log(err)
+				return
+			}
+		}
+	}
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	ctx := context.Background()
+	rs, err := c.NewRawSession(ctx, verDefault, testRawType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Close()
+	for i, msg := range []string{"one", "two", "three"} {
+		if err = rs.WritePacket(ctx, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		p, err := rs.ReadPacket(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := bytes.ToUpper([]byte(msg)); !bytes.Equal(p.Body, want) {
+			t.Errorf("got body %q, want %q", p.Body, want)
+		}
+		if want := uint8(2 * (i + 1)); p.SeqNo != want {
+			t.Errorf("got sequence number %d, want %d", p.SeqNo, want)
+		}
+		if p.Type != testRawType || p.Version != verDefault {
+			t.Errorf("bad header %+v", p.Header)
+		}
+	}
+	if err = rs.WritePacket(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	// server closes session without a reply
+	ctx, cancel := context.WithTimeout(ctx, timeScale)
+	defer cancel()
+	if _, err = rs.ReadPacket(ctx); err == nil {
+		t.Error("expected no reply")
+	}
+	if err = s.err(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,68 @@
+package tacplus
+
+import (
+	"context"
+	"errors"
+	"net"
+	"runtime"
+)
+
+// ErrReusePortUnsupported is returned by ListenReusePort on platforms without
+// SO_REUSEPORT.
+var ErrReusePortUnsupported = errors.New("tacplus: SO_REUSEPORT not supported on this platform")
+
+// ListenReusePort returns n listeners on the local network address addr, all
+// sharing the port with the SO_REUSEPORT socket option so the kernel spreads
+// incoming connections between them. If addr has port zero the listeners share
+// the port chosen for the first. If n is zero or less, one listener is opened
+// for each CPU usable by the process.
+//
+// ErrReusePortUnsupported is returned on platforms without SO_REUSEPORT.
+func ListenReusePort(ctx context.Context, network, addr string, n int) ([]net.Listener, error) {
+	if reusePortControl == nil {
+		return nil, ErrReusePortUnsupported
+	}
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	lc := net.ListenConfig{Control: reusePortControl}
+	ls := make([]net.Listener, 0, n)
+	for len(ls) < n {
+		l, err := lc.Listen(ctx, network, addr)
+		if err != nil {
+			for _, l := range ls {
+				_ = l.Close()
+			}
+			return nil, err
+		}
+		if len(ls) == 0 {
+			addr = l.Addr().String()
+		}
+		ls = append(ls, l)
+	}
+	return ls, nil
+}
+
+// ListenAndServeReusePort listens on n sockets sharing the local network
+// address addr, as opened by ListenReusePort, and serves each on its own
+// goroutine, spreading the cost of accepting connections across CPUs. It
+// returns once every listener has stopped. If one stops with an error the
+// others are closed, and the error is returned.
+func (srv *Server) ListenAndServeReusePort(network, addr string, n int) error {
+	ls, err := ListenReusePort(context.Background(), network, addr, n)
+	if err != nil {
+		return err
+	}
+	errc := make(chan error, len(ls))
+	for _, l := range ls {
+		go func(l net.Listener) { errc <- srv.Serve(l) }(l)
+	}
+	err = <-errc
+	for _, l := range ls {
+		_ = l.Close()
+	}
+	for range ls[1:] {
+		<-errc
+	}
+	return err
+}
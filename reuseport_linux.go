@@ -0,0 +1,7 @@
+//go:build linux && !(mips || mipsle || mips64 || mips64le)
+
+package tacplus
+
+// soReusePort is SO_REUSEPORT, which the syscall package doesn't define for
+// Linux.
+const soReusePort = 0xf
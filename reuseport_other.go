@@ -0,0 +1,8 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package tacplus
+
+import "syscall"
+
+// reusePortControl is nil as SO_REUSEPORT isn't available.
+var reusePortControl func(network, address string, rc syscall.RawConn) error
@@ -0,0 +1,71 @@
+package tacplus
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestListenReusePort(t *testing.T) {
+	ls, err := ListenReusePort(context.Background(), "tcp", "127.0.0.1:0", 4)
+	if errors.Is(err, ErrReusePortUnsupported) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ls[0].Addr().String()
+	for _, l := range ls {
+		if l.Addr().String() != addr {
+			t.Errorf("got listener address %s, want %s", l.Addr(), addr)
+		}
+		_ = l.Close()
+	}
+}
+
+func TestListenAndServeReusePort(t *testing.T) {
+	skipWithoutMD5(t)
+	// find a free port, then serve on it with several listeners
+	ls, err := ListenReusePort(context.Background(), "tcp", "127.0.0.1:0", 1)
+	if errors.Is(err, ErrReusePortUnsupported) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ls[0].Addr().String()
+	_ = ls[0].Close()
+
+	h := testHandler
+	h.ConnConfig.Log = func(...interface{}) {}
+	srv := &Server{ServeConnContext: h.ServeContext}
+	served := make(chan error, 1)
+	go func() { served <- srv.ListenAndServeReusePort("tcp", addr, 3) }()
+
+	c := &Client{Addr: addr, ConnConfig: ConnConfig{Secret: testSecret}}
+	var ok bool
+	for i := 0; i < 10 && !ok; i++ {
+		_, err = c.SendAcctRequest(context.Background(), testAcctReq)
+		if ok = err == nil; !ok {
+			time.Sleep(timeScale / 4)
+		}
+	}
+	if !ok {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err = c.SendAcctRequest(context.Background(), testAcctReq); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = srv.Close()
+	select {
+	case err = <-served:
+		if err != ErrServerClosed {
+			t.Errorf("got %v, want %v", err, ErrServerClosed)
+		}
+	case <-time.After(10 * timeScale):
+		t.Fatal("server did not stop")
+	}
+}
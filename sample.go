@@ -0,0 +1,154 @@
+package tacplus
+
+import (
+	"sync"
+	"time"
+)
+
+// redacted replaces secrets in sampled packets.
+const redacted = "<redacted>"
+
+// sampleExpiry is how long a sampled session is remembered without a packet,
+// for sessions that end without a final reply.
+const sampleExpiry = 10 * time.Minute
+
+// TranscriptSampler records the packets of a sample of sessions with a
+// TranscriptRecorder, for investigating intermittent problems without tracing
+// every session. Its Trace method can be used as a ConnConfig PacketTrace function.
+//
+// A session is sampled if its user is matched by Users, or it is one of every
+// Every sessions. No more than MaxPerMinute sessions are sampled each minute.
+//
+// Secrets are redacted from sampled packets: the Data of authentication starts,
+// such as PAP passwords, and answers to prompts with NoEcho set.
+type TranscriptSampler struct {
+	Recorder *TranscriptRecorder // recorder for sampled sessions
+
+	Every int       // sample one in Every sessions, ignored if zero
+	Users Condition // optional condition sampling sessions by user
+
+	// Maximum sessions sampled per minute, ignored if zero.
+	MaxPerMinute int
+
+	mu      sync.Mutex
+	n       int // sessions seen
+	window  time.Time
+	inWin   int // sessions sampled in the current window
+	sampled map[transcriptKey]*sampledSession
+}
+
+type sampledSession struct {
+	last   time.Time // time of the last packet
+	secret bool      // the last prompt had NoEcho set or asked for a password
+}
+
+// startUser returns the user of the session start packet p, if it has one.
+func startUser(p Packet) string {
+	switch r := p.(type) {
+	case *AuthenStart:
+		return r.User
+	case *AuthorRequest:
+		return r.User
+	case *AcctRequest:
+		return r.User
+	}
+	return ""
+}
+
+// sample reports whether to sample a new session with start packet p.
+func (s *TranscriptSampler) sample(p Packet, now time.Time) bool {
+	s.n++
+	pick := s.Every > 0 && s.n%s.Every == 0
+	if !pick && s.Users != nil {
+		if user := startUser(p); user != "" {
+			pick = s.Users(&RequestInfo{Time: now, User: user})
+		}
+	}
+	if !pick || s.MaxPerMinute <= 0 {
+		return pick
+	}
+	if now.Sub(s.window) >= time.Minute {
+		s.window, s.inWin = now, 0
+	}
+	if s.inWin >= s.MaxPerMinute {
+		return false
+	}
+	s.inWin++
+	return true
+}
+
+// expire forgets sampled sessions with no packets for sampleExpiry.
+func (s *TranscriptSampler) expire(now time.Time) {
+	for k, ss := range s.sampled {
+		if now.Sub(ss.last) > sampleExpiry {
+			delete(s.sampled, k)
+		}
+	}
+}
+
+// redact returns p with the secrets in its decoded body pkt replaced.
+func (ss *sampledSession) redact(p TracedPacket, pkt Packet) TracedPacket {
+	changed := false
+	switch r := pkt.(type) {
+	case *AuthenStart:
+		if len(r.Data) > 0 {
+			r.Data, changed = []byte(redacted), true
+		}
+	case *AuthenContinue:
+		if ss.secret && !r.Abort {
+			r.Message, changed = redacted, true
+		}
+	case *AuthenReply:
+		// upstream servers don't always set NoEcho when asking for a password
+		ss.secret = r.NoEcho || r.Status == AuthenStatusGetPass
+	}
+	if !changed {
+		return p
+	}
+	if b, err := pkt.MarshalBinary(); err == nil {
+		p.Body = b
+		p.Header.BodyLen = uint32(len(b))
+	}
+	return p
+}
+
+// last reports whether the decoded packet pkt ends its session.
+func lastPacket(pkt Packet) bool {
+	switch r := pkt.(type) {
+	case *AuthenReply:
+		return r.last()
+	case *AuthorResponse, *AcctReply:
+		return true
+	}
+	return false
+}
+
+// Trace adds the packet p to its session's transcript if the session is sampled.
+func (s *TranscriptSampler) Trace(p TracedPacket) {
+	pkt, err := p.Decode()
+	if err != nil {
+		return
+	}
+	key := transcriptKey{p.RemoteAddr, p.LocalAddr, p.Header.SessionID}
+	s.mu.Lock()
+	if s.sampled == nil {
+		s.sampled = make(map[transcriptKey]*sampledSession)
+	}
+	ss := s.sampled[key]
+	if ss == nil {
+		if p.Header.SeqNo != 1 || !s.sample(pkt, p.Time) {
+			s.mu.Unlock()
+			return
+		}
+		s.expire(p.Time)
+		ss = new(sampledSession)
+		s.sampled[key] = ss
+	}
+	ss.last = p.Time
+	p = ss.redact(p, pkt)
+	if lastPacket(pkt) {
+		delete(s.sampled, key)
+	}
+	s.mu.Unlock()
+	s.Recorder.Trace(p)
+}
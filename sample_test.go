@@ -0,0 +1,101 @@
+package tacplus
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTranscriptSampler(t *testing.T) {
+	skipWithoutMD5(t)
+	rec := new(TranscriptRecorder)
+	ts := &TranscriptSampler{Recorder: rec, Every: 3, Users: Users("fred"), MaxPerMinute: 3}
+	h := testHandler
+	h.ConnConfig.PacketTrace = ts.Trace
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	ctx := context.Background()
+	// sessions 1 and 2 aren't sampled, 3 is
+	for i := 0; i < 2; i++ {
+		if _, err = c.SendAuthorRequest(ctx, testAuthorReq); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = authenticate(ctx, c, "user", "password123"); err != nil {
+		t.Fatal(err)
+	}
+	// fred's sessions are sampled until the rate limit is reached
+	for i := 0; i < 3; i++ {
+		if _, err = c.SendAcctRequest(ctx, testAcctReq); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := rec.Transcripts()
+	if len(got) != 3 {
+		t.Fatalf("got %d transcripts, want 3", len(got))
+	}
+	login := got[0]
+	if len(login.Packets) != 6 {
+		t.Fatalf("got %d authentication packets, want 6", len(login.Packets))
+	}
+	user, err := login.Packets[2].Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := user.(*AuthenContinue).Message; m != "user" {
+		t.Errorf("got user name %q", m)
+	}
+	pass, err := login.Packets[4].Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := pass.(*AuthenContinue).Message; m != redacted {
+		t.Errorf("password not redacted: %q", m)
+	}
+	for _, tr := range got[1:] {
+		if len(tr.Packets) != 2 || tr.Packets[0].Header.Type != TypeAcct {
+			t.Errorf("unexpected transcript %+v", tr)
+		}
+	}
+	if len(ts.sampled) != 0 {
+		t.Errorf("%d finished sessions still tracked", len(ts.sampled))
+	}
+}
+
+func TestSampleRedactPAP(t *testing.T) {
+	ss := new(sampledSession)
+	as := &AuthenStart{Action: AuthenActionLogin, AuthenType: AuthenTypePAP, User: "user", Data: []byte("secret")}
+	body, _ := as.MarshalBinary()
+	p := ss.redact(TracedPacket{Header: Header{SeqNo: 1, Type: TypeAuthen, BodyLen: uint32(len(body))}, Body: body}, as)
+	pkt, err := p.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := string(pkt.(*AuthenStart).Data); d != redacted || int(p.Header.BodyLen) != len(p.Body) {
+		t.Errorf("got data %q body length %d", d, p.Header.BodyLen)
+	}
+}
+
+func TestSampleRedactGetPass(t *testing.T) {
+	for _, rep := range []*AuthenReply{
+		{Status: AuthenStatusGetPass},
+		{Status: AuthenStatusGetData, NoEcho: true},
+	} {
+		ss := new(sampledSession)
+		ss.redact(TracedPacket{}, rep)
+		ac := &AuthenContinue{Message: "secret"}
+		body, _ := ac.MarshalBinary()
+		p := ss.redact(TracedPacket{Header: Header{SeqNo: 3, Type: TypeAuthen, BodyLen: uint32(len(body))}, Body: body}, ac)
+		pkt, err := p.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m := pkt.(*AuthenContinue).Message; m != redacted {
+			t.Errorf("reply %+v: got message %q", rep, m)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package tacplus
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	errNoValidSecret = errors.New("no valid secret key")
+	errTruncatedKey  = errors.New("no single secret key decrypts truncated request")
+)
+
+// KeyedSecret is a shared secret key with an identifier and an optional validity period,
+// allowing secrets to be rotated in stages. The ID of the key used by a session is
+// available from its SecretID method.
+type KeyedSecret struct {
+	ID        string    // key identifier
+	Secret    []byte    // shared secret key
+	NotBefore time.Time // key is not valid before this time, ignored if zero
+	NotAfter  time.Time // key is not valid after this time, ignored if zero
+}
+
+// valid returns whether the key is valid at time t.
+func (k *KeyedSecret) valid(t time.Time) bool {
+	return (k.NotBefore.IsZero() || !t.Before(k.NotBefore)) && (k.NotAfter.IsZero() || !t.After(k.NotAfter))
+}
+
+// keys returns the secret keys valid at time t.
+func (c *ConnConfig) keys(t time.Time) []*KeyedSecret {
+	if len(c.Secrets) == 0 {
+		return []*KeyedSecret{{Secret: c.Secret}}
+	}
+	var keys []*KeyedSecret
+	for i := range c.Secrets {
+		if k := &c.Secrets[i]; k.valid(t) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// clientKey returns the key a client uses for a new session.
+func (c *ConnConfig) clientKey() (*KeyedSecret, error) {
+	keys := c.keys(time.Now())
+	if len(keys) == 0 {
+		return nil, errNoValidSecret
+	}
+	return keys[0], nil
+}
+
+// serverKey returns the key used to encrypt the first packet p of a session.
+// If more than one key is valid, the first that decrypts a well formed request
+// is chosen. If truncOK is set, for peers with QuirkTruncatedArgs, a request
+// truncated part way through its arguments must be decrypted by exactly one
+// key, rather than falling back to a key that may decode it wrongly.
+func (c *ConnConfig) serverKey(p []byte, truncOK bool) (*KeyedSecret, error) {
+	keys := c.keys(time.Now())
+	switch len(keys) {
+	case 0:
+		return nil, errNoValidSecret
+	case 1:
+		return keys[0], nil
+	}
+	buf := make([]byte, len(p))
+	var truncated []*KeyedSecret
+	for _, k := range keys {
+		copy(buf, p)
+		crypt(buf, k.Secret)
+		if requestLenOK(buf[hdrType], buf[hdrLen:]) {
+			return k, nil
+		}
+		if truncOK && truncatedRequestOK(buf[hdrType], buf[hdrLen:]) {
+			truncated = append(truncated, k)
+		}
+	}
+	if truncOK {
+		if len(truncated) != 1 {
+			return nil, errTruncatedKey
+		}
+		return truncated[0], nil
+	}
+	return keys[0], nil
+}
+
+// A SecretPolicy checks a shared secret, returning an error if it is not acceptable.
+type SecretPolicy func(secret []byte) error
+
+// MinSecretLength returns a SecretPolicy that rejects secrets shorter than n bytes.
+func MinSecretLength(n int) SecretPolicy {
+	return func(secret []byte) error {
+		if len(secret) == 0 {
+			return errors.New("empty secret")
+		}
+		if len(secret) < n {
+			return fmt.Errorf("secret shorter than %d bytes", n)
+		}
+		return nil
+	}
+}
+
+// weakSecret returns whether secret looks like a dictionary word,
+// being all letters with up to four trailing digits.
+func weakSecret(secret []byte) bool {
+	i := 0
+	for i < len(secret) && (secret[i] >= 'a' && secret[i] <= 'z' || secret[i] >= 'A' && secret[i] <= 'Z') {
+		i++
+	}
+	if i == 0 {
+		return false
+	}
+	digits := 0
+	for ; i < len(secret) && secret[i] >= '0' && secret[i] <= '9'; i++ {
+		digits++
+	}
+	return i == len(secret) && digits <= 4
+}
+
+// NewConnConfig checks the settings of cfg, returning it if they are valid.
+// Each shared secret in use is checked with policy if it is not nil. A warning
+// is logged for secrets that look like a dictionary word. Secrets aren't checked
+// if cfg is Secretless. Configurations that aren't Secretless are rejected when
+// built with the tacplus_nomd5 tag.
+func NewConnConfig(cfg ConnConfig, policy SecretPolicy) (ConnConfig, error) {
+	for _, d := range []time.Duration{cfg.IdleTimeout, cfg.ReadTimeout, cfg.WriteTimeout, cfg.ErrorReplyTimeout, cfg.HandlerTimeout} {
+		if d < 0 {
+			return cfg, errors.New("negative timeout")
+		}
+	}
+
+	if cfg.Secretless {
+		return cfg, nil
+	}
+	if !md5Available {
+		return cfg, errNoMD5
+	}
+	keys := cfg.Secrets
+	if len(keys) == 0 {
+		keys = []KeyedSecret{{Secret: cfg.Secret}}
+	}
+	ids := make(map[string]bool)
+	for _, k := range keys {
+		if ids[k.ID] {
+			return cfg, fmt.Errorf("duplicate secret id %q", k.ID)
+		}
+		ids[k.ID] = true
+		if !k.NotBefore.IsZero() && !k.NotAfter.IsZero() && k.NotAfter.Before(k.NotBefore) {
+			return cfg, fmt.Errorf("secret %q NotAfter is before NotBefore", k.ID)
+		}
+		if policy != nil {
+			if err := policy(k.Secret); err != nil {
+				if k.ID != "" {
+					err = fmt.Errorf("secret %q: %w", k.ID, err)
+				}
+				return cfg, err
+			}
+		}
+		if weakSecret(k.Secret) {
+			cfg.log("warning: weak secret ", fmt.Sprintf("%q", k.ID), " looks like a dictionary word")
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,168 @@
+package tacplus
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// keyIDRequestHandler replies to accounting requests with the session secret ID.
+type keyIDRequestHandler struct {
+	RequestHandler
+}
+
+func (h keyIDRequestHandler) HandleAcctRequest(ctx context.Context, a *AcctRequest, s *ServerSession) *AcctReply {
+	return &AcctReply{Status: AcctStatusSuccess, Data: s.SecretID()}
+}
+
+func TestKeyedSecrets(t *testing.T) {
+	skipWithoutMD5(t)
+	now := time.Now()
+	h := ServerConnHandler{
+		Handler: keyIDRequestHandler{testHandler.Handler},
+		ConnConfig: ConnConfig{
+			Secrets: []KeyedSecret{
+				{ID: "expired", Secret: []byte("expired secret"), NotAfter: now.Add(-time.Hour)},
+				{ID: "old", Secret: []byte("old secret")},
+				{ID: "new", Secret: []byte("new secret"), NotBefore: now.Add(-time.Hour)},
+			},
+		},
+	}
+	l, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.close()
+	defer c.Close()
+	c.ConnConfig.Mux = false
+
+	tests := []struct {
+		secret string
+		id     string
+	}{
+		{"old secret", "old"},
+		{"new secret", "new"},
+	}
+	ctx := context.Background()
+	for _, test := range tests {
+		c.ConnConfig.Secret = []byte(test.secret)
+		reply, err := c.SendAcctRequest(ctx, testAcctReq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply.Data != test.id {
+			t.Errorf("want secret id %q: got %q", test.id, reply.Data)
+		}
+	}
+
+	c.ConnConfig.Secret = []byte("expired secret")
+	if _, err = c.SendAcctRequest(ctx, testAcctReq); err != ErrBadSecret {
+		t.Errorf("want %v: got %v", ErrBadSecret, err)
+	}
+
+	c.ConnConfig.Secrets = h.ConnConfig.Secrets[:1]
+	if _, err = c.SendAcctRequest(ctx, testAcctReq); err != errNoValidSecret {
+		t.Errorf("want %v: got %v", errNoValidSecret, err)
+	}
+}
+
+func TestRequestLenOK(t *testing.T) {
+	for _, test := range []struct {
+		t uint8
+		p packet
+	}{
+		{sessTypeAuthen, marshalUnmarshalTests[0]},
+		{sessTypeAuthor, marshalUnmarshalTests[4]},
+		{sessTypeAcct, marshalUnmarshalTests[6]},
+	} {
+		b, err := test.p.marshal(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !requestLenOK(test.t, b) {
+			t.Errorf("session type %d: valid request rejected", test.t)
+		}
+		if requestLenOK(test.t, b[:len(b)-1]) {
+			t.Errorf("session type %d: short request accepted", test.t)
+		}
+	}
+}
+
+func TestServerKeyShortBody(t *testing.T) {
+	skipWithoutMD5(t)
+	c := ConnConfig{Secrets: []KeyedSecret{
+		{ID: "old", Secret: []byte("old secret")},
+		{ID: "new", Secret: []byte("new secret")},
+	}}
+	for _, n := range []int{0, 1} {
+		p := make([]byte, hdrLen+n)
+		p[hdrType] = sessTypeAcct
+		k, err := c.serverKey(p, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k.ID != "old" {
+			t.Errorf("%d byte body: got key %q, want fallback to first key", n, k.ID)
+		}
+	}
+}
+
+func TestServerKeyTruncated(t *testing.T) {
+	skipWithoutMD5(t)
+	c := ConnConfig{Secrets: []KeyedSecret{
+		{ID: "old", Secret: []byte("old secret")},
+		{ID: "new", Secret: []byte("new secret")},
+	}}
+	body, err := testAcctReq.marshal(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, hdrLen, hdrLen+len(body))
+	p[hdrVer], p[hdrType], p[hdrSeqNo] = verDefault, sessTypeAcct, 1
+	p = append(p, body[:len(body)-1]...)
+	crypt(p, []byte("new secret"))
+
+	if k, err := c.serverKey(p, true); err != nil || k.ID != "new" {
+		t.Errorf("got key %+v %v, want new", k, err)
+	}
+	if k, _ := c.serverKey(p, false); k.ID != "old" {
+		t.Errorf("without quirk got key %q, want fallback to old", k.ID)
+	}
+	crypt(p, []byte("new secret"))
+	crypt(p, []byte("other secret"))
+	if _, err := c.serverKey(p, true); err != errTruncatedKey {
+		t.Errorf("unknown key: got %v, want %v", err, errTruncatedKey)
+	}
+}
+
+func TestNewConnConfig(t *testing.T) {
+	skipWithoutMD5(t)
+	var warnings int
+	logf := func(...interface{}) { warnings++ }
+	policy := MinSecretLength(8)
+
+	tests := []struct {
+		cfg      ConnConfig
+		ok       bool
+		warnings int
+	}{
+		{ConnConfig{Secret: []byte("x7#kP!2qZ")}, true, 0},
+		{ConnConfig{Secret: []byte("password1")}, true, 1},
+		{ConnConfig{}, false, 0},
+		{ConnConfig{Secret: []byte("short")}, false, 0},
+		{ConnConfig{Secret: []byte("x7#kP!2qZ"), ReadTimeout: -1}, false, 0},
+		{ConnConfig{Secrets: []KeyedSecret{{ID: "a", Secret: []byte("x7#kP!2qZ")}, {ID: "a", Secret: []byte("k2@Lm9!xQ")}}}, false, 0},
+		{ConnConfig{Secrets: []KeyedSecret{{ID: "a", Secret: []byte("x7#kP!2qZ"), NotBefore: time.Now(), NotAfter: time.Now().Add(-time.Hour)}}}, false, 0},
+	}
+	for i, test := range tests {
+		warnings = 0
+		test.cfg.Log = logf
+		_, err := NewConnConfig(test.cfg, policy)
+		if (err == nil) != test.ok {
+			t.Errorf("case %d: unexpected result: %v", i, err)
+		}
+		if warnings != test.warnings {
+			t.Errorf("case %d: want %d warnings: got %d", i, test.warnings, warnings)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package tacplus
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// live counts connection resources across the process, accessed atomically.
+var live struct {
+	conns    int64
+	sessions int64
+	handlers int64
+	loops    int64
+}
+
+// LiveCounts is the number of TACACS+ connection resources in use by all
+// clients and servers in the process.
+type LiveCounts struct {
+	Conns    int // connections being served
+	Sessions int // open sessions
+	Handlers int // running server session handlers
+	Loops    int // connection read and write loop goroutines
+}
+
+// Live returns the number of TACACS+ connection resources currently in use.
+// Counts that keep growing under a steady load point to a leak.
+func Live() LiveCounts {
+	return LiveCounts{
+		Conns:    int(atomic.LoadInt64(&live.conns)),
+		Sessions: int(atomic.LoadInt64(&live.sessions)),
+		Handlers: int(atomic.LoadInt64(&live.handlers)),
+		Loops:    int(atomic.LoadInt64(&live.loops)),
+	}
+}
+
+// addSession counts a session added to, or removed from, the session map.
+func addSession(n int64) {
+	atomic.AddInt64(&live.sessions, n)
+}
+
+// loop runs f as a connection loop goroutine, counting it while it runs.
+func (c *conn) loop(f func()) {
+	atomic.AddInt32(&c.loops, 1)
+	atomic.AddInt64(&live.loops, 1)
+	go func() {
+		defer atomic.AddInt64(&live.loops, -1)
+		defer atomic.AddInt32(&c.loops, -1)
+		f()
+	}()
+}
+
+// selfCheck returns descriptions of any inconsistencies in the connection's
+// bookkeeping. It must be called from the serve goroutine.
+func (c *conn) selfCheck() []string {
+	var bad []string
+	for id, s := range c.sess {
+		if s.id != id {
+			bad = append(bad, fmt.Sprintf("session %d stored as session %d", s.id, id))
+		}
+		select {
+		case <-s.done:
+			bad = append(bad, fmt.Sprintf("closed session %d still open on connection", id))
+		default:
+		}
+	}
+	if c.held != nil && c.sess[c.held.id] != c.held {
+		bad = append(bad, fmt.Sprintf("packet held for closed session %d", c.held.id))
+	}
+	if (c.held == nil) != (c.heldP == nil) {
+		bad = append(bad, "held packet and session out of step")
+	}
+	if c.stats != nil && c.stats.sessionCount() >= 0 && c.stats.sessionCount() != len(c.sess) {
+		bad = append(bad, fmt.Sprintf("server counts %d sessions, connection has %d", c.stats.sessionCount(), len(c.sess)))
+	}
+	if len(c.sess) == 0 && c.mux && c.IdleTimeout > 0 && c.idleT == nil {
+		bad = append(bad, "idle multiplexed connection has no idle timer")
+	}
+	// Handlers may briefly outlive their sessions, so only report handlers
+	// still running after a whole check interval.
+	orphans := int(atomic.LoadInt32(&c.handlers)) - len(c.sess)
+	if orphans > 0 && c.orphans > 0 {
+		bad = append(bad, fmt.Sprintf("%d session handlers running after their sessions closed", orphans))
+	}
+	c.orphans = orphans
+	min := int32(2)
+	if c.peerClosed {
+		// the read loop exits when the peer half-closes
+		min = 1
+	}
+	if n := atomic.LoadInt32(&c.loops); n < min || n > 2 {
+		bad = append(bad, fmt.Sprintf("%d read and write loops running, want 2", n))
+	}
+	return bad
+}
+
+// reportCheck logs problems found by a self check, or panics if SelfCheckPanic is set.
+func (c *conn) reportCheck(bad []string) {
+	if len(bad) == 0 {
+		return
+	}
+	msg := "tacplus: self check of connection to " + c.nc.RemoteAddr().String() + ": " + strings.Join(bad, "; ")
+	if c.SelfCheckPanic {
+		panic(msg)
+	}
+	c.log(msg)
+}
+
+// checkClosed reports any read or write loop still running SelfCheckInterval
+// after the connection closed.
+func (c *conn) checkClosed() {
+	time.AfterFunc(c.SelfCheckInterval, func() {
+		if n := atomic.LoadInt32(&c.loops); n != 0 {
+			c.reportCheck([]string{fmt.Sprintf("%d read and write loops running after close", n)})
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package tacplus
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelfCheck(t *testing.T) {
+	cc, sc := net.Pipe()
+	defer cc.Close()
+	c := newConn(sc, func(*session) {}, ConnConfig{Mux: true})
+	c.loops = 2
+	if bad := c.selfCheck(); len(bad) != 0 {
+		t.Fatalf("new connection failed self check: %v", bad)
+	}
+
+	s := newSession(c, 1)
+	c.sess[2] = s
+	close(s.done)
+	c.held, c.heldP = newSession(c, 3), []byte{}
+	c.handlers = 3
+	c.mux, c.IdleTimeout = true, time.Second
+	bad := c.selfCheck()
+	for _, want := range []string{"stored as session 2", "closed session 2", "held for closed session 3"} {
+		found := false
+		for _, b := range bad {
+			found = found || strings.Contains(b, want)
+		}
+		if !found {
+			t.Errorf("self check %q missing %q", bad, want)
+		}
+	}
+	// handlers outliving their sessions are reported on the second check
+	if bad = c.selfCheck(); !strings.Contains(strings.Join(bad, ";"), "2 session handlers running") {
+		t.Errorf("self check %q missing handlers", bad)
+	}
+
+	delete(c.sess, 2)
+	c.held, c.heldP = nil, nil
+	if bad = c.selfCheck(); !strings.Contains(strings.Join(bad, ";"), "no idle timer") {
+		t.Errorf("self check %q missing idle timer", bad)
+	}
+}
+
+func TestSelfCheckServe(t *testing.T) {
+	skipWithoutMD5(t)
+	h := testHandler
+	h.ConnConfig.SelfCheckInterval = timeScale / 4
+	h.ConnConfig.IdleTimeout = time.Second
+	l, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.close()
+
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		if _, err = authenticate(ctx, c, "user", "password123"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = c.SendAcctRequest(ctx, testAcctReq); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := Live(); n.Conns < 1 || n.Loops < 2 {
+		t.Errorf("got live counts %+v while serving", n)
+	}
+	time.Sleep(2 * timeScale)
+	c.Close()
+	time.Sleep(2 * timeScale)
+	if err = l.err(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+}
@@ -2,16 +2,29 @@ package tacplus
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // ServerSession is a TACACS+ Server Session.
 type ServerSession struct {
 	*session
-	p []byte
+	p      []byte
+	turn   chan struct{} // held while exchanging packets with the client
+	start  time.Time     // time the session started
+	rounds int           // number of prompts sent to the client
+
+	mu     sync.Mutex
+	values map[interface{}]interface{} // set by SetValue
+	user   string                      // authentication user, from the start packet or GetUser
+	final  uint8                       // status of the final authentication reply
+	info   *RequestInfo                // request fields for message templates, or nil
 }
 
 // Log output using the connections ConnConfig Log function.
@@ -24,11 +37,36 @@ func (s *ServerSession) close() {
 	s.session.close()
 }
 
+// detach returns a copy of s for a handler that runs after s may have closed,
+// such as a shadow handler. It keeps the addresses, device, values and Log
+// function of s, but has no packet and is closed, so it can't reach the client.
+func (s *ServerSession) detach() *ServerSession {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(errSessionClosed)
+	done := make(chan struct{})
+	close(done)
+	d := &ServerSession{
+		session: &session{id: s.id, c: s.c, done: done, key: s.key, ctx: ctx, cancel: cancel},
+		turn:    make(chan struct{}, 1),
+		start:   s.start,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d.user, d.final, d.info = s.user, s.final, s.info
+	if s.values != nil {
+		d.values = make(map[interface{}]interface{}, len(s.values))
+		for k, v := range s.values {
+			d.values[k] = v
+		}
+	}
+	return d
+}
+
 func (s *ServerSession) writePacket(ctx context.Context, p []byte) error {
 	if p[hdrSeqNo] == 1 {
 		// Set single connect header flag in the first reply packet for the session.
 		// Set it even in LegacyMux to allow normal Mux client connections to multiplex.
-		if s.c.Mux || s.c.LegacyMux {
+		if (s.c.Mux || s.c.LegacyMux) && !s.c.quirks.Has(QuirkSingleConnect) {
 			p[hdrFlags] &= hdrFlagSingleConnect
 		} else {
 			p[hdrFlags] = 0
@@ -37,15 +75,36 @@ func (s *ServerSession) writePacket(ctx context.Context, p []byte) error {
 	return s.session.writePacket(ctx, p)
 }
 
+// replyBuf returns the session packet header in a buffer with room for a reply
+// of at least the configured size for the session type.
+func (s *ServerSession) replyBuf() []byte {
+	n := s.c.bufferSize(s.p[hdrType])
+	if cap(s.p) >= n {
+		return s.p[:hdrLen]
+	}
+	p := append(getBuf(n), s.p[:hdrLen]...)
+	putBuf(s.p)
+	s.p = p
+	return p
+}
+
+// sendError sends an error reply for err and closes the session.
+// The reply is abandoned if ctx is canceled, the connection is closed,
+// or the ErrorReplyTimeout expires.
 func (s *ServerSession) sendError(ctx context.Context, err error) {
 	if s.p == nil {
 		return
 	}
+	if s.c.ErrorReplyTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.c.ErrorReplyTimeout)
+		defer cancel()
+	}
 	msg := err.Error()
 	if len(msg) > maxUint16 {
 		msg = msg[:maxUint16]
 	}
-	p := s.p[:hdrLen]
+	p := s.replyBuf()
 	switch p[hdrType] {
 	case sessTypeAuthen:
 		r := AuthenReply{Status: AuthenStatusError, ServerMsg: msg}
@@ -63,38 +122,200 @@ func (s *ServerSession) sendError(ctx context.Context, err error) {
 	s.close()
 }
 
+// sendFail sends an authentication Fail reply with the message of err and closes
+// the session. The reply is abandoned if ctx is canceled, the connection is closed,
+// or the ErrorReplyTimeout expires.
+func (s *ServerSession) sendFail(ctx context.Context, err error) {
+	if s.c.ErrorReplyTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.c.ErrorReplyTimeout)
+		defer cancel()
+	}
+	r := AuthenReply{Status: AuthenStatusFail, ServerMsg: err.Error()}
+	p, _ := r.marshal(s.replyBuf())
+	s.mu.Lock()
+	s.final = r.Status
+	s.mu.Unlock()
+	if werr := s.writePacket(ctx, p); werr != nil {
+		s.c.log(werr)
+	}
+	s.setErr(err)
+	s.close()
+}
+
+// checkVersion checks the request has the minor version expected for the request
+// type named kind, applying the connection's VersionPolicy if not. Replies use the
+// expected version unless the policy is VersionAccept.
+func (s *ServerSession) checkVersion(want uint8, kind string) error {
+	got := s.p[hdrVer]
+	if got == want {
+		return nil
+	}
+	if s.c.quirks.Has(QuirkMinorVersion) {
+		return nil
+	}
+	err := fmt.Errorf("unsupported %s minor version %d", kind, got&0xf)
+	switch s.c.VersionPolicy {
+	case VersionAccept:
+		s.c.log(err)
+		return nil
+	case VersionRemap:
+		err = nil
+	}
+	s.p[hdrVer] = want
+	return err
+}
+
+// call runs the request handler function f.
+//
+// If HandlerTimeout is set, f is run with a context that has the timeout and
+// errHandlerTimeout is returned if f has not returned before it expires. If the
+// handler is waiting for a reply from the client at the time, the session is
+// closed so no error reply is sent.
+func (s *ServerSession) call(ctx context.Context, f func(context.Context)) error {
+	if s.c.HandlerTimeout <= 0 {
+		f(ctx)
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.c.HandlerTimeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f(ctx)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+	select {
+	case <-done:
+		return nil
+	case s.turn <- struct{}{}:
+		// Handler isn't exchanging packets. Keep the turn so it can't
+		// start, leaving the session to send the error reply.
+	default:
+		// Handler is waiting for the client. Close the session and wait
+		// for the handler to give up its turn.
+		s.setErr(errHandlerTimeout)
+		s.session.close()
+		s.turn <- struct{}{}
+	}
+	return errHandlerTimeout
+}
+
 func (s *ServerSession) sendReply(ctx context.Context, r *AuthenReply) (*AuthenContinue, error) {
+	select {
+	case s.turn <- struct{}{}:
+		defer func() { <-s.turn }()
+	case <-s.done:
+		return nil, errSessionClosed
+	}
+	r.ServerMsg = s.message(authenMessages[r.Status], r.ServerMsg)
+	if c, err := s.banner(ctx, r); err != nil {
+		return c, err
+	}
+	return s.exchange(ctx, r)
+}
+
+// exchange sends the prompt r and reads the client's answer. The session turn
+// must be held.
+func (s *ServerSession) exchange(ctx context.Context, r *AuthenReply) (*AuthenContinue, error) {
 	if s.p == nil {
 		return nil, errSessionClosed
 	}
-	//if s.seq > 0xfb {
-	//	return nil errors.New("operation will cause sequence number to overlap")
-	//}
-	p, err := r.marshal(s.p[:hdrLen])
+	if s.seq > 0xfb {
+		// no sequence numbers left for the client's answer and a final reply
+		s.sendFail(ctx, errSeqOverflow)
+		return nil, errSeqOverflow
+	}
+	if s.c.MaxAuthenPrompts > 0 && s.rounds >= s.c.MaxAuthenPrompts {
+		s.sendFail(ctx, errTooManyPrompts)
+		return nil, errTooManyPrompts
+	}
+	rctx := ctx
+	if s.c.MaxAuthenDuration > 0 {
+		limit := s.start.Add(s.c.MaxAuthenDuration)
+		if !time.Now().Before(limit) {
+			s.sendFail(ctx, errAuthenTooLong)
+			return nil, errAuthenTooLong
+		}
+		var cancel context.CancelFunc
+		rctx, cancel = context.WithDeadline(ctx, limit)
+		defer cancel()
+	}
+	s.rounds++
+	p, err := r.marshal(s.replyBuf())
 	if err != nil {
 		return nil, err
 	}
 	err = s.writePacket(ctx, p)
 	if err != nil {
+		s.setErr(err)
 		s.close()
 		return nil, err
 	}
-	s.p, err = s.readPacket(ctx)
+	putBuf(p)
+	s.p, err = s.readPacket(rctx)
 	if err != nil {
+		if rctx.Err() != nil && ctx.Err() == nil {
+			// The client didn't answer within MaxAuthenDuration. It isn't
+			// expecting a packet, so close the session without a reply.
+			s.setErr(errAuthenTooLong)
+			s.close()
+			return nil, errAuthenTooLong
+		}
+		s.setErr(err)
 		s.sendError(ctx, err)
 		return nil, err
 	}
 	c := new(AuthenContinue)
 	err = c.unmarshal(s.p[hdrLen:])
 	if err != nil {
+		err = s.c.decodeError(s.p, err)
 		s.sendError(ctx, err)
 		return nil, err
 	}
+	if c.Abort {
+		// the client expects no reply to an abort
+		err = &AbortError{Reason: c.Message}
+		s.c.securityEvent(EventClientAbort, s.p, err)
+		s.setErr(err)
+		s.close()
+		return c, err
+	}
 	return c, nil
 }
 
+// AbortError is the error returned by a ServerSession prompt when the client
+// aborts the session. The session is closed, and no reply is sent.
+type AbortError struct {
+	Reason string // reason given by the client, may be empty
+}
+
+func (e *AbortError) Error() string {
+	if e.Reason == "" {
+		return "session aborted by client"
+	}
+	return "session aborted by client: " + e.Reason
+}
+
+// Err returns the reason the session was closed, or nil if it is still open.
+func (s *ServerSession) Err() error {
+	select {
+	case <-s.done:
+	default:
+		return nil
+	}
+	return context.Cause(s.ctx)
+}
+
 // GetData requests the TACACS+ client prompt the user for data with the given message.
 // If noEcho is set the client will not echo the users response as it is entered.
+// If the client aborts the session an *AbortError is returned.
 func (s *ServerSession) GetData(ctx context.Context, message string, noEcho bool) (*AuthenContinue, error) {
 	r := &AuthenReply{Status: AuthenStatusGetData, ServerMsg: message, NoEcho: noEcho}
 	return s.sendReply(ctx, r)
@@ -103,7 +324,11 @@ func (s *ServerSession) GetData(ctx context.Context, message string, noEcho bool
 // GetUser requests the TACACS+ client prompt the user for a username with the given message.
 func (s *ServerSession) GetUser(ctx context.Context, message string) (*AuthenContinue, error) {
 	r := &AuthenReply{Status: AuthenStatusGetUser, ServerMsg: message}
-	return s.sendReply(ctx, r)
+	c, err := s.sendReply(ctx, r)
+	if err == nil {
+		s.setUser(c.Message)
+	}
+	return c, err
 }
 
 // GetPass requests the TACACS+ client prompt the user for a password with the given message.
@@ -122,12 +347,48 @@ func (s *ServerSession) LocalAddr() net.Addr {
 	return s.session.c.nc.LocalAddr()
 }
 
+// ConnectionState returns the TLS connection state for the session,
+// or nil if the session is not using a TLS connection.
+func (s *ServerSession) ConnectionState() *tls.ConnectionState {
+	return s.session.c.tlsState()
+}
+
+// SecretID returns the ID of the KeyedSecret used by the session,
+// or an empty string if the ConnConfig Secret is being used.
+func (s *ServerSession) SecretID() string {
+	return s.keyID()
+}
+
+// Value returns the value stored for key by SetValue, or nil if none.
+func (s *ServerSession) Value(key interface{}) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key]
+}
+
+// SetValue stores v for key for the rest of the session, so multi-step
+// handlers can keep state, such as a user name checked before prompting
+// for a one-time password, between GetData calls. A nil v removes key.
+// Keys follow the same rules as context.WithValue keys.
+func (s *ServerSession) SetValue(key, v interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v == nil {
+		delete(s.values, key)
+		return
+	}
+	if s.values == nil {
+		s.values = make(map[interface{}]interface{})
+	}
+	s.values[key] = v
+}
+
 // A RequestHandler is used for processing the three different types of TACACS+ requests.
 //
 // Each handle function takes a context and a request/start packet and returns a reply/response
 // packet to be sent back to the client. A nil reply will close the session with no reply packet
 // being sent. The supplied context is canceled if the underlying TACACS+ session or connection
-// is closed.
+// is closed, and context.Cause reports why, such as a *ConnClosedError or *AbortError.
 //
 // HandleAuthenStart processes an authentication start, returning an optional reply.
 // The ServerSession can be used by interactive sessions to prompt the user for more
@@ -142,51 +403,216 @@ type RequestHandler interface {
 	HandleAcctRequest(ctx context.Context, a *AcctRequest, s *ServerSession) *AcctReply
 }
 
+// Passthrough is a RequestHandler passing each request to Handler. Handlers
+// wrapping another embed it, overriding only the methods of the requests they
+// change. Requests get no reply, closing the session, if Handler is nil.
+type Passthrough struct {
+	Handler RequestHandler // handler for requests
+}
+
+// HandleAuthenStart calls the HandleAuthenStart method of Handler.
+func (p Passthrough) HandleAuthenStart(ctx context.Context, a *AuthenStart, s *ServerSession) *AuthenReply {
+	if p.Handler == nil {
+		return nil
+	}
+	return p.Handler.HandleAuthenStart(ctx, a, s)
+}
+
+// HandleAuthorRequest calls the HandleAuthorRequest method of Handler.
+func (p Passthrough) HandleAuthorRequest(ctx context.Context, a *AuthorRequest, s *ServerSession) *AuthorResponse {
+	if p.Handler == nil {
+		return nil
+	}
+	return p.Handler.HandleAuthorRequest(ctx, a, s)
+}
+
+// HandleAcctRequest calls the HandleAcctRequest method of Handler.
+func (p Passthrough) HandleAcctRequest(ctx context.Context, a *AcctRequest, s *ServerSession) *AcctReply {
+	if p.Handler == nil {
+		return nil
+	}
+	return p.Handler.HandleAcctRequest(ctx, a, s)
+}
+
+// errInternal is the message sent to clients when a RequestHandlerV2 fails.
+const errInternal = "internal server error"
+
+// A RequestHandlerV2 is used for processing the three different types of TACACS+ requests,
+// reporting failures as errors. Use HandlerV2 to serve it as a RequestHandler.
+//
+// If a handle function returns a non-nil error an Error status reply is sent to the client
+// and the error is logged. The error message is not sent to the client unless the error is
+// an *ErrorReply, in which case its reply is sent instead without logging. A nil reply and
+// error closes the session with no reply packet being sent.
+type RequestHandlerV2 interface {
+	HandleAuthenStart(ctx context.Context, a *AuthenStart, s *ServerSession) (*AuthenReply, error)
+	HandleAuthorRequest(ctx context.Context, a *AuthorRequest, s *ServerSession) (*AuthorResponse, error)
+	HandleAcctRequest(ctx context.Context, a *AcctRequest, s *ServerSession) (*AcctReply, error)
+}
+
+// HandlerV2 returns a RequestHandler that serves requests using h.
+func HandlerV2(h RequestHandlerV2) RequestHandler {
+	return handlerV2{h}
+}
+
+type handlerV2 struct {
+	h RequestHandlerV2
+}
+
+// errorReply returns the *ErrorReply to send for err, logging errors
+// that aren't an *ErrorReply.
+func errorReply(s *ServerSession, err error) *ErrorReply {
+	var e *ErrorReply
+	if !errors.As(err, &e) {
+		s.Log(err)
+		e = &ErrorReply{Msg: errInternal}
+	}
+	return e
+}
+
+func (h handlerV2) HandleAuthenStart(ctx context.Context, a *AuthenStart, s *ServerSession) *AuthenReply {
+	r, err := h.h.HandleAuthenStart(ctx, a, s)
+	if err != nil {
+		return errorReply(s, err).AuthenReply()
+	}
+	return r
+}
+
+func (h handlerV2) HandleAuthorRequest(ctx context.Context, a *AuthorRequest, s *ServerSession) *AuthorResponse {
+	r, err := h.h.HandleAuthorRequest(ctx, a, s)
+	if err != nil {
+		return errorReply(s, err).AuthorResponse()
+	}
+	return r
+}
+
+func (h handlerV2) HandleAcctRequest(ctx context.Context, a *AcctRequest, s *ServerSession) *AcctReply {
+	r, err := h.h.HandleAcctRequest(ctx, a, s)
+	if err != nil {
+		return errorReply(s, err).AcctReply()
+	}
+	return r
+}
+
 // A ServerConnHandler serves TACACS+ requests on a network connection.
 type ServerConnHandler struct {
 	Handler    RequestHandler // TACACS+ request handler
 	ConnConfig ConnConfig     // TACACS+ connection config
+
+	// Optional handlers for sessions with packet types other than authentication,
+	// authorization or accounting. Custom handlers are chosen by packet type, with
+	// RawHandler serving any remaining types. Sessions with no handler are sent an error.
+	Custom     map[uint8]CustomHandler
+	RawHandler RawHandler
+
+	// Optional pool of workers serving authorization and accounting sessions.
+	Workers *WorkerPool
+
+	// Optional function mapping the identity of a TLS client with a verified
+	// certificate to its device configuration. Returning an error closes the
+	// connection. The identity is available to handlers from PeerIdentityFromContext.
+	Identify func(*PeerIdentity) (*DeviceConfig, error)
+
+	// Optional inventory of client devices by address. The device of a connection
+	// is available to handlers from DeviceFromContext, unless Identify chose one.
+	Inventory *Inventory
+
+	// Optional ConnConfig overrides by device group, applied to connections from
+	// devices in the group when they are accepted. The device is the one chosen
+	// by Identify or Inventory.
+	GroupConfig map[string]*ConnOverrides
+
+	// Optional name of an authorization and accounting argument carrying a
+	// correlation ID, such as "trace-id". The ID is available to handlers from
+	// TraceIDFromContext, and is passed on by a Client with the same TraceArg.
+	TraceArg string
+
+	// Optional functions called when a connection is opened and closed, for
+	// auditing disconnections. The Reason of the close event tells why it closed.
+	OnConnect    func(ConnEvent)
+	OnDisconnect func(ConnEvent)
 }
 
 func (h *ServerConnHandler) handleAuthenStart(ctx context.Context, s *ServerSession) ([]byte, error) {
 	as := new(AuthenStart)
 	err := as.unmarshal(s.p[hdrLen:])
 	if err != nil {
+		return s.p, s.c.decodeError(s.p, err)
+	}
+	if s.c.RejectDeprecated {
+		if err = as.deprecated(); err != nil {
+			return s.p, err
+		}
+	}
+	if err = s.checkVersion(as.version(), "authentication"); err != nil {
 		return s.p, err
 	}
-	v := as.version()
-	if s.p[hdrVer] != v {
-		err = fmt.Errorf("unsupported authentication minor version %d", s.p[hdrVer]&0xf)
-		s.p[hdrVer] = v
+	s.setUser(as.User)
+	s.setRequestInfo(as.User, as.Port, as.RemAddr)
+	var reply *AuthenReply
+	err = s.call(ctx, func(ctx context.Context) {
+		reply = h.Handler.HandleAuthenStart(ctx, as, s)
+	})
+	if err != nil {
 		return s.p, err
 	}
-	reply := h.Handler.HandleAuthenStart(s.context(), as, s)
-	if reply == nil {
+	if reply == nil || s.p == nil {
+		// no reply, or the session was closed while prompting the client
 		return nil, nil
 	}
-	s.p, err = reply.marshal(s.p[:hdrLen])
+	if msg := s.message(authenMessages[reply.Status], reply.ServerMsg); msg != reply.ServerMsg {
+		r := *reply
+		r.ServerMsg = msg
+		reply = &r
+	}
+	if reply.Status == AuthenStatusFail || reply.Status == AuthenStatusError {
+		s.mu.Lock()
+		user := s.user
+		s.mu.Unlock()
+		s.denied(user, reply.Status, reply.ServerMsg, reply.DenyReason)
+	}
+	s.p, err = reply.marshal(s.replyBuf())
 	if err != nil {
 		err = fmt.Errorf("Bad Server AuthenReply: %s", err)
+	} else {
+		s.mu.Lock()
+		s.final = reply.Status
+		s.mu.Unlock()
 	}
 	return s.p, err
 }
 
 func (h *ServerConnHandler) handleAuthorRequest(ctx context.Context, s *ServerSession) ([]byte, error) {
 	ar := new(AuthorRequest)
-	err := ar.unmarshal(s.p[hdrLen:])
+	err := s.c.truncated(s.p, ar.unmarshal(s.p[hdrLen:]))
 	if err != nil {
+		return s.p, s.c.decodeError(s.p, err)
+	}
+	if err = s.checkVersion(verDefault, "authorization"); err != nil {
 		return s.p, err
 	}
-	if s.p[hdrVer] != verDefault {
-		err = fmt.Errorf("unsupported authorization minor version %d", s.p[hdrVer]&0xf)
-		s.p[hdrVer] = verDefault
+	ar.Arg = s.c.quirks.NormalizeArgs(ar.Arg)
+	s.setRequestInfo(ar.User, ar.Port, ar.RemAddr)
+	ctx = traceContext(ctx, h.TraceArg, ar.Arg)
+	var reply *AuthorResponse
+	err = s.call(ctx, func(ctx context.Context) {
+		reply = h.Handler.HandleAuthorRequest(ctx, ar, s)
+	})
+	if err != nil {
 		return s.p, err
 	}
-	reply := h.Handler.HandleAuthorRequest(ctx, ar, s)
 	if reply == nil {
 		return nil, nil
 	}
-	s.p, err = reply.marshal(s.p[:hdrLen])
+	if msg := s.message(authorMessages[reply.Status], reply.ServerMsg); msg != reply.ServerMsg {
+		r := *reply
+		r.ServerMsg = msg
+		reply = &r
+	}
+	if reply.Status == AuthorStatusFail || reply.Status == AuthorStatusError {
+		s.denied(ar.User, reply.Status, reply.ServerMsg, reply.DenyReason)
+	}
+	s.p, err = reply.marshal(s.replyBuf())
 	if err != nil {
 		err = fmt.Errorf("Bad Server AuthorResponse: %s", err)
 	}
@@ -195,20 +621,34 @@ func (h *ServerConnHandler) handleAuthorRequest(ctx context.Context, s *ServerSe
 
 func (h *ServerConnHandler) handleAcctRequest(ctx context.Context, s *ServerSession) ([]byte, error) {
 	ar := new(AcctRequest)
-	err := ar.unmarshal(s.p[hdrLen:])
+	err := s.c.truncated(s.p, ar.unmarshal(s.p[hdrLen:]))
 	if err != nil {
+		return s.p, s.c.decodeError(s.p, err)
+	}
+	if err = s.checkVersion(verDefault, "accounting"); err != nil {
 		return s.p, err
 	}
-	if s.p[hdrVer] != verDefault {
-		err = fmt.Errorf("unsupported accounting minor version %d", s.p[hdrVer]&0xf)
-		s.p[hdrVer] = verDefault
+	s.setRequestInfo(ar.User, ar.Port, ar.RemAddr)
+	ctx = traceContext(ctx, h.TraceArg, ar.Arg)
+	var reply *AcctReply
+	err = s.call(ctx, func(ctx context.Context) {
+		reply = h.Handler.HandleAcctRequest(ctx, ar, s)
+	})
+	if err != nil {
 		return s.p, err
 	}
-	reply := h.Handler.HandleAcctRequest(ctx, ar, s)
 	if reply == nil {
 		return nil, nil
 	}
-	s.p, err = reply.marshal(s.p[:hdrLen])
+	if msg := s.message(acctMessages[reply.Status], reply.ServerMsg); msg != reply.ServerMsg {
+		r := *reply
+		r.ServerMsg = msg
+		reply = &r
+	}
+	if reply.Status == AcctStatusError {
+		s.denied(ar.User, reply.Status, reply.ServerMsg, reply.DenyReason)
+	}
+	s.p, err = reply.marshal(s.replyBuf())
 	if err != nil {
 		err = fmt.Errorf("Bad Server AcctReply: %s", err)
 	}
@@ -218,7 +658,7 @@ func (h *ServerConnHandler) handleAcctRequest(ctx context.Context, s *ServerSess
 func (h *ServerConnHandler) serveSession(sess *session) {
 	var err error
 
-	s := &ServerSession{sess, nil}
+	s := &ServerSession{session: sess, turn: make(chan struct{}, 1), start: time.Now()}
 	defer s.close()
 
 	ctx := context.Background()
@@ -231,12 +671,17 @@ func (h *ServerConnHandler) serveSession(sess *session) {
 
 	switch s.p[hdrType] {
 	case sessTypeAuthen:
+		defer func() { s.reportAbandoned(err) }()
 		s.p, err = h.handleAuthenStart(s.context(), s)
 	case sessTypeAuthor:
 		s.p, err = h.handleAuthorRequest(s.context(), s)
 	case sessTypeAcct:
 		s.p, err = h.handleAcctRequest(s.context(), s)
 	default:
+		if rh := h.rawHandler(s.p[hdrType]); rh != nil {
+			s.serveRaw(s.context(), rh)
+			return
+		}
 		err = fmt.Errorf("invalid session type %d", s.p[hdrType])
 	}
 
@@ -250,30 +695,341 @@ func (h *ServerConnHandler) serveSession(sess *session) {
 		err = s.writePacket(ctx, s.p)
 		if err != nil {
 			s.c.log(err)
+			return
 		}
+		putBuf(s.p)
+		s.p = nil
 	}
 }
 
 // Serve processes incoming TACACS+ requests on the network connection nc.
 // A nil ServerConnHandler will close the connection without any processing.
 func (h *ServerConnHandler) Serve(nc net.Conn) {
+	h.ServeContext(context.Background(), nc)
+}
+
+// ServeContext processes incoming TACACS+ requests on the network connection nc.
+// If ctx is canceled the connection is closed, canceling the contexts of all
+// its sessions. Session contexts carry the values of ctx.
+// A nil ServerConnHandler will close the connection without any processing.
+func (h *ServerConnHandler) ServeContext(ctx context.Context, nc net.Conn) {
 	var c *conn
 	if h != nil {
-		c = newConn(nc, h.serveSession, h.ConnConfig)
+		var id *PeerIdentity
+		if tc, ok := nc.(*tls.Conn); ok {
+			var err error
+			if ctx, id, err = h.identify(ctx, tc); err != nil {
+				h.ConnConfig.log(err)
+				_ = nc.Close()
+				return
+			}
+		}
+		if h.Inventory != nil && (id == nil || id.Device == nil) {
+			if dev := h.Inventory.Lookup(addrIP(nc.RemoteAddr())); dev != nil {
+				ctx = context.WithValue(ctx, deviceKey{}, dev)
+			}
+		}
+		dev := DeviceFromContext(ctx)
+		c = newConn(nc, h.serveSession, h.connConfig(dev))
+		c.ctx = ctx
+		c.workers = h.Workers
+		// secretless devices rely on TLS, so only verified TLS peers are trusted
+		if dev != nil && dev.Secretless && id != nil {
+			c.Secretless = true
+		}
+		if c.stats = statsFromContext(ctx); c.stats != nil {
+			atomic.StoreInt32(&c.stats.counted, 1)
+		}
+		h.connEvents(c)
 		c.serve()
 	} else if err := nc.Close(); err != nil {
 		c.log(err)
 	}
 }
 
+// connEvents calls OnConnect for the new connection c, and has it call
+// OnDisconnect when it closes.
+func (h *ServerConnHandler) connEvents(c *conn) {
+	addr := c.nc.RemoteAddr().String()
+	if h.OnConnect != nil {
+		h.OnConnect(ConnEvent{Addr: addr, Mux: c.mux || c.checkMux, Context: c.ctx})
+	}
+	if h.OnDisconnect != nil {
+		start := time.Now()
+		c.onClose = func(err error) {
+			h.OnDisconnect(ConnEvent{Addr: addr, Mux: c.mux, Duration: time.Since(start), Err: err,
+				Reason: closeReason(err), Sessions: c.started, Context: c.ctx})
+		}
+	}
+}
+
+// ErrServerClosed is returned by Server.Serve after a call to Close or Shutdown.
+var ErrServerClosed = errors.New("tacplus: server closed")
+
+// ConnInfo describes a network connection being served by a Server.
+type ConnInfo struct {
+	RemoteAddr net.Addr  // remote network address
+	LocalAddr  net.Addr  // local network address
+	Start      time.Time // time the connection was accepted
+	Sessions   int       // number of sessions in progress, or -1 if not known
+}
+
+// connStats tracks a connection being served by a Server.
+type connStats struct {
+	nc       net.Conn
+	start    time.Time
+	counted  int32 // set to 1 if sessions are being counted, accessed atomically
+	sessions int32 // accessed atomically
+	closed   int32 // close reason plus one once closed, accessed atomically
+	closing  int32 // close reason plus one once closed by the Server, accessed atomically
+}
+
+// setClosed records the reason the connection closed.
+func (st *connStats) setClosed(err error) {
+	atomic.StoreInt32(&st.closed, int32(closeReason(err))+1)
+}
+
+// closeFor closes the network connection for reason r, which is reported as
+// the reason it closed.
+func (st *connStats) closeFor(r CloseReason) {
+	atomic.CompareAndSwapInt32(&st.closing, 0, int32(r)+1)
+	_ = st.nc.Close()
+}
+
+// closingReason returns the reason passed to closeFor, and false if it hasn't
+// been called.
+func (st *connStats) closingReason() (CloseReason, bool) {
+	r := atomic.LoadInt32(&st.closing)
+	return CloseReason(r - 1), r > 0
+}
+
+// closeReason returns the reason the connection closed, and false if it is
+// not known.
+func (st *connStats) closeReason() (CloseReason, bool) {
+	r := atomic.LoadInt32(&st.closed)
+	return CloseReason(r - 1), r > 0
+}
+
+// sessionCount returns the number of sessions in progress, or -1 if not known.
+func (st *connStats) sessionCount() int {
+	if atomic.LoadInt32(&st.counted) == 0 {
+		return -1
+	}
+	return int(atomic.LoadInt32(&st.sessions))
+}
+
+type connStatsKey struct{}
+
+// statsFromContext returns the connStats for a connection served by a Server, or nil.
+func statsFromContext(ctx context.Context) *connStats {
+	st, _ := ctx.Value(connStatsKey{}).(*connStats)
+	return st
+}
+
 // Server is a generic network server.
+//
+// Server keeps track of the connections it is serving, which can be listed with
+// Connections and closed with CloseConn, Close or Shutdown.
 type Server struct {
 	// ServeConn is run on incoming network connections. It must close the
 	// supplied net.Conn when finsihed with it.
 	ServeConn func(net.Conn)
 
+	// Optional alternative to ServeConn that is passed a context that is canceled
+	// when the Server is closed. Session counts are reported by Connections for
+	// connections served by ServerConnHandler.ServeContext with this context.
+	ServeConnContext func(context.Context, net.Conn)
+
+	// Optional policy for errors accepting connections. If not defined
+	// DefaultAcceptErrorPolicy will be used.
+	AcceptErrorPolicy AcceptErrorPolicy
+
 	// Optional function to log errors. If not defined log.Print will be used.
 	Log func(...interface{})
+
+	mu        sync.Mutex
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closed    bool
+	draining  int32 // set to 1 while draining, accessed atomically
+	listeners map[net.Listener]struct{}
+	conns     map[*connStats]struct{}
+	closes    map[CloseReason]int
+}
+
+func (srv *Server) init() {
+	if srv.ctx == nil {
+		srv.ctx, srv.cancel = context.WithCancel(context.Background())
+		srv.listeners = make(map[net.Listener]struct{})
+		srv.conns = make(map[*connStats]struct{})
+		srv.closes = make(map[CloseReason]int)
+	}
+}
+
+// trackListener adds or removes a listener, returning false if the Server is closed.
+func (srv *Server) trackListener(l net.Listener, add bool) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.init()
+	if add {
+		if srv.closed {
+			return false
+		}
+		srv.listeners[l] = struct{}{}
+	} else {
+		delete(srv.listeners, l)
+	}
+	return true
+}
+
+// serveConn serves the network connection nc, tracking it while it is served.
+func (srv *Server) serveConn(nc net.Conn) {
+	st := &connStats{nc: nc, start: time.Now()}
+	srv.mu.Lock()
+	srv.init()
+	srv.conns[st] = struct{}{}
+	ctx := context.WithValue(srv.ctx, connStatsKey{}, st)
+	srv.mu.Unlock()
+
+	defer func() {
+		srv.mu.Lock()
+		delete(srv.conns, st)
+		if r, ok := st.closeReason(); ok {
+			srv.closes[r]++
+		}
+		srv.mu.Unlock()
+	}()
+	if srv.ServeConnContext != nil {
+		srv.ServeConnContext(ctx, nc)
+	} else {
+		srv.ServeConn(nc)
+	}
+}
+
+// Connections returns information on the connections currently being served.
+func (srv *Server) Connections() []ConnInfo {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	ci := make([]ConnInfo, 0, len(srv.conns))
+	for st := range srv.conns {
+		ci = append(ci, ConnInfo{
+			RemoteAddr: st.nc.RemoteAddr(),
+			LocalAddr:  st.nc.LocalAddr(),
+			Start:      st.start,
+			Sessions:   st.sessionCount(),
+		})
+	}
+	return ci
+}
+
+// CloseCounts returns the number of connections served that have closed, by
+// reason, distinguishing connections closed by the peer from those closed by
+// errors. Only connections served by ServerConnHandler.ServeContext with the
+// context passed to ServeConnContext are counted, along with connections closed
+// as soon as they are accepted while draining, which count as CloseShed.
+func (srv *Server) CloseCounts() map[CloseReason]int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	counts := make(map[CloseReason]int, len(srv.closes))
+	for r, n := range srv.closes {
+		counts[r] = n
+	}
+	return counts
+}
+
+// CloseConn closes the connections from the remote address addr, which can be
+// either a host or host:port address. It returns the number of connections closed.
+func (srv *Server) CloseConn(addr string) int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	n := 0
+	for st := range srv.conns {
+		ra := st.nc.RemoteAddr().String()
+		host, _, err := net.SplitHostPort(ra)
+		if ra == addr || (err == nil && host == addr) {
+			st.closeFor(CloseLocal)
+			n++
+		}
+	}
+	return n
+}
+
+// closeListeners closes all listeners and marks the Server as closed.
+func (srv *Server) closeListeners() error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.init()
+	srv.closed = true
+	var err error
+	for l := range srv.listeners {
+		if cerr := l.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(srv.listeners, l)
+	}
+	return err
+}
+
+// closeConns closes connections being served for reason r. If idle is set only
+// connections known to have no sessions in progress are closed. It returns the
+// number of connections still being served.
+func (srv *Server) closeConns(idle bool, r CloseReason) int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for st := range srv.conns {
+		if !idle || st.sessionCount() == 0 {
+			st.closeFor(r)
+		}
+	}
+	return len(srv.conns)
+}
+
+// SetDraining starts or stops draining the Server. While draining, accepted
+// connections are closed immediately and connections known to have no sessions
+// in progress are closed, so clients move to other servers, but the listeners
+// stay open so draining can be stopped.
+func (srv *Server) SetDraining(drain bool) {
+	var v int32
+	if drain {
+		v = 1
+	}
+	atomic.StoreInt32(&srv.draining, v)
+	if drain {
+		srv.closeConns(true, CloseShed)
+	}
+}
+
+// Draining reports whether the Server is draining.
+func (srv *Server) Draining() bool {
+	return atomic.LoadInt32(&srv.draining) == 1
+}
+
+// Close immediately closes all listeners and connections being served.
+func (srv *Server) Close() error {
+	err := srv.closeListeners()
+	srv.cancel()
+	srv.closeConns(false, CloseShutdown)
+	return err
+}
+
+// Shutdown closes all listeners, then closes connections once they have no sessions
+// in progress. Connections without session counts are waited on to close themselves.
+// If ctx is done before all connections have closed, the remaining connections are
+// closed and the context error is returned.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	err := srv.closeListeners()
+	t := time.NewTicker(10 * time.Millisecond)
+	defer t.Stop()
+	for srv.closeConns(true, CloseShutdown) > 0 {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			_ = srv.Close() // ignore error, can only return one
+			return ctx.Err()
+		}
+	}
+	srv.cancel()
+	return err
 }
 
 // Serve accepts incoming connections on the net.Listener l, creating a new
@@ -283,28 +1039,69 @@ func (srv *Server) Serve(l net.Listener) error {
 	if logErr == nil {
 		logErr = log.Print
 	}
+	if !srv.trackListener(l, true) {
+		_ = l.Close()
+		return ErrServerClosed
+	}
+	defer srv.trackListener(l, false)
 
-	var tempDelay time.Duration
+	policy := srv.AcceptErrorPolicy
+	if policy == nil {
+		policy = DefaultAcceptErrorPolicy
+	}
+	failures := 0
 	for {
 		c, err := l.Accept()
 		if err != nil {
-			if ne, ok := err.(net.Error); ok && ne.Temporary() {
-				if tempDelay == 0 {
-					tempDelay = 5 * time.Millisecond
-				} else {
-					tempDelay *= 2
-				}
-				if max := 1 * time.Second; tempDelay > max {
-					tempDelay = max
+			srv.mu.Lock()
+			closed := srv.closed
+			srv.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			failures++
+			action, delay := policy(err, failures)
+			switch action {
+			case AcceptShed:
+				if addr := srv.shedIdle(); addr != nil {
+					logErr("Accept error: ", err, " closed idle connection from ", addr)
 				}
-				logErr("Accept error: ", err, " retrying in ", tempDelay)
-				time.Sleep(tempDelay)
+				fallthrough
+			case AcceptRetry:
+				logErr("Accept error: ", err, " retrying in ", delay)
+				time.Sleep(delay)
 				continue
 			}
 			_ = l.Close() // ignore error, can only return one
 			return err
 		}
-		tempDelay = 0
-		go srv.ServeConn(c)
+		failures = 0
+		if srv.Draining() {
+			_ = c.Close()
+			srv.mu.Lock()
+			srv.init()
+			srv.closes[CloseShed]++
+			srv.mu.Unlock()
+			continue
+		}
+		go srv.serveConn(c)
+	}
+}
+
+// shedIdle closes the oldest connection known to have no sessions in progress,
+// returning its remote address, or nil if there is none.
+func (srv *Server) shedIdle() net.Addr {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	var oldest *connStats
+	for st := range srv.conns {
+		if st.sessionCount() == 0 && (oldest == nil || st.start.Before(oldest.start)) {
+			oldest = st
+		}
+	}
+	if oldest == nil {
+		return nil
 	}
+	oldest.closeFor(CloseShed)
+	return oldest.nc.RemoteAddr()
 }
@@ -2,6 +2,7 @@ package tacplus
 
 import (
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"net"
@@ -160,6 +161,15 @@ func (t *testLog) close() {
 	}
 }
 
+// closeConns closes all server connections, leaving the listener open.
+func (t *testLog) closeConns() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, c := range t.connLog {
+		_ = c.Close()
+	}
+}
+
 func (t *testLog) connCount() int {
 	t.mu.Lock()
 	defer t.mu.Unlock()
@@ -192,6 +202,15 @@ func (t *testLog) err() error {
 	return err
 }
 
+// skipWithoutMD5 skips a test using an obfuscating Secret in tacplus_nomd5
+// builds, which refuse connections that aren't Secretless.
+func skipWithoutMD5(t *testing.T) {
+	t.Helper()
+	if !md5Available {
+		t.Skip("packet obfuscation is left out of tacplus_nomd5 builds")
+	}
+}
+
 func newTestInstance(h *ServerConnHandler) (*testLog, *Client, error) {
 	l, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
@@ -228,6 +247,7 @@ func newTestInstance(h *ServerConnHandler) (*testLog, *Client, error) {
 }
 
 func TestServe(t *testing.T) {
+	skipWithoutMD5(t)
 	s, c, err := newTestInstance(nil)
 	if err != nil {
 		t.Fatal(err)
@@ -263,6 +283,7 @@ func TestServe(t *testing.T) {
 }
 
 func TestEncryption(t *testing.T) {
+	skipWithoutMD5(t)
 	s, c, err := newTestInstance(nil)
 	if err != nil {
 		t.Fatal(err)
@@ -277,12 +298,12 @@ func TestEncryption(t *testing.T) {
 	c.Close()
 
 	c.ConnConfig.Secret = []byte("bad secret")
-	if _, err = c.SendAcctRequest(ctx, testAcctReq); err != errBadPacket {
+	if _, err = c.SendAcctRequest(ctx, testAcctReq); err != ErrBadSecret {
 		t.Fatal(err)
 	}
 
-	if err := s.err(); err != errBadPacket {
-		t.Fatalf("want %v: got %v", errBadPacket, err)
+	if err := s.err(); err != ErrBadSecret {
+		t.Fatalf("want %v: got %v", ErrBadSecret, err)
 	}
 }
 
@@ -333,6 +354,7 @@ func testMux(t *testing.T, cmux, clmux, smux, slmux bool, count int) {
 }
 
 func TestConnectionMux(t *testing.T) {
+	skipWithoutMD5(t)
 	var muxTests = []struct {
 		smux, slmux bool
 		cmux, clmux bool
@@ -358,10 +380,18 @@ func TestConnectionMux(t *testing.T) {
 
 	for _, test := range muxTests {
 		testMux(t, test.cmux, test.clmux, test.smux, test.slmux, test.count)
+
+		// NegotiateMux predicts the connections used
+		o := NegotiateMux(ConnConfig{Mux: test.cmux, LegacyMux: test.clmux}, ConnConfig{Mux: test.smux, LegacyMux: test.slmux})
+		count := map[MuxMode]int{MuxNone: 3, MuxClientOnly: 2, MuxSingleConnect: 1, MuxLegacy: 1}[o.Mode]
+		if count != test.count || o.Reason == "" {
+			t.Errorf("%+v: NegotiateMux got %v (%s), want %d connections", test, o.Mode, o.Reason, test.count)
+		}
 	}
 }
 
 func TestRequestHandlerNilReturn(t *testing.T) {
+	skipWithoutMD5(t)
 	s, c, err := newTestInstance(nil)
 	if err != nil {
 		t.Fatal(err)
@@ -409,8 +439,635 @@ func TestRequestHandlerNilReturn(t *testing.T) {
 		c.ConnConfig.Mux = false
 		err = f(ctx)
 		cancel()
-		if err != errSessionClosed {
-			t.Error(desc, "expected:", errSessionClosed, ", got:", err)
+		var ce *ConnClosedError
+		if !errors.As(err, &ce) || ce.Reason != ClosePeer {
+			t.Error(desc, "expected:", ClosePeer, ", got:", err)
+		}
+	}
+}
+
+func TestErrorReplyTimeout(t *testing.T) {
+	skipWithoutMD5(t)
+	h := testHandler
+	h.ConnConfig.ErrorReplyTimeout = timeScale
+	h.ConnConfig.Log = func(...interface{}) {}
+
+	nc, sc := net.Pipe()
+	defer nc.Close()
+	done := make(chan struct{})
+	go func() {
+		h.Serve(sc)
+		close(done)
+	}()
+
+	// send a packet with an invalid session type, but never read the error reply
+	p := make([]byte, hdrLen, hdrLen+4)
+	p[hdrVer] = verDefault
+	p[hdrType] = 0xff
+	p[hdrSeqNo] = 1
+	p = append(p, 1, 2, 3, 4)
+	binary.BigEndian.PutUint32(p[hdrBodyLen:], 4)
+	crypt(p, h.ConnConfig.Secret)
+	if _, err := nc.Write(p); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(10 * timeScale):
+		t.Fatal("server blocked sending error reply")
+	}
+}
+
+type testRequestHandlerV2 struct{}
+
+func (testRequestHandlerV2) HandleAuthenStart(ctx context.Context, a *AuthenStart, s *ServerSession) (*AuthenReply, error) {
+	return nil, errors.New("ldap: connection refused")
+}
+
+func (testRequestHandlerV2) HandleAuthorRequest(ctx context.Context, a *AuthorRequest, s *ServerSession) (*AuthorResponse, error) {
+	return nil, &ErrorReply{Code: "bad-user", Msg: "unknown user", Fail: true}
+}
+
+func (testRequestHandlerV2) HandleAcctRequest(ctx context.Context, a *AcctRequest, s *ServerSession) (*AcctReply, error) {
+	return &AcctReply{Status: AcctStatusSuccess}, nil
+}
+
+func TestRequestHandlerV2(t *testing.T) {
+	skipWithoutMD5(t)
+	h := ServerConnHandler{
+		Handler:    HandlerV2(testRequestHandlerV2{}),
+		ConnConfig: testHandler.ConnConfig,
+	}
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	defer c.Close()
+
+	ctx := context.Background()
+	authen, _, err := c.SendAuthenStart(ctx, testAuthStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authen.Status != AuthenStatusError || authen.ServerMsg != errInternal {
+		t.Errorf("want status %d msg %q: got %d %q", AuthenStatusError, errInternal, authen.Status, authen.ServerMsg)
+	}
+	if err = s.err(); err == nil || err.Error() != "ldap: connection refused" {
+		t.Error("handler error not logged:", err)
+	}
+
+	author, err := c.SendAuthorRequest(ctx, testAuthorReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if re, ok := author.Err().(*ReplyError); !ok || re.Status != AuthorStatusFail || re.Code != "bad-user" {
+		t.Errorf("unexpected author response error: %v", author.Err())
+	}
+
+	acct, err := c.SendAcctRequest(ctx, testAcctReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acct.Status != AcctStatusSuccess {
+		t.Errorf("want status %d: got %d", AcctStatusSuccess, acct.Status)
+	}
+	if err = s.err(); err != nil {
+		t.Fatal("unexpected server/client error:", err)
+	}
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	skipWithoutMD5(t)
+	h := delayHandler
+	h.ConnConfig.HandlerTimeout = timeScale
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*timeScale)
+	defer cancel()
+	reply, err := c.SendAcctRequest(ctx, testAcctReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Status != AcctStatusError || reply.ServerMsg != errHandlerTimeout.Error() {
+		t.Errorf("want status %d msg %q: got %d %q", AcctStatusError, errHandlerTimeout, reply.Status, reply.ServerMsg)
+	}
+	if err = s.err(); err != errHandlerTimeout {
+		t.Errorf("want %v: got %v", errHandlerTimeout, err)
+	}
+
+	// handler times out while waiting for the client to continue
+	h = testHandler
+	h.ConnConfig.HandlerTimeout = timeScale
+	s2, c2, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s2.close()
+	defer c2.Close()
+	_, sess, err := c2.SendAuthenStart(ctx, testAuthStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * timeScale)
+	if r, err := sess.Continue(ctx, "user"); err == nil && r.Status != AuthenStatusError {
+		t.Error("expected error continuing timed out session")
+	}
+	if err = s2.err(); err != errHandlerTimeout {
+		t.Errorf("want %v: got %v", errHandlerTimeout, err)
+	}
+}
+
+type ctxKey struct{}
+
+// ctxRequestHandler reports the value of ctxKey in the accounting request
+// context, then waits for the context to be canceled.
+type ctxRequestHandler struct {
+	RequestHandler
+	value    chan interface{}
+	canceled chan struct{}
+}
+
+func (h *ctxRequestHandler) HandleAcctRequest(ctx context.Context, a *AcctRequest, s *ServerSession) *AcctReply {
+	h.value <- ctx.Value(ctxKey{})
+	<-ctx.Done()
+	close(h.canceled)
+	return nil
+}
+
+func TestServeContext(t *testing.T) {
+	skipWithoutMD5(t)
+	rh := &ctxRequestHandler{testHandler.Handler, make(chan interface{}, 1), make(chan struct{})}
+	h := ServerConnHandler{Handler: rh, ConnConfig: testHandler.ConnConfig}
+
+	nc, sc := net.Pipe()
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "value"))
+	defer cancel()
+	served := make(chan struct{})
+	go func() {
+		h.ServeContext(ctx, sc)
+		close(served)
+	}()
+
+	c := &Client{
+		ConnConfig: ConnConfig{Secret: testSecret},
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return nc, nil
+		},
+	}
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c.SendAcctRequest(context.Background(), testAcctReq)
+		errc <- err
+	}()
+
+	if v := <-rh.value; v != "value" {
+		t.Errorf("want context value %q: got %v", "value", v)
+	}
+	cancel()
+	select {
+	case <-rh.canceled:
+	case <-time.After(5 * timeScale):
+		t.Fatal("handler context not canceled")
+	}
+	<-served
+	if err := <-errc; !errors.Is(err, ErrConnClosed) {
+		t.Errorf("want %v: got %v", ErrConnClosed, err)
+	}
+}
+
+// waitSessions waits for the first connection served by srv to have n sessions.
+func waitSessions(t *testing.T, srv *Server, n int) {
+	t.Helper()
+	for i := 0; i < 50; i++ {
+		if ci := srv.Connections(); len(ci) == 1 && ci[0].Sessions == n {
+			return
 		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("want 1 connection with %d sessions: got %+v", n, srv.Connections())
+}
+
+// waitClientConns waits for c to have n cached connections.
+func waitClientConns(t *testing.T, c *Client, n int) {
+	t.Helper()
+	for i := 0; i < 50; i++ {
+		c.mu.Lock()
+		got := len(c.conns)
+		c.mu.Unlock()
+		if got == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("want %d cached client connections", n)
+}
+
+func TestServerConnections(t *testing.T) {
+	skipWithoutMD5(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := testHandler
+	h.ConnConfig.Log = func(...interface{}) {}
+	srv := &Server{ServeConnContext: h.ServeContext}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(l) }()
+
+	c := &Client{Addr: l.Addr().String(), ConnConfig: testHandler.ConnConfig}
+	defer c.Close()
+	ctx := context.Background()
+
+	_, sess, err := c.SendAuthenStart(ctx, testAuthStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitSessions(t, srv, 1)
+	if err = sess.Abort(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	waitSessions(t, srv, 0)
+	if n := srv.CloseConn("127.0.0.1"); n != 1 {
+		t.Fatalf("want 1 connection closed: got %d", n)
+	}
+	// authentication starts aren't retried on a new connection
+	waitClientConns(t, c, 0)
+
+	// Shutdown waits for the session in progress before closing
+	_, sess, err = c.SendAuthenStart(ctx, testAuthStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitSessions(t, srv, 1)
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- srv.Shutdown(ctx) }()
+	if err = <-served; err != ErrServerClosed {
+		t.Errorf("want %v: got %v", ErrServerClosed, err)
+	}
+	if _, err = sess.Continue(ctx, "user"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sess.Continue(ctx, "password123"); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-shutdown; err != nil {
+		t.Fatal(err)
+	}
+	if ci := srv.Connections(); len(ci) != 0 {
+		t.Errorf("want no connections: got %+v", ci)
+	}
+	if counts := srv.CloseCounts(); counts[CloseLocal] != 1 || counts[CloseShutdown] != 1 {
+		t.Errorf("want 1 connection closed locally and 1 shut down: got %v", counts)
+	}
+}
+
+func TestServerConnEvents(t *testing.T) {
+	skipWithoutMD5(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan ConnEvent, 4)
+	h := testHandler
+	h.ConnConfig.Log = func(...interface{}) {}
+	h.OnConnect = func(e ConnEvent) { events <- e }
+	h.OnDisconnect = func(e ConnEvent) { events <- e }
+	srv := &Server{ServeConnContext: h.ServeContext}
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Close()
+
+	next := func() ConnEvent {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(10 * timeScale):
+			t.Fatal("connection event not received")
+		}
+		return ConnEvent{}
+	}
+
+	// a connection that isn't multiplexed closes when its session is done
+	c := &Client{Addr: l.Addr().String(), ConnConfig: ConnConfig{Secret: testSecret}}
+	if _, err = c.SendAcctRequest(context.Background(), testAcctReq); err != nil {
+		t.Fatal(err)
+	}
+	if e := next(); e.Addr == "" || e.Err != nil {
+		t.Errorf("unexpected connect event: %+v", e)
+	}
+	if e := next(); e.Reason != CloseDone || e.Sessions != 1 || e.Context == nil {
+		t.Errorf("want close reason %v with 1 session: got %+v", CloseDone, e)
+	}
+
+	// a multiplexed connection closed by the server shutting down
+	c = &Client{Addr: l.Addr().String(), ConnConfig: testHandler.ConnConfig}
+	defer c.Close()
+	if _, err = c.SendAcctRequest(context.Background(), testAcctReq); err != nil {
+		t.Fatal(err)
+	}
+	next()
+	srv.Close()
+	if e := next(); e.Reason != CloseShutdown || e.Sessions != 1 {
+		t.Errorf("want close reason %v with 1 session: got %+v", CloseShutdown, e)
+	}
+}
+
+func TestVersionPolicy(t *testing.T) {
+	skipWithoutMD5(t)
+	as := &AuthenStart{
+		Action:        AuthenActionLogin,
+		AuthenType:    AuthenTypeASCII,
+		AuthenService: AuthenServiceLogin,
+		User:          "user",
+	}
+	body, err := as.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		policy VersionPolicy
+		ver    uint8
+		errRep bool
+	}{
+		{VersionStrict, verDefault, true},
+		{VersionAccept, verDefaultMinorOne, false},
+		{VersionRemap, verDefault, false},
+	} {
+		h := testHandler
+		h.ConnConfig.VersionPolicy = test.policy
+		s, c, err := newTestInstance(&h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := context.Background()
+		rs, err := c.NewRawSession(ctx, verDefaultMinorOne, sessTypeAuthen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = rs.WritePacket(ctx, body); err != nil {
+			t.Fatal(err)
+		}
+		p, err := rs.ReadPacket(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs.Close()
+		rep := new(AuthenReply)
+		if err = rep.UnmarshalBinary(p.Body); err != nil {
+			t.Fatal(err)
+		}
+		if p.Version != test.ver {
+			t.Errorf("policy %d: got reply version %#x, want %#x", test.policy, p.Version, test.ver)
+		}
+		if (rep.Status == AuthenStatusError) != test.errRep {
+			t.Errorf("policy %d: unexpected reply status %d: %s", test.policy, rep.Status, rep.ServerMsg)
+		}
+		s.close()
+	}
+}
+
+// promptLoopHandler prompts the client until an error occurs.
+type promptLoopHandler struct {
+	RequestHandler
+	errs chan error
+}
+
+func (h *promptLoopHandler) HandleAuthenStart(ctx context.Context, a *AuthenStart, s *ServerSession) *AuthenReply {
+	for {
+		if _, err := s.GetData(ctx, "again", false); err != nil {
+			h.errs <- err
+			return &AuthenReply{Status: AuthenStatusError}
+		}
+	}
+}
+
+func TestMaxAuthenPrompts(t *testing.T) {
+	skipWithoutMD5(t)
+	ph := &promptLoopHandler{RequestHandler: testHandler.Handler, errs: make(chan error, 1)}
+	h := testHandler
+	h.Handler = ph
+	h.ConnConfig.MaxAuthenPrompts = 3
+	l, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.close()
+
+	ctx := context.Background()
+	rep, s, err := c.SendAuthenStart(ctx, testAuthStart)
+	for i := 0; err == nil && rep.Status == AuthenStatusGetData; i++ {
+		if i == 3 {
+			t.Fatal("more prompts than MaxAuthenPrompts")
+		}
+		rep, err = s.Continue(ctx, "data")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Status != AuthenStatusFail || rep.ServerMsg != errTooManyPrompts.Error() {
+		t.Errorf("got reply %+v", rep)
+	}
+	if err = <-ph.errs; err != errTooManyPrompts {
+		t.Errorf("handler got error %v, want %v", err, errTooManyPrompts)
+	}
+}
+
+func TestMaxAuthenDuration(t *testing.T) {
+	skipWithoutMD5(t)
+	ph := &promptLoopHandler{RequestHandler: testHandler.Handler, errs: make(chan error, 1)}
+	h := testHandler
+	h.Handler = ph
+	h.ConnConfig.MaxAuthenDuration = 2 * timeScale
+	l, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.close()
+
+	ctx := context.Background()
+	rep, s, err := c.SendAuthenStart(ctx, testAuthStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Status != AuthenStatusGetData {
+		t.Fatalf("got status %d, want %d", rep.Status, AuthenStatusGetData)
+	}
+	// the client is slow to answer, so the server fails the session
+	time.Sleep(3 * timeScale)
+	if err = <-ph.errs; err != errAuthenTooLong {
+		t.Errorf("handler got error %v, want %v", err, errAuthenTooLong)
+	}
+	if rep, err = s.Continue(ctx, "data"); err == nil && rep.Status != AuthenStatusError {
+		t.Errorf("continue after session closed got status %d", rep.Status)
+	}
+}
+
+// abortHandler records the error from prompting the client and the session error.
+type abortHandler struct {
+	RequestHandler
+	errs chan error
+}
+
+func (h *abortHandler) HandleAuthenStart(ctx context.Context, a *AuthenStart, s *ServerSession) *AuthenReply {
+	_, err := s.GetUser(ctx, "Username:")
+	h.errs <- err
+	h.errs <- s.Err()
+	return &AuthenReply{Status: AuthenStatusFail}
+}
+
+func TestClientAbort(t *testing.T) {
+	skipWithoutMD5(t)
+	ah := &abortHandler{RequestHandler: testHandler.Handler, errs: make(chan error, 2)}
+	events := make(chan SecurityEvent, 1)
+	h := testHandler
+	h.Handler = ah
+	h.ConnConfig.OnSecurityEvent = func(e SecurityEvent) { events <- e }
+	l, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.close()
+
+	ctx := context.Background()
+	_, s, err := c.SendAuthenStart(ctx, testAuthStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Abort(ctx, "user gave up"); err != nil {
+		t.Fatal(err)
+	}
+	var ae *AbortError
+	if err = <-ah.errs; !errors.As(err, &ae) || ae.Reason != "user gave up" {
+		t.Errorf("got prompt error %v", err)
+	}
+	if err = <-ah.errs; !errors.As(err, &ae) {
+		t.Errorf("got session error %v, want *AbortError", err)
+	}
+	if e := <-events; e.Kind != EventClientAbort || !errors.As(e.Err, &ae) {
+		t.Errorf("got event %+v", e)
+	}
+}
+
+// causeHandler records the cause of its context being canceled while prompting.
+type causeHandler struct {
+	RequestHandler
+	causes chan error
+}
+
+func (h *causeHandler) HandleAuthenStart(ctx context.Context, a *AuthenStart, s *ServerSession) *AuthenReply {
+	_, _ = s.GetUser(ctx, "Username:")
+	<-ctx.Done()
+	h.causes <- context.Cause(ctx)
+	return nil
+}
+
+func TestSessionContextCause(t *testing.T) {
+	skipWithoutMD5(t)
+	ch := &causeHandler{RequestHandler: testHandler.Handler, causes: make(chan error, 1)}
+	h := testHandler
+	h.Handler = ch
+	l, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.close()
+
+	ctx := context.Background()
+	if _, _, err = c.SendAuthenStart(ctx, testAuthStart); err != nil {
+		t.Fatal(err)
+	}
+	// closing the client hangs up the connection mid-session
+	c.Close()
+	var ce *ConnClosedError
+	select {
+	case err = <-ch.causes:
+		if !errors.As(err, &ce) || ce.Reason != ClosePeer {
+			t.Errorf("got cause %v, want close reason %v", err, ClosePeer)
+		}
+	case <-time.After(5 * timeScale):
+		t.Fatal("handler context not canceled")
+	}
+}
+
+type otpUserKey struct{}
+
+// otpHandler prompts for a user name, password and one-time password,
+// keeping the user name in the session between prompts.
+type otpHandler struct {
+	RequestHandler
+}
+
+func (h otpHandler) prompt(ctx context.Context, s *ServerSession) bool {
+	c, err := s.GetPass(ctx, "OTP:")
+	if err != nil || c.Abort {
+		return false
+	}
+	user, _ := s.Value(otpUserKey{}).(string)
+	return user == "fred" && c.Message == "123456"
+}
+
+func (h otpHandler) HandleAuthenStart(ctx context.Context, a *AuthenStart, s *ServerSession) *AuthenReply {
+	if s.Value(otpUserKey{}) != nil {
+		return &AuthenReply{Status: AuthenStatusError}
+	}
+	c, err := s.GetUser(ctx, "Username:")
+	if err != nil || c.Abort {
+		return nil
+	}
+	s.SetValue(otpUserKey{}, c.Message)
+	if c, err = s.GetPass(ctx, "Password:"); err != nil || c.Abort || c.Message != "pass" {
+		return &AuthenReply{Status: AuthenStatusFail}
+	}
+	if !h.prompt(ctx, s) {
+		return &AuthenReply{Status: AuthenStatusFail}
+	}
+	s.SetValue(otpUserKey{}, nil)
+	if s.Value(otpUserKey{}) != nil {
+		return &AuthenReply{Status: AuthenStatusError}
+	}
+	return &AuthenReply{Status: AuthenStatusPass}
+}
+
+func TestServerSessionValue(t *testing.T) {
+	skipWithoutMD5(t)
+	h := testHandler
+	h.Handler = otpHandler{testHandler.Handler}
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	defer c.Close()
+
+	ctx := context.Background()
+	for _, user := range []string{"fred", "bob", "fred"} {
+		_, sess, err := c.SendAuthenStart(ctx, testAuthStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rep *AuthenReply
+		for _, msg := range []string{user, "pass", "123456"} {
+			if rep, err = sess.Continue(ctx, msg); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want := uint8(AuthenStatusFail)
+		if user == "fred" {
+			want = AuthenStatusPass
+		}
+		if rep.Status != want {
+			t.Errorf("user %s: got status %d, want %d", user, rep.Status, want)
+		}
+		sess.Close()
+	}
+	if err = s.err(); err != nil {
+		t.Error(err)
 	}
 }
@@ -0,0 +1,174 @@
+package tacplus
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultMaxShadows is the default ShadowHandler MaxPending.
+const defaultMaxShadows = 100
+
+// ShadowMismatch describes a shadow handler response that differs from the
+// primary handler's response.
+type ShadowMismatch struct {
+	Request interface{} // *AuthorRequest or *AcctRequest
+	Primary interface{} // primary handler response
+	Shadow  interface{} // shadow handler response
+}
+
+func (m ShadowMismatch) String() string {
+	return fmt.Sprintf("shadow handler mismatch for %+v: primary %+v, shadow %+v", m.Request, m.Primary, m.Shadow)
+}
+
+// ShadowHandler is a RequestHandler that sends a copy of each authorization and
+// accounting request to a Shadow handler, comparing its response with that of the
+// Primary handler. Only the Primary response is sent to the client, so a new
+// handler can be validated against production traffic.
+//
+// Shadow requests are run in a separate goroutine after the primary handler
+// returns, with a context that is not canceled when the session closes. The
+// shadow handler gets a copy of the session that can't reach the client, as
+// the session may have closed. Only the fields sent to the client are
+// compared, so responses differing only in DenyReason match. Authentication
+// requests can be interactive, so they are only sent to Primary.
+//
+// No more than MaxPending shadow requests run at once, so a slow shadow
+// handler can't pile up goroutines. Further shadow requests are dropped, and
+// counted by Dropped.
+type ShadowHandler struct {
+	Primary RequestHandler
+	Shadow  RequestHandler
+
+	// Maximum time for the shadow handler to respond. Ignored if zero.
+	Timeout time.Duration
+
+	// Maximum number of shadow requests running at once. Defaults to 100 if zero.
+	MaxPending int
+
+	// Optional function called with mismatched responses. If not set they are
+	// logged with the session's Log function.
+	OnMismatch func(ShadowMismatch)
+
+	mu      sync.Mutex
+	pending int   // shadow requests running
+	dropped int64 // shadow requests dropped because MaxPending were running
+}
+
+// Dropped returns the number of shadow requests dropped because MaxPending
+// were already running.
+func (h *ShadowHandler) Dropped() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.dropped
+}
+
+// acquire reserves a slot for a shadow request, returning false and counting
+// the request as dropped if MaxPending are running.
+func (h *ShadowHandler) acquire() bool {
+	max := h.MaxPending
+	if max <= 0 {
+		max = defaultMaxShadows
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.pending >= max {
+		h.dropped++
+		return false
+	}
+	h.pending++
+	return true
+}
+
+func (h *ShadowHandler) release() {
+	h.mu.Lock()
+	h.pending--
+	h.mu.Unlock()
+}
+
+// shadow runs f with a context carrying the values of ctx and a detached copy
+// of s, calling OnMismatch if the result differs from primary. The request is
+// dropped if MaxPending shadow requests are running.
+func (h *ShadowHandler) shadow(ctx context.Context, s *ServerSession, req, primary interface{}, f func(context.Context, *ServerSession) interface{}) {
+	if !h.acquire() {
+		return
+	}
+	s = s.detach()
+	// rules the shadow reports didn't decide the request
+	ctx = WithRuleTrace(valuesContext{ctx}, nil)
+	go func() {
+		defer h.release()
+		if h.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+			defer cancel()
+		}
+		r := f(ctx, s)
+		if sameReply(r, primary) {
+			return
+		}
+		m := ShadowMismatch{Request: req, Primary: primary, Shadow: r}
+		if h.OnMismatch != nil {
+			h.OnMismatch(m)
+		} else {
+			s.Log(m)
+		}
+	}()
+}
+
+// sameReply reports whether the responses a and b, both *AuthorResponse or
+// both *AcctReply, would be sent to the client the same. Internal fields
+// such as DenyReason are ignored.
+func sameReply(a, b interface{}) bool {
+	switch a := a.(type) {
+	case *AuthorResponse:
+		b := b.(*AuthorResponse)
+		if a == nil || b == nil {
+			return a == b
+		}
+		if a.Status != b.Status || a.ServerMsg != b.ServerMsg || a.Data != b.Data || len(a.Arg) != len(b.Arg) {
+			return false
+		}
+		for i := range a.Arg {
+			if a.Arg[i] != b.Arg[i] {
+				return false
+			}
+		}
+		return true
+	case *AcctReply:
+		b := b.(*AcctReply)
+		if a == nil || b == nil {
+			return a == b
+		}
+		return a.Status == b.Status && a.ServerMsg == b.ServerMsg && a.Data == b.Data
+	}
+	return false
+}
+
+// HandleAuthenStart calls the HandleAuthenStart method of Primary.
+func (h *ShadowHandler) HandleAuthenStart(ctx context.Context, a *AuthenStart, s *ServerSession) *AuthenReply {
+	return h.Primary.HandleAuthenStart(ctx, a, s)
+}
+
+// HandleAuthorRequest returns the response of Primary, comparing it with Shadow.
+func (h *ShadowHandler) HandleAuthorRequest(ctx context.Context, a *AuthorRequest, s *ServerSession) *AuthorResponse {
+	req := *a
+	req.Arg = append([]string(nil), a.Arg...)
+	r := h.Primary.HandleAuthorRequest(ctx, a, s)
+	h.shadow(ctx, s, &req, r, func(ctx context.Context, s *ServerSession) interface{} {
+		return h.Shadow.HandleAuthorRequest(ctx, &req, s)
+	})
+	return r
+}
+
+// HandleAcctRequest returns the reply of Primary, comparing it with Shadow.
+func (h *ShadowHandler) HandleAcctRequest(ctx context.Context, a *AcctRequest, s *ServerSession) *AcctReply {
+	req := *a
+	req.Arg = append([]string(nil), a.Arg...)
+	r := h.Primary.HandleAcctRequest(ctx, a, s)
+	h.shadow(ctx, s, &req, r, func(ctx context.Context, s *ServerSession) interface{} {
+		return h.Shadow.HandleAcctRequest(ctx, &req, s)
+	})
+	return r
+}
@@ -0,0 +1,233 @@
+//go:build !tacplus_nomd5
+
+package tacplus
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// failFredHandler fails authorization requests for fred.
+type failFredHandler struct {
+	RequestHandler
+}
+
+func (h failFredHandler) HandleAuthorRequest(ctx context.Context, a *AuthorRequest, s *ServerSession) *AuthorResponse {
+	if a.User == "fred" {
+		return &AuthorResponse{Status: AuthorStatusFail}
+	}
+	return h.RequestHandler.HandleAuthorRequest(ctx, a, s)
+}
+
+func TestShadowHandler(t *testing.T) {
+	mismatches := make(chan ShadowMismatch, 10)
+	h := testHandler
+	h.Handler = &ShadowHandler{
+		Primary:    testHandler.Handler,
+		Shadow:     failFredHandler{testHandler.Handler},
+		OnMismatch: func(m ShadowMismatch) { mismatches <- m },
+	}
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	ctx := context.Background()
+	for _, user := range []string{"user", "fred"} {
+		req := *testAuthorReq
+		req.User = user
+		resp, err := c.SendAuthorRequest(ctx, &req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != AuthorStatusPassAdd {
+			t.Errorf("%s: got status %d, want primary status %d", user, resp.Status, AuthorStatusPassAdd)
+		}
+	}
+	if _, err = c.SendAcctRequest(ctx, testAcctReq); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case m := <-mismatches:
+		if m.Request.(*AuthorRequest).User != "fred" || m.Shadow.(*AuthorResponse).Status != AuthorStatusFail {
+			t.Errorf("unexpected mismatch %v", m)
+		}
+	case <-time.After(10 * timeScale):
+		t.Fatal("no mismatch reported")
+	}
+	select {
+	case m := <-mismatches:
+		t.Errorf("unexpected mismatch %v", m)
+	case <-time.After(timeScale):
+	}
+}
+
+// slowFailHandler fails authorization requests after a delay, or returns nil if
+// the ctx deadline is sooner or ctx is done first.
+type slowFailHandler struct {
+	RequestHandler
+	delay time.Duration
+}
+
+func (h slowFailHandler) HandleAuthorRequest(ctx context.Context, a *AuthorRequest, s *ServerSession) *AuthorResponse {
+	if d, ok := ctx.Deadline(); ok && time.Until(d) < h.delay {
+		return nil
+	}
+	select {
+	case <-time.After(h.delay):
+		return &AuthorResponse{Status: AuthorStatusFail}
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+func TestShadowHandlerTimeout(t *testing.T) {
+	mismatches := make(chan ShadowMismatch, 1)
+	h := testHandler
+	h.ConnConfig.HandlerTimeout = timeScale
+	h.Handler = &ShadowHandler{
+		Primary:    testHandler.Handler,
+		Shadow:     slowFailHandler{testHandler.Handler, 3 * timeScale},
+		Timeout:    10 * timeScale,
+		OnMismatch: func(m ShadowMismatch) { mismatches <- m },
+	}
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	defer c.Close()
+
+	if _, err = c.SendAuthorRequest(context.Background(), testAuthorReq); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m := <-mismatches:
+		if r, _ := m.Shadow.(*AuthorResponse); r == nil || r.Status != AuthorStatusFail {
+			t.Errorf("shadow cut off by handler timeout: got %+v", m.Shadow)
+		}
+	case <-time.After(20 * timeScale):
+		t.Fatal("no mismatch reported")
+	}
+}
+
+// sessionShadow waits until the primary's session closes, then passes the
+// session it was given to check and fails the request with a DenyReason.
+type sessionShadow struct {
+	RequestHandler
+	check func(*ServerSession)
+}
+
+func (h sessionShadow) HandleAuthorRequest(ctx context.Context, a *AuthorRequest, s *ServerSession) *AuthorResponse {
+	time.Sleep(2 * timeScale)
+	h.check(s)
+	r := h.RequestHandler.HandleAuthorRequest(ctx, a, s)
+	if r != nil {
+		r.DenyReason = "shadow"
+	}
+	return r
+}
+
+func TestShadowHandlerSession(t *testing.T) {
+	checked := make(chan error, 1)
+	mismatches := make(chan ShadowMismatch, 1)
+	h := testHandler
+	h.Handler = &ShadowHandler{
+		Primary: testHandler.Handler,
+		Shadow: sessionShadow{testHandler.Handler, func(s *ServerSession) {
+			if s.RemoteAddr() == nil || s.Err() == nil {
+				checked <- errors.New("session not detached")
+				return
+			}
+			_, err := s.GetData(context.Background(), "data", false)
+			checked <- err
+		}},
+		OnMismatch: func(m ShadowMismatch) { mismatches <- m },
+	}
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	if _, err = c.SendAuthorRequest(context.Background(), testAuthorReq); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err = <-checked:
+		if err != errSessionClosed {
+			t.Errorf("got %v, want %v", err, errSessionClosed)
+		}
+	case <-time.After(10 * timeScale):
+		t.Fatal("shadow handler not called")
+	}
+	// the responses differ only in DenyReason, which isn't sent
+	select {
+	case m := <-mismatches:
+		t.Errorf("unexpected mismatch %v", m)
+	case <-time.After(timeScale):
+	}
+}
+
+// blockedShadow blocks authorization requests until unblock is closed.
+type blockedShadow struct {
+	RequestHandler
+	unblock chan struct{}
+}
+
+func (h blockedShadow) HandleAuthorRequest(ctx context.Context, a *AuthorRequest, s *ServerSession) *AuthorResponse {
+	<-h.unblock
+	return h.RequestHandler.HandleAuthorRequest(ctx, a, s)
+}
+
+func TestShadowHandlerMaxPending(t *testing.T) {
+	unblock := make(chan struct{})
+	sh := &ShadowHandler{
+		Primary:    testHandler.Handler,
+		Shadow:     blockedShadow{testHandler.Handler, unblock},
+		MaxPending: 2,
+	}
+	h := testHandler
+	h.Handler = sh
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	defer c.Close()
+
+	for i := 0; i < 5; i++ {
+		if _, err = c.SendAuthorRequest(context.Background(), testAuthorReq); err != nil {
+			close(unblock)
+			t.Fatal(err)
+		}
+	}
+	if n := sh.Dropped(); n != 3 {
+		t.Errorf("got %d dropped shadow requests, want 3", n)
+	}
+	close(unblock)
+
+	// finished shadow requests free their slots
+	for i := 0; ; i++ {
+		sh.mu.Lock()
+		pending := sh.pending
+		sh.mu.Unlock()
+		if pending == 0 {
+			break
+		}
+		if i == 50 {
+			t.Fatalf("%d shadow requests still pending", pending)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err = c.SendAuthorRequest(context.Background(), testAuthorReq); err != nil {
+		t.Fatal(err)
+	}
+	if n := sh.Dropped(); n != 3 {
+		t.Errorf("got %d dropped shadow requests after unblocking, want 3", n)
+	}
+}
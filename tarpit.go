@@ -0,0 +1,143 @@
+package tacplus
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Tarpit defaults
+const (
+	defaultTarpitRefill   = time.Minute
+	defaultTarpitMaxDelay = 30 * time.Second
+)
+
+// tarpitBucket is the failure token bucket of a user and peer host.
+type tarpitBucket struct {
+	tokens float64   // failures allowed before replies are delayed, negative when in debt
+	t      time.Time // time tokens was last updated
+}
+
+// Tarpit is a RequestHandler that slows online password guessing by delaying
+// authentication Fail replies to users failing repeatedly from the same peer
+// host, without locking them out. Requests are passed to Handler.
+//
+// Failures are paced with a token bucket for each user and host holding up to
+// Burst tokens, refilled at one token each Refill. Each Fail reply takes a
+// token, and once the bucket is empty a reply is delayed by Delay for each
+// token owed, up to MaxDelay. A Pass reply empties the debt of the user and
+// host. The delay ends early if the session is closed.
+//
+// Users are taken from the authentication start packet, or the answer to
+// GetUser for ASCII logins. Delays count towards the HandlerTimeout, so it
+// should be longer than MaxDelay.
+type Tarpit struct {
+	Passthrough // handler for requests
+
+	Burst    int           // failures allowed without delay
+	Delay    time.Duration // delay per failure beyond Burst, no delay if zero
+	Refill   time.Duration // time to refill one token, defaults to a minute if zero
+	MaxDelay time.Duration // ceiling on delays, defaults to 30 seconds if zero
+
+	mu      sync.Mutex
+	buckets map[string]*tarpitBucket
+	swept   time.Time // last time full buckets were removed
+}
+
+func (tp *Tarpit) refill() time.Duration {
+	if tp.Refill > 0 {
+		return tp.Refill
+	}
+	return defaultTarpitRefill
+}
+
+func (tp *Tarpit) maxDelay() time.Duration {
+	if tp.MaxDelay > 0 {
+		return tp.MaxDelay
+	}
+	return defaultTarpitMaxDelay
+}
+
+// fill adds the tokens earned by b since its last update to now.
+func (tp *Tarpit) fill(b *tarpitBucket, now time.Time) {
+	b.tokens += float64(now.Sub(b.t)) / float64(tp.refill())
+	if max := float64(tp.Burst); b.tokens > max {
+		b.tokens = max
+	}
+	b.t = now
+}
+
+// fail takes a token for a failure by key at time now, returning the delay
+// for the reply.
+func (tp *Tarpit) fail(key string, now time.Time) time.Duration {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	if tp.buckets == nil {
+		tp.buckets = make(map[string]*tarpitBucket)
+	}
+	if now.Sub(tp.swept) >= tp.refill() {
+		for k, b := range tp.buckets {
+			if tp.fill(b, now); b.tokens >= float64(tp.Burst) {
+				delete(tp.buckets, k)
+			}
+		}
+		tp.swept = now
+	}
+	b := tp.buckets[key]
+	if b == nil {
+		b = &tarpitBucket{tokens: float64(tp.Burst), t: now}
+		tp.buckets[key] = b
+	}
+	tp.fill(b, now)
+	// limit the debt to what MaxDelay needs, so it is repaid in bounded time
+	if min := -math.Ceil(float64(tp.maxDelay()) / float64(tp.Delay)); b.tokens-1 >= min {
+		b.tokens--
+	}
+	if b.tokens >= 0 {
+		return 0
+	}
+	d := time.Duration(-b.tokens * float64(tp.Delay))
+	if d > tp.maxDelay() {
+		d = tp.maxDelay()
+	}
+	return d
+}
+
+// pass clears the debt of key after a successful authentication.
+func (tp *Tarpit) pass(key string) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	delete(tp.buckets, key)
+}
+
+// tarpitKey returns the key of the bucket for the authentication session s.
+func tarpitKey(s *ServerSession) string {
+	s.mu.Lock()
+	user := s.user
+	s.mu.Unlock()
+	return user + "\x00" + remoteHost(s)
+}
+
+// HandleAuthenStart calls the HandleAuthenStart method of Handler, delaying
+// Fail replies to users failing repeatedly.
+func (tp *Tarpit) HandleAuthenStart(ctx context.Context, a *AuthenStart, s *ServerSession) *AuthenReply {
+	r := tp.Passthrough.HandleAuthenStart(ctx, a, s)
+	if r == nil || tp.Delay <= 0 {
+		return r
+	}
+	switch r.Status {
+	case AuthenStatusPass:
+		tp.pass(tarpitKey(s))
+	case AuthenStatusFail:
+		if d := tp.fail(tarpitKey(s), time.Now()); d > 0 {
+			t := time.NewTimer(d)
+			defer t.Stop()
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+			}
+		}
+	}
+	return r
+}
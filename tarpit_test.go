@@ -0,0 +1,89 @@
+package tacplus
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTarpitFail(t *testing.T) {
+	tp := &Tarpit{Burst: 2, Delay: time.Second, Refill: time.Minute, MaxDelay: 3 * time.Second}
+	now := time.Now()
+	want := []time.Duration{0, 0, time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second}
+	for i, d := range want {
+		if got := tp.fail("fred", now); got != d {
+			t.Errorf("failure %d: got delay %v, want %v", i+1, got, d)
+		}
+	}
+	// debt is limited to what MaxDelay needs, so five refills leave a full bucket
+	now = now.Add(5 * time.Minute)
+	if got := tp.fail("fred", now); got != 0 {
+		t.Errorf("after refill got delay %v, want 0", got)
+	}
+	if got := tp.fail("bob", now); got != 0 {
+		t.Errorf("other user got delay %v, want 0", got)
+	}
+	tp.pass("fred")
+	if got := tp.fail("fred", now); got != 0 {
+		t.Errorf("after pass got delay %v, want 0", got)
+	}
+}
+
+func TestTarpit(t *testing.T) {
+	skipWithoutMD5(t)
+	h := testHandler
+	h.Handler = &Tarpit{
+		Passthrough: Passthrough{testHandler.Handler},
+		Burst:       1,
+		Delay:       2 * timeScale,
+		MaxDelay:    3 * timeScale,
+	}
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	defer c.Close()
+
+	ctx := context.Background()
+	login := func(pass string) (*AuthenReply, time.Duration) {
+		_, cs, err := c.SendAuthenStart(ctx, testAuthStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cs.Close()
+		if _, err = cs.Continue(ctx, "fred"); err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		rep, err := cs.Continue(ctx, pass)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rep, time.Since(start)
+	}
+
+	tests := []struct {
+		pass   string
+		status uint8
+		delay  time.Duration
+	}{
+		{"wrong", AuthenStatusFail, 0},
+		{"wrong", AuthenStatusFail, 2 * timeScale},
+		{"wrong", AuthenStatusFail, 3 * timeScale},
+		{"@password@", AuthenStatusPass, 0},
+		{"wrong", AuthenStatusFail, 0},
+	}
+	for i, tt := range tests {
+		rep, d := login(tt.pass)
+		if rep.Status != tt.status {
+			t.Errorf("login %d: got status %d, want %d", i+1, rep.Status, tt.status)
+		}
+		if d < tt.delay || d > tt.delay+timeScale {
+			t.Errorf("login %d: reply took %v, want %v", i+1, d, tt.delay)
+		}
+	}
+	if err = s.err(); err != nil {
+		t.Error(err)
+	}
+}
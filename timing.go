@@ -0,0 +1,60 @@
+package tacplus
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// RequestTiming is the wall time taken by a Client request.
+type RequestTiming struct {
+	Addr      string        // server address
+	Type      uint8         // session type
+	User      string        // user in the request
+	Dial      time.Duration // time spent connecting, zero if a cached connection was used
+	FirstByte time.Duration // time until the reply was received
+	Total     time.Duration // time until the request completed
+	Err       error         // request error, if any
+
+	start time.Time
+}
+
+func (rt RequestTiming) String() string {
+	s := fmt.Sprintf("%s request for user %q to %s took %v (dial %v, first byte %v)",
+		sessTypeName(rt.Type), rt.User, rt.Addr, rt.Total, rt.Dial, rt.FirstByte)
+	if rt.Err != nil {
+		s += ": " + rt.Err.Error()
+	}
+	return s
+}
+
+type requestTimingKey struct{}
+
+func timingFromContext(ctx context.Context) *RequestTiming {
+	rt, _ := ctx.Value(requestTimingKey{}).(*RequestTiming)
+	return rt
+}
+
+// requestUser returns the user of request packet req.
+func requestUser(req packet) string {
+	switch r := req.(type) {
+	case *AuthenStart:
+		return r.User
+	case *AuthorRequest:
+		return r.User
+	case *AcctRequest:
+		return r.User
+	}
+	return ""
+}
+
+// reportTiming completes rt, passing it to OnRequest and logging it if it was slow.
+func (c *Client) reportTiming(rt *RequestTiming) {
+	rt.Total = time.Since(rt.start)
+	if c.OnRequest != nil {
+		c.OnRequest(*rt)
+	}
+	if c.SlowRequestThreshold > 0 && rt.Total > c.SlowRequestThreshold {
+		c.ConnConfig.log("slow request: ", rt)
+	}
+}
@@ -0,0 +1,46 @@
+//go:build !tacplus_nomd5
+
+package tacplus
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRequestTiming(t *testing.T) {
+	l, c, err := newTestInstance(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.close()
+	defer c.Close()
+
+	timings := make(chan RequestTiming, 2)
+	c.OnRequest = func(rt RequestTiming) { timings <- rt }
+	logged := make(chan string, 2)
+	c.ConnConfig.Log = func(v ...interface{}) { logged <- fmt.Sprint(v...) }
+	c.SlowRequestThreshold = 1
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, err = c.SendAuthorRequest(ctx, testAuthorReq); err != nil {
+			t.Fatal(err)
+		}
+		rt := <-timings
+		if rt.Type != TypeAuthor || rt.User != testAuthorReq.User || rt.Addr != c.Addr || rt.Err != nil {
+			t.Errorf("unexpected timing %+v", rt)
+		}
+		if rt.FirstByte <= 0 || rt.Total < rt.FirstByte {
+			t.Errorf("first byte %v, total %v", rt.FirstByte, rt.Total)
+		}
+		// the second request uses the cached connection
+		if dialed := rt.Dial > 0; dialed != (i == 0) {
+			t.Errorf("request %d dial time %v", i, rt.Dial)
+		}
+		if msg := <-logged; !strings.HasPrefix(msg, "slow request: author request for user") {
+			t.Errorf("unexpected log message %q", msg)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package tacplus
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Direction is the direction of a traced packet.
+type Direction int
+
+// Direction values
+const (
+	DirIn  Direction = iota // packet received
+	DirOut                  // packet sent
+)
+
+func (d Direction) String() string {
+	if d == DirOut {
+		return "out"
+	}
+	return "in"
+}
+
+// MarshalText encodes the direction as "in" or "out".
+func (d Direction) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText decodes a direction encoded by MarshalText.
+func (d *Direction) UnmarshalText(b []byte) error {
+	switch string(b) {
+	case "in":
+		*d = DirIn
+	case "out":
+		*d = DirOut
+	default:
+		return errors.New("invalid direction " + string(b))
+	}
+	return nil
+}
+
+// TracedPacket is a packet sent or received on a connection, passed to the
+// ConnConfig PacketTrace function. Body is the decrypted packet body, so traces
+// contain secrets such as user passwords and must be handled with care.
+type TracedPacket struct {
+	Time       time.Time `json:"time"`
+	Dir        Direction `json:"dir"`
+	RemoteAddr string    `json:"remote_addr"`
+	LocalAddr  string    `json:"local_addr"`
+	Header     Header    `json:"header"`
+	Body       []byte    `json:"body"`
+}
+
+// Decode decodes the packet body, choosing the packet type from the header
+// type and sequence number. Sessions of other packet types return an error.
+func (p *TracedPacket) Decode() (Packet, error) {
+	var pkt Packet
+	request := p.Header.SeqNo&1 == 1
+	switch p.Header.Type {
+	case sessTypeAuthen:
+		switch {
+		case p.Header.SeqNo == 1:
+			pkt = new(AuthenStart)
+		case request:
+			pkt = new(AuthenContinue)
+		default:
+			pkt = new(AuthenReply)
+		}
+	case sessTypeAuthor:
+		if request {
+			pkt = new(AuthorRequest)
+		} else {
+			pkt = new(AuthorResponse)
+		}
+	case sessTypeAcct:
+		if request {
+			pkt = new(AcctRequest)
+		} else {
+			pkt = new(AcctReply)
+		}
+	default:
+		return nil, errors.New("unknown packet type")
+	}
+	return pkt, pkt.UnmarshalBinary(p.Body)
+}
+
+// trace calls the PacketTrace function, if set, with the unencrypted packet p.
+func (c *conn) trace(dir Direction, p []byte) {
+	if c.PacketTrace == nil {
+		return
+	}
+	h, _ := ParseHeader(p)
+	c.PacketTrace(TracedPacket{
+		Time:       time.Now(),
+		Dir:        dir,
+		RemoteAddr: c.nc.RemoteAddr().String(),
+		LocalAddr:  c.nc.LocalAddr().String(),
+		Header:     h,
+		Body:       append([]byte(nil), p[hdrLen:]...),
+	})
+}
+
+// Transcript is the ordered packets of a single session, serializable to JSON
+// for sharing reproductions of problems.
+type Transcript struct {
+	SessionID  uint32         `json:"session_id"`
+	RemoteAddr string         `json:"remote_addr"`
+	LocalAddr  string         `json:"local_addr"`
+	Start      time.Time      `json:"start"`
+	Packets    []TracedPacket `json:"packets"`
+}
+
+// TranscriptRecorder collects traced packets into a Transcript per session.
+// Its Trace method can be used as a ConnConfig PacketTrace function.
+type TranscriptRecorder struct {
+	// Maximum number of transcripts kept. When it is exceeded the transcript
+	// with the oldest start time is discarded. Ignored if zero.
+	MaxTranscripts int
+
+	mu          sync.Mutex
+	transcripts map[transcriptKey]*Transcript
+}
+
+type transcriptKey struct {
+	remote, local string
+	id            uint32
+}
+
+// Trace adds the packet p to its session's transcript.
+func (r *TranscriptRecorder) Trace(p TracedPacket) {
+	// session ids are only unique per connection
+	key := transcriptKey{p.RemoteAddr, p.LocalAddr, p.Header.SessionID}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.transcripts == nil {
+		r.transcripts = make(map[transcriptKey]*Transcript)
+	}
+	t := r.transcripts[key]
+	if t == nil {
+		if r.MaxTranscripts > 0 && len(r.transcripts) >= r.MaxTranscripts {
+			r.discardOldest()
+		}
+		t = &Transcript{
+			SessionID:  p.Header.SessionID,
+			RemoteAddr: p.RemoteAddr,
+			LocalAddr:  p.LocalAddr,
+			Start:      p.Time,
+		}
+		r.transcripts[key] = t
+	}
+	t.Packets = append(t.Packets, p)
+}
+
+func (r *TranscriptRecorder) discardOldest() {
+	var oldest transcriptKey
+	var start time.Time
+	for k, t := range r.transcripts {
+		if start.IsZero() || t.Start.Before(start) {
+			oldest, start = k, t.Start
+		}
+	}
+	delete(r.transcripts, oldest)
+}
+
+// Transcripts returns the recorded transcripts ordered by start time.
+func (r *TranscriptRecorder) Transcripts() []Transcript {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ts := make([]Transcript, 0, len(r.transcripts))
+	for _, t := range r.transcripts {
+		tc := *t
+		tc.Packets = append([]TracedPacket(nil), t.Packets...)
+		ts = append(ts, tc)
+	}
+	sort.Slice(ts, func(i, j int) bool { return ts[i].Start.Before(ts[j].Start) })
+	return ts
+}
@@ -0,0 +1,71 @@
+//go:build !tacplus_nomd5
+
+package tacplus
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestTranscriptRecorder(t *testing.T) {
+	rec := new(TranscriptRecorder)
+	h := testHandler
+	h.ConnConfig.PacketTrace = rec.Trace
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	ctx := context.Background()
+	if _, err = c.SendAuthorRequest(ctx, testAuthorReq); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = authenticate(ctx, c, "user", "password123"); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := rec.Transcripts()
+	if len(ts) != 2 {
+		t.Fatalf("got %d transcripts, want 2", len(ts))
+	}
+	if n := len(ts[0].Packets); n != 2 {
+		t.Errorf("got %d authorization packets, want 2", n)
+	}
+	in := ts[0].Packets[0]
+	if in.Dir != DirIn || in.Header.Type != TypeAuthor || in.Header.SeqNo != 1 {
+		t.Errorf("unexpected first packet %+v", in)
+	}
+	p, err := in.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p, testAuthorReq) {
+		t.Errorf("decoded %+v, want %+v", p, testAuthorReq)
+	}
+	out := ts[0].Packets[1]
+	if p, err = out.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	if r, ok := p.(*AuthorResponse); !ok || out.Dir != DirOut || r.Status != AuthorStatusPassAdd {
+		t.Errorf("unexpected reply %+v %+v", out, p)
+	}
+	// interactive login: start, get user, user, get pass, password, pass
+	if n := len(ts[1].Packets); n != 6 {
+		t.Errorf("got %d authentication packets, want 6", n)
+	}
+
+	b, err := json.Marshal(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ts2 []Transcript
+	if err = json.Unmarshal(b, &ts2); err != nil {
+		t.Fatal(err)
+	}
+	if len(ts2) != 2 || !reflect.DeepEqual(ts2[1].Packets[0].Body, ts[1].Packets[0].Body) || ts2[1].Packets[1].Dir != DirOut {
+		t.Errorf("transcript JSON round trip failed: %s", b)
+	}
+}
@@ -0,0 +1,49 @@
+package tacplus
+
+import "context"
+
+type traceIDKey struct{}
+
+// WithTraceID returns a copy of ctx carrying the correlation ID id. A Client with
+// TraceArg set sends it with authorization and accounting requests made with ctx.
+func WithTraceID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, id)
+}
+
+// TraceIDFromContext returns the correlation ID carried by ctx, or an empty string.
+// Server handler contexts carry the ID sent by the client if the ServerConnHandler
+// TraceArg is set.
+func TraceIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(traceIDKey{}).(string)
+	return id
+}
+
+// withTraceArg returns args with a name argument set to the correlation ID of
+// ctx, unless there is no ID or args already has one. Authorization arguments
+// are sent as optional so servers that don't know the argument accept them.
+func withTraceArg(ctx context.Context, name string, args []string, optional bool) []string {
+	id := TraceIDFromContext(ctx)
+	if name == "" || id == "" {
+		return args
+	}
+	if _, ok := argValue(args, name); ok {
+		return args
+	}
+	sep := "="
+	if optional {
+		sep = "*"
+	}
+	return append(append([]string(nil), args...), name+sep+id)
+}
+
+// traceContext returns ctx carrying the correlation ID in the name argument of
+// args, if there is one.
+func traceContext(ctx context.Context, name string, args []string) context.Context {
+	if name == "" {
+		return ctx
+	}
+	if id, ok := argValue(args, name); ok && id != "" {
+		return WithTraceID(ctx, id)
+	}
+	return ctx
+}
@@ -0,0 +1,91 @@
+//go:build !tacplus_nomd5
+
+package tacplus
+
+import (
+	"context"
+	"testing"
+)
+
+// traceIDHandler records the correlation IDs and arguments of requests.
+type traceIDHandler struct {
+	RequestHandler
+	ids  chan string
+	args chan []string
+}
+
+func (h *traceIDHandler) HandleAuthorRequest(ctx context.Context, a *AuthorRequest, s *ServerSession) *AuthorResponse {
+	h.ids <- TraceIDFromContext(ctx)
+	h.args <- a.Arg
+	return h.RequestHandler.HandleAuthorRequest(ctx, a, s)
+}
+
+func (h *traceIDHandler) HandleAcctRequest(ctx context.Context, a *AcctRequest, s *ServerSession) *AcctReply {
+	h.ids <- TraceIDFromContext(ctx)
+	h.args <- a.Arg
+	return h.RequestHandler.HandleAcctRequest(ctx, a, s)
+}
+
+func TestTraceID(t *testing.T) {
+	th := &traceIDHandler{RequestHandler: testHandler.Handler, ids: make(chan string, 1), args: make(chan []string, 1)}
+	uh := testHandler
+	uh.Handler = th
+	uh.TraceArg = "trace-id"
+	us, uc, err := newTestInstance(&uh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer us.close()
+	uc.TraceArg = "trace-id"
+
+	// requests pass through a proxy to the upstream server
+	ph := testHandler
+	ph.Handler = &Proxy{Upstream: uc}
+	ph.TraceArg = "trace-id"
+	ps, c, err := newTestInstance(&ph)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ps.close()
+	c.TraceArg = "trace-id"
+
+	ctx := WithTraceID(context.Background(), "abc123")
+	check := func(kind, arg string) {
+		t.Helper()
+		if id := <-th.ids; id != "abc123" {
+			t.Errorf("%s: got trace id %q, want %q", kind, id, "abc123")
+		}
+		args := <-th.args
+		n := 0
+		for _, a := range args {
+			if argName(a) == "trace-id" {
+				n++
+				if a != arg {
+					t.Errorf("%s: got argument %q, want %q", kind, a, arg)
+				}
+			}
+		}
+		if n != 1 {
+			t.Errorf("%s: got arguments %q, want one trace-id", kind, args)
+		}
+	}
+	if _, err = c.SendAuthorRequest(ctx, testAuthorReq); err != nil {
+		t.Fatal(err)
+	}
+	check("authorization", "trace-id*abc123")
+	if _, err = c.SendAcctRequest(ctx, testAcctReq); err != nil {
+		t.Fatal(err)
+	}
+	check("accounting", "trace-id=abc123")
+
+	// requests without an ID are unchanged
+	if _, err = c.SendAcctRequest(context.Background(), testAcctReq); err != nil {
+		t.Fatal(err)
+	}
+	if id := <-th.ids; id != "" {
+		t.Errorf("got trace id %q without one set", id)
+	}
+	if args := <-th.args; len(args) != len(testAcctReq.Arg) {
+		t.Errorf("got arguments %q, want %q", args, testAcctReq.Arg)
+	}
+}
@@ -0,0 +1,186 @@
+package tacplus
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UserConfig is a user of a UserStore, as written by Save and read by Load.
+// Login windows of the account aren't saved, as Conditions are functions.
+type UserConfig struct {
+	User     string     `json:"user"`
+	Hash     string     `json:"hash"`
+	Changed  time.Time  `json:"changed"`
+	Expired  bool       `json:"expired,omitempty"`
+	Disabled bool       `json:"disabled,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"` // never if nil
+	Groups   []string   `json:"groups,omitempty"`
+}
+
+// Save writes the users of u to w as a JSON array of UserConfig objects,
+// sorted by user name.
+func (u *UserStore) Save(w io.Writer) error {
+	u.mu.Lock()
+	cfg := make([]UserConfig, 0, len(u.users))
+	for user, su := range u.users {
+		c := UserConfig{
+			User:     user,
+			Hash:     su.hash,
+			Changed:  su.changed,
+			Expired:  su.expired,
+			Disabled: su.account.Disabled,
+			Groups:   su.groups,
+		}
+		if t := su.account.Expires; !t.IsZero() {
+			c.Expires = &t
+		}
+		cfg = append(cfg, c)
+	}
+	u.mu.Unlock()
+	sort.Slice(cfg, func(i, j int) bool { return cfg[i].User < cfg[j].User })
+	b, err := json.MarshalIndent(cfg, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// Load adds the users read from r, a JSON array of UserConfig objects as
+// written by Save, replacing users that already exist. No users are added if
+// any has an unsupported hash.
+func (u *UserStore) Load(r io.Reader) error {
+	var cfg []UserConfig
+	if err := json.NewDecoder(r).Decode(&cfg); err != nil {
+		return err
+	}
+	for i, c := range cfg {
+		if c.User == "" {
+			return fmt.Errorf("user %d: no name", i)
+		}
+		if err := checkHash(c.Hash); err != nil {
+			return fmt.Errorf("user %s: %w", c.User, err)
+		}
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.users == nil {
+		u.users = make(map[string]*storedUser)
+	}
+	for _, c := range cfg {
+		su := &storedUser{
+			hash:    c.Hash,
+			changed: c.Changed,
+			expired: c.Expired,
+			account: Account{Disabled: c.Disabled},
+			groups:  c.Groups,
+		}
+		if c.Expires != nil {
+			su.account.Expires = *c.Expires
+		}
+		u.users[c.User] = su
+	}
+	return nil
+}
+
+// readLines calls fn with each line of r and its line number, skipping blank
+// lines and # comments.
+func readLines(r io.Reader, fn func(n int, line string) error) error {
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if err := fn(n, line); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// ImportHtpasswd adds the users of an htpasswd file read from r to u, with
+// their password changed at the time of the import. Users with hashes of an
+// unsupported scheme, such as bcrypt or MD5, can't be imported and are
+// returned in skipped.
+func ImportHtpasswd(u *UserStore, r io.Reader) (skipped []string, err error) {
+	now := time.Now()
+	err = readLines(r, func(n int, line string) error {
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return fmt.Errorf("htpasswd line %d: invalid entry", n)
+		}
+		if u.SetHash(user, hash, now) != nil {
+			skipped = append(skipped, user)
+		}
+		return nil
+	})
+	return skipped, err
+}
+
+// shadowDate returns the time of a shadow file date in days since the epoch,
+// or the zero time if it is empty.
+func shadowDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	days, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(days*24*60*60, 0).UTC(), nil
+}
+
+// ImportShadow adds the users of a shadow password file, such as /etc/shadow,
+// read from r to u. Only root can normally read the shadow file.
+//
+// The time of the last password change is kept, and a password last changed
+// on day 0 must be changed at the next login. Locked passwords, prefixed with
+// "!", disable the account, and the account expiry date is kept. Users without
+// a password, such as system accounts, and users with hashes of an unsupported
+// scheme, such as MD5 or yescrypt, are returned in skipped.
+func ImportShadow(u *UserStore, r io.Reader) (skipped []string, err error) {
+	err = readLines(r, func(n int, line string) error {
+		f := strings.Split(line, ":")
+		if len(f) != 9 || f[0] == "" {
+			return fmt.Errorf("shadow line %d: invalid entry", n)
+		}
+		user, hash := f[0], f[1]
+		locked := strings.HasPrefix(hash, "!")
+		hash = strings.TrimLeft(hash, "!")
+		changed, err := shadowDate(f[2])
+		if err != nil {
+			return fmt.Errorf("shadow line %d: invalid last change date: %w", n, err)
+		}
+		expires, err := shadowDate(f[7])
+		if err != nil {
+			return fmt.Errorf("shadow line %d: invalid expiry date: %w", n, err)
+		}
+		if checkHash(hash) != nil {
+			skipped = append(skipped, user)
+			return nil
+		}
+		if changed.IsZero() {
+			changed = time.Now()
+		}
+		u.mu.Lock()
+		defer u.mu.Unlock()
+		if u.users == nil {
+			u.users = make(map[string]*storedUser)
+		}
+		u.users[user] = &storedUser{
+			hash:    hash,
+			changed: changed,
+			expired: f[2] == "0",
+			account: Account{Disabled: locked, Expires: expires},
+		}
+		return nil
+	})
+	return skipped, err
+}
@@ -0,0 +1,112 @@
+package tacplus
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUserStoreSaveLoad(t *testing.T) {
+	ctx := context.Background()
+	changed := time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC)
+	u := new(UserStore)
+	u.Set("alice", "secret", changed)
+	u.SetGroups("alice", "netops", "staff")
+	u.Set("bob", "pass", changed)
+	u.Expire("bob")
+	u.SetAccount("bob", Account{Disabled: true, Expires: changed.Add(time.Hour)})
+	if err := u.SetHash("carol", "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", changed); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := u.Save(&b); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(b.String(), "secret") {
+		t.Error("password saved in plain text")
+	}
+
+	got := new(UserStore)
+	if err := got.Load(bytes.NewReader(b.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.users, u.users) {
+		t.Errorf("loaded users differ from saved users")
+	}
+	for user, pass := range map[string]string{"alice": "secret", "bob": "pass", "carol": "secret"} {
+		if ok, _ := got.Authenticate(ctx, user, pass); !ok {
+			t.Errorf("%s: password rejected after load", user)
+		}
+	}
+
+	err := got.Load(strings.NewReader(`[{"user": "dave", "hash": "$2y$05$abc"}]`))
+	if err == nil || !strings.Contains(err.Error(), ErrUnsupportedHash.Error()) {
+		t.Errorf("got error %v loading bcrypt hash", err)
+	}
+}
+
+func TestImportHtpasswd(t *testing.T) {
+	const file = `# users
+alice:$6$abcdefgh$ltjgWl6579NluT/Vi1nwEvcil.G5Nbc4NiXZaNGStk8PSwGfQv72N2CKPPrVACtLtip/cZ/1GM/O6IND4WQhG.
+bob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=
+
+carol:$apr1$abcdefgh$h9FWgUz3n9YxylKLlR5SQ/
+dave:$2y$05$c4WoMPo3SXsafkva.HHa6uXQZWr7oboPiC2bT/r7q1BB8I2s0BRqC
+`
+	ctx := context.Background()
+	u := new(UserStore)
+	skipped, err := ImportHtpasswd(u, strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"carol", "dave"}; !reflect.DeepEqual(skipped, want) {
+		t.Errorf("got skipped %v, want %v", skipped, want)
+	}
+	for user, want := range map[string]bool{"alice": true, "bob": true, "carol": false, "dave": false} {
+		if ok, _ := u.Authenticate(ctx, user, "secret"); ok != want {
+			t.Errorf("%s: got authenticated %v, want %v", user, ok, want)
+		}
+	}
+	if _, err = ImportHtpasswd(u, strings.NewReader("nocolon\n")); err == nil {
+		t.Error("invalid line accepted")
+	}
+}
+
+func TestImportShadow(t *testing.T) {
+	const file = `root:*:19000:0:99999:7:::
+alice:$6$abcdefgh$ltjgWl6579NluT/Vi1nwEvcil.G5Nbc4NiXZaNGStk8PSwGfQv72N2CKPPrVACtLtip/cZ/1GM/O6IND4WQhG.:19000:0:99999:7:::
+bob:!$5$12345678$vfXTriTcIE3fmtdKcmhtmhxt0kqEh3a0Degk.vD/PuB:19000:0:99999:7::20000:
+carol:$5$12345678$vfXTriTcIE3fmtdKcmhtmhxt0kqEh3a0Degk.vD/PuB:0:0:99999:7:::
+dave:$y$j9T$abc$def:19000:0:99999:7:::
+daemon:!!:19000::::::
+`
+	ctx := context.Background()
+	u := new(UserStore)
+	skipped, err := ImportShadow(u, strings.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"root", "dave", "daemon"}; !reflect.DeepEqual(skipped, want) {
+		t.Errorf("got skipped %v, want %v", skipped, want)
+	}
+	for _, user := range []string{"alice", "bob", "carol"} {
+		if ok, _ := u.Authenticate(ctx, user, "secret"); !ok {
+			t.Errorf("%s: password rejected", user)
+		}
+	}
+	if changed := u.users["alice"].changed; !changed.Equal(time.Date(2022, 1, 8, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("got changed %v", changed)
+	}
+	if a := u.users["bob"].account; !a.Disabled || !a.Expires.Equal(time.Date(2024, 10, 4, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("got bob account %+v, want disabled and expiring", a)
+	}
+	if expired, _ := u.PasswordExpired(ctx, "carol"); !expired {
+		t.Error("password changed on day 0 not expired")
+	}
+	if _, err = ImportShadow(u, strings.NewReader("alice:x:abc:0:99999:7:::\n")); err == nil {
+		t.Error("invalid date accepted")
+	}
+}
@@ -0,0 +1,175 @@
+package tacplus
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// defaultOutcomeWindow is the default UserGauge Window.
+const defaultOutcomeWindow = 5 * time.Minute
+
+// UserStats is the authentication activity of a user seen by a UserGauge.
+type UserStats struct {
+	Active   int       // authentication sessions in progress
+	Pass     int       // sessions passed within the window
+	Fail     int       // sessions failed within the window
+	Error    int       // sessions ending with an error, or no reply, within the window
+	LastFail time.Time // time of the last failure, zero if none within the window
+}
+
+type outcome struct {
+	t      time.Time
+	status uint8 // AuthenStatusPass, AuthenStatusFail or AuthenStatusError
+}
+
+type userGauge struct {
+	active   int
+	outcomes []outcome // oldest first
+}
+
+// UserGauge is a RequestHandler keeping a live count of the authentication
+// sessions in progress for each user and the outcomes of their recent sessions,
+// so monitoring can tell when an account is being attacked. Requests are passed
+// to Handler.
+//
+// ASCII logins that prompt for the user name are counted as active under an
+// empty user name, and their outcome is counted under the name given.
+type UserGauge struct {
+	Passthrough // handler for requests
+
+	// Period for which session outcomes are counted. Defaults to five minutes if zero.
+	Window time.Duration
+
+	mu     sync.Mutex
+	users  map[string]*userGauge
+	pruned time.Time // time all users were last pruned
+}
+
+func (g *UserGauge) window() time.Duration {
+	if g.Window > 0 {
+		return g.Window
+	}
+	return defaultOutcomeWindow
+}
+
+// prune drops outcomes of u before the window ending at now, removing u if it
+// has nothing left to report.
+func (g *UserGauge) prune(user string, u *userGauge, now time.Time) {
+	start := now.Add(-g.window())
+	i := 0
+	for i < len(u.outcomes) && u.outcomes[i].t.Before(start) {
+		i++
+	}
+	u.outcomes = u.outcomes[i:]
+	if u.active == 0 && len(u.outcomes) == 0 {
+		delete(g.users, user)
+	}
+}
+
+// get returns the gauge of user, adding it if needed.
+func (g *UserGauge) get(user string) *userGauge {
+	if g.users == nil {
+		g.users = make(map[string]*userGauge)
+	}
+	u := g.users[user]
+	if u == nil {
+		u = new(userGauge)
+		g.users[user] = u
+	}
+	return u
+}
+
+func (g *UserGauge) start(user string) {
+	now := time.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	// prune all users once a window, so users that don't return aren't kept
+	if now.Sub(g.pruned) >= g.window() {
+		g.pruned = now
+		for user, u := range g.users {
+			g.prune(user, u, now)
+		}
+	}
+	g.get(user).active++
+}
+
+// finish ends a session started for the user started, counting its outcome
+// for user, the name it logged in with.
+func (g *UserGauge) finish(started, user string, status uint8) {
+	now := time.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if u := g.users[started]; u != nil {
+		u.active--
+		g.prune(started, u, now)
+	}
+	u := g.get(user)
+	u.outcomes = append(u.outcomes, outcome{now, status})
+	g.prune(user, u, now)
+}
+
+func (u *userGauge) stats() UserStats {
+	st := UserStats{Active: u.active}
+	for _, o := range u.outcomes {
+		switch o.status {
+		case AuthenStatusPass:
+			st.Pass++
+		case AuthenStatusFail:
+			st.Fail++
+			st.LastFail = o.t
+		default:
+			st.Error++
+		}
+	}
+	return st
+}
+
+// User returns the statistics for user.
+func (g *UserGauge) User(user string) UserStats {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	u := g.users[user]
+	if u == nil {
+		return UserStats{}
+	}
+	g.prune(user, u, time.Now())
+	return u.stats()
+}
+
+// Stats returns the statistics of all users with sessions in progress or
+// finished within the window.
+func (g *UserGauge) Stats() map[string]UserStats {
+	now := time.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	m := make(map[string]UserStats, len(g.users))
+	for user, u := range g.users {
+		g.prune(user, u, now)
+		if g.users[user] != nil {
+			m[user] = u.stats()
+		}
+	}
+	return m
+}
+
+// HandleAuthenStart calls the HandleAuthenStart method of Handler, counting the
+// session as active until it returns.
+func (g *UserGauge) HandleAuthenStart(ctx context.Context, a *AuthenStart, s *ServerSession) *AuthenReply {
+	g.start(a.User)
+	status := uint8(AuthenStatusError)
+	defer func() {
+		user := a.User
+		if user == "" && s != nil {
+			s.mu.Lock()
+			user = s.user // answer to GetUser
+			s.mu.Unlock()
+		}
+		g.finish(a.User, user, status)
+	}()
+	r := g.Passthrough.HandleAuthenStart(ctx, a, s)
+	if r != nil && (r.Status == AuthenStatusPass || r.Status == AuthenStatusFail) {
+		status = r.Status
+	}
+	return r
+}
@@ -0,0 +1,86 @@
+package tacplus
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type blockAuthenHandler struct {
+	RequestHandler
+	started chan struct{}
+	unblock chan struct{}
+}
+
+func (h *blockAuthenHandler) HandleAuthenStart(ctx context.Context, a *AuthenStart, s *ServerSession) *AuthenReply {
+	h.started <- struct{}{}
+	<-h.unblock
+	return &AuthenReply{Status: AuthenStatusFail}
+}
+
+func TestUserGauge(t *testing.T) {
+	skipWithoutMD5(t)
+	g := &UserGauge{Passthrough: Passthrough{testHandler.Handler}}
+	h := testHandler
+	h.Handler = g
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	ctx := context.Background()
+	for _, pass := range []string{"wrong", "password123", "wrong"} {
+		if _, err = c.SendAuthenStartAuto(ctx, testAuthStart, "user", pass); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// ASCII logins prompting for the user are counted under the name given
+	st := g.User("user")
+	if st.Pass != 1 || st.Fail != 2 || st.Active != 0 || st.LastFail.IsZero() {
+		t.Errorf("got stats %+v", st)
+	}
+	if _, ok := g.Stats()[""]; ok {
+		t.Error("got stats for empty user name")
+	}
+
+	bh := &blockAuthenHandler{started: make(chan struct{}), unblock: make(chan struct{})}
+	g.Handler = bh
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.HandleAuthenStart(ctx, &AuthenStart{User: "alice"}, nil)
+	}()
+	<-bh.started
+	if st = g.User("alice"); st.Active != 1 {
+		t.Errorf("got %d active sessions, want 1", st.Active)
+	}
+	close(bh.unblock)
+	<-done
+	if st = g.Stats()["alice"]; st.Active != 0 || st.Fail != 1 {
+		t.Errorf("got stats %+v after session", st)
+	}
+
+	// outcomes outside the window are forgotten
+	g.Window = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	if n := len(g.Stats()); n != 0 {
+		t.Errorf("got stats for %d users after the window", n)
+	}
+}
+
+func TestUserGaugePruneOnStart(t *testing.T) {
+	g := &UserGauge{Window: timeScale}
+	for _, user := range []string{"a", "b", "c"} {
+		g.start(user)
+		g.finish(user, user, AuthenStatusFail)
+	}
+	time.Sleep(timeScale)
+	g.start("d")
+	g.mu.Lock()
+	n := len(g.users)
+	g.mu.Unlock()
+	if n != 1 {
+		t.Errorf("got %d users kept, want 1", n)
+	}
+}
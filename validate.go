@@ -0,0 +1,190 @@
+package tacplus
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxPrivLvl is the highest privilege level defined by RFC 8907.
+const maxPrivLvl = 15
+
+// A ValidationError describes a packet field that does not conform to RFC 8907.
+type ValidationError struct {
+	Packet string // packet type name, e.g. "AcctRequest"
+	Field  string // field name
+	Reason string // description of the problem
+}
+
+func (e *ValidationError) Error() string {
+	return "tacplus: invalid " + e.Packet + "." + e.Field + ": " + e.Reason
+}
+
+func invalid(pkt, field, format string, a ...interface{}) error {
+	return &ValidationError{Packet: pkt, Field: field, Reason: fmt.Sprintf(format, a...)}
+}
+
+func validAuthenMethod(m uint8) bool {
+	switch m {
+	case AuthenMethodNotSet, AuthenMethodNone, AuthenMethodKRB5, AuthenMethodLine,
+		AuthenMethodEnable, AuthenMethodLocal, AuthenMethodTACACSPlus,
+		AuthenMethodGuest, AuthenMethodRADIUS, AuthenMethodKRB4, AuthenMethodRCMD:
+		return true
+	}
+	return false
+}
+
+func validAuthenStatus(s uint8) bool {
+	return (s >= AuthenStatusPass && s <= AuthenStatusError) || s == AuthenStatusFollow
+}
+
+func validAuthorStatus(s uint8) bool {
+	switch s {
+	case AuthorStatusPassAdd, AuthorStatusPassRepl, AuthorStatusFail,
+		AuthorStatusError, AuthorStatusFollow:
+		return true
+	}
+	return false
+}
+
+func validAcctStatus(s uint8) bool {
+	return s == AcctStatusSuccess || s == AcctStatusError || s == AcctStatusFollow
+}
+
+// checkLen returns an error if field value v is longer than max.
+func checkLen(pkt, field string, n, max int) error {
+	if n > max {
+		return invalid(pkt, field, "length %d exceeds %d", n, max)
+	}
+	return nil
+}
+
+// checkCommon validates the fields shared by AuthenStart, AuthorRequest and AcctRequest.
+// If typeNotSet is true an AuthenType of zero is permitted.
+func checkCommon(pkt string, privLvl, authenType, authenService uint8, typeNotSet bool, user, port, remAddr string) error {
+	if privLvl > maxPrivLvl {
+		return invalid(pkt, "PrivLvl", "%d out of range 0-%d", privLvl, maxPrivLvl)
+	}
+	if (authenType == 0 && !typeNotSet) || authenType > AuthenTypeMSCHAPV2 {
+		return invalid(pkt, "AuthenType", "unknown value %#x", authenType)
+	}
+	if authenService > AuthenServiceFWProxy {
+		return invalid(pkt, "AuthenService", "unknown value %#x", authenService)
+	}
+	if err := checkLen(pkt, "User", len(user), maxUint8); err != nil {
+		return err
+	}
+	if err := checkLen(pkt, "Port", len(port), maxUint8); err != nil {
+		return err
+	}
+	return checkLen(pkt, "RemAddr", len(remAddr), maxUint8)
+}
+
+// checkArgs validates a list of attribute-value pairs, which must have a
+// non-empty attribute name followed by a '=' or '*' separator.
+func checkArgs(pkt string, args []string) error {
+	if len(args) > maxUint8 {
+		return invalid(pkt, "Arg", "%d arguments exceeds %d", len(args), maxUint8)
+	}
+	for i, s := range args {
+		if len(s) > maxUint8 {
+			return invalid(pkt, "Arg", "argument %d length %d exceeds %d", i, len(s), maxUint8)
+		}
+		if strings.IndexAny(s, "=*") < 1 {
+			return invalid(pkt, "Arg", "argument %d %q is not an attribute-value pair", i, s)
+		}
+	}
+	return nil
+}
+
+// Validate checks that the AuthenStart fields conform to RFC 8907.
+func (a *AuthenStart) Validate() error {
+	const pkt = "AuthenStart"
+	if a.Action < AuthenActionLogin || a.Action > AuthenActionSendAuth {
+		return invalid(pkt, "Action", "unknown value %#x", a.Action)
+	}
+	if err := checkCommon(pkt, a.PrivLvl, a.AuthenType, a.AuthenService, false, a.User, a.Port, a.RemAddr); err != nil {
+		return err
+	}
+	if a.Action == AuthenActionSendAuth && a.AuthenType == AuthenTypeASCII {
+		return invalid(pkt, "AuthenType", "ASCII not permitted with SendAuth action")
+	}
+	if a.Action == AuthenActionChangePass && a.AuthenType != AuthenTypeASCII {
+		return invalid(pkt, "AuthenType", "only ASCII permitted with ChangePass action")
+	}
+	return checkLen(pkt, "Data", len(a.Data), maxUint8)
+}
+
+// Validate checks that the AuthenReply fields conform to RFC 8907.
+func (a *AuthenReply) Validate() error {
+	const pkt = "AuthenReply"
+	if !validAuthenStatus(a.Status) {
+		return invalid(pkt, "Status", "unknown value %#x", a.Status)
+	}
+	if err := checkLen(pkt, "ServerMsg", len(a.ServerMsg), maxUint16); err != nil {
+		return err
+	}
+	return checkLen(pkt, "Data", len(a.Data), maxUint16)
+}
+
+// Validate checks that the AuthenContinue fields conform to RFC 8907.
+func (a *AuthenContinue) Validate() error {
+	return checkLen("AuthenContinue", "Message", len(a.Message), maxUint16)
+}
+
+// Validate checks that the AuthorRequest fields conform to RFC 8907.
+func (a *AuthorRequest) Validate() error {
+	const pkt = "AuthorRequest"
+	if !validAuthenMethod(a.AuthenMethod) {
+		return invalid(pkt, "AuthenMethod", "unknown value %#x", a.AuthenMethod)
+	}
+	if err := checkCommon(pkt, a.PrivLvl, a.AuthenType, a.AuthenService, true, a.User, a.Port, a.RemAddr); err != nil {
+		return err
+	}
+	return checkArgs(pkt, a.Arg)
+}
+
+// Validate checks that the AuthorResponse fields conform to RFC 8907.
+func (a *AuthorResponse) Validate() error {
+	const pkt = "AuthorResponse"
+	if !validAuthorStatus(a.Status) {
+		return invalid(pkt, "Status", "unknown value %#x", a.Status)
+	}
+	if err := checkArgs(pkt, a.Arg); err != nil {
+		return err
+	}
+	if err := checkLen(pkt, "ServerMsg", len(a.ServerMsg), maxUint16); err != nil {
+		return err
+	}
+	return checkLen(pkt, "Data", len(a.Data), maxUint16)
+}
+
+// Validate checks that the AcctRequest fields conform to RFC 8907.
+// Exactly one of the Start, Stop or Watchdog flags must be set,
+// except that Start may be combined with Watchdog.
+func (a *AcctRequest) Validate() error {
+	const pkt = "AcctRequest"
+	switch a.Flags &^ AcctFlagMore {
+	case AcctFlagStart, AcctFlagStop, AcctFlagWatchdog, AcctFlagWatchdog | AcctFlagStart:
+	default:
+		return invalid(pkt, "Flags", "illegal combination %#x", a.Flags)
+	}
+	if !validAuthenMethod(a.AuthenMethod) {
+		return invalid(pkt, "AuthenMethod", "unknown value %#x", a.AuthenMethod)
+	}
+	if err := checkCommon(pkt, a.PrivLvl, a.AuthenType, a.AuthenService, true, a.User, a.Port, a.RemAddr); err != nil {
+		return err
+	}
+	return checkArgs(pkt, a.Arg)
+}
+
+// Validate checks that the AcctReply fields conform to RFC 8907.
+func (a *AcctReply) Validate() error {
+	const pkt = "AcctReply"
+	if !validAcctStatus(a.Status) {
+		return invalid(pkt, "Status", "unknown value %#x", a.Status)
+	}
+	if err := checkLen(pkt, "ServerMsg", len(a.ServerMsg), maxUint16); err != nil {
+		return err
+	}
+	return checkLen(pkt, "Data", len(a.Data), maxUint16)
+}
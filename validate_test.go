@@ -0,0 +1,67 @@
+package tacplus
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type validator interface {
+	Validate() error
+}
+
+func TestValidate(t *testing.T) {
+	valid := []validator{
+		&AuthenStart{Action: AuthenActionLogin, PrivLvl: 1, AuthenType: AuthenTypeASCII, AuthenService: AuthenServiceLogin, User: "fred"},
+		&AuthenStart{Action: AuthenActionSendAuth, AuthenType: AuthenTypeMSCHAPV2, AuthenService: AuthenServicePPP},
+		&AuthenReply{Status: AuthenStatusFollow, ServerMsg: "msg"},
+		&AuthenContinue{Message: "password"},
+		&AuthorRequest{AuthenMethod: AuthenMethodTACACSPlus, PrivLvl: 15, Arg: []string{"service=shell", "cmd*"}},
+		&AuthorResponse{Status: AuthorStatusPassAdd, Arg: []string{"priv-lvl=15"}},
+		&AcctRequest{Flags: AcctFlagStart | AcctFlagWatchdog, AuthenMethod: AuthenMethodNone, Arg: []string{"task_id=1"}},
+		&AcctRequest{Flags: AcctFlagStop | AcctFlagMore},
+		&AcctReply{Status: AcctStatusSuccess},
+	}
+	for _, p := range valid {
+		if err := p.Validate(); err != nil {
+			t.Errorf("%+v: %v", p, err)
+		}
+	}
+
+	invalid := []struct {
+		p     validator
+		field string
+	}{
+		{&AuthenStart{Action: 9, AuthenType: AuthenTypeASCII}, "Action"},
+		{&AuthenStart{Action: AuthenActionLogin, AuthenType: AuthenTypeASCII, PrivLvl: 16}, "PrivLvl"},
+		{&AuthenStart{Action: AuthenActionLogin}, "AuthenType"},
+		{&AuthenStart{Action: AuthenActionSendAuth, AuthenType: AuthenTypeASCII}, "AuthenType"},
+		{&AuthenStart{Action: AuthenActionChangePass, AuthenType: AuthenTypePAP}, "AuthenType"},
+		{&AuthenStart{Action: AuthenActionLogin, AuthenType: AuthenTypeASCII, AuthenService: 10}, "AuthenService"},
+		{&AuthenStart{Action: AuthenActionLogin, AuthenType: AuthenTypeASCII, User: strings.Repeat("u", 256)}, "User"},
+		{&AuthenStart{Action: AuthenActionLogin, AuthenType: AuthenTypePAP, Data: make([]byte, 256)}, "Data"},
+		{&AuthenReply{Status: 0}, "Status"},
+		{&AuthenReply{Status: AuthenStatusPass, Data: make([]byte, 1<<16)}, "Data"},
+		{&AuthenContinue{Message: strings.Repeat("m", 1<<16)}, "Message"},
+		{&AuthorRequest{AuthenMethod: 0x7}, "AuthenMethod"},
+		{&AuthorRequest{Arg: []string{"=value"}}, "Arg"},
+		{&AuthorRequest{Arg: []string{"novalue"}}, "Arg"},
+		{&AuthorRequest{Arg: make([]string, 256)}, "Arg"},
+		{&AuthorResponse{Status: 0x3}, "Status"},
+		{&AuthorResponse{Status: AuthorStatusFail, Arg: []string{strings.Repeat("a", 254) + "=b"}}, "Arg"},
+		{&AcctRequest{Flags: AcctFlagStart | AcctFlagStop}, "Flags"},
+		{&AcctRequest{Flags: AcctFlagStop | AcctFlagWatchdog}, "Flags"},
+		{&AcctRequest{Flags: AcctFlagMore}, "Flags"},
+		{&AcctRequest{Flags: AcctFlagStart, PrivLvl: 20}, "PrivLvl"},
+		{&AcctReply{Status: 0x3}, "Status"},
+	}
+	for _, test := range invalid {
+		err := test.p.Validate()
+		var ve *ValidationError
+		if !errors.As(err, &ve) {
+			t.Errorf("%+v: expected ValidationError, got %v", test.p, err)
+		} else if ve.Field != test.field {
+			t.Errorf("%+v: expected invalid field %s, got %v", test.p, test.field, err)
+		}
+	}
+}
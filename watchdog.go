@@ -0,0 +1,144 @@
+package tacplus
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// An AcctTask is a long-lived session started with Client.StartTask. The Client
+// sends watchdog accounting updates for it every WatchdogInterval until Stop is
+// called, so servers can tell it is still alive.
+type AcctTask struct {
+	c     *Client
+	id    string
+	start time.Time
+
+	sendMu sync.Mutex // held while a watchdog or stop record is sent
+
+	mu      sync.Mutex  // protects the following
+	req     AcctRequest // request with the task's current arguments
+	updated bool        // arguments changed since the last record was sent
+	timer   *time.Timer // timer sending the next watchdog update
+	stopped bool
+}
+
+// StartTask sends an accounting start record for req and returns a task that
+// gets watchdog updates every WatchdogInterval until stopped. A task_id argument
+// is added if req has none, and start_time is set to the current time.
+func (c *Client) StartTask(ctx context.Context, req *AcctRequest) (*AcctTask, error) {
+	t := &AcctTask{c: c, start: time.Now(), req: *req}
+	t.req.Arg = append([]string(nil), req.Arg...)
+	var ok bool
+	if t.id, ok = argValue(t.req.Arg, "task_id"); !ok {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		t.id = hex.EncodeToString(b)
+		t.req.Arg = append(t.req.Arg, "task_id="+t.id)
+	}
+	t.req.Arg = mergeArgs(t.req.Arg, []string{"start_time=" + strconv.FormatInt(t.start.Unix(), 10)})
+
+	if err := t.send(ctx, AcctFlagStart, nil); err != nil {
+		return nil, err
+	}
+	if c.WatchdogInterval > 0 {
+		t.mu.Lock()
+		t.timer = time.AfterFunc(c.WatchdogInterval, t.watchdog)
+		t.mu.Unlock()
+	}
+	return t, nil
+}
+
+// ID returns the task_id of the task.
+func (t *AcctTask) ID() string {
+	return t.id
+}
+
+// Update replaces or adds arguments sent with the following watchdog updates and
+// stop record, such as byte counts. The next watchdog is sent as an update with
+// the new arguments.
+func (t *AcctTask) Update(args ...string) {
+	t.mu.Lock()
+	t.req.Arg = mergeArgs(t.req.Arg, args)
+	t.updated = true
+	t.mu.Unlock()
+}
+
+// Stop stops sending watchdog updates and sends an accounting stop record with
+// any extra arguments.
+func (t *AcctTask) Stop(ctx context.Context, args ...string) error {
+	// wait for any watchdog being sent, so the stop record is last
+	t.sendMu.Lock()
+	defer t.sendMu.Unlock()
+	t.mu.Lock()
+	if t.stopped {
+		t.mu.Unlock()
+		return nil
+	}
+	t.stopped = true
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.mu.Unlock()
+	now := time.Now()
+	args = append(args, "stop_time="+strconv.FormatInt(now.Unix(), 10))
+	return t.send(ctx, AcctFlagStop, args)
+}
+
+// send sends an accounting record for the task with flags and extra arguments.
+func (t *AcctTask) send(ctx context.Context, flags uint8, args []string) error {
+	t.mu.Lock()
+	req := t.req
+	req.Flags = flags
+	if flags != AcctFlagStart {
+		args = append(args, "elapsed_time="+strconv.FormatInt(int64(time.Since(t.start)/time.Second), 10))
+	}
+	req.Arg = mergeArgs(req.Arg, args)
+	t.updated = false
+	t.mu.Unlock()
+
+	rep, err := t.c.SendAcctRequest(ctx, &req)
+	if err != nil {
+		return err
+	}
+	return rep.Err()
+}
+
+// watchdog sends a watchdog update and schedules the next one.
+func (t *AcctTask) watchdog() {
+	t.sendMu.Lock()
+	defer t.sendMu.Unlock()
+	t.mu.Lock()
+	if t.stopped {
+		t.mu.Unlock()
+		return
+	}
+	flags := uint8(AcctFlagWatchdog)
+	if t.updated {
+		flags |= AcctFlagStart
+	}
+	t.mu.Unlock()
+
+	// don't let a slow server pile up updates
+	ctx, cancel := context.WithTimeout(context.Background(), t.c.WatchdogInterval)
+	err := t.send(ctx, flags, nil)
+	cancel()
+	if err != nil {
+		if t.c.OnWatchdogError != nil {
+			t.c.OnWatchdogError(t, err)
+		} else {
+			t.c.ConnConfig.log("watchdog for task ", t.id, ": ", err)
+		}
+	}
+
+	t.mu.Lock()
+	if !t.stopped {
+		t.timer.Reset(t.c.WatchdogInterval)
+	}
+	t.mu.Unlock()
+}
@@ -0,0 +1,88 @@
+//go:build !tacplus_nomd5
+
+package tacplus
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flagAcctHandler records the flags of accounting requests.
+type flagAcctHandler struct {
+	RequestHandler
+	mu    sync.Mutex
+	flags []uint8
+}
+
+func (h *flagAcctHandler) HandleAcctRequest(ctx context.Context, a *AcctRequest, s *ServerSession) *AcctReply {
+	h.mu.Lock()
+	h.flags = append(h.flags, a.Flags)
+	h.mu.Unlock()
+	return h.RequestHandler.HandleAcctRequest(ctx, a, s)
+}
+
+func TestAcctTask(t *testing.T) {
+	var stopped []LiveSession
+	var mu sync.Mutex
+	tracker := &AcctTracker{OnStop: func(l LiveSession) {
+		mu.Lock()
+		stopped = append(stopped, l)
+		mu.Unlock()
+	}}
+	fh := &flagAcctHandler{RequestHandler: tracker}
+	h := testHandler
+	h.Handler = fh
+	l, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.close()
+	c.WatchdogInterval = timeScale
+
+	ctx := context.Background()
+	task, err := c.StartTask(ctx, testAcctReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(timeScale * 5 / 2)
+	task.Update("bytes_in=100")
+	time.Sleep(timeScale * 3 / 2)
+	if live := tracker.Sessions(); len(live) != 1 || live[0].TaskID != task.ID() {
+		t.Fatalf("got live sessions %+v, want task %s", live, task.ID())
+	}
+	if err = task.Stop(ctx, "bytes_out=200"); err != nil {
+		t.Fatal(err)
+	}
+	// no more watchdogs are sent once stopped
+	time.Sleep(2 * timeScale)
+
+	fh.mu.Lock()
+	flags := fh.flags
+	fh.mu.Unlock()
+	if len(flags) < 4 || flags[0] != AcctFlagStart || flags[len(flags)-1] != AcctFlagStop {
+		t.Fatalf("got flags %v, want start, watchdogs and stop", flags)
+	}
+	updates := 0
+	for _, f := range flags[1 : len(flags)-1] {
+		switch f {
+		case AcctFlagWatchdog:
+		case AcctFlagWatchdog | AcctFlagStart:
+			updates++
+		default:
+			t.Errorf("got watchdog flags %#x", f)
+		}
+	}
+	if updates != 1 {
+		t.Errorf("got flags %v, want one watchdog update after Update", flags)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(stopped) != 1 || stopped[0].BytesIn != 100 || stopped[0].BytesOut != 200 {
+		t.Errorf("got stopped sessions %+v", stopped)
+	}
+	if err = l.err(); err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+}
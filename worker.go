@@ -0,0 +1,68 @@
+package tacplus
+
+import "sync"
+
+// WorkerPool is a fixed set of goroutines serving authorization and accounting
+// sessions, avoiding starting a goroutine for each request. It can be shared by
+// any number of ServerConnHandlers.
+//
+// Authentication and other session types, which may exchange several packets
+// with the client, always get their own goroutine. Sessions are also given their
+// own goroutine when all workers are busy and the queue is full, so a pool never
+// delays a request. Handlers that block for long periods tie up a worker, so
+// size the pool for the expected number of concurrent requests.
+type WorkerPool struct {
+	tasks chan func()
+	wg    sync.WaitGroup
+
+	mu     sync.Mutex // protects closed
+	closed bool
+}
+
+// NewWorkerPool starts a WorkerPool with n workers and a queue of up to n waiting sessions.
+func NewWorkerPool(n int) *WorkerPool {
+	if n < 1 {
+		n = 1
+	}
+	wp := &WorkerPool{tasks: make(chan func(), n)}
+	wp.wg.Add(n)
+	for i := 0; i < n; i++ {
+		go wp.work()
+	}
+	return wp
+}
+
+func (wp *WorkerPool) work() {
+	defer wp.wg.Done()
+	for f := range wp.tasks {
+		f()
+	}
+}
+
+// submit queues f to be run by a worker, returning false if the queue is full
+// or the pool is closed.
+func (wp *WorkerPool) submit(f func()) bool {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	if wp.closed {
+		return false
+	}
+	select {
+	case wp.tasks <- f:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops the workers after queued sessions are served. Sessions started
+// after Close each get their own goroutine.
+func (wp *WorkerPool) Close() {
+	wp.mu.Lock()
+	if !wp.closed {
+		wp.closed = true
+		close(wp.tasks)
+	}
+	wp.mu.Unlock()
+	wp.wg.Wait()
+}
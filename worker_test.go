@@ -0,0 +1,71 @@
+package tacplus
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestWorkerPoolSubmit(t *testing.T) {
+	wp := NewWorkerPool(1)
+	block := make(chan struct{})
+	ran := make(chan struct{})
+	if !wp.submit(func() { <-block }) {
+		t.Fatal("submit to idle pool failed")
+	}
+	// fill the queue once the worker is busy
+	for !wp.submit(func() { close(ran) }) {
+	}
+	if wp.submit(func() {}) {
+		t.Error("submit succeeded with full queue")
+	}
+	close(block)
+	<-ran
+	wp.Close()
+	if wp.submit(func() {}) {
+		t.Error("submit succeeded after close")
+	}
+}
+
+func TestWorkerPoolServer(t *testing.T) {
+	skipWithoutMD5(t)
+	wp := NewWorkerPool(2)
+	defer wp.Close()
+	h := testHandler
+	h.ConnConfig.Mux = true
+	h.Workers = wp
+	s, c, err := newTestInstance(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	c.ConnConfig.Mux = true
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.SendAuthorRequest(ctx, testAuthorReq); err != nil {
+				errs <- err
+			}
+			if _, err := c.SendAcctRequest(ctx, testAcctReq); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	// interactive sessions still get their own goroutine
+	if _, err = authenticate(ctx, c, "user", "password123"); err != nil {
+		t.Error(err)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if err = s.err(); err != nil {
+		t.Error(err)
+	}
+}